@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+var (
+	ErrNegativePrice = errors.New("adjustment would make the price negative")
+	ErrUnpriced      = errors.New("symbol has no price to adjust")
+)
+
+var hundred = decimal.NewFromInt(100)
+
+// Apply a relative change to a symbol's price: the new price is
+// price * (1 + percent/100) + delta. The row is locked while the new price is computed,
+// so concurrent adjustments apply one after the other instead of overwriting each other.
+// Returns nil if the symbol doesn't exist; cacheConsistent is as for SetBitcoin.
+func (cs *CacheService) AdjustBitcoin(ctx context.Context, symbol string, delta, percent decimal.Decimal, ttl time.Duration) (adjusted *Bitcoin, cacheConsistent bool, err error) {
+	if cs.readOnly {
+		return nil, false, ErrReadOnly
+	}
+	symbol, err = normalizeSymbol(symbol)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := cs.claimUpdateSlot(ctx, symbol); err != nil {
+		return nil, false, err
+	}
+
+	bitcoin, previousPrice, err := cs.adjustPrice(ctx, symbol, delta, percent)
+	if err != nil {
+		cs.releaseUpdateSlot(ctx, symbol)
+		return nil, false, err
+	}
+	if bitcoin == nil {
+		cs.releaseUpdateSlot(ctx, symbol)
+		return nil, true, nil
+	}
+
+	cacheConsistent = cs.writeThroughCache(ctx, bitcoin, previousPrice, ttl)
+
+	slog.InfoContext(ctx, "write-through completed", "symbol", symbol, "previous_price", previousPrice, "price", bitcoin.Price)
+	return bitcoin, cacheConsistent, nil
+}
+
+// The read, compute and write of AdjustBitcoin in one transaction, appending to price
+// history as SetBitcoin does
+func (cs *CacheService) adjustPrice(ctx context.Context, symbol string, delta, percent decimal.Decimal) (*Bitcoin, *decimal.Decimal, error) {
+	start := time.Now()
+	defer cs.observeDB("adjust", start)
+
+	tx, err := cs.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("database error: %w", err)
+	}
+	defer tx.Rollback()
+
+	var previousPrice *decimal.Decimal
+	err = tx.QueryRowContext(ctx, `SELECT price FROM bitcoins WHERE symbol = $1 AND deleted_at IS NULL FOR UPDATE`, symbol).Scan(&previousPrice)
+	if err == sql.ErrNoRows {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("database error: %w", err)
+	}
+	if previousPrice == nil {
+		return nil, nil, ErrUnpriced
+	}
+
+	price := previousPrice.Mul(decimal.NewFromInt(1).Add(percent.Div(hundred))).Add(delta)
+	if price.IsNegative() {
+		return nil, nil, ErrNegativePrice
+	}
+
+	var bitcoin Bitcoin
+	err = tx.QueryRowContext(ctx, `
+		WITH updated AS (
+			UPDATE bitcoins SET price = $2, updated_at = CURRENT_TIMESTAMP
+			WHERE symbol = $1
+			RETURNING symbol, price, supply, created_at, updated_at
+		), history AS (
+			INSERT INTO bitcoin_price_history (symbol, price, recorded_at)
+			SELECT symbol, price, updated_at FROM updated
+		)
+		SELECT symbol, price, supply, created_at, updated_at FROM updated
+	`, symbol, price).Scan(&bitcoin.Symbol, &bitcoin.Price, &bitcoin.Supply, &bitcoin.CreatedAt, &bitcoin.UpdatedAt)
+	if err != nil {
+		return nil, nil, fmt.Errorf("database error: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, nil, fmt.Errorf("database error: %w", err)
+	}
+	return &bitcoin, previousPrice, nil
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+const (
+	apiKeyHeader     = "X-API-Key"
+	apiKeyContextKey = "api_key"
+	apiKeyPrefix     = "bck_"
+	apiKeyCacheTTL   = 1 * time.Minute // How long a key lookup is cached; bounds revocation lag
+	apiKeyCachePref  = "bitcoin:apikey:"
+	quotaWindow      = time.Minute
+)
+
+// APIKey is a partner credential. Only the SHA-256 of the key is stored; the
+// plaintext is returned once, when the key is created.
+type APIKey struct {
+	ID             int64     `json:"id"`
+	Name           string    `json:"name"`
+	AllowedSymbols []string  `json:"allowed_symbols"` // Empty means every symbol
+	QuotaPerMinute int       `json:"quota_per_minute"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+func (k *APIKey) allows(symbol string) bool {
+	if len(k.AllowedSymbols) == 0 {
+		return true
+	}
+	for _, allowed := range k.AllowedSymbols {
+		if strings.EqualFold(allowed, symbol) {
+			return true
+		}
+	}
+	return false
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// Create a key and return it with its plaintext, which is not stored anywhere
+func (cs *CacheService) CreateAPIKey(ctx context.Context, name string, allowedSymbols []string, quotaPerMinute int) (*APIKey, string, error) {
+	if cs.readOnly {
+		return nil, "", ErrReadOnly
+	}
+
+	secret, err := randomHex(24)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to generate key: %w", err)
+	}
+	plaintext := apiKeyPrefix + secret
+
+	key := &APIKey{Name: name, AllowedSymbols: allowedSymbols, QuotaPerMinute: quotaPerMinute}
+	err = cs.db.QueryRowContext(ctx, `
+		INSERT INTO api_keys (key_hash, name, allowed_symbols, quota_per_minute)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at
+	`, hashAPIKey(plaintext), name, pq.Array(allowedSymbols), quotaPerMinute).Scan(&key.ID, &key.CreatedAt)
+	if err != nil {
+		return nil, "", fmt.Errorf("database error: %w", err)
+	}
+
+	slog.InfoContext(ctx, "API key created", "key_id", key.ID, "name", name)
+	return key, plaintext, nil
+}
+
+// Look up a presented key (nil if unknown). Lookups are cached briefly by hash so
+// authenticated traffic doesn't cost a DB query per request.
+func (cs *CacheService) lookupAPIKey(ctx context.Context, plaintext string) (*APIKey, error) {
+	hash := hashAPIKey(plaintext)
+	cacheKey := apiKeyCachePref + hash
+
+	if cs.cacheDisabled() {
+		return cs.queryAPIKey(ctx, hash)
+	}
+
+	cached, err := cs.redisClient.Get(cs.ctx, cacheKey).Result()
+	if err == nil {
+		if cached == negativeCacheSentinel {
+			return nil, nil
+		}
+		var key APIKey
+		if err := decodeCacheValue([]byte(cached), &key); err == nil {
+			return &key, nil
+		}
+	}
+
+	key, err := cs.queryAPIKey(ctx, hash)
+	if err != nil {
+		return nil, err
+	}
+	if key == nil {
+		// Cache unknown keys too, so guessing keys can't hammer the database
+		if err := cs.redisClient.Set(cs.ctx, cacheKey, negativeCacheSentinel, apiKeyCacheTTL).Err(); err != nil {
+			slog.ErrorContext(ctx, "caching API key lookup failed", "error", err)
+		}
+		return nil, nil
+	}
+
+	data, err := cs.encodeCacheValue(key)
+	if err != nil {
+		slog.ErrorContext(ctx, "marshaling API key failed", "error", err)
+	} else if err := cs.redisClient.Set(cs.ctx, cacheKey, data, apiKeyCacheTTL).Err(); err != nil {
+		slog.ErrorContext(ctx, "caching API key lookup failed", "error", err)
+	}
+
+	return key, nil
+}
+
+func (cs *CacheService) queryAPIKey(ctx context.Context, hash string) (*APIKey, error) {
+	var key APIKey
+	err := cs.db.QueryRowContext(ctx, `
+		SELECT id, name, allowed_symbols, quota_per_minute, created_at
+		FROM api_keys
+		WHERE key_hash = $1
+	`, hash).Scan(&key.ID, &key.Name, pq.Array(&key.AllowedSymbols), &key.QuotaPerMinute, &key.CreatedAt)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	return &key, nil
+}
+
+// Count a request against the key's per-minute quota (fixed window in Redis).
+// Returns whether it's allowed and, if not, how long until the window resets.
+func (cs *CacheService) consumeQuota(key *APIKey) (bool, time.Duration, error) {
+	// Fail open, as the middleware does on a Redis error
+	if cs.cacheDisabled() {
+		return true, 0, nil
+	}
+
+	now := time.Now()
+	window := now.Truncate(quotaWindow)
+	counterKey := fmt.Sprintf("%squota:%d:%d", apiKeyCachePref, key.ID, window.Unix())
+
+	pipe := cs.redisClient.TxPipeline()
+	incr := pipe.Incr(cs.ctx, counterKey)
+	pipe.Expire(cs.ctx, counterKey, quotaWindow)
+	if _, err := pipe.Exec(cs.ctx); err != nil {
+		return false, 0, err
+	}
+
+	if incr.Val() > int64(key.QuotaPerMinute) {
+		return false, window.Add(quotaWindow).Sub(now), nil
+	}
+	return true, 0, nil
+}
+
+// Authenticate X-API-Key, enforce its symbol scope on :symbol routes and its quota.
+// Requests without a key pass through unless required, in which case every /api/
+// route except the admin ones (which use ADMIN_TOKEN) needs one.
+func apiKeyMiddleware(cs *CacheService, required bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		plaintext := c.GetHeader(apiKeyHeader)
+		if plaintext == "" {
+			path := c.Request.URL.Path
+			if required && strings.HasPrefix(path, "/api/") && !strings.HasPrefix(path, "/api/admin/") {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, errorJSON(CodeUnauthorized, "API key required"))
+				return
+			}
+			c.Next()
+			return
+		}
+
+		key, err := cs.lookupAPIKey(c.Request.Context(), plaintext)
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "looking up API key failed", "error", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, errorJSON(codeFor(err), "Failed to authenticate API key"))
+			return
+		}
+		if key == nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, errorJSON(CodeUnauthorized, "Invalid API key"))
+			return
+		}
+
+		if symbol := strings.TrimSpace(c.Param("symbol")); symbol != "" && !key.allows(symbol) {
+			c.AbortWithStatusJSON(http.StatusForbidden, errorJSON(CodeForbidden, "API key is not allowed to access "+symbol))
+			return
+		}
+
+		// Fail open: a Redis blip shouldn't lock every partner out
+		allowed, retryAfter, err := cs.consumeQuota(key)
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "tracking API key quota failed", "key_id", key.ID, "error", err)
+		} else if !allowed {
+			c.Header("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, errorJSON(CodeRateLimited, "API key quota exhausted"))
+			return
+		}
+
+		c.Set(apiKeyContextKey, key)
+		c.Next()
+	}
+}
+
+// Whether the request's API key (if any) may access symbol
+func apiKeyAllows(c *gin.Context, symbol string) bool {
+	key, ok := c.Get(apiKeyContextKey)
+	return !ok || key.(*APIKey).allows(symbol)
+}
+
+// Drop symbols outside the request's API key scope from a list response
+func filterForAPIKey(c *gin.Context, bitcoins []Bitcoin) []Bitcoin {
+	if _, ok := c.Get(apiKeyContextKey); !ok {
+		return bitcoins
+	}
+	filtered := []Bitcoin{}
+	for _, b := range bitcoins {
+		if apiKeyAllows(c, b.Symbol) {
+			filtered = append(filtered, b)
+		}
+	}
+	return filtered
+}
+
+// Guard admin routes with a static bearer token (ADMIN_TOKEN). With no token
+// configured the guarded routes are disabled entirely.
+func adminAuthMiddleware(token string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if checkAdminToken(c, token) {
+			c.Next()
+		}
+	}
+}
+
+// Whether the request carries the admin token; if not, it is aborted with 403 (no
+// token configured) or 401. For public routes with admin-only options.
+func checkAdminToken(c *gin.Context, token string) bool {
+	if token == "" {
+		c.AbortWithStatusJSON(http.StatusForbidden, errorJSON(CodeForbidden, "Admin API disabled (ADMIN_TOKEN not set)"))
+		return false
+	}
+	presented := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(presented), []byte(token)) != 1 {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, errorJSON(CodeUnauthorized, "Invalid admin token"))
+		return false
+	}
+	return true
+}
@@ -0,0 +1,406 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/redis/go-redis/v9"
+	"github.com/shopspring/decimal"
+)
+
+// Most symbols one POST /api/bitcoins/batch may ask for
+const maxBatchGetSymbols = 100
+
+// Symbols per MGET and per ANY($1) query in a batch read (BATCH_READ_CHUNK_SIZE).
+// Without a bound a large batch becomes one huge command that blocks Redis for
+// everyone else.
+const defaultBatchReadChunkSize = 500
+
+// BATCH READ-THROUGH: One MGET per chunk of symbols, then one DB query per chunk of
+// misses. Symbols that don't exist are absent from the returned map.
+func (cs *CacheService) GetBitcoinsBatch(ctx context.Context, symbols []string) (map[string]Bitcoin, error) {
+	if cs.cacheDisabled() {
+		return cs.queryBitcoins(ctx, symbols)
+	}
+
+	found, misses := cs.getCachedBatch(ctx, symbols)
+	if len(misses) == 0 {
+		return found, nil
+	}
+
+	loaded, err := cs.queryBitcoins(ctx, misses)
+	if err != nil {
+		return nil, err
+	}
+	for symbol, b := range loaded {
+		found[symbol] = b
+	}
+
+	// Backfill the cache (and negative-cache unknown symbols) in one round trip
+	_, err = cs.redisClient.Pipelined(cs.ctx, func(pipe redis.Pipeliner) error {
+		for _, symbol := range misses {
+			b, ok := loaded[symbol]
+			if !ok {
+				pipe.Set(cs.ctx, cs.getBitcoinCacheKey(symbol), negativeCacheSentinel, cs.ttlFor(keyKindNegative, 1))
+				continue
+			}
+			data, err := cs.encodeCacheValue(b)
+			if err != nil {
+				cs.marshalFailed(ctx, cs.getBitcoinCacheKey(symbol), err)
+				continue
+			}
+			pipe.Set(cs.ctx, cs.getBitcoinCacheKey(symbol), data, cs.bitcoinTTL(symbol))
+		}
+		return nil
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "caching batch failed", "error", err)
+	}
+
+	return found, nil
+}
+
+// Serve a batch only if every symbol is cached; ErrCacheOnlyMiss otherwise. Symbols
+// cached as not found are absent from the returned map.
+func (cs *CacheService) GetBitcoinsBatchCacheOnly(ctx context.Context, symbols []string) (map[string]Bitcoin, error) {
+	found, misses := cs.getCachedBatch(ctx, symbols)
+	if len(misses) > 0 {
+		return nil, ErrCacheOnlyMiss
+	}
+	return found, nil
+}
+
+// One MGET per chunk of symbols. Returns the cached records and the symbols still to
+// be read: those not cached or unreadable. Symbols cached as not found are in neither.
+func (cs *CacheService) getCachedBatch(ctx context.Context, symbols []string) (map[string]Bitcoin, []string) {
+	found := make(map[string]Bitcoin, len(symbols))
+	if len(symbols) == 0 {
+		return found, nil
+	}
+
+	keys := make([]string, len(symbols))
+	for i, symbol := range symbols {
+		keys[i] = cs.getBitcoinCacheKey(symbol)
+	}
+
+	var misses []string
+	for start := 0; start < len(symbols); start += cs.batchReadChunkSize {
+		end := min(start+cs.batchReadChunkSize, len(symbols))
+		values, err := cs.redisClient.MGet(ctx, keys[start:end]...).Result()
+		if err != nil {
+			slog.ErrorContext(ctx, "reading batch from cache failed", "error", err)
+			misses = append(misses, symbols[start:end]...)
+			continue
+		}
+		for i, value := range values {
+			symbol := symbols[start+i]
+			cached, ok := value.(string)
+			if !ok {
+				misses = append(misses, symbol)
+				continue
+			}
+			if cached == negativeCacheSentinel {
+				continue
+			}
+
+			var bitcoin Bitcoin
+			if err := decodeCacheValue([]byte(cached), &bitcoin); err != nil {
+				slog.ErrorContext(ctx, "unmarshaling cached bitcoin failed", "symbol", symbol, "error", err)
+				misses = append(misses, symbol)
+				continue
+			}
+			found[symbol] = bitcoin
+		}
+	}
+
+	slog.InfoContext(ctx, "batch cache lookup", "hits", len(symbols)-len(misses), "misses", len(misses))
+	cs.metrics.cacheLookups("batch", len(symbols)-len(misses), len(misses))
+	return found, misses
+}
+
+// Read the given symbols from the database, bypassing the cache: one query per
+// batchReadChunkSize symbols
+func (cs *CacheService) queryBitcoins(ctx context.Context, symbols []string) (map[string]Bitcoin, error) {
+	loaded := make(map[string]Bitcoin, len(symbols))
+	for start := 0; start < len(symbols); start += cs.batchReadChunkSize {
+		if err := cs.queryBitcoinsChunk(ctx, symbols[start:min(start+cs.batchReadChunkSize, len(symbols))], loaded); err != nil {
+			return nil, err
+		}
+	}
+	return loaded, nil
+}
+
+// Read one chunk of symbols from the database into loaded, bypassing the cache
+func (cs *CacheService) queryBitcoinsChunk(ctx context.Context, symbols []string, loaded map[string]Bitcoin) error {
+	defer cs.observeDB("batch_get", time.Now())
+
+	rows, err := cs.db.QueryContext(ctx, `
+		SELECT symbol, price, supply, created_at, updated_at
+		FROM bitcoins
+		WHERE symbol = ANY($1) AND deleted_at IS NULL
+	`, pq.Array(symbols))
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var b Bitcoin
+		if err := rows.Scan(&b.Symbol, &b.Price, &b.Supply, &b.CreatedAt, &b.UpdatedAt); err != nil {
+			return fmt.Errorf("scan error: %w", err)
+		}
+		loaded[b.Symbol] = b
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("scan error: %w", err)
+	}
+	return nil
+}
+
+// Returned for a batch write with no entries, which callers should reject up front
+var ErrEmptyBatch = errors.New("batch has no entries")
+
+// How SetBitcoinsBatch treats a symbol that appears more than once (BATCH_DUPLICATES)
+const (
+	batchDuplicatesLastWins = "last-wins" // The last occurrence's values are written
+	batchDuplicatesReject   = "reject"    // The whole batch is rejected
+)
+
+// One entry of a batch write
+type BitcoinInput struct {
+	Symbol string           `json:"symbol" binding:"required"`
+	Price  *decimal.Decimal `json:"price" binding:"required"`
+	Supply *float64         `json:"supply" binding:"omitempty,gte=0"`
+}
+
+// Returned when BATCH_DUPLICATES=reject and a batch repeats symbols
+type DuplicateSymbolsError struct {
+	Symbols []string
+}
+
+func (e *DuplicateSymbolsError) Error() string {
+	return fmt.Sprintf("duplicate symbols in batch: %s", strings.Join(e.Symbols, ", "))
+}
+
+// Collapse repeated symbols so the multi-row upsert never touches a row twice (Postgres
+// rejects that). Last one wins: a repeated symbol keeps its first position but takes
+// its last values. With batchDuplicatesReject any repeat is a *DuplicateSymbolsError.
+func dedupeBatch(items []BitcoinInput, policy string) ([]BitcoinInput, error) {
+	index := make(map[string]int, len(items))
+	deduped := make([]BitcoinInput, 0, len(items))
+	var duplicates []string
+
+	for _, item := range items {
+		i, seen := index[item.Symbol]
+		if !seen {
+			index[item.Symbol] = len(deduped)
+			deduped = append(deduped, item)
+			continue
+		}
+		if !slices.Contains(duplicates, item.Symbol) {
+			duplicates = append(duplicates, item.Symbol)
+		}
+		deduped[i] = item
+	}
+
+	if len(duplicates) > 0 && policy == batchDuplicatesReject {
+		sort.Strings(duplicates)
+		return nil, &DuplicateSymbolsError{Symbols: duplicates}
+	}
+	return deduped, nil
+}
+
+// BATCH WRITE-THROUGH: Upsert every item in one statement (all or nothing), then cache
+// the results in one pipeline and invalidate derived caches once for the whole batch.
+// Returns the written records in request order, duplicates collapsed per dedupeBatch.
+func (cs *CacheService) SetBitcoinsBatch(ctx context.Context, items []BitcoinInput) ([]Bitcoin, error) {
+	if cs.readOnly {
+		return nil, ErrReadOnly
+	}
+	if len(items) == 0 {
+		return nil, ErrEmptyBatch
+	}
+
+	items, err := dedupeBatch(items, cs.batchDuplicates)
+	if err != nil {
+		return nil, err
+	}
+	return cs.upsertBatch(ctx, items)
+}
+
+// Upsert already-deduplicated items in one statement and write them through to the cache
+func (cs *CacheService) upsertBatch(ctx context.Context, items []BitcoinInput) ([]Bitcoin, error) {
+	if len(items) == 0 {
+		return nil, ErrEmptyBatch
+	}
+
+	symbols := make([]string, len(items))
+	prices := make([]string, len(items))
+	supplies := make([]*float64, len(items))
+	for i, item := range items {
+		symbols[i] = item.Symbol
+		prices[i] = item.Price.String()
+		supplies[i] = item.Supply
+	}
+
+	start := time.Now()
+	rows, err := cs.db.QueryContext(ctx, `
+		WITH input AS (
+			SELECT * FROM unnest($1::text[], $2::numeric[], $3::float8[]) AS t(symbol, price, supply)
+		), prev AS (
+			SELECT b.symbol, b.price FROM bitcoins b JOIN input i ON i.symbol = b.symbol
+			WHERE b.deleted_at IS NULL
+		), upserted AS (
+			INSERT INTO bitcoins (symbol, price, supply)
+			SELECT symbol, price, supply FROM input
+			ON CONFLICT (symbol)
+			DO UPDATE SET price = EXCLUDED.price, supply = COALESCE(EXCLUDED.supply, bitcoins.supply), updated_at = CURRENT_TIMESTAMP,
+				created_at = `+recreatedCreatedAt+`, deleted_at = NULL
+			RETURNING symbol, price, supply, created_at, updated_at
+		), history AS (
+			INSERT INTO bitcoin_price_history (symbol, price, recorded_at)
+			SELECT symbol, price, updated_at FROM upserted
+		)
+		SELECT u.symbol, u.price, u.supply, u.created_at, u.updated_at, p.price
+		FROM upserted u
+		LEFT JOIN prev p ON p.symbol = u.symbol
+	`, pq.Array(symbols), pq.Array(prices), pq.Array(supplies))
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	defer rows.Close()
+
+	written := make(map[string]Bitcoin, len(items))
+	previousPrices := make(map[string]*decimal.Decimal, len(items))
+	for rows.Next() {
+		var b Bitcoin
+		var previousPrice *decimal.Decimal
+		if err := rows.Scan(&b.Symbol, &b.Price, &b.Supply, &b.CreatedAt, &b.UpdatedAt, &previousPrice); err != nil {
+			return nil, fmt.Errorf("scan error: %w", err)
+		}
+		written[b.Symbol] = b
+		previousPrices[b.Symbol] = previousPrice
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	cs.observeDB("batch_upsert", start)
+
+	bitcoins := make([]Bitcoin, 0, len(items))
+	for _, symbol := range symbols {
+		bitcoins = append(bitcoins, written[symbol])
+	}
+
+	cs.writeThroughCacheBatch(ctx, bitcoins)
+	for i := range bitcoins {
+		b := &bitcoins[i]
+		cs.publishChange(ctx, ChangeEvent{Type: eventTypeUpdate, Symbol: b.Symbol, Bitcoin: b, PreviousPrice: previousPrices[b.Symbol]})
+		cs.publishInvalidation(ctx, b.Symbol, eventTypeUpdate)
+	}
+
+	slog.InfoContext(ctx, "batch write-through completed", "symbols", len(bitcoins))
+	return bitcoins, nil
+}
+
+// writeThroughCache for many records: one pipeline for the records and rankings
+// entries, and a single derived-cache invalidation
+func (cs *CacheService) writeThroughCacheBatch(ctx context.Context, bitcoins []Bitcoin) {
+	for _, b := range bitcoins {
+		cs.l1.evict(b.Symbol)
+	}
+	if cs.cacheWritesDisabled() {
+		return
+	}
+	payloads := make(map[string][]byte, len(bitcoins))
+	symbolKeys := make([]string, 0, len(bitcoins))
+	for _, b := range bitcoins {
+		data, err := cs.encodeCacheValue(b)
+		if err != nil {
+			cs.marshalFailed(ctx, cs.getBitcoinCacheKey(b.Symbol), err)
+		} else {
+			payloads[b.Symbol] = data
+		}
+		symbolKeys = append(symbolKeys, cs.symbolDerivedKeys(b.Symbol)...)
+	}
+
+	write := func(ctx context.Context, pipe redis.Pipeliner) {
+		for i, b := range bitcoins {
+			if data, ok := payloads[b.Symbol]; ok {
+				pipe.Set(ctx, cs.getBitcoinCacheKey(b.Symbol), data, cs.bitcoinTTL(b.Symbol))
+			} else {
+				pipe.Del(ctx, cs.getBitcoinCacheKey(b.Symbol))
+			}
+			setRankEntry(ctx, pipe, &bitcoins[i])
+		}
+	}
+
+	cmds, err := cs.redisClient.Pipelined(cs.ctx, func(pipe redis.Pipeliner) error {
+		write(cs.ctx, pipe)
+		for i := range bitcoins {
+			cs.updateExtremes(pipe, &bitcoins[i])
+		}
+		return nil
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "caching batch write failed", "error", err)
+		cs.evictFailedCommands(ctx, cmds)
+	}
+
+	cs.invalidateDerived(ctx, symbolKeys...)
+
+	cs.mirror(func(ctx context.Context, pipe redis.Pipeliner) {
+		write(ctx, pipe)
+		pipe.Del(ctx, derivedKeysWith(symbolKeys...)...)
+	})
+}
+
+// Commands in a pipeline fail independently, so a batch can leave some keys updated
+// and others holding the previous value. Drop whatever a failed command should have
+// written so reads fall through to the DB, which already has the batch. A failed
+// rankings entry means the sorted set is rebuilt from the DB (or, failing that,
+// removed, which sends rankings reads to the DB too).
+func (cs *CacheService) evictFailedCommands(ctx context.Context, cmds []redis.Cmder) {
+	var keys []string
+	rankingsFailed := false
+	for _, cmd := range cmds {
+		if err := cmd.Err(); err == nil || err == redis.Nil {
+			continue
+		}
+		cs.metrics.pipelineFailures.WithLabelValues(cmd.Name()).Inc()
+
+		args := cmd.Args()
+		switch cmd.Name() {
+		case "zadd", "zrem":
+			rankingsFailed = true
+		case "eval", "evalsha":
+			// eval <script> <numkeys> <key>
+			keys = append(keys, fmt.Sprint(args[3]))
+		default:
+			keys = append(keys, fmt.Sprint(args[1]))
+		}
+	}
+	slog.WarnContext(ctx, "batch cache write partially failed, evicting keys", "keys", len(keys), "rankings_failed", rankingsFailed)
+
+	if len(keys) > 0 {
+		if err := cs.redisClient.Del(cs.ctx, keys...).Err(); err != nil {
+			slog.ErrorContext(ctx, "evicting keys after failed batch cache write failed", "error", err)
+		}
+	}
+	// The batch is already committed, so the rebuild uses the service's context and
+	// isn't cut short by the request ending
+	if rankingsFailed {
+		if _, err := cs.refreshRankingsSortedSet(cs.ctx); err != nil {
+			slog.ErrorContext(ctx, "rebuilding rankings sorted set after failed batch cache write failed", "error", err)
+			if err := cs.redisClient.Del(cs.ctx, rankSortedSetKey).Err(); err != nil {
+				slog.ErrorContext(ctx, "removing rankings sorted set failed", "error", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/lib/pq"
+	"github.com/shopspring/decimal"
+)
+
+func TestSetBitcoinsBatchRejectsEmpty(t *testing.T) {
+	cs := newTestCacheService(t)
+	for name, items := range map[string][]BitcoinInput{"empty": {}, "nil": nil} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := cs.SetBitcoinsBatch(context.Background(), items); !errors.Is(err, ErrEmptyBatch) {
+				t.Fatalf("err = %v, want ErrEmptyBatch", err)
+			}
+			if _, err := cs.upsertBatch(context.Background(), items); !errors.Is(err, ErrEmptyBatch) {
+				t.Fatalf("upsertBatch err = %v, want ErrEmptyBatch", err)
+			}
+		})
+	}
+	if code := codeFor(ErrEmptyBatch); code != CodeValidationFailed {
+		t.Errorf("codeFor(ErrEmptyBatch) = %s, want %s", code, CodeValidationFailed)
+	}
+}
+
+func TestGetBitcoinsBatchChunksReads(t *testing.T) {
+	var queried [][]string
+	cs, fr, fdb := newFakeBackedCacheService(t, func(query string, args []driver.Value) (fakeResult, error) {
+		symbols := fakeArrayArg(args[0])
+		queried = append(queried, symbols)
+		res := fakeResult{columns: bitcoinColumns}
+		for _, symbol := range symbols {
+			if symbol != "E" { // E doesn't exist
+				res.rows = append(res.rows, bitcoinRow(symbol, "10"))
+			}
+		}
+		return res, nil
+	})
+	cs.batchReadChunkSize = 2
+
+	for _, symbol := range []string{"A", "B"} {
+		data, _ := cs.encodeCacheValue(Bitcoin{Symbol: symbol, CreatedAt: testTime, UpdatedAt: testTime})
+		fr.set(cs.getBitcoinCacheKey(symbol), string(data))
+	}
+
+	found, err := cs.GetBitcoinsBatch(context.Background(), []string{"A", "B", "C", "D", "E"})
+	if err != nil {
+		t.Fatalf("GetBitcoinsBatch: %v", err)
+	}
+
+	if len(found) != 4 {
+		t.Errorf("found %d records, want 4: %v", len(found), found)
+	}
+	for _, symbol := range []string{"A", "B", "C", "D"} {
+		if found[symbol].Symbol != symbol {
+			t.Errorf("%s missing from result", symbol)
+		}
+	}
+	if _, ok := found["E"]; ok {
+		t.Error("unknown symbol E in result")
+	}
+
+	// Five symbols in chunks of two: three MGETs, and the three misses in two queries
+	if n := fr.count("MGET"); n != 3 {
+		t.Errorf("%d MGETs, want 3", n)
+	}
+	if n := fdb.count("ANY($1)"); n != 2 {
+		t.Errorf("%d queries, want 2", n)
+	}
+	if !reflect.DeepEqual(queried, [][]string{{"C", "D"}, {"E"}}) {
+		t.Errorf("queried %v, want [[C D] [E]]", queried)
+	}
+	for _, symbol := range []string{"C", "D"} {
+		if !fr.exists(cs.getBitcoinCacheKey(symbol)) {
+			t.Errorf("%s not backfilled", symbol)
+		}
+	}
+	if v, _ := fr.get(cs.getBitcoinCacheKey("E")); v != negativeCacheSentinel {
+		t.Errorf("E cached as %q, want the not-found marker", v)
+	}
+}
+
+func TestGetBitcoinsBatchEmpty(t *testing.T) {
+	cs, fr, fdb := newFakeBackedCacheService(t, nil)
+	for name, symbols := range map[string][]string{"empty": {}, "nil": nil} {
+		t.Run(name, func(t *testing.T) {
+			found, err := cs.GetBitcoinsBatch(context.Background(), symbols)
+			if err != nil || found == nil || len(found) != 0 {
+				t.Fatalf("GetBitcoinsBatch = %v, %v; want an empty result", found, err)
+			}
+		})
+	}
+	if n := fr.count("MGET"); n != 0 {
+		t.Errorf("%d MGETs for no symbols, want none", n)
+	}
+	if n := len(fdb.queries); n != 0 {
+		t.Errorf("%d queries for no symbols, want none", n)
+	}
+}
+
+// Cache-only batch reads never query the database: all cached (or cached as not
+// found) is served, anything else is ErrCacheOnlyMiss
+func TestGetBitcoinsBatchCacheOnly(t *testing.T) {
+	cs, fr, fdb := newFakeBackedCacheService(t, nil)
+	data, _ := cs.encodeCacheValue(Bitcoin{Symbol: "A", Price: decimalPtr("10"), CreatedAt: testTime, UpdatedAt: testTime})
+	fr.set(cs.getBitcoinCacheKey("A"), string(data))
+	fr.set(cs.getBitcoinCacheKey("GONE"), negativeCacheSentinel)
+
+	found, err := cs.GetBitcoinsBatchCacheOnly(context.Background(), []string{"A", "GONE"})
+	if err != nil || len(found) != 1 || found["A"].Price == nil || found["A"].Price.String() != "10" {
+		t.Fatalf("GetBitcoinsBatchCacheOnly = %v, %v; want only A", found, err)
+	}
+	if _, err := cs.GetBitcoinsBatchCacheOnly(context.Background(), []string{"A", "B"}); !errors.Is(err, ErrCacheOnlyMiss) {
+		t.Errorf("with B not cached err = %v, want ErrCacheOnlyMiss", err)
+	}
+	if n := len(fdb.queries); n != 0 {
+		t.Errorf("%d queries, want none", n)
+	}
+}
+
+// A fake upsert that, like Postgres, refuses to touch a row twice in one statement
+func upsertingDB() fakeHandler {
+	return func(query string, args []driver.Value) (fakeResult, error) {
+		if !strings.Contains(query, "ON CONFLICT (symbol)") {
+			return fakeResult{}, nil
+		}
+		symbols, prices := fakeArrayArg(args[0]), fakeArrayArg(args[1])
+		res := fakeResult{columns: append(bitcoinColumns, "previous_price")}
+		seen := map[string]bool{}
+		for i, symbol := range symbols {
+			if seen[symbol] {
+				return fakeResult{}, &pq.Error{Code: "21000", Message: "ON CONFLICT DO UPDATE command cannot affect row a second time"}
+			}
+			seen[symbol] = true
+			res.rows = append(res.rows, append(bitcoinRow(symbol, prices[i]), nil))
+		}
+		return res, nil
+	}
+}
+
+var duplicatedBatch = []BitcoinInput{
+	{Symbol: "BTC", Price: decimalPtr("1")},
+	{Symbol: "ETH", Price: decimalPtr("2")},
+	{Symbol: "BTC", Price: decimalPtr("3")},
+}
+
+func decimalPtr(s string) *decimal.Decimal {
+	d := decimal.RequireFromString(s)
+	return &d
+}
+
+func TestSetBitcoinsBatchDuplicatesLastWins(t *testing.T) {
+	cs, fr, fdb := newFakeBackedCacheService(t, upsertingDB())
+
+	written, err := cs.SetBitcoinsBatch(context.Background(), duplicatedBatch)
+	if err != nil {
+		t.Fatalf("SetBitcoinsBatch: %v", err)
+	}
+
+	// BTC keeps its first position but takes its last price
+	var got []string
+	for _, b := range written {
+		got = append(got, b.Symbol+"="+b.Price.String())
+	}
+	if want := []string{"BTC=3", "ETH=2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("written %v, want %v", got, want)
+	}
+	if n := fdb.count("ON CONFLICT (symbol)"); n != 1 {
+		t.Errorf("%d upserts, want 1", n)
+	}
+
+	cached, _ := fr.get(cs.getBitcoinCacheKey("BTC"))
+	var btc Bitcoin
+	if err := decodeCacheValue([]byte(cached), &btc); err != nil {
+		t.Fatalf("decoding cached BTC: %v", err)
+	}
+	if btc.Price == nil || btc.Price.String() != "3" {
+		t.Errorf("cached BTC price %v, want 3", btc.Price)
+	}
+}
+
+func TestSetBitcoinsBatchDuplicatesReject(t *testing.T) {
+	cs, _, fdb := newFakeBackedCacheService(t, upsertingDB())
+	cs.batchDuplicates = batchDuplicatesReject
+
+	items := append(duplicatedBatch, BitcoinInput{Symbol: "ETH", Price: decimalPtr("4")})
+	_, err := cs.SetBitcoinsBatch(context.Background(), items)
+
+	var duplicates *DuplicateSymbolsError
+	if !errors.As(err, &duplicates) {
+		t.Fatalf("err = %v, want *DuplicateSymbolsError", err)
+	}
+	if want := []string{"BTC", "ETH"}; !reflect.DeepEqual(duplicates.Symbols, want) {
+		t.Errorf("duplicate symbols %v, want %v", duplicates.Symbols, want)
+	}
+	if code := codeFor(err); code != CodeValidationFailed {
+		t.Errorf("codeFor = %s, want %s", code, CodeValidationFailed)
+	}
+	if n := len(fdb.queries); n != 0 {
+		t.Errorf("%d database queries, want none for a rejected batch", n)
+	}
+}
+
+// Regression: repeated symbols reaching the multi-row upsert fail the whole batch in
+// Postgres, which is why SetBitcoinsBatch collapses them first
+func TestUpsertBatchWithDuplicatesFailsInPostgres(t *testing.T) {
+	cs, _, _ := newFakeBackedCacheService(t, upsertingDB())
+
+	_, err := cs.upsertBatch(context.Background(), duplicatedBatch)
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) || pqErr.Code != "21000" {
+		t.Fatalf("upsertBatch err = %v, want the ON CONFLICT cardinality error", err)
+	}
+	if _, err := cs.SetBitcoinsBatch(context.Background(), duplicatedBatch); err != nil {
+		t.Errorf("SetBitcoinsBatch with the same items: %v", err)
+	}
+}
+
+// Fail the pipelined command named cmd when it targets key
+func failCommandOn(fr *fakeRedis, cmd, key string) {
+	fr.failCommands(func(args []string) error {
+		if strings.EqualFold(args[0], cmd) && len(args) > 1 && args[1] == key {
+			return errors.New("OOM command not allowed when used memory > 'maxmemory'")
+		}
+		return nil
+	})
+}
+
+func batchOf(prices map[string]string, symbols ...string) []Bitcoin {
+	bitcoins := make([]Bitcoin, len(symbols))
+	for i, symbol := range symbols {
+		bitcoins[i] = Bitcoin{Symbol: symbol, Price: decimalPtr(prices[symbol]), CreatedAt: testTime, UpdatedAt: testTime}
+	}
+	return bitcoins
+}
+
+// One SET failing mid-pipeline leaves the others written and drops the failed key
+// rather than leaving its pre-batch value behind
+func TestWriteThroughCacheBatchEvictsFailedSet(t *testing.T) {
+	cs, fr, _ := newFakeBackedCacheService(t, nil)
+	for _, symbol := range []string{"A", "B", "C"} {
+		fr.set(cs.getBitcoinCacheKey(symbol), "stale")
+	}
+	failCommandOn(fr, "SET", cs.getBitcoinCacheKey("B"))
+
+	cs.writeThroughCacheBatch(context.Background(), batchOf(map[string]string{"A": "1", "B": "2", "C": "3"}, "A", "B", "C"))
+
+	for symbol, want := range map[string]string{"A": "1", "C": "3"} {
+		cached, _ := fr.get(cs.getBitcoinCacheKey(symbol))
+		var b Bitcoin
+		if err := decodeCacheValue([]byte(cached), &b); err != nil || b.Price == nil || b.Price.String() != want {
+			t.Errorf("%s cached as %q, want price %s", symbol, cached, want)
+		}
+	}
+	if fr.exists(cs.getBitcoinCacheKey("B")) {
+		t.Error("B kept its pre-batch value after its SET failed")
+	}
+	if n := counterValue(t, cs.metrics.pipelineFailures.WithLabelValues("set")); n != 1 {
+		t.Errorf("set pipeline failures = %v, want 1", n)
+	}
+}
+
+// A failed rankings entry with no way to rebuild the sorted set removes it, so
+// rankings reads go to the database instead of missing the batch's prices
+func TestWriteThroughCacheBatchDropsSortedSetOnFailedZAdd(t *testing.T) {
+	cs, fr, _ := newFakeBackedCacheService(t, func(query string, args []driver.Value) (fakeResult, error) {
+		return fakeResult{}, errors.New("connection refused")
+	})
+	fr.zadd(rankSortedSetKey, 100, "A")
+	failCommandOn(fr, "ZADD", rankSortedSetKey)
+
+	cs.writeThroughCacheBatch(context.Background(), batchOf(map[string]string{"A": "1", "B": "2"}, "A", "B"))
+
+	if fr.exists(rankSortedSetKey) {
+		t.Error("sorted set kept stale scores after its ZADD failed")
+	}
+	if n := counterValue(t, cs.metrics.pipelineFailures.WithLabelValues("zadd")); n != 2 {
+		t.Errorf("zadd pipeline failures = %v, want 2", n)
+	}
+	if !fr.exists(cs.getBitcoinCacheKey("A")) || !fr.exists(cs.getBitcoinCacheKey("B")) {
+		t.Error("records not cached alongside the failed rankings entries")
+	}
+}
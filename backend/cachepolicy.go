@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/redis/go-redis/v9"
+)
+
+// How often each instance reloads policies and tag membership, so changes made
+// through another replica (or new tag assignments) take effect
+const defaultCachePolicyRefreshInterval = 30 * time.Second
+
+// A record TTL applied to a group of symbols: either every symbol carrying Tag or an
+// explicit Symbols list. When several policies match a symbol, an explicit list beats
+// a tag, and among policies of the same kind the shortest TTL wins.
+type CachePolicy struct {
+	Name       string    `json:"name"`
+	Tag        *string   `json:"tag,omitempty"`
+	Symbols    []string  `json:"symbols,omitempty"`
+	TTLSeconds int       `json:"ttl_seconds"`
+	Backfill   bool      `json:"backfill"` // Re-expire records already cached when the policy is saved
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+func (p *CachePolicy) ttl() time.Duration {
+	return time.Duration(p.TTLSeconds) * time.Second
+}
+
+// Whether p takes precedence over q for a symbol both match
+func (p *CachePolicy) outranks(q *CachePolicy) bool {
+	if (p.Tag == nil) != (q.Tag == nil) {
+		return p.Tag == nil
+	}
+	if p.TTLSeconds != q.TTLSeconds {
+		return p.TTLSeconds < q.TTLSeconds
+	}
+	return p.Name < q.Name
+}
+
+// The winning policy per symbol, rebuilt whenever policies are reloaded
+type cachePolicies struct {
+	mu       sync.RWMutex
+	bySymbol map[string]*CachePolicy
+}
+
+func (cp *cachePolicies) lookup(symbol string) *CachePolicy {
+	cp.mu.RLock()
+	defer cp.mu.RUnlock()
+	return cp.bySymbol[symbol]
+}
+
+func (cp *cachePolicies) replace(bySymbol map[string]*CachePolicy) {
+	cp.mu.Lock()
+	cp.bySymbol = bySymbol
+	cp.mu.Unlock()
+}
+
+// Create or replace a policy by name and start applying it. With Backfill set, records
+// already cached for the symbols it now governs get the new TTL right away; otherwise
+// they pick it up on their next cache write. Returns how many were re-expired.
+func (cs *CacheService) PutCachePolicy(ctx context.Context, policy CachePolicy) (*CachePolicy, int, error) {
+	if cs.readOnly {
+		return nil, 0, ErrReadOnly
+	}
+
+	var symbols interface{}
+	if policy.Symbols != nil {
+		symbols = pq.Array(policy.Symbols)
+	}
+	err := cs.db.QueryRowContext(ctx, `
+		INSERT INTO cache_policies (name, tag, symbols, ttl_seconds, backfill)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (name)
+		DO UPDATE SET tag = $2, symbols = $3, ttl_seconds = $4, backfill = $5, updated_at = CURRENT_TIMESTAMP
+		RETURNING updated_at
+	`, policy.Name, policy.Tag, symbols, policy.TTLSeconds, policy.Backfill).Scan(&policy.UpdatedAt)
+	if err != nil {
+		return nil, 0, fmt.Errorf("database error: %w", err)
+	}
+	slog.InfoContext(ctx, "cache policy saved", "policy", policy.Name, "ttl", policy.ttl().String())
+
+	if err := cs.loadCachePolicies(ctx); err != nil {
+		return nil, 0, err
+	}
+
+	backfilled := 0
+	if policy.Backfill {
+		backfilled = cs.backfillCachePolicy(ctx, policy.Name)
+	}
+	return &policy, backfilled, nil
+}
+
+// Returns false if no policy has that name. Records keep their current TTL.
+func (cs *CacheService) DeleteCachePolicy(ctx context.Context, name string) (bool, error) {
+	if cs.readOnly {
+		return false, ErrReadOnly
+	}
+
+	result, err := cs.db.ExecContext(ctx, `DELETE FROM cache_policies WHERE name = $1`, name)
+	if err != nil {
+		return false, fmt.Errorf("database error: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return false, nil
+	}
+
+	slog.InfoContext(ctx, "cache policy deleted", "policy", name)
+	return true, cs.loadCachePolicies(ctx)
+}
+
+func (cs *CacheService) ListCachePolicies(ctx context.Context) ([]CachePolicy, error) {
+	rows, err := cs.db.QueryContext(ctx, `
+		SELECT name, tag, symbols, ttl_seconds, backfill, updated_at
+		FROM cache_policies
+		ORDER BY name
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	defer rows.Close()
+
+	policies := []CachePolicy{}
+	for rows.Next() {
+		var p CachePolicy
+		var symbols pq.StringArray
+		if err := rows.Scan(&p.Name, &p.Tag, &symbols, &p.TTLSeconds, &p.Backfill, &p.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("scan error: %w", err)
+		}
+		if symbols != nil {
+			p.Symbols = symbols
+		}
+		policies = append(policies, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("scan error: %w", err)
+	}
+
+	return policies, nil
+}
+
+// Reload policies and the members of the tags they name, and resolve the winning
+// policy for every symbol they cover
+func (cs *CacheService) loadCachePolicies(ctx context.Context) error {
+	policies, err := cs.ListCachePolicies(ctx)
+	if err != nil {
+		return err
+	}
+
+	bySymbol := make(map[string]*CachePolicy)
+	apply := func(symbol string, p *CachePolicy) {
+		if current, ok := bySymbol[symbol]; !ok || p.outranks(current) {
+			bySymbol[symbol] = p
+		}
+	}
+
+	byTag := make(map[string][]*CachePolicy)
+	for i := range policies {
+		p := &policies[i]
+		if p.Tag != nil {
+			byTag[*p.Tag] = append(byTag[*p.Tag], p)
+			continue
+		}
+		for _, symbol := range p.Symbols {
+			apply(symbol, p)
+		}
+	}
+
+	if len(byTag) > 0 {
+		tags := make([]string, 0, len(byTag))
+		for tag := range byTag {
+			tags = append(tags, tag)
+		}
+
+		rows, err := cs.db.QueryContext(ctx, `SELECT symbol, tag FROM symbol_tags WHERE tag = ANY($1)`, pq.Array(tags))
+		if err != nil {
+			return fmt.Errorf("database error: %w", err)
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var symbol, tag string
+			if err := rows.Scan(&symbol, &tag); err != nil {
+				return fmt.Errorf("scan error: %w", err)
+			}
+			for _, p := range byTag[tag] {
+				apply(symbol, p)
+			}
+		}
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("scan error: %w", err)
+		}
+	}
+
+	cs.cachePolicies.replace(bySymbol)
+	return nil
+}
+
+func (cs *CacheService) RunCachePolicyRefresh(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := cs.loadCachePolicies(ctx); err != nil {
+				slog.ErrorContext(ctx, "reloading cache policies failed", "error", err)
+			}
+		}
+	}
+}
+
+// PEXPIRE a cached record, leaving "not found" markers on their own short TTL
+var expireRecordScript = redis.NewScript(`
+	local value = redis.call("GET", KEYS[1])
+	if not value or value == ARGV[2] then
+		return 0
+	end
+	return redis.call("PEXPIRE", KEYS[1], ARGV[1])
+`)
+
+// Apply the named policy's TTL to the cached records of every symbol it governs.
+// Preloaded symbols are skipped: their refresh keeps them on PRELOAD_CACHE_TTL.
+func (cs *CacheService) backfillCachePolicy(ctx context.Context, name string) int {
+	cs.cachePolicies.mu.RLock()
+	var symbols []string
+	var ttl time.Duration
+	for symbol, p := range cs.cachePolicies.bySymbol {
+		if p.Name == name && !cs.preload[symbol] {
+			symbols = append(symbols, symbol)
+			ttl = p.ttl()
+		}
+	}
+	cs.cachePolicies.mu.RUnlock()
+	sort.Strings(symbols)
+
+	expired := 0
+	for _, symbol := range symbols {
+		n, err := expireRecordScript.Run(cs.ctx, cs.redisClient,
+			[]string{cs.getBitcoinCacheKey(symbol)}, cs.jitterTTL(ttl).Milliseconds(), negativeCacheSentinel).Int()
+		if err != nil {
+			slog.ErrorContext(ctx, "applying cache policy failed", "policy", name, "symbol", symbol, "error", err)
+			continue
+		}
+		expired += n
+	}
+
+	slog.InfoContext(ctx, "cache policy backfilled", "policy", name, "cached", expired, "symbols", len(symbols))
+	return expired
+}
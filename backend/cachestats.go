@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// Keys fetched per SCAN call when counting the keyspace. SCAN walks it in small steps,
+// so unlike KEYS it never blocks Redis for long, however many keys there are.
+const statsScanCount = 1000
+
+// Hits and misses of one kind of lookup since this instance started
+type lookupCounters struct {
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+// Lookups counted for GET /api/cache/stats, by cacheLookup operation: single-symbol
+// reads from L1 and Redis, and the rankings payload
+func newLookupCounters() map[string]*lookupCounters {
+	return map[string]*lookupCounters{
+		"l1":       {},
+		"get":      {},
+		"rankings": {},
+	}
+}
+
+type LookupStats struct {
+	Hits     uint64  `json:"hits"`
+	Misses   uint64  `json:"misses"`
+	HitRatio float64 `json:"hit_ratio"` // 0 before the first lookup
+}
+
+func newLookupStats(hits, misses uint64) LookupStats {
+	stats := LookupStats{Hits: hits, Misses: misses}
+	if total := hits + misses; total > 0 {
+		stats.HitRatio = float64(hits) / float64(total)
+	}
+	return stats
+}
+
+type CacheStats struct {
+	InstanceID string `json:"instance_id"`
+	Since      string `json:"since"` // Counters are per instance, since it started
+	// Keys under the bitcoin: prefix; null while Redis is unreachable
+	Keys        *int64                 `json:"keys"`
+	CacheActive bool                   `json:"cache_active"`
+	Lookups     map[string]LookupStats `json:"lookups"`
+	Total       LookupStats            `json:"total"` // All lookups combined, an L1 miss followed by a Redis hit counting once
+	TTLSeconds  struct {
+		Record   float64 `json:"record"`
+		Rankings float64 `json:"rankings"`
+		Negative float64 `json:"negative"`
+	} `json:"ttl_seconds"`
+	TTLJitter float64 `json:"ttl_jitter"`
+}
+
+// Count a cache lookup in both Prometheus and the in-process counters
+func (cs *CacheService) countCacheLookup(operation string, hit bool) {
+	cs.metrics.cacheLookup(operation, hit)
+	if counters := cs.lookups[operation]; counters != nil {
+		if hit {
+			counters.hits.Add(1)
+		} else {
+			counters.misses.Add(1)
+		}
+	}
+}
+
+func (cs *CacheService) GetCacheStats(ctx context.Context) (*CacheStats, error) {
+	stats := &CacheStats{
+		InstanceID:  cs.instanceID,
+		Since:       startTime.UTC().Format(time.RFC3339),
+		CacheActive: !cs.cacheDisabled(),
+		Lookups:     make(map[string]LookupStats, len(cs.lookups)),
+		TTLJitter:   cs.ttlJitter,
+	}
+	stats.TTLSeconds.Record = cs.cacheTTL.Seconds()
+	stats.TTLSeconds.Rankings = cs.rankingsTTL.Seconds()
+	stats.TTLSeconds.Negative = cs.negativeTTL.Seconds()
+
+	var hits, misses uint64
+	for operation, counters := range cs.lookups {
+		if operation == "l1" && cs.l1 == nil {
+			continue
+		}
+		h, m := counters.hits.Load(), counters.misses.Load()
+		stats.Lookups[operation] = newLookupStats(h, m)
+		hits += h
+		// An L1 miss goes on to Redis and is counted again there
+		if operation != "l1" {
+			misses += m
+		}
+	}
+	stats.Total = newLookupStats(hits, misses)
+
+	if stats.CacheActive {
+		keys, err := cs.countCacheKeys(ctx)
+		if err != nil {
+			return nil, err
+		}
+		stats.Keys = &keys
+	}
+	return stats, nil
+}
+
+// Count the keys under cachePrefix with SCAN
+func (cs *CacheService) countCacheKeys(ctx context.Context) (int64, error) {
+	var count int64
+	var cursor uint64
+	for {
+		keys, next, err := cs.redisClient.Scan(ctx, cursor, cachePrefix+"*", statsScanCount).Result()
+		if err != nil {
+			return 0, fmt.Errorf("cache error: %w", err)
+		}
+		count += int64(len(keys))
+		if next == 0 {
+			return count, nil
+		}
+		cursor = next
+	}
+}
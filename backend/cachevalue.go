@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+)
+
+// Cached JSON values longer than CACHE_COMPRESS_THRESHOLD bytes are stored gzipped.
+// The gzip magic bytes mark a compressed value (JSON never starts with 0x1f), so
+// values written uncompressed, before the setting existed or while it is 0, still
+// read back, and the threshold can be changed one instance at a time.
+const defaultCompressThreshold = 1024
+
+// Marshal v for the cache, compressing it if it's over the threshold
+func (cs *CacheService) encodeCacheValue(v any) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil || cs.compressThreshold <= 0 || len(data) <= cs.compressThreshold {
+		return data, err
+	}
+	var buf bytes.Buffer
+	zw, err := gzip.NewWriterLevel(&buf, gzip.BestSpeed)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := zw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Unmarshal a cached value into v, whether or not it was stored compressed
+func decodeCacheValue(cached []byte, v any) error {
+	if isGzipped(cached) {
+		zr, err := gzip.NewReader(bytes.NewReader(cached))
+		if err != nil {
+			return err
+		}
+		if cached, err = io.ReadAll(zr); err != nil {
+			return err
+		}
+	}
+	return json.Unmarshal(cached, v)
+}
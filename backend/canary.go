@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"math/rand"
+)
+
+// Migration aid for the sorted-set rankings: on a sampled fraction of rankings reads
+// (RANKINGS_CANARY_SAMPLE_RATE), also build the rankings with the source that isn't
+// RANKINGS_SOURCE and compare it with what was served. Clients always get the primary
+// result; the comparison runs in the background. Set the rate to 0 (the default) to
+// remove it from the read path.
+
+// Whether this read is sampled and, if so, the rankings version it starts from. The
+// version must be captured before the served payload is read, so a write landing
+// afterwards is recognised rather than reported as a mismatch.
+func (cs *CacheService) rankingsCanary() (string, bool) {
+	if cs.canarySampleRate <= 0 || rand.Float64() >= cs.canarySampleRate {
+		return "", false
+	}
+	return cs.rankingsVersion(), true
+}
+
+func (cs *CacheService) compareRankingsCanary(version string, served []Bitcoin) {
+	secondarySource := rankingsSourcePostgres
+	build := cs.getBitcoinsRankedFromDB
+	if cs.rankingsSource == rankingsSourcePostgres {
+		secondarySource = rankingsSourceRedis
+		build = cs.buildBitcoinsRanked
+	}
+
+	other, err := build(cs.ctx)
+	if err != nil {
+		slog.ErrorContext(cs.ctx, "rankings canary: building rankings failed", "source", secondarySource, "error", err)
+		cs.metrics.rankingsCanary.WithLabelValues("error").Inc()
+		return
+	}
+
+	// A write since the read (or one still pending invalidation) makes the two
+	// legitimately differ
+	if cs.rankingsVersion() != version || cs.invalidationPending() {
+		cs.metrics.rankingsCanary.WithLabelValues("skipped").Inc()
+		return
+	}
+
+	if i := firstRankingDifference(served, other); i >= 0 {
+		slog.WarnContext(cs.ctx, "rankings canary mismatch", "rank", i+1, "served_source", cs.rankingsSource, "served_entries", len(served), "served", describeRank(served, i), "canary_source", secondarySource, "canary_entries", len(other), "canary", describeRank(other, i))
+		cs.metrics.rankingsCanary.WithLabelValues("mismatch").Inc()
+		return
+	}
+	cs.metrics.rankingsCanary.WithLabelValues("match").Inc()
+}
+
+// Index of the first position whose symbol or price differs, or -1 if none do
+func firstRankingDifference(a, b []Bitcoin) int {
+	for i := 0; i < len(a) || i < len(b); i++ {
+		if i >= len(a) || i >= len(b) || a[i].Symbol != b[i].Symbol || !samePrice(a[i].Price, b[i].Price) {
+			return i
+		}
+	}
+	return -1
+}
+
+func describeRank(bitcoins []Bitcoin, i int) string {
+	if i >= len(bitcoins) {
+		return "<none>"
+	}
+	return fmt.Sprintf("%s@%s", bitcoins[i].Symbol, formatPrice(bitcoins[i].Price))
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"fmt"
+)
+
+// How fresh a read must be: eventual reads may be served from cache, strong reads
+// always go to PostgreSQL and refresh the cache with what they find
+type consistencyLevel string
+
+const (
+	consistencyEventual consistencyLevel = "eventual"
+	consistencyStrong   consistencyLevel = "strong"
+)
+
+// Parse the ?consistency= query param; empty means eventual
+func parseConsistency(value string) (consistencyLevel, error) {
+	switch consistencyLevel(value) {
+	case "", consistencyEventual:
+		return consistencyEventual, nil
+	case consistencyStrong:
+		return consistencyStrong, nil
+	default:
+		return "", fmt.Errorf("consistency must be %s or %s", consistencyEventual, consistencyStrong)
+	}
+}
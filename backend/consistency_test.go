@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+)
+
+func TestParseConsistency(t *testing.T) {
+	for _, tc := range []struct {
+		value   string
+		want    consistencyLevel
+		wantErr bool
+	}{
+		{"", consistencyEventual, false},
+		{"eventual", consistencyEventual, false},
+		{"strong", consistencyStrong, false},
+		{"STRONG", "", true},
+		{"linearizable", "", true},
+	} {
+		got, err := parseConsistency(tc.value)
+		if (err != nil) != tc.wantErr || got != tc.want {
+			t.Errorf("parseConsistency(%q) = %q, %v; want %q (error: %v)", tc.value, got, err, tc.want, tc.wantErr)
+		}
+	}
+}
+
+// The cache holds a stale BTC at 1 while PostgreSQL has 2: eventual reads serve the
+// cache, a strong read goes to the database and refreshes the cache for later reads
+func TestReadConsistencyLevels(t *testing.T) {
+	cs, fr, fdb := newFakeBackedCacheService(t, func(query string, args []driver.Value) (fakeResult, error) {
+		return fakeResult{columns: bitcoinColumns, rows: [][]driver.Value{bitcoinRow("BTC", "2")}}, nil
+	})
+	close(cs.primed)
+	data, _ := cs.encodeCacheValue(Bitcoin{Symbol: "BTC", Price: decimalPtr("1"), CreatedAt: testTime, UpdatedAt: testTime})
+	fr.set(cs.getBitcoinCacheKey("BTC"), string(data))
+
+	read := func(consistency consistencyLevel) string {
+		t.Helper()
+		bitcoin, err := cs.GetBitcoin(context.Background(), "BTC", consistency)
+		if err != nil || bitcoin == nil {
+			t.Fatalf("%s read: %v, %v", consistency, bitcoin, err)
+		}
+		return formatPrice(bitcoin.Price)
+	}
+
+	if price := read(consistencyEventual); price != "1" {
+		t.Errorf("eventual read = %s, want the cached 1", price)
+	}
+	if n := len(fdb.queries); n != 0 {
+		t.Errorf("eventual read ran %d queries, want none", n)
+	}
+
+	if price := read(consistencyStrong); price != "2" {
+		t.Errorf("strong read = %s, want the database's 2", price)
+	}
+	if n := len(fdb.queries); n != 1 {
+		t.Errorf("strong read ran %d queries, want 1", n)
+	}
+
+	if price := read(consistencyEventual); price != "2" {
+		t.Errorf("eventual read after a strong read = %s, want the refreshed 2", price)
+	}
+	if n := len(fdb.queries); n != 1 {
+		t.Errorf("%d queries in total, want 1", n)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	correlationCacheTTL      = 1 * time.Hour
+	maxCorrelationWindow     = 365 // days
+	maxCorrelatedLimit       = 50
+	defaultCorrelatedLimit   = 10
+	minCorrelationOverlap    = 5 // Daily returns both symbols must share to be compared
+	defaultCorrelationDays   = 30
+	correlationMethodPearson = "pearson_daily_returns"
+)
+
+type CorrelatedSymbol struct {
+	Symbol      string  `json:"symbol"`
+	Correlation float64 `json:"correlation"` // Pearson coefficient, -1..1
+	Days        int     `json:"days"`        // Overlapping daily returns it was computed from
+}
+
+// Parse a correlation window like "30d" into days (2..maxCorrelationWindow)
+func parseWindowDays(window string) (int, error) {
+	days, err := strconv.Atoi(strings.TrimSuffix(window, "d"))
+	if err != nil || !strings.HasSuffix(window, "d") || days < 2 || days > maxCorrelationWindow {
+		return 0, fmt.Errorf("window must be a number of days between 2d and %dd", maxCorrelationWindow)
+	}
+	return days, nil
+}
+
+func (cs *CacheService) getCorrelatedCacheKey(symbol string, days int, loc *time.Location) string {
+	return fmt.Sprintf("%scorrelated:%s:%dd:%s", cachePrefix, symbol, days, loc)
+}
+
+// Symbols whose daily returns correlate most with symbol's over the last days days,
+// with days bucketed in loc, strongest positive correlation first. The full top
+// maxCorrelatedLimit list is cached per (symbol, window, zone) and cut down to limit
+// on the way out.
+func (cs *CacheService) GetCorrelatedSymbols(ctx context.Context, symbol string, days, limit int, loc *time.Location) ([]CorrelatedSymbol, error) {
+	cacheKey := cs.getCorrelatedCacheKey(symbol, days, loc)
+
+	var correlated []CorrelatedSymbol
+	start := time.Now()
+	cached, err := cs.redisClient.Get(cs.ctx, cacheKey).Result()
+	if err == nil {
+		if err := decodeCacheValue([]byte(cached), &correlated); err != nil {
+			slog.ErrorContext(ctx, "unmarshaling cached correlations failed", "symbol", symbol, "error", err)
+			correlated = nil
+		} else {
+			cs.logCacheLookup(cs.ctx, "correlation", true, start, "symbol", symbol, "window_days", days)
+		}
+	}
+
+	if correlated == nil {
+		cs.logCacheLookup(cs.ctx, "correlation", false, start, "symbol", symbol, "window_days", days)
+		correlated, err = cs.getCorrelatedSymbolsFromDB(ctx, symbol, days, loc)
+		if err != nil {
+			return nil, err
+		}
+
+		data, err := cs.encodeCacheValue(correlated)
+		if err != nil {
+			slog.ErrorContext(ctx, "marshaling correlations failed", "symbol", symbol, "error", err)
+		} else if err := cs.redisClient.Set(cs.ctx, cacheKey, data, correlationCacheTTL).Err(); err != nil {
+			slog.ErrorContext(ctx, "caching correlations failed", "symbol", symbol, "error", err)
+		}
+	}
+
+	if len(correlated) > limit {
+		correlated = correlated[:limit]
+	}
+	return correlated, nil
+}
+
+// Daily close = last history point of each day in loc (recorded_at is stored as UTC,
+// so it's tagged as UTC and then converted before truncating). A daily return is close / previous
+// close - 1, only between consecutive days, so gaps in the history don't turn into
+// multi-day returns. corr() is Postgres's Pearson correlation over the days both
+// symbols have a return.
+func (cs *CacheService) getCorrelatedSymbolsFromDB(ctx context.Context, symbol string, days int, loc *time.Location) ([]CorrelatedSymbol, error) {
+	defer cs.observeDB("correlated", time.Now())
+
+	rows, err := cs.db.QueryContext(ctx, `
+		WITH daily AS (
+			SELECT DISTINCT ON (symbol, day) symbol, day, price
+			FROM (
+				SELECT id, symbol, price, recorded_at,
+					date_trunc('day', (recorded_at AT TIME ZONE 'UTC') AT TIME ZONE $5) AS day
+				FROM bitcoin_price_history
+				WHERE recorded_at >= date_trunc('day', CURRENT_TIMESTAMP) - make_interval(days => $2)
+			) h
+			ORDER BY symbol, day, recorded_at DESC, id DESC
+		), returns AS (
+			SELECT symbol, day,
+				CASE WHEN LAG(day) OVER w = day - INTERVAL '1 day'
+					THEN price::float8 / NULLIF(LAG(price) OVER w, 0) - 1
+				END AS ret
+			FROM daily
+			WINDOW w AS (PARTITION BY symbol ORDER BY day)
+		)
+		SELECT o.symbol, corr(t.ret, o.ret), COUNT(*)
+		FROM returns t
+		JOIN returns o ON o.day = t.day AND o.symbol <> t.symbol
+		WHERE t.symbol = $1 AND t.ret IS NOT NULL AND o.ret IS NOT NULL
+		GROUP BY o.symbol
+		HAVING COUNT(*) >= $3 AND corr(t.ret, o.ret) IS NOT NULL
+		ORDER BY 2 DESC, o.symbol
+		LIMIT $4
+	`, symbol, days, minCorrelationOverlap, maxCorrelatedLimit, loc.String())
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	defer rows.Close()
+
+	correlated := []CorrelatedSymbol{}
+	for rows.Next() {
+		var c CorrelatedSymbol
+		if err := rows.Scan(&c.Symbol, &c.Correlation, &c.Days); err != nil {
+			return nil, fmt.Errorf("scan error: %w", err)
+		}
+		correlated = append(correlated, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("scan error: %w", err)
+	}
+
+	return correlated, nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var ErrEncryptionDisabled = errors.New("field encryption is not configured (set ENCRYPTION_KEY)")
+
+// fieldCipher encrypts individual sensitive column values with AES-256-GCM.
+// Ciphertext is stored as "<keyID>:<base64(nonce||sealed)>" so values written
+// under an older key stay readable after rotating to a new one.
+type fieldCipher struct {
+	activeKeyID string
+	keys        map[string]cipher.AEAD
+}
+
+// Parse ENCRYPTION_KEY: comma-separated "keyID:base64(32-byte key)" entries.
+// The first entry encrypts new values; the rest are only used to decrypt.
+func newFieldCipher(spec string) (*fieldCipher, error) {
+	fc := &fieldCipher{keys: make(map[string]cipher.AEAD)}
+
+	for _, entry := range strings.Split(spec, ",") {
+		id, encoded, ok := strings.Cut(strings.TrimSpace(entry), ":")
+		if !ok || id == "" {
+			return nil, fmt.Errorf("invalid key entry %q, expected keyID:base64key", entry)
+		}
+		if _, dup := fc.keys[id]; dup {
+			return nil, fmt.Errorf("duplicate key ID %q", id)
+		}
+
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("key %q is not valid base64: %w", id, err)
+		}
+		if len(key) != 32 {
+			return nil, fmt.Errorf("key %q must be 32 bytes (AES-256), got %d", id, len(key))
+		}
+
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", id, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", id, err)
+		}
+
+		fc.keys[id] = aead
+		if fc.activeKeyID == "" {
+			fc.activeKeyID = id
+		}
+	}
+
+	return fc, nil
+}
+
+// Encrypt plaintext bound to context (e.g. the row's symbol) so ciphertext can't be moved between rows
+func (fc *fieldCipher) Encrypt(plaintext, context string) (string, error) {
+	if fc == nil {
+		return "", ErrEncryptionDisabled
+	}
+
+	aead := fc.keys[fc.activeKeyID]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	sealed := aead.Seal(nonce, nonce, []byte(plaintext), []byte(context))
+	return fc.activeKeyID + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func (fc *fieldCipher) Decrypt(ciphertext, context string) (string, error) {
+	if fc == nil {
+		return "", ErrEncryptionDisabled
+	}
+
+	id, encoded, ok := strings.Cut(ciphertext, ":")
+	if !ok {
+		return "", errors.New("malformed ciphertext")
+	}
+	aead, ok := fc.keys[id]
+	if !ok {
+		return "", fmt.Errorf("unknown encryption key ID %q", id)
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return "", errors.New("malformed ciphertext")
+	}
+
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(context))
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt: %w", err)
+	}
+	return string(plaintext), nil
+}
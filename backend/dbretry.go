@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"log/slog"
+	"net"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// A failover or connection reset usually clears within a few hundred milliseconds, so
+// the hot-path queries retry transient errors a few times before failing the request
+// (DB_RETRY_ATTEMPTS, DB_RETRY_BASE_DELAY; the delay doubles per attempt)
+const (
+	defaultDBRetryAttempts  = 3
+	defaultDBRetryBaseDelay = 50 * time.Millisecond
+)
+
+// Errors worth another attempt: the connection was lost or refused, the server is
+// shutting down or starting up, or the transaction lost a serialization conflict or
+// deadlock. Constraint violations and other errors would only fail again.
+func transientDBError(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code {
+		case "57P01", "57P02", "57P03", // admin_shutdown, crash_shutdown, cannot_connect_now
+			"40001", "40P01": // serialization_failure, deadlock_detected
+			return true
+		}
+		return pqErr.Code.Class() == "08" // connection_exception
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var netErr net.Error
+	return errors.Is(err, driver.ErrBadConn) || errors.As(err, &netErr)
+}
+
+// Run fn, retrying transient errors with exponential backoff up to dbRetryAttempts
+// times in all. A retry that couldn't finish before ctx's deadline isn't attempted,
+// so the last error is returned instead of a timeout. fn must be safe to repeat.
+func (cs *CacheService) withDBRetry(ctx context.Context, operation string, fn func() error) error {
+	delay := cs.dbRetryBaseDelay
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= cs.dbRetryAttempts || !transientDBError(err) || ctx.Err() != nil {
+			return err
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			return err
+		}
+
+		slog.WarnContext(ctx, "transient database error, retrying",
+			"operation", operation,
+			"attempt", attempt,
+			"max_attempts", cs.dbRetryAttempts,
+			"delay_ms", latencyMs(delay),
+			"error", err,
+		)
+		cs.metrics.dbRetries.WithLabelValues(operation).Inc()
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		delay *= 2
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// What the cache holds for a symbol, as reported by the debug endpoint
+const (
+	cacheStateHit      = "hit"      // A record
+	cacheStateMissing  = "missing"  // Nothing; the next read goes to the DB
+	cacheStateNegative = "negative" // The not-found marker
+	cacheStateCorrupt  = "corrupt"  // Something that doesn't unmarshal as a record
+)
+
+// Cached and authoritative views of one symbol side by side
+type SymbolDebug struct {
+	Symbol     string   `json:"symbol"`
+	Cache      *Bitcoin `json:"cache"`
+	CacheState string   `json:"cache_state"`
+	TTLSeconds *float64 `json:"ttl_seconds"` // nil when the key is missing or has no expiry
+	DB         *Bitcoin `json:"db"`
+	Divergent  bool     `json:"divergent"` // The cache would serve something other than the DB row
+}
+
+// Read the cache entry and the DB row for a symbol without populating either
+func (cs *CacheService) DebugSymbol(symbol string) (*SymbolDebug, error) {
+	key := cs.getBitcoinCacheKey(symbol)
+	debug := &SymbolDebug{Symbol: symbol}
+
+	cached, err := cs.redisClient.Get(cs.ctx, key).Result()
+	switch {
+	case err == redis.Nil:
+		debug.CacheState = cacheStateMissing
+	case err != nil:
+		return nil, fmt.Errorf("cache error: %w", err)
+	case cached == negativeCacheSentinel:
+		debug.CacheState = cacheStateNegative
+	default:
+		var bitcoin Bitcoin
+		if err := decodeCacheValue([]byte(cached), &bitcoin); err != nil {
+			debug.CacheState = cacheStateCorrupt
+		} else {
+			debug.CacheState = cacheStateHit
+			debug.Cache = &bitcoin
+		}
+	}
+
+	if ttl, err := cs.redisClient.PTTL(cs.ctx, key).Result(); err == nil && ttl > 0 {
+		seconds := ttl.Seconds()
+		debug.TTLSeconds = &seconds
+	}
+
+	debug.DB, err = cs.queryBitcoin(cs.ctx, symbol)
+	if err != nil {
+		return nil, err
+	}
+
+	switch debug.CacheState {
+	case cacheStateHit:
+		debug.Divergent = debug.DB == nil || !sameRecord(debug.Cache, debug.DB)
+	case cacheStateNegative:
+		debug.Divergent = debug.DB != nil
+	case cacheStateCorrupt:
+		debug.Divergent = true
+	}
+
+	return debug, nil
+}
+
+// Compare the stored fields of two records (rank is derived, so it's ignored)
+func sameRecord(a, b *Bitcoin) bool {
+	sameSupply := (a.Supply == nil) == (b.Supply == nil) && (a.Supply == nil || *a.Supply == *b.Supply)
+	return a.Symbol == b.Symbol && samePrice(a.Price, b.Price) && sameSupply &&
+		a.CreatedAt.Equal(b.CreatedAt) && a.UpdatedAt.Equal(b.UpdatedAt)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/redis/go-redis/v9"
+)
+
+// How often the Redis connection is checked (REDIS_HEALTH_INTERVAL)
+const defaultRedisHealthInterval = 5 * time.Second
+
+// ErrCacheDisabled is returned by cache-only operations while Redis is unreachable
+var ErrCacheDisabled = errors.New("cache disabled: redis unavailable")
+
+// Whether the cache is in use (CacheService.redisState)
+const (
+	redisStateUp        int32 = iota
+	redisStateDown            // DB-only: reads and writes skip the cache
+	redisStateResyncing       // Redis is back: writes update the cache again, reads wait for the resync
+)
+
+// DB-only mode. While Redis is unreachable the cache is bypassed entirely: reads go
+// to Postgres and writes skip their cache updates, instead of every request paying
+// for a timed-out Redis round trip (with retries) before falling back.
+func (cs *CacheService) cacheDisabled() bool {
+	return cs.redisState.Load() != redisStateUp
+}
+
+// Writes resume their cache updates as soon as the resync starts: one that still
+// skipped it had already committed, so the resync's query sees it
+func (cs *CacheService) cacheWritesDisabled() bool {
+	return cs.redisState.Load() == redisStateDown
+}
+
+func (cs *CacheService) setRedisState(state int32) {
+	cs.redisState.Store(state)
+	if state == redisStateUp {
+		// Other instances' writes during the outage were never broadcast
+		cs.l1.evict("")
+		cs.metrics.redisUp.Set(1)
+	} else {
+		cs.metrics.redisUp.Set(0)
+	}
+}
+
+// Ping Redis every interval. A failed ping switches to DB-only mode; once Redis
+// answers again the cache is resynced with everything written since the last good
+// ping (those writes never reached it) and only then used again.
+func (cs *CacheService) RunRedisMonitor(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	lastHealthy := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		pingCtx, cancel := context.WithTimeout(ctx, interval)
+		err := cs.redisClient.Ping(pingCtx).Err()
+		cancel()
+
+		switch {
+		case err != nil && !cs.cacheWritesDisabled():
+			slog.WarnContext(ctx, "Redis unreachable, serving from the database only", "error", err)
+			cs.setRedisState(redisStateDown)
+		case err == nil && cs.cacheDisabled():
+			slog.InfoContext(ctx, "Redis reachable again, resyncing the cache")
+			cs.setRedisState(redisStateResyncing)
+			if err := cs.resyncCache(ctx, lastHealthy); err != nil {
+				slog.ErrorContext(ctx, "cache resync failed, staying in DB-only mode", "error", err)
+				cs.setRedisState(redisStateDown)
+				continue
+			}
+			cs.setRedisState(redisStateUp)
+			slog.InfoContext(ctx, "cache resynced, caching resumed")
+			lastHealthy = time.Now()
+		case err == nil:
+			lastHealthy = time.Now()
+		}
+	}
+}
+
+// Bring the cache back in line with the database after an outage: rewrite every
+// record and the rankings sorted set, drop records of symbols deleted meanwhile and
+// the derived keys of every symbol written since, then invalidate derived caches.
+func (cs *CacheService) resyncCache(ctx context.Context, since time.Time) error {
+	listed, err := cs.redisClient.ZRange(cs.ctx, rankSortedSetKey, 0, -1).Result()
+	if err != nil {
+		return fmt.Errorf("failed to read sorted set: %w", err)
+	}
+
+	if err := cs.primeCache(ctx); err != nil {
+		return err
+	}
+	if _, err := cs.refreshRankingsSortedSet(ctx); err != nil {
+		return err
+	}
+
+	// Symbols the cache still lists but the database no longer has (or has tombstoned), and symbols
+	// written since the last good ping (a minute early, for clock skew between hosts)
+	rows, err := cs.db.QueryContext(ctx, `
+		SELECT s AS symbol, true AS deleted
+		FROM unnest($1::text[]) s
+		WHERE NOT EXISTS (SELECT 1 FROM bitcoins b WHERE b.symbol = s AND b.deleted_at IS NULL)
+		UNION ALL
+		SELECT symbol, false FROM bitcoins WHERE updated_at >= $2 AND deleted_at IS NULL
+	`, pq.Array(listed), since.Add(-time.Minute).UTC())
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	defer rows.Close()
+
+	var stale []string
+	for rows.Next() {
+		var symbol string
+		var deleted bool
+		if err := rows.Scan(&symbol, &deleted); err != nil {
+			return fmt.Errorf("scan error: %w", err)
+		}
+		if deleted {
+			stale = append(stale, cs.getBitcoinCacheKey(symbol))
+		}
+		stale = append(stale, cs.getExtremesCacheKey(symbol))
+		stale = append(stale, cs.symbolDerivedKeys(symbol)...)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("scan error: %w", err)
+	}
+
+	_, err = cs.redisClient.Pipelined(cs.ctx, func(pipe redis.Pipeliner) error {
+		for start := 0; start < len(stale); start += sortedSetBuildChunk {
+			pipe.Del(cs.ctx, stale[start:min(start+sortedSetBuildChunk, len(stale))]...)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to drop stale keys: %w", err)
+	}
+
+	cs.invalidateDerivedNow(cs.ctx)
+	slog.InfoContext(cs.ctx, "cache resync dropped stale keys", "keys", len(stale))
+	return nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Dual-write to a secondary Redis (SECONDARY_REDIS_ADDR) while migrating clusters.
+// Reads always come from the primary; the secondary only receives copies of the
+// writes made by SetBitcoin, DeleteBitcoin and PrimeCache.
+const (
+	secondaryWriteTimeout   = 2 * time.Second
+	maxInflightMirrorWrites = 64
+	dualWriteSampleSize     = 20
+)
+
+// Copy a cache write to the secondary without blocking the caller. If too many
+// mirror writes are already in flight the write is dropped and counted, since the
+// comparator will surface any divergence that causes.
+func (cs *CacheService) mirror(write func(ctx context.Context, pipe redis.Pipeliner)) {
+	if cs.secondary == nil {
+		return
+	}
+
+	select {
+	case cs.mirrorSlots <- struct{}{}:
+	default:
+		cs.metrics.dualWriteErrors.WithLabelValues("dropped").Inc()
+		return
+	}
+
+	go func() {
+		defer func() { <-cs.mirrorSlots }()
+
+		ctx, cancel := context.WithTimeout(context.Background(), secondaryWriteTimeout)
+		defer cancel()
+
+		_, err := cs.secondary.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			write(ctx, pipe)
+			return nil
+		})
+		if err != nil {
+			slog.ErrorContext(ctx, "mirroring write to secondary Redis failed", "error", err)
+			cs.metrics.dualWriteErrors.WithLabelValues("write").Inc()
+		}
+	}()
+}
+
+// Periodically sample symbols and compare their cached records on both clusters
+func (cs *CacheService) RunDualWriteComparator(ctx context.Context, interval time.Duration) {
+	if cs.secondary == nil {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			cs.compareDualWriteSample(ctx)
+		}
+	}
+}
+
+func (cs *CacheService) compareDualWriteSample(ctx context.Context) {
+	symbols, err := cs.redisClient.ZRandMember(ctx, rankSortedSetKey, dualWriteSampleSize).Result()
+	if err != nil {
+		slog.ErrorContext(ctx, "dual-write comparator: sampling symbols failed", "error", err)
+		cs.metrics.dualWriteErrors.WithLabelValues("compare").Inc()
+		return
+	}
+
+	divergent := 0
+	for _, symbol := range symbols {
+		key := cs.getBitcoinCacheKey(symbol)
+
+		primary, err := cs.redisClient.Get(ctx, key).Result()
+		if err == redis.Nil {
+			continue // Expired on the primary; nothing to compare
+		}
+		if err != nil {
+			cs.metrics.dualWriteErrors.WithLabelValues("compare").Inc()
+			continue
+		}
+
+		secondary, err := cs.secondary.Get(ctx, key).Result()
+		if err != nil && err != redis.Nil {
+			cs.metrics.dualWriteErrors.WithLabelValues("compare").Inc()
+			continue
+		}
+
+		cs.metrics.dualWriteCompared.Inc()
+		if err == redis.Nil || secondary != primary {
+			divergent++
+			cs.metrics.dualWriteDivergent.Inc()
+			slog.WarnContext(ctx, "dual-write divergence", "key", key, "missing_on_secondary", err == redis.Nil)
+		}
+	}
+
+	slog.InfoContext(ctx, "dual-write comparator", "sampled", len(symbols), "divergent", divergent)
+}
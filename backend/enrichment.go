@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// Enrichment changes on every write, so it's cached apart from the record with a shorter TTL
+const defaultEnrichmentTTL = 1 * time.Minute
+
+// Single-symbol response: the record plus fields derived from price history
+type BitcoinDetail struct {
+	Bitcoin
+	PreviousPrice *decimal.Decimal `json:"previous_price"`
+	Velocity      *float64         `json:"velocity"` // Price change per minute between the last two history points
+	PriceExtremes
+	PricePrecision int `json:"price_precision"` // Decimal places to display prices with
+}
+
+type bitcoinEnrichment struct {
+	PreviousPrice  *decimal.Decimal `json:"previous_price"`
+	Velocity       *float64         `json:"velocity"`
+	PricePrecision *int             `json:"price_precision"` // From symbol metadata; nil when unset
+}
+
+func (cs *CacheService) getEnrichmentCacheKey(symbol string) string {
+	return fmt.Sprintf("%s%s:enrichment", cachePrefix, symbol)
+}
+
+// Get a bitcoin with its enrichment fields. Enrichment is best effort: if it
+// can't be loaded the record is still returned with those fields null.
+func (cs *CacheService) GetBitcoinDetail(ctx context.Context, symbol string, consistency consistencyLevel) (*BitcoinDetail, error) {
+	bitcoin, err := cs.GetBitcoin(ctx, symbol, consistency)
+	if err != nil || bitcoin == nil {
+		return nil, err
+	}
+
+	detail := &BitcoinDetail{Bitcoin: *bitcoin, PricePrecision: cs.pricePrecision}
+
+	// Set on the copy: the record may be shared with concurrent callers
+	rank, err := cs.rankOf(ctx, bitcoin, consistency)
+	if err != nil {
+		slog.ErrorContext(ctx, "loading rank failed", "symbol", symbol, "error", err)
+	} else {
+		detail.Rank = rank
+	}
+
+	enrichment, err := cs.getEnrichment(ctx, bitcoin.Symbol)
+	if err != nil {
+		slog.ErrorContext(ctx, "loading enrichment failed", "symbol", symbol, "error", err)
+	} else {
+		detail.PreviousPrice = enrichment.PreviousPrice
+		detail.Velocity = enrichment.Velocity
+		if enrichment.PricePrecision != nil {
+			detail.PricePrecision = *enrichment.PricePrecision
+		}
+	}
+
+	extremes, err := cs.getPriceExtremes(ctx, bitcoin.Symbol)
+	if err != nil {
+		slog.ErrorContext(ctx, "loading extremes failed", "symbol", symbol, "error", err)
+	} else {
+		detail.PriceExtremes = *extremes
+	}
+	return detail, nil
+}
+
+func (cs *CacheService) getEnrichment(ctx context.Context, symbol string) (*bitcoinEnrichment, error) {
+	cacheKey := cs.getEnrichmentCacheKey(symbol)
+
+	cached, err := cs.redisClient.Get(cs.ctx, cacheKey).Result()
+	if err == nil {
+		var enrichment bitcoinEnrichment
+		if err := decodeCacheValue([]byte(cached), &enrichment); err != nil {
+			slog.ErrorContext(ctx, "unmarshaling cached enrichment failed", "symbol", symbol, "error", err)
+		} else {
+			return &enrichment, nil
+		}
+	}
+
+	var enrichment bitcoinEnrichment
+
+	// The two most recent history records (the latest is the current price, the
+	// second is the previous price) alongside the symbol's precision. The outer row
+	// is always there, so the precision comes back even without history.
+	rows, err := cs.db.QueryContext(ctx, `
+		SELECT m.price_precision, h.price, h.recorded_at
+		FROM (SELECT $1::varchar AS symbol) s
+		LEFT JOIN symbol_metadata m ON m.symbol = s.symbol
+		LEFT JOIN LATERAL (
+			SELECT price, recorded_at
+			FROM bitcoin_price_history
+			WHERE symbol = s.symbol
+			ORDER BY recorded_at DESC, id DESC
+			LIMIT 2
+		) h ON true
+		ORDER BY h.recorded_at DESC
+	`, symbol)
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	defer rows.Close()
+
+	var points []PricePoint
+	for rows.Next() {
+		var price *decimal.Decimal
+		var recordedAt *time.Time
+		if err := rows.Scan(&enrichment.PricePrecision, &price, &recordedAt); err != nil {
+			return nil, fmt.Errorf("scan error: %w", err)
+		}
+		if price != nil {
+			points = append(points, PricePoint{Price: *price, RecordedAt: *recordedAt})
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+
+	if len(points) == 2 {
+		latest, previous := points[0], points[1]
+		enrichment.PreviousPrice = &previous.Price
+
+		// Velocity stays null when both points share a timestamp
+		if minutes := latest.RecordedAt.Sub(previous.RecordedAt).Minutes(); minutes > 0 {
+			velocity := latest.Price.Sub(previous.Price).InexactFloat64() / minutes
+			enrichment.Velocity = &velocity
+		}
+	}
+
+	data, err := cs.encodeCacheValue(enrichment)
+	if err != nil {
+		slog.ErrorContext(ctx, "marshaling enrichment failed", "symbol", symbol, "error", err)
+	} else if err := cs.redisClient.Set(cs.ctx, cacheKey, data, cs.enrichmentTTL).Err(); err != nil {
+		slog.ErrorContext(ctx, "caching enrichment failed", "symbol", symbol, "error", err)
+	}
+
+	return &enrichment, nil
+}
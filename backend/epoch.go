@@ -0,0 +1,34 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+// Global data version: INCRed with every derived-cache invalidation, i.e. on every
+// write (and again when a debounced invalidation flushes, which is when the rankings
+// change). Clients compare X-Data-Epoch between polls to tell whether anything changed.
+const (
+	dataEpochKey    = "bitcoin:epoch"
+	dataEpochHeader = "X-Data-Epoch"
+)
+
+// Set X-Data-Epoch on GET responses. The epoch is read before the handler runs, so
+// the response is at least as new as the epoch it carries: a client may re-render
+// data that hasn't changed, but never skips data that has. Left out when Redis is
+// unreachable.
+func dataEpochMiddleware(cs *CacheService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if (c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead) && !cs.cacheDisabled() {
+			epoch, err := cs.redisClient.Get(c.Request.Context(), dataEpochKey).Result()
+			if err == nil {
+				c.Header(dataEpochHeader, epoch)
+			} else if err == redis.Nil {
+				c.Header(dataEpochHeader, "0")
+			}
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"net"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+// Machine-readable error codes, sent as "code" next to the human-readable "error" in
+// every error response. Clients should branch on these; messages may change.
+type ErrorCode string
+
+const (
+	CodeValidationFailed     ErrorCode = "VALIDATION_FAILED"      // 400: malformed body, parameter or symbol
+	CodeUnauthorized         ErrorCode = "UNAUTHORIZED"           // 401: missing or invalid API key or admin token
+	CodeForbidden            ErrorCode = "FORBIDDEN"              // 403: API key not allowed the symbol, or admin API disabled
+	CodeSymbolNotFound       ErrorCode = "SYMBOL_NOT_FOUND"       // 404: the symbol doesn't exist
+	CodeNotFound             ErrorCode = "NOT_FOUND"              // 404: another resource (job, metadata, price point, policy, route)
+	CodeReadOnly             ErrorCode = "READ_ONLY"              // 405: write sent to a read-only instance
+	CodeConflict             ErrorCode = "CONFLICT"               // 409: the operation is already running
+	CodeInvalidAdjustment    ErrorCode = "INVALID_ADJUSTMENT"     // 422: PATCH would make the price negative, or there is no price
+	CodeIdempotencyKeyReused ErrorCode = "IDEMPOTENCY_KEY_REUSED" // 422: Idempotency-Key already used for a different request
+	CodeRateLimited          ErrorCode = "RATE_LIMITED"           // 429: update throttle, API key quota or rate limit; see Retry-After
+	CodeDBUnavailable        ErrorCode = "DB_UNAVAILABLE"         // 500: PostgreSQL could not be reached
+	CodeInternal             ErrorCode = "INTERNAL_ERROR"         // 500: any other failure
+	CodeOverloaded           ErrorCode = "OVERLOADED"             // 503: load shedding; retry shortly
+	CodeEncryptionDisabled   ErrorCode = "ENCRYPTION_DISABLED"    // 503: the request needs ENCRYPTION_KEY
+)
+
+// Error response body
+func errorJSON(code ErrorCode, message string) gin.H {
+	return gin.H{"error": message, "code": code}
+}
+
+// Code for an error returned by the service layer
+func codeFor(err error) ErrorCode {
+	var throttled *ThrottledError
+	var duplicates *DuplicateSymbolsError
+	switch {
+	case errors.Is(err, ErrReadOnly):
+		return CodeReadOnly
+	case errors.Is(err, ErrCacheOnlyMiss), errors.Is(err, ErrLoadTimeout):
+		return CodeOverloaded
+	case errors.Is(err, ErrRecomputeInProgress), errors.Is(err, ErrRebuildInProgress):
+		return CodeConflict
+	case errors.Is(err, ErrNegativePrice), errors.Is(err, ErrUnpriced):
+		return CodeInvalidAdjustment
+	case errors.Is(err, ErrEncryptionDisabled):
+		return CodeEncryptionDisabled
+	case errors.Is(err, ErrInvalidCursor), errors.Is(err, ErrInvalidSymbol), errors.Is(err, ErrEmptyBatch), errors.As(err, &duplicates):
+		return CodeValidationFailed
+	case errors.As(err, &throttled):
+		return CodeRateLimited
+	case dbUnavailable(err):
+		return CodeDBUnavailable
+	default:
+		return CodeInternal
+	}
+}
+
+// Whether err means the database couldn't be reached, as opposed to a failed query
+func dbUnavailable(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		// connection_exception, and admin_shutdown / crash_shutdown / cannot_connect_now
+		return pqErr.Code.Class() == "08" || pqErr.Code == "57P01" || pqErr.Code == "57P02" || pqErr.Code == "57P03"
+	}
+	var netErr net.Error
+	return errors.Is(err, driver.ErrBadConn) || errors.Is(err, sql.ErrConnDone) ||
+		errors.Is(err, context.DeadlineExceeded) || errors.As(err, &netErr)
+}
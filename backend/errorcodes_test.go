@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+func TestCodeFor(t *testing.T) {
+	wrapped := func(err error) error { return fmt.Errorf("database error: %w", err) }
+	cases := []struct {
+		name string
+		err  error
+		want ErrorCode
+	}{
+		{"read only", ErrReadOnly, CodeReadOnly},
+		{"cache-only miss", ErrCacheOnlyMiss, CodeOverloaded},
+		{"load timeout", ErrLoadTimeout, CodeOverloaded},
+		{"recompute running", ErrRecomputeInProgress, CodeConflict},
+		{"rebuild running", ErrRebuildInProgress, CodeConflict},
+		{"negative price", ErrNegativePrice, CodeInvalidAdjustment},
+		{"unpriced", ErrUnpriced, CodeInvalidAdjustment},
+		{"encryption disabled", ErrEncryptionDisabled, CodeEncryptionDisabled},
+		{"invalid cursor", ErrInvalidCursor, CodeValidationFailed},
+		{"invalid symbol", ErrInvalidSymbol, CodeValidationFailed},
+		{"empty batch", ErrEmptyBatch, CodeValidationFailed},
+		{"duplicate symbols", &DuplicateSymbolsError{Symbols: []string{"BTC"}}, CodeValidationFailed},
+		{"throttled", &ThrottledError{Symbol: "BTC", RetryAfter: time.Second}, CodeRateLimited},
+		{"wrapped throttled", fmt.Errorf("update: %w", &ThrottledError{Symbol: "BTC"}), CodeRateLimited},
+		{"bad connection", wrapped(driver.ErrBadConn), CodeDBUnavailable},
+		{"connection done", wrapped(sql.ErrConnDone), CodeDBUnavailable},
+		{"deadline", wrapped(context.DeadlineExceeded), CodeDBUnavailable},
+		{"network", wrapped(&net.OpError{Op: "dial", Err: errors.New("connection refused")}), CodeDBUnavailable},
+		{"connection exception", wrapped(&pq.Error{Code: "08006"}), CodeDBUnavailable},
+		{"admin shutdown", wrapped(&pq.Error{Code: "57P01"}), CodeDBUnavailable},
+		{"cannot connect now", wrapped(&pq.Error{Code: "57P03"}), CodeDBUnavailable},
+		{"failed query", wrapped(&pq.Error{Code: "23505"}), CodeInternal},
+		{"no rows", sql.ErrNoRows, CodeInternal},
+		{"anything else", errors.New("boom"), CodeInternal},
+	}
+	for _, tc := range cases {
+		if got := codeFor(tc.err); got != tc.want {
+			t.Errorf("%s: codeFor(%v) = %s, want %s", tc.name, tc.err, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/shopspring/decimal"
+)
+
+// Redis pub/sub channel carrying a ChangeEvent (JSON) for every write, so
+// streaming endpoints work across replicas
+const changesChannel = "bitcoin:changes"
+
+const (
+	eventTypeUpdate = "update"
+	eventTypeDelete = "delete"
+	eventTypeGap    = "gap" // Local only: the subscription dropped and events may have been missed
+)
+
+const (
+	defaultPubSubMinBackoff = 100 * time.Millisecond
+	defaultPubSubMaxBackoff = 10 * time.Second
+	changeEventBuffer       = 64
+)
+
+type ChangeEvent struct {
+	Type          string           `json:"type"`
+	Symbol        string           `json:"symbol"`
+	Bitcoin       *Bitcoin         `json:"bitcoin"`
+	PreviousPrice *decimal.Decimal `json:"previous_price"`
+}
+
+// Price move in basis points relative to the previous price; ok is false when
+// the event has no prior price to compare against (new symbol or delete)
+func (e ChangeEvent) moveBps() (bps float64, ok bool) {
+	if e.Type != eventTypeUpdate || e.Bitcoin == nil || e.Bitcoin.Price == nil || e.PreviousPrice == nil || e.PreviousPrice.IsZero() {
+		return 0, false
+	}
+	return e.Bitcoin.Price.Sub(*e.PreviousPrice).InexactFloat64() * 10000 / e.PreviousPrice.InexactFloat64(), true
+}
+
+// Best effort: a failed publish only affects live subscribers, never the write itself
+func (cs *CacheService) publishChange(ctx context.Context, event ChangeEvent) {
+	if cs.cacheWritesDisabled() {
+		return
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		slog.ErrorContext(ctx, "marshaling change event failed", "symbol", event.Symbol, "error", err)
+		return
+	}
+	if err := cs.redisClient.Publish(cs.ctx, changesChannel, data).Err(); err != nil {
+		slog.ErrorContext(ctx, "publishing change event failed", "symbol", event.Symbol, "error", err)
+	}
+}
+
+// Stream change events until ctx is cancelled, then close the channel. If the
+// subscription drops it is re-established with backoff; events published during
+// the gap are lost, so a gap event is delivered to let the consumer know.
+func (cs *CacheService) SubscribeChanges(ctx context.Context) <-chan ChangeEvent {
+	events := make(chan ChangeEvent, changeEventBuffer)
+
+	go func() {
+		defer close(events)
+
+		backoff := cs.pubsubMinBackoff
+		for {
+			sub := cs.redisClient.Subscribe(ctx, changesChannel)
+			err := cs.forwardChanges(ctx, sub, events, func() { backoff = cs.pubsubMinBackoff })
+			sub.Close()
+			if ctx.Err() != nil {
+				return
+			}
+
+			cs.metrics.pubsubReconnects.Inc()
+			slog.WarnContext(ctx, "change subscription dropped, resubscribing; events published meanwhile are lost", "error", err, "backoff", backoff.String())
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			backoff = min(backoff*2, cs.pubsubMaxBackoff)
+
+			select {
+			case <-ctx.Done():
+				return
+			case events <- ChangeEvent{Type: eventTypeGap}:
+			}
+		}
+	}()
+
+	return events
+}
+
+// Decode messages from sub onto events until the subscription fails (returning the
+// error) or ctx is cancelled. received is called after every successful receive.
+func (cs *CacheService) forwardChanges(ctx context.Context, sub *redis.PubSub, events chan<- ChangeEvent, received func()) error {
+	for {
+		msg, err := sub.ReceiveMessage(ctx)
+		if err != nil {
+			return err
+		}
+		received()
+
+		var event ChangeEvent
+		if err := json.Unmarshal([]byte(msg.Payload), &event); err != nil {
+			slog.ErrorContext(ctx, "unmarshaling change event failed", "error", err)
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case events <- event:
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSubscribeChangesSurvivesDroppedSubscription(t *testing.T) {
+	cs, fr, _ := newFakeBackedCacheService(t, nil)
+	cs.pubsubMinBackoff, cs.pubsubMaxBackoff = 10*time.Millisecond, 20*time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events := cs.SubscribeChanges(ctx)
+	t.Cleanup(func() {
+		// A blocked receive doesn't watch ctx; closing the connection ends it
+		cancel()
+		fr.dropSubscribers()
+		for range events {
+		}
+	})
+
+	next := func(what string) ChangeEvent {
+		t.Helper()
+		select {
+		case event, ok := <-events:
+			if !ok {
+				t.Fatalf("events closed waiting for %s", what)
+			}
+			return event
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		return ChangeEvent{}
+	}
+
+	waitFor(t, "the subscription", func() bool { return fr.subscribers(changesChannel) == 1 })
+	cs.publishChange(context.Background(), ChangeEvent{Type: eventTypeUpdate, Symbol: "BTC"})
+	if event := next("the first event"); event.Type != eventTypeUpdate || event.Symbol != "BTC" {
+		t.Errorf("first event = %+v, want an update for BTC", event)
+	}
+
+	fr.dropSubscribers()
+	if event := next("the gap event"); event.Type != eventTypeGap {
+		t.Errorf("event after the drop = %+v, want a gap", event)
+	}
+	waitFor(t, "the resubscription", func() bool { return fr.subscribers(changesChannel) == 1 })
+	cs.publishChange(context.Background(), ChangeEvent{Type: eventTypeDelete, Symbol: "SOL"})
+	if event := next("an event after resubscribing"); event.Type != eventTypeDelete || event.Symbol != "SOL" {
+		t.Errorf("event after resubscribing = %+v, want a delete for SOL", event)
+	}
+
+	if n := counterValue(t, cs.metrics.pubsubReconnects); n != 1 {
+		t.Errorf("pubsub reconnects = %v, want 1", n)
+	}
+}
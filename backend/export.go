@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	defaultExportPageSize = 100
+	maxExportPageSize     = 1000
+)
+
+var ErrInvalidCursor = errors.New("invalid export cursor")
+
+// Position of an export: the last symbol returned and the export's point in time.
+// Handed to clients as opaque base64 JSON so both travel together on resumption.
+type exportCursor struct {
+	After string    `json:"after"`
+	AsOf  time.Time `json:"as_of"`
+}
+
+func encodeExportCursor(cur exportCursor) string {
+	data, _ := json.Marshal(cur)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeExportCursor(token string) (exportCursor, error) {
+	var cur exportCursor
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return cur, ErrInvalidCursor
+	}
+	if err := json.Unmarshal(data, &cur); err != nil || cur.AsOf.IsZero() || cur.AsOf.After(time.Now()) {
+		return cur, ErrInvalidCursor
+	}
+	return cur, nil
+}
+
+type ExportPage struct {
+	AsOf       time.Time `json:"as_of"`
+	Items      []Bitcoin `json:"items"`
+	NextCursor *string   `json:"next_cursor"` // nil once the export is complete
+}
+
+// One page of a resumable export in symbol order. An empty cursor starts a new export
+// as of now; otherwise the page continues after the cursor's symbol at the cursor's
+// as_of. Each symbol that existed at as_of is returned with the price it had then,
+// from the price history, so writes landing mid-export don't skew the result.
+// Symbols deleted after as_of but before their page is read are not returned.
+func (cs *CacheService) ExportBitcoins(ctx context.Context, cursor string, pageSize int) (*ExportPage, error) {
+	cur := exportCursor{AsOf: time.Now().UTC().Truncate(time.Microsecond)}
+	if cursor != "" {
+		var err error
+		if cur, err = decodeExportCursor(cursor); err != nil {
+			return nil, err
+		}
+	}
+
+	bitcoins, err := cs.exportPageFromDB(ctx, cur, pageSize+1)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &ExportPage{AsOf: cur.AsOf, Items: bitcoins}
+	if len(bitcoins) > pageSize {
+		page.Items = bitcoins[:pageSize]
+		next := encodeExportCursor(exportCursor{After: page.Items[pageSize-1].Symbol, AsOf: cur.AsOf})
+		page.NextCursor = &next
+	}
+
+	return page, nil
+}
+
+func (cs *CacheService) exportPageFromDB(ctx context.Context, cur exportCursor, limit int) ([]Bitcoin, error) {
+	defer cs.observeDB("export", time.Now())
+
+	// Columns are TIMESTAMP without zone, written by a server running in UTC. Rows with
+	// no history at or before as_of (written before history was kept) fall back to the
+	// current row. A symbol soft-deleted after as_of was still listed then.
+	rows, err := cs.db.QueryContext(ctx, `
+		SELECT b.symbol, COALESCE(h.price, b.price), b.supply, b.created_at, COALESCE(h.recorded_at, b.updated_at)
+		FROM bitcoins b
+		LEFT JOIN LATERAL (
+			SELECT price, recorded_at
+			FROM bitcoin_price_history
+			WHERE symbol = b.symbol AND recorded_at <= $2
+			ORDER BY recorded_at DESC
+			LIMIT 1
+		) h ON true
+		WHERE b.symbol > $1 AND b.created_at <= $2 AND (b.deleted_at IS NULL OR b.deleted_at > $2)
+		ORDER BY b.symbol
+		LIMIT $3
+	`, cur.After, cur.AsOf.UTC(), limit)
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	defer rows.Close()
+
+	bitcoins := []Bitcoin{}
+	for rows.Next() {
+		var b Bitcoin
+		if err := rows.Scan(&b.Symbol, &b.Price, &b.Supply, &b.CreatedAt, &b.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("scan error: %w", err)
+		}
+		bitcoins = append(bitcoins, b)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("scan error: %w", err)
+	}
+
+	return bitcoins, nil
+}
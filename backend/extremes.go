@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/shopspring/decimal"
+)
+
+// All-time extremes only move when a write breaks one, which writes apply in place,
+// so the computed values can live long
+const extremesCacheTTL = 24 * time.Hour
+
+// A write that finds no cached extremes leaves a marker for this long, so a read that
+// queried history before the write can't cache a result missing it
+const extremesWriteMarkerTTL = 5 * time.Second
+
+// All-time high and low from the price history, each with when it was first reached.
+// All null when the symbol has no history.
+type PriceExtremes struct {
+	ATH   *decimal.Decimal `json:"ath"`
+	ATHAt *time.Time       `json:"ath_at"`
+	ATL   *decimal.Decimal `json:"atl"`
+	ATLAt *time.Time       `json:"atl_at"`
+}
+
+// A hash with ath/ath_at/atl/atl_at, plus "computed" so an empty history is cached too
+// (a hash without it is a write marker)
+func (cs *CacheService) getExtremesCacheKey(symbol string) string {
+	return fmt.Sprintf("%s%s:ath", cachePrefix, symbol)
+}
+
+func (cs *CacheService) getPriceExtremes(ctx context.Context, symbol string) (*PriceExtremes, error) {
+	cacheKey := cs.getExtremesCacheKey(symbol)
+
+	fields, err := cs.redisClient.HGetAll(cs.ctx, cacheKey).Result()
+	if err == nil && fields["computed"] != "" {
+		if extremes, err := parseExtremes(fields); err != nil {
+			slog.ErrorContext(ctx, "parsing cached extremes failed", "symbol", symbol, "error", err)
+		} else {
+			return extremes, nil
+		}
+	}
+
+	extremes, err := cs.getPriceExtremesFromDB(ctx, symbol)
+	if err != nil {
+		return nil, err
+	}
+
+	args := []interface{}{extremesCacheTTL.Milliseconds(), "computed", "1"}
+	if extremes.ATH != nil {
+		args = append(args,
+			"ath", extremes.ATH.String(), "ath_at", extremes.ATHAt.Format(time.RFC3339Nano),
+			"atl", extremes.ATL.String(), "atl_at", extremes.ATLAt.Format(time.RFC3339Nano))
+	}
+	stored, err := cacheExtremesScript.Run(cs.ctx, cs.redisClient, []string{cacheKey}, args...).Int()
+	if err != nil {
+		slog.ErrorContext(ctx, "caching extremes failed", "symbol", symbol, "error", err)
+	} else if stored == 0 {
+		slog.InfoContext(ctx, "written while computing extremes, not caching them", "symbol", symbol)
+	}
+
+	return extremes, nil
+}
+
+// Store computed extremes unless the key exists: either another reader cached them
+// first or a write left its marker (see raiseExtremesScript)
+var cacheExtremesScript = redis.NewScript(`
+	if redis.call("EXISTS", KEYS[1]) == 1 then
+		return 0
+	end
+	redis.call("HSET", KEYS[1], unpack(ARGV, 2))
+	redis.call("PEXPIRE", KEYS[1], ARGV[1])
+	return 1
+`)
+
+func parseExtremes(fields map[string]string) (*PriceExtremes, error) {
+	extremes := &PriceExtremes{}
+	if fields["ath"] == "" {
+		return extremes, nil
+	}
+
+	ath, err := decimal.NewFromString(fields["ath"])
+	if err != nil {
+		return nil, err
+	}
+	athAt, err := time.Parse(time.RFC3339Nano, fields["ath_at"])
+	if err != nil {
+		return nil, err
+	}
+	atl, err := decimal.NewFromString(fields["atl"])
+	if err != nil {
+		return nil, err
+	}
+	atlAt, err := time.Parse(time.RFC3339Nano, fields["atl_at"])
+	if err != nil {
+		return nil, err
+	}
+
+	extremes.ATH, extremes.ATHAt, extremes.ATL, extremes.ATLAt = &ath, &athAt, &atl, &atlAt
+	return extremes, nil
+}
+
+func (cs *CacheService) getPriceExtremesFromDB(ctx context.Context, symbol string) (*PriceExtremes, error) {
+	defer cs.observeDB("extremes", time.Now())
+
+	// The earliest point at the maximum and at the minimum price
+	rows, err := cs.db.QueryContext(ctx, `
+		WITH h AS (
+			SELECT price, recorded_at, MAX(price) OVER () AS hi, MIN(price) OVER () AS lo
+			FROM bitcoin_price_history
+			WHERE symbol = $1
+		)
+		SELECT DISTINCT ON (extreme) extreme, price, recorded_at
+		FROM (
+			SELECT 'ath' AS extreme, price, recorded_at FROM h WHERE price = hi
+			UNION ALL
+			SELECT 'atl' AS extreme, price, recorded_at FROM h WHERE price = lo
+		) e
+		ORDER BY extreme, recorded_at
+	`, symbol)
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	defer rows.Close()
+
+	extremes := &PriceExtremes{}
+	for rows.Next() {
+		var extreme string
+		var price decimal.Decimal
+		var recordedAt time.Time
+		if err := rows.Scan(&extreme, &price, &recordedAt); err != nil {
+			return nil, fmt.Errorf("scan error: %w", err)
+		}
+		if extreme == "ath" {
+			extremes.ATH, extremes.ATHAt = &price, &recordedAt
+		} else {
+			extremes.ATL, extremes.ATLAt = &price, &recordedAt
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("scan error: %w", err)
+	}
+
+	return extremes, nil
+}
+
+// Apply a newly written price to the cached extremes: replace the ATH (ATL) only
+// when the price is strictly above (below) it, keeping the TTL. Lua compares the
+// prices as doubles, which only matters past 15 significant digits. When they aren't
+// cached, leave a short-lived marker instead; the next read computes them from history.
+var raiseExtremesScript = redis.NewScript(`
+	if redis.call("HEXISTS", KEYS[1], "computed") == 0 then
+		redis.call("HSET", KEYS[1], "written", "1")
+		redis.call("PEXPIRE", KEYS[1], ARGV[3])
+		return 0
+	end
+	local price = tonumber(ARGV[1])
+	local ath = tonumber(redis.call("HGET", KEYS[1], "ath"))
+	local atl = tonumber(redis.call("HGET", KEYS[1], "atl"))
+	if not ath or price > ath then
+		redis.call("HSET", KEYS[1], "ath", ARGV[1], "ath_at", ARGV[2])
+	end
+	if not atl or price < atl then
+		redis.call("HSET", KEYS[1], "atl", ARGV[1], "atl_at", ARGV[2])
+	end
+	return 1
+`)
+
+// An unpriced record can't move the extremes; returns nil then
+func (cs *CacheService) updateExtremes(s redis.Scripter, bitcoin *Bitcoin) *redis.Cmd {
+	if bitcoin.Price == nil {
+		return nil
+	}
+	return raiseExtremesScript.Eval(cs.ctx, s, []string{cs.getExtremesCacheKey(bitcoin.Symbol)},
+		bitcoin.Price.String(), bitcoin.UpdatedAt.Format(time.RFC3339Nano), extremesWriteMarkerTTL.Milliseconds())
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// A database/sql driver whose every statement is answered by a test's handler, for
+// exercising the service without Postgres. Arguments arrive as driver values, so
+// pq.Array(symbols) is the string "{A,B}".
+
+type fakeResult struct {
+	columns  []string
+	rows     [][]driver.Value
+	affected int64 // For Exec; defaults to len(rows)
+}
+
+type fakeHandler func(query string, args []driver.Value) (fakeResult, error)
+
+type fakeDB struct {
+	mu      sync.Mutex
+	handler fakeHandler
+	queries []string // Every statement run, in order
+}
+
+var (
+	fakeDBsMu sync.Mutex
+	fakeDBs   = map[string]*fakeDB{}
+)
+
+func init() {
+	sql.Register("fakedb", fakeDriver{})
+}
+
+// Open a *sql.DB answered by handler; closed when the test ends
+func newFakeDB(t testing.TB, handler fakeHandler) (*fakeDB, *sql.DB) {
+	t.Helper()
+	f := &fakeDB{handler: handler}
+	fakeDBsMu.Lock()
+	fakeDBs[t.Name()] = f
+	fakeDBsMu.Unlock()
+
+	db, err := sql.Open("fakedb", t.Name())
+	if err != nil {
+		t.Fatalf("open fake db: %v", err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+		fakeDBsMu.Lock()
+		delete(fakeDBs, t.Name())
+		fakeDBsMu.Unlock()
+	})
+	return f, db
+}
+
+// Statements run so far that contain substr
+func (f *fakeDB) count(substr string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for _, q := range f.queries {
+		if strings.Contains(q, substr) {
+			n++
+		}
+	}
+	return n
+}
+
+func (f *fakeDB) run(query string, named []driver.NamedValue) (fakeResult, error) {
+	args := make([]driver.Value, len(named))
+	for i, nv := range named {
+		args[i] = nv.Value
+	}
+	f.mu.Lock()
+	f.queries = append(f.queries, query)
+	handler := f.handler
+	f.mu.Unlock()
+	return handler(query, args)
+}
+
+// Parse a pq.Array argument back into its elements
+func fakeArrayArg(v driver.Value) []string {
+	s, _ := v.(string)
+	s = strings.Trim(s, "{}")
+	if s == "" {
+		return nil
+	}
+	elems := strings.Split(s, ",")
+	for i, e := range elems {
+		elems[i] = strings.Trim(e, `"`)
+	}
+	return elems
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(name string) (driver.Conn, error) {
+	fakeDBsMu.Lock()
+	defer fakeDBsMu.Unlock()
+	f, ok := fakeDBs[name]
+	if !ok {
+		return nil, fmt.Errorf("no fake db %q", name)
+	}
+	return &fakeDBConn{db: f}, nil
+}
+
+type fakeDBConn struct{ db *fakeDB }
+
+func (c *fakeDBConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeStmt{conn: c, query: query}, nil
+}
+
+func (c *fakeDBConn) Close() error              { return nil }
+func (c *fakeDBConn) Begin() (driver.Tx, error) { return fakeTx{}, nil }
+
+func (c *fakeDBConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	res, err := c.db.run(query, args)
+	if err != nil {
+		return nil, err
+	}
+	return &fakeRows{result: res}, nil
+}
+
+func (c *fakeDBConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	res, err := c.db.run(query, args)
+	if err != nil {
+		return nil, err
+	}
+	if res.affected == 0 {
+		res.affected = int64(len(res.rows))
+	}
+	return driver.RowsAffected(res.affected), nil
+}
+
+type fakeTx struct{}
+
+func (fakeTx) Commit() error   { return nil }
+func (fakeTx) Rollback() error { return nil }
+
+type fakeStmt struct {
+	conn  *fakeDBConn
+	query string
+}
+
+func (s *fakeStmt) Close() error  { return nil }
+func (s *fakeStmt) NumInput() int { return -1 }
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.conn.ExecContext(context.Background(), s.query, namedValues(args))
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.conn.QueryContext(context.Background(), s.query, namedValues(args))
+}
+
+func namedValues(args []driver.Value) []driver.NamedValue {
+	named := make([]driver.NamedValue, len(args))
+	for i, v := range args {
+		named[i] = driver.NamedValue{Ordinal: i + 1, Value: v}
+	}
+	return named
+}
+
+type fakeRows struct {
+	result fakeResult
+	next   int
+}
+
+func (r *fakeRows) Columns() []string { return r.result.columns }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.next >= len(r.result.rows) {
+		return io.EOF
+	}
+	copy(dest, r.result.rows[r.next])
+	r.next++
+	return nil
+}
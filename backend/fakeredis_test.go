@@ -0,0 +1,863 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// An in-process Redis speaking RESP2, with the commands the service uses. Lua can't
+// run here, so each script the service loads is emulated in Go (see fakeScripts).
+// Tests can inspect the keyspace, count commands and inject failures.
+
+type fakeStatus string // Simple string reply, e.g. +OK
+type fakeError string  // Error reply
+
+type fakeRedis struct {
+	ln net.Listener
+
+	mu       sync.Mutex
+	strs     map[string]string
+	hashes   map[string]map[string]string
+	zsets    map[string]map[string]float64
+	expires  map[string]time.Time
+	subs     map[*fakeConn]map[string]bool
+	counts   map[string]int // Commands received, by upper-cased name
+	failWith func(args []string) error
+}
+
+type fakeConn struct {
+	net.Conn
+	wmu sync.Mutex
+	w   *bufio.Writer
+}
+
+// Start a fake Redis and return it with a client connected to it; both are closed
+// when the test ends
+func newFakeRedis(t testing.TB) (*fakeRedis, *redis.Client) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	f := &fakeRedis{
+		ln:      ln,
+		strs:    map[string]string{},
+		hashes:  map[string]map[string]string{},
+		zsets:   map[string]map[string]float64{},
+		expires: map[string]time.Time{},
+		subs:    map[*fakeConn]map[string]bool{},
+		counts:  map[string]int{},
+	}
+	go f.accept()
+
+	client := redis.NewClient(&redis.Options{
+		Addr:             ln.Addr().String(),
+		Protocol:         2,
+		DisableIndentity: true,
+		MaxRetries:       -1,
+	})
+	t.Cleanup(func() {
+		client.Close()
+		ln.Close()
+		f.dropSubscribers()
+	})
+	return f, client
+}
+
+func (f *fakeRedis) accept() {
+	for {
+		c, err := f.ln.Accept()
+		if err != nil {
+			return
+		}
+		go f.serve(&fakeConn{Conn: c, w: bufio.NewWriter(c)})
+	}
+}
+
+// Fail every command for which fn returns an error; nil stops injecting failures
+func (f *fakeRedis) failCommands(fn func(args []string) error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failWith = fn
+}
+
+// How many times the named command (e.g. "MGET") was received
+func (f *fakeRedis) count(name string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.counts[strings.ToUpper(name)]
+}
+
+func (f *fakeRedis) resetCounts() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.counts = map[string]int{}
+}
+
+// Close every subscribed connection, as when Redis restarts or the network drops
+func (f *fakeRedis) dropSubscribers() {
+	f.mu.Lock()
+	conns := make([]*fakeConn, 0, len(f.subs))
+	for c := range f.subs {
+		conns = append(conns, c)
+	}
+	f.subs = map[*fakeConn]map[string]bool{}
+	f.mu.Unlock()
+	for _, c := range conns {
+		c.Close()
+	}
+}
+
+// Number of connections subscribed to channel
+func (f *fakeRedis) subscribers(channel string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for _, channels := range f.subs {
+		if channels[channel] {
+			n++
+		}
+	}
+	return n
+}
+
+// Direct keyspace access for test setup and assertions, bypassing failure injection
+
+func (f *fakeRedis) get(key string) (string, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.expire(key)
+	v, ok := f.strs[key]
+	return v, ok
+}
+
+func (f *fakeRedis) set(key, value string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.del(key)
+	f.strs[key] = value
+}
+
+func (f *fakeRedis) exists(key string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.has(key)
+}
+
+func (f *fakeRedis) zadd(key string, score float64, member string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.zsets[key] == nil {
+		f.zsets[key] = map[string]float64{}
+	}
+	f.zsets[key][member] = score
+}
+
+func (f *fakeRedis) zscore(key, member string) (float64, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	score, ok := f.zsets[key][member]
+	return score, ok
+}
+
+func (f *fakeRedis) serve(c *fakeConn) {
+	defer func() {
+		f.mu.Lock()
+		delete(f.subs, c)
+		f.mu.Unlock()
+		c.Close()
+	}()
+
+	r := bufio.NewReader(c)
+	var queued [][]string
+	inMulti := false
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		switch strings.ToUpper(args[0]) {
+		case "MULTI":
+			inMulti, queued = true, nil
+			c.reply(fakeStatus("OK"))
+		case "EXEC":
+			replies := make([]any, len(queued))
+			for i, cmd := range queued {
+				replies[i] = f.do(cmd)
+			}
+			inMulti, queued = false, nil
+			c.reply(replies)
+		case "DISCARD":
+			inMulti, queued = false, nil
+			c.reply(fakeStatus("OK"))
+		case "SUBSCRIBE":
+			f.subscribe(c, args[1:])
+		case "UNSUBSCRIBE":
+			f.unsubscribe(c, args[1:])
+		default:
+			if inMulti {
+				queued = append(queued, args)
+				c.reply(fakeStatus("QUEUED"))
+				continue
+			}
+			c.reply(f.do(args))
+		}
+	}
+}
+
+func (f *fakeRedis) subscribe(c *fakeConn, channels []string) {
+	f.mu.Lock()
+	f.counts["SUBSCRIBE"]++
+	if f.subs[c] == nil {
+		f.subs[c] = map[string]bool{}
+	}
+	for _, ch := range channels {
+		f.subs[c][ch] = true
+	}
+	n := len(f.subs[c])
+	f.mu.Unlock()
+	for _, ch := range channels {
+		c.reply([]any{"subscribe", ch, n})
+	}
+}
+
+func (f *fakeRedis) unsubscribe(c *fakeConn, channels []string) {
+	f.mu.Lock()
+	if len(channels) == 0 {
+		for ch := range f.subs[c] {
+			channels = append(channels, ch)
+		}
+	}
+	for _, ch := range channels {
+		delete(f.subs[c], ch)
+	}
+	n := len(f.subs[c])
+	f.mu.Unlock()
+	for _, ch := range channels {
+		c.reply([]any{"unsubscribe", ch, n})
+	}
+}
+
+func (f *fakeRedis) publish(channel, message string) int {
+	var receivers []*fakeConn
+	for c, channels := range f.subs {
+		if channels[channel] {
+			receivers = append(receivers, c)
+		}
+	}
+	for _, c := range receivers {
+		c.reply([]any{"message", channel, message})
+	}
+	return len(receivers)
+}
+
+// Run one command against the keyspace
+func (f *fakeRedis) do(args []string) any {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	name := strings.ToUpper(args[0])
+	f.counts[name]++
+	if f.failWith != nil {
+		if err := f.failWith(args); err != nil {
+			return fakeError(err.Error())
+		}
+	}
+	return f.run(name, args[1:])
+}
+
+// Callers hold f.mu
+func (f *fakeRedis) run(name string, args []string) any {
+	switch name {
+	case "PING":
+		return fakeStatus("PONG")
+	case "HELLO":
+		return fakeError("ERR unknown command 'HELLO'")
+	case "GET":
+		f.expire(args[0])
+		if v, ok := f.strs[args[0]]; ok {
+			return v
+		}
+		return nil
+	case "MGET":
+		values := make([]any, len(args))
+		for i, key := range args {
+			f.expire(key)
+			if v, ok := f.strs[key]; ok {
+				values[i] = v
+			}
+		}
+		return values
+	case "SET":
+		return f.setCommand(args)
+	case "DEL", "UNLINK":
+		n := 0
+		for _, key := range args {
+			f.expire(key)
+			if f.del(key) {
+				n++
+			}
+		}
+		return n
+	case "EXISTS":
+		n := 0
+		for _, key := range args {
+			if f.has(key) {
+				n++
+			}
+		}
+		return n
+	case "INCR":
+		f.expire(args[0])
+		n, err := strconv.ParseInt(f.strsOr(args[0], "0"), 10, 64)
+		if err != nil {
+			return fakeError("ERR value is not an integer or out of range")
+		}
+		f.strs[args[0]] = strconv.FormatInt(n+1, 10)
+		return n + 1
+	case "EXPIRE", "PEXPIRE":
+		if !f.has(args[0]) {
+			return 0
+		}
+		n, _ := strconv.ParseInt(args[1], 10, 64)
+		unit := time.Millisecond
+		if name == "EXPIRE" {
+			unit = time.Second
+		}
+		f.expires[args[0]] = time.Now().Add(time.Duration(n) * unit)
+		return 1
+	case "PERSIST":
+		if _, ok := f.expires[args[0]]; !ok || !f.has(args[0]) {
+			return 0
+		}
+		delete(f.expires, args[0])
+		return 1
+	case "PTTL", "TTL":
+		if !f.has(args[0]) {
+			return -2
+		}
+		at, ok := f.expires[args[0]]
+		if !ok {
+			return -1
+		}
+		if name == "TTL" {
+			return int64(time.Until(at).Seconds())
+		}
+		return time.Until(at).Milliseconds()
+	case "RENAME":
+		if !f.has(args[0]) {
+			return fakeError("ERR no such key")
+		}
+		s, sok := f.strs[args[0]]
+		h, hok := f.hashes[args[0]]
+		z, zok := f.zsets[args[0]]
+		at, eok := f.expires[args[0]]
+		f.del(args[0])
+		f.del(args[1])
+		switch {
+		case sok:
+			f.strs[args[1]] = s
+		case hok:
+			f.hashes[args[1]] = h
+		case zok:
+			f.zsets[args[1]] = z
+		}
+		if eok {
+			f.expires[args[1]] = at
+		}
+		return fakeStatus("OK")
+	case "SCAN":
+		return f.scan(args)
+	case "PUBLISH":
+		return f.publish(args[0], args[1])
+	case "HGETALL":
+		f.expire(args[0])
+		var out []string
+		for field, v := range f.hashes[args[0]] {
+			out = append(out, field, v)
+		}
+		return out
+	case "HGET":
+		f.expire(args[0])
+		if v, ok := f.hashes[args[0]][args[1]]; ok {
+			return v
+		}
+		return nil
+	case "HEXISTS":
+		f.expire(args[0])
+		if _, ok := f.hashes[args[0]][args[1]]; ok {
+			return 1
+		}
+		return 0
+	case "HSET":
+		f.expire(args[0])
+		if f.hashes[args[0]] == nil {
+			f.hashes[args[0]] = map[string]string{}
+		}
+		n := 0
+		for i := 1; i+1 < len(args); i += 2 {
+			if _, ok := f.hashes[args[0]][args[i]]; !ok {
+				n++
+			}
+			f.hashes[args[0]][args[i]] = args[i+1]
+		}
+		return n
+	case "ZADD":
+		f.expire(args[0])
+		if f.zsets[args[0]] == nil {
+			f.zsets[args[0]] = map[string]float64{}
+		}
+		n := 0
+		for i := 1; i+1 < len(args); i += 2 {
+			score, err := parseFakeScore(args[i])
+			if err != nil {
+				return fakeError("ERR value is not a valid float")
+			}
+			if _, ok := f.zsets[args[0]][args[i+1]]; !ok {
+				n++
+			}
+			f.zsets[args[0]][args[i+1]] = score
+		}
+		return n
+	case "ZREM":
+		f.expire(args[0])
+		n := 0
+		for _, member := range args[1:] {
+			if _, ok := f.zsets[args[0]][member]; ok {
+				delete(f.zsets[args[0]], member)
+				n++
+			}
+		}
+		if len(f.zsets[args[0]]) == 0 {
+			delete(f.zsets, args[0])
+		}
+		return n
+	case "ZCARD":
+		f.expire(args[0])
+		return len(f.zsets[args[0]])
+	case "ZSCORE":
+		f.expire(args[0])
+		if score, ok := f.zsets[args[0]][args[1]]; ok {
+			return formatFakeScore(score)
+		}
+		return nil
+	case "ZRANGE", "ZREVRANGE":
+		entries := f.sortedEntries(args[0], name == "ZREVRANGE")
+		start, _ := strconv.Atoi(args[1])
+		stop, _ := strconv.Atoi(args[2])
+		start, stop = clampRange(start, stop, len(entries))
+		if start > stop {
+			return []string{}
+		}
+		return entriesReply(entries[start:stop+1], hasArg(args[3:], "WITHSCORES"))
+	case "ZRANGEBYSCORE", "ZREVRANGEBYSCORE", "ZCOUNT":
+		lo, hi := args[1], args[2]
+		if name == "ZREVRANGEBYSCORE" {
+			lo, hi = hi, lo
+		}
+		matched, err := f.byScore(args[0], lo, hi, name == "ZREVRANGEBYSCORE")
+		if err != nil {
+			return fakeError(err.Error())
+		}
+		if name == "ZCOUNT" {
+			return len(matched)
+		}
+		for i := 3; i < len(args); i++ {
+			if strings.EqualFold(args[i], "LIMIT") && i+2 < len(args) {
+				offset, _ := strconv.Atoi(args[i+1])
+				count, _ := strconv.Atoi(args[i+2])
+				matched = matched[min(offset, len(matched)):]
+				if count >= 0 {
+					matched = matched[:min(count, len(matched))]
+				}
+			}
+		}
+		return entriesReply(matched, hasArg(args[3:], "WITHSCORES"))
+	case "ZRANDMEMBER":
+		entries := f.sortedEntries(args[0], false)
+		count := 1
+		if len(args) > 1 {
+			count, _ = strconv.Atoi(args[1])
+		}
+		return entriesReply(entries[:min(count, len(entries))], false)
+	case "EVAL", "EVALSHA":
+		sha := args[0]
+		if name == "EVAL" {
+			sha = sha1Hex(args[0])
+		}
+		script, ok := fakeScripts[sha]
+		if !ok {
+			if name == "EVALSHA" {
+				return fakeError("NOSCRIPT No matching script. Please use EVAL.")
+			}
+			return fakeError("ERR fake redis can't run this script")
+		}
+		numKeys, _ := strconv.Atoi(args[1])
+		return script(f, args[2:2+numKeys], args[2+numKeys:])
+	}
+	return fakeError(fmt.Sprintf("ERR unknown command '%s'", name))
+}
+
+func (f *fakeRedis) setCommand(args []string) any {
+	key, value := args[0], args[1]
+	var ttl time.Duration
+	nx, xx, get := false, false, false
+	for i := 2; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		case "GET":
+			get = true
+		case "EX", "PX":
+			n, _ := strconv.ParseInt(args[i+1], 10, 64)
+			ttl = time.Duration(n) * time.Millisecond
+			if strings.EqualFold(args[i], "EX") {
+				ttl = time.Duration(n) * time.Second
+			}
+			i++
+		}
+	}
+	f.expire(key)
+	old, existed := f.strs[key]
+	if (nx && f.has(key)) || (xx && !f.has(key)) {
+		if get && existed {
+			return old
+		}
+		return nil
+	}
+	f.del(key)
+	f.strs[key] = value
+	if ttl > 0 {
+		f.expires[key] = time.Now().Add(ttl)
+	}
+	if get {
+		if existed {
+			return old
+		}
+		return nil
+	}
+	return fakeStatus("OK")
+}
+
+// Whole keyspace in one page: SCAN 0 MATCH pattern → cursor 0
+func (f *fakeRedis) scan(args []string) any {
+	pattern := "*"
+	for i := 1; i+1 < len(args); i++ {
+		if strings.EqualFold(args[i], "MATCH") {
+			pattern = args[i+1]
+		}
+	}
+	keys := []string{}
+	for key := range f.keyset() {
+		if ok, _ := path.Match(pattern, key); ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return []any{"0", keys}
+}
+
+func (f *fakeRedis) keyset() map[string]bool {
+	keys := map[string]bool{}
+	for key := range f.strs {
+		keys[key] = true
+	}
+	for key := range f.hashes {
+		keys[key] = true
+	}
+	for key := range f.zsets {
+		keys[key] = true
+	}
+	for key := range keys {
+		if !f.has(key) {
+			delete(keys, key)
+		}
+	}
+	return keys
+}
+
+func (f *fakeRedis) strsOr(key, def string) string {
+	if v, ok := f.strs[key]; ok {
+		return v
+	}
+	return def
+}
+
+// Drop key if it has expired. Callers hold f.mu.
+func (f *fakeRedis) expire(key string) {
+	if at, ok := f.expires[key]; ok && !time.Now().Before(at) {
+		f.del(key)
+	}
+}
+
+func (f *fakeRedis) has(key string) bool {
+	f.expire(key)
+	_, s := f.strs[key]
+	_, h := f.hashes[key]
+	_, z := f.zsets[key]
+	return s || h || z
+}
+
+func (f *fakeRedis) del(key string) bool {
+	_, s := f.strs[key]
+	_, h := f.hashes[key]
+	_, z := f.zsets[key]
+	delete(f.strs, key)
+	delete(f.hashes, key)
+	delete(f.zsets, key)
+	delete(f.expires, key)
+	return s || h || z
+}
+
+type fakeZEntry struct {
+	member string
+	score  float64
+}
+
+// Members in Redis order: by score, ties by member (reversed for ZREV*)
+func (f *fakeRedis) sortedEntries(key string, rev bool) []fakeZEntry {
+	f.expire(key)
+	entries := make([]fakeZEntry, 0, len(f.zsets[key]))
+	for member, score := range f.zsets[key] {
+		entries = append(entries, fakeZEntry{member, score})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if rev {
+			a, b = b, a
+		}
+		if a.score != b.score {
+			return a.score < b.score
+		}
+		return a.member < b.member
+	})
+	return entries
+}
+
+func (f *fakeRedis) byScore(key, lo, hi string, rev bool) ([]fakeZEntry, error) {
+	min, minExcl, err := parseFakeBound(lo)
+	if err != nil {
+		return nil, err
+	}
+	max, maxExcl, err := parseFakeBound(hi)
+	if err != nil {
+		return nil, err
+	}
+	var matched []fakeZEntry
+	for _, e := range f.sortedEntries(key, rev) {
+		if e.score < min || (minExcl && e.score == min) || e.score > max || (maxExcl && e.score == max) {
+			continue
+		}
+		matched = append(matched, e)
+	}
+	return matched, nil
+}
+
+func parseFakeBound(s string) (float64, bool, error) {
+	exclusive := strings.HasPrefix(s, "(")
+	v, err := parseFakeScore(strings.TrimPrefix(s, "("))
+	if err != nil {
+		return 0, false, fmt.Errorf("ERR min or max is not a float")
+	}
+	return v, exclusive, nil
+}
+
+func parseFakeScore(s string) (float64, error) {
+	switch strings.ToLower(s) {
+	case "+inf", "inf":
+		return math.Inf(1), nil
+	case "-inf":
+		return math.Inf(-1), nil
+	}
+	return strconv.ParseFloat(s, 64)
+}
+
+func formatFakeScore(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "inf"
+	case math.IsInf(v, -1):
+		return "-inf"
+	}
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+func entriesReply(entries []fakeZEntry, withScores bool) []string {
+	out := make([]string, 0, len(entries)*2)
+	for _, e := range entries {
+		out = append(out, e.member)
+		if withScores {
+			out = append(out, formatFakeScore(e.score))
+		}
+	}
+	return out
+}
+
+func clampRange(start, stop, n int) (int, int) {
+	if start < 0 {
+		start = max(n+start, 0)
+	}
+	if stop < 0 {
+		stop = n + stop
+	}
+	return start, min(stop, n-1)
+}
+
+func hasArg(args []string, want string) bool {
+	for _, a := range args {
+		if strings.EqualFold(a, want) {
+			return true
+		}
+	}
+	return false
+}
+
+func sha1Hex(s string) string {
+	sum := sha1.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// Go versions of the service's Lua scripts, by SHA1. Each runs with f.mu held, so
+// it is atomic like the real thing. Filled in by init, since the scripts call run.
+var fakeScripts map[string]func(f *fakeRedis, keys, argv []string) any
+
+func init() {
+	fakeScripts = map[string]func(f *fakeRedis, keys, argv []string) any{
+		releaseLockScript.Hash(): func(f *fakeRedis, keys, argv []string) any {
+			if v, ok := f.run("GET", keys[:1]).(string); ok && v == argv[0] {
+				return f.run("DEL", keys[:1])
+			}
+			return 0
+		},
+		finishIdempotencyScript.Hash(): func(f *fakeRedis, keys, argv []string) any {
+			if v, ok := f.run("GET", keys[:1]).(string); !ok || v != argv[0] {
+				return 0
+			}
+			if argv[1] == "" {
+				return f.run("DEL", keys[:1])
+			}
+			f.run("SET", []string{keys[0], argv[1], "PX", argv[2]})
+			return 1
+		},
+		setIfVersionScript.Hash(): func(f *fakeRedis, keys, argv []string) any {
+			current, ok := f.run("GET", keys[:1]).(string)
+			if !ok {
+				current = "0"
+			}
+			if current != argv[0] || f.has(keys[2]) {
+				return 0
+			}
+			f.run("SET", []string{keys[1], argv[1], "PX", argv[2]})
+			return 1
+		},
+		cacheExtremesScript.Hash(): func(f *fakeRedis, keys, argv []string) any {
+			if f.has(keys[0]) {
+				return 0
+			}
+			f.run("HSET", append([]string{keys[0]}, argv[1:]...))
+			f.run("PEXPIRE", []string{keys[0], argv[0]})
+			return 1
+		},
+		raiseExtremesScript.Hash(): func(f *fakeRedis, keys, argv []string) any {
+			if f.run("HEXISTS", []string{keys[0], "computed"}) == 0 {
+				f.run("HSET", []string{keys[0], "written", "1"})
+				f.run("PEXPIRE", []string{keys[0], argv[2]})
+				return 0
+			}
+			price, _ := strconv.ParseFloat(argv[0], 64)
+			ath, athErr := strconv.ParseFloat(f.hashes[keys[0]]["ath"], 64)
+			atl, atlErr := strconv.ParseFloat(f.hashes[keys[0]]["atl"], 64)
+			if athErr != nil || price > ath {
+				f.run("HSET", []string{keys[0], "ath", argv[0], "ath_at", argv[1]})
+			}
+			if atlErr != nil || price < atl {
+				f.run("HSET", []string{keys[0], "atl", argv[0], "atl_at", argv[1]})
+			}
+			return 1
+		},
+		expireRecordScript.Hash(): func(f *fakeRedis, keys, argv []string) any {
+			value, ok := f.run("GET", keys[:1]).(string)
+			if !ok || value == argv[1] {
+				return 0
+			}
+			return f.run("PEXPIRE", []string{keys[0], argv[0]})
+		},
+	}
+}
+
+func (c *fakeConn) reply(v any) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	writeRESP(c.w, v)
+	c.w.Flush()
+}
+
+func writeRESP(w *bufio.Writer, v any) {
+	switch v := v.(type) {
+	case nil:
+		w.WriteString("$-1\r\n")
+	case fakeStatus:
+		fmt.Fprintf(w, "+%s\r\n", v)
+	case fakeError:
+		fmt.Fprintf(w, "-%s\r\n", v)
+	case int:
+		fmt.Fprintf(w, ":%d\r\n", v)
+	case int64:
+		fmt.Fprintf(w, ":%d\r\n", v)
+	case string:
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(v), v)
+	case []string:
+		fmt.Fprintf(w, "*%d\r\n", len(v))
+		for _, s := range v {
+			writeRESP(w, s)
+		}
+	case []any:
+		fmt.Fprintf(w, "*%d\r\n", len(v))
+		for _, e := range v {
+			writeRESP(w, e)
+		}
+	default:
+		panic(fmt.Sprintf("fake redis: can't encode %T", v))
+	}
+}
+
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "*") {
+		return nil, fmt.Errorf("unexpected %q", line)
+	}
+	n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+	args := make([]string, n)
+	for i := range args {
+		header, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, _ := strconv.Atoi(strings.TrimSpace(header[1:]))
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
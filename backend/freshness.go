@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"math/rand"
+	"time"
+)
+
+const defaultFreshnessTolerance = time.Second
+
+// On a sampled fraction of cache hits (FRESHNESS_SAMPLE_RATE), compare the cached
+// updated_at with the row's. A record more than freshnessTolerance behind, or one
+// whose row is gone, slipped past invalidation: reload it from the DB.
+// Returns the record to serve and whether it replaced the cached one.
+func (cs *CacheService) verifyFreshness(ctx context.Context, cached *Bitcoin) (*Bitcoin, bool, error) {
+	if cs.freshnessSampleRate <= 0 || rand.Float64() >= cs.freshnessSampleRate {
+		return cached, false, nil
+	}
+
+	var updatedAt time.Time
+	start := time.Now()
+	err := cs.db.QueryRowContext(ctx, `SELECT updated_at FROM bitcoins WHERE symbol = $1 AND deleted_at IS NULL`, cached.Symbol).Scan(&updatedAt)
+	cs.observeDB("freshness_check", start)
+
+	switch {
+	case err == sql.ErrNoRows:
+		slog.WarnContext(ctx, "stale cache entry: row deleted", "symbol", cached.Symbol)
+	case err != nil:
+		// The check is opportunistic; serve the cached record
+		slog.ErrorContext(ctx, "checking freshness failed", "symbol", cached.Symbol, "error", err)
+		cs.metrics.freshnessChecks.WithLabelValues("error").Inc()
+		return cached, false, nil
+	case updatedAt.Sub(cached.UpdatedAt) <= cs.freshnessTolerance:
+		cs.metrics.freshnessChecks.WithLabelValues("fresh").Inc()
+		return cached, false, nil
+	default:
+		slog.WarnContext(ctx, "stale cache entry", "symbol", cached.Symbol, "cached_updated_at", cached.UpdatedAt, "db_updated_at", updatedAt)
+	}
+
+	cs.metrics.freshnessChecks.WithLabelValues("stale").Inc()
+	fresh, err := cs.loadBitcoin(ctx, cached.Symbol)
+	return fresh, true, err
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// How long each dependency ping in /health and /ready may take (HEALTH_CHECK_TIMEOUT)
+const defaultHealthCheckTimeout = 1 * time.Second
+
+type dependencyStatus struct {
+	Postgres string `json:"postgres"` // "up" or "down"
+	Redis    string `json:"redis"`
+}
+
+func (d dependencyStatus) healthy() bool {
+	return d.Postgres == "up" && d.Redis == "up"
+}
+
+func upOrDown(err error) string {
+	if err != nil {
+		return "down"
+	}
+	return "up"
+}
+
+// Ping Postgres and Redis concurrently, each bounded by timeout
+func (cs *CacheService) checkDependencies(ctx context.Context, timeout time.Duration) dependencyStatus {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	redisErr := make(chan error, 1)
+	go func() { redisErr <- cs.redisClient.Ping(ctx).Err() }()
+	pgErr := cs.db.PingContext(ctx)
+
+	return dependencyStatus{Postgres: upOrDown(pgErr), Redis: upOrDown(<-redisErr)}
+}
+
+// Readiness only needs Postgres: without Redis the instance still serves (DB-only mode)
+func (cs *CacheService) postgresReachable(ctx context.Context, timeout time.Duration) bool {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	return cs.db.PingContext(ctx) == nil
+}
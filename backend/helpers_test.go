@@ -0,0 +1,69 @@
+package main
+
+import (
+	"database/sql/driver"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// A CacheService with default settings and its own metrics registry. db and Redis are
+// left nil; tests that need them set cs.db and cs.redisClient.
+func newTestCacheService(t testing.TB) *CacheService {
+	t.Helper()
+	return NewCacheService(nil, nil, NewMetrics(prometheus.NewRegistry(), defaultLatencyBuckets))
+}
+
+// A CacheService backed by a fake Redis and a fake database answered by handler
+func newFakeBackedCacheService(t testing.TB, handler fakeHandler) (*CacheService, *fakeRedis, *fakeDB) {
+	t.Helper()
+	fr, client := newFakeRedis(t)
+	fdb, db := newFakeDB(t, handler)
+	cs := newTestCacheService(t)
+	cs.db, cs.redisClient = db, client
+	return cs, fr, fdb
+}
+
+// Columns of the bitcoins reads that scan symbol, price, supply, created_at, updated_at
+var bitcoinColumns = []string{"symbol", "price", "supply", "created_at", "updated_at"}
+
+var testTime = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+// A bitcoins row for bitcoinColumns; price "" is NULL
+func bitcoinRow(symbol, price string) []driver.Value {
+	var p driver.Value
+	if price != "" {
+		p = price
+	}
+	return []driver.Value{symbol, p, nil, testTime, testTime}
+}
+
+// The current value of a single counter, gathered through a registry of its own
+func counterValue(t testing.TB, counter prometheus.Counter) float64 {
+	t.Helper()
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(counter)
+	families, err := registry.Gather()
+	if err != nil || len(families) != 1 || len(families[0].GetMetric()) != 1 {
+		t.Fatalf("gathering counter: %v (%d families)", err, len(families))
+	}
+	return families[0].GetMetric()[0].GetCounter().GetValue()
+}
+
+// Poll cond until it holds, failing the test after a few seconds
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// Historical lookups never change once the time has passed, so cache them for a long time
+const historicalCacheTTL = 24 * time.Hour
+
+type PricePoint struct {
+	Symbol     string          `json:"symbol"`
+	Price      decimal.Decimal `json:"price"`
+	RecordedAt time.Time       `json:"recorded_at"`
+}
+
+func (cs *CacheService) getPriceAtCacheKey(symbol string, t time.Time) string {
+	return fmt.Sprintf("%sat:%s:%d", cachePrefix, symbol, t.Unix())
+}
+
+// Get the price a symbol had at a point in time (latest history record at or before t)
+func (cs *CacheService) GetPriceAt(ctx context.Context, symbol string, t time.Time) (*PricePoint, error) {
+	t = t.UTC().Truncate(time.Second)
+	cacheKey := cs.getPriceAtCacheKey(symbol, t)
+
+	start := time.Now()
+	cached, err := cs.redisClient.Get(cs.ctx, cacheKey).Result()
+	if err == nil {
+		var point PricePoint
+		if err := decodeCacheValue([]byte(cached), &point); err != nil {
+			slog.ErrorContext(ctx, "unmarshaling cached price point failed", "symbol", symbol, "error", err)
+		} else {
+			cs.logCacheLookup(cs.ctx, "price_at", true, start, "symbol", symbol, "at", t)
+			return &point, nil
+		}
+	}
+
+	cs.logCacheLookup(cs.ctx, "price_at", false, start, "symbol", symbol, "at", t)
+
+	var point PricePoint
+	err = cs.db.QueryRowContext(ctx, `
+		SELECT symbol, price, recorded_at
+		FROM bitcoin_price_history
+		WHERE symbol = $1 AND recorded_at <= $2
+		ORDER BY recorded_at DESC
+		LIMIT 1
+	`, symbol, t).Scan(&point.Symbol, &point.Price, &point.RecordedAt)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+
+	// Only a lookup strictly in the past is final; "now" can still gain newer records
+	if t.Before(time.Now().Add(-time.Minute)) {
+		data, err := cs.encodeCacheValue(point)
+		if err != nil {
+			slog.ErrorContext(ctx, "marshaling price point failed", "symbol", symbol, "error", err)
+		} else if err := cs.redisClient.Set(cs.ctx, cacheKey, data, historicalCacheTTL).Err(); err != nil {
+			slog.ErrorContext(ctx, "caching price point failed", "symbol", symbol, "error", err)
+		}
+	}
+
+	return &point, nil
+}
+
+// Default window of GET /api/bitcoins/:symbol/history, and the most points one response returns
+const (
+	defaultHistoryWindow = 24 * time.Hour
+	maxHistoryPoints     = 5000
+)
+
+type cachedPriceHistory struct {
+	Points    []PricePoint `json:"points"`
+	Truncated bool         `json:"truncated"`
+}
+
+func (cs *CacheService) getPriceHistoryCacheKey(symbol string, from, to time.Time) string {
+	return fmt.Sprintf("%shistory:%s:%d:%d", cachePrefix, symbol, from.Unix(), to.Unix())
+}
+
+// The history records of a symbol in [from, to], oldest first. At most maxHistoryPoints
+// are returned; truncated reports whether the window held more.
+func (cs *CacheService) GetPriceHistory(ctx context.Context, symbol string, from, to time.Time) (points []PricePoint, truncated bool, err error) {
+	from = from.UTC().Truncate(time.Second)
+	to = to.UTC().Truncate(time.Second)
+	cacheKey := cs.getPriceHistoryCacheKey(symbol, from, to)
+
+	start := time.Now()
+	cached, err := cs.redisClient.Get(ctx, cacheKey).Result()
+	if err == nil {
+		var series cachedPriceHistory
+		if err := decodeCacheValue([]byte(cached), &series); err != nil {
+			slog.ErrorContext(ctx, "unmarshaling cached price history failed", "symbol", symbol, "error", err)
+		} else {
+			cs.logCacheLookup(ctx, "history", true, start, "symbol", symbol)
+			return series.Points, series.Truncated, nil
+		}
+	}
+	cs.logCacheLookup(ctx, "history", false, start, "symbol", symbol)
+
+	rows, err := cs.db.QueryContext(ctx, `
+		SELECT symbol, price, recorded_at
+		FROM bitcoin_price_history
+		WHERE symbol = $1 AND recorded_at >= $2 AND recorded_at <= $3
+		ORDER BY recorded_at ASC
+		LIMIT $4
+	`, symbol, from, to, maxHistoryPoints+1)
+	if err != nil {
+		return nil, false, fmt.Errorf("database error: %w", err)
+	}
+	defer rows.Close()
+
+	points = []PricePoint{}
+	for rows.Next() {
+		var point PricePoint
+		if err := rows.Scan(&point.Symbol, &point.Price, &point.RecordedAt); err != nil {
+			return nil, false, fmt.Errorf("database error: %w", err)
+		}
+		points = append(points, point)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, false, fmt.Errorf("database error: %w", err)
+	}
+	if len(points) > maxHistoryPoints {
+		points, truncated = points[:maxHistoryPoints], true
+	}
+
+	// As with GetPriceAt, only a window that ended in the past is final
+	if to.Before(time.Now().Add(-time.Minute)) {
+		data, err := cs.encodeCacheValue(cachedPriceHistory{Points: points, Truncated: truncated})
+		if err != nil {
+			slog.ErrorContext(ctx, "marshaling price history failed", "symbol", symbol, "error", err)
+		} else if err := cs.redisClient.Set(cs.ctx, cacheKey, data, historicalCacheTTL).Err(); err != nil {
+			slog.ErrorContext(ctx, "caching price history failed", "symbol", symbol, "error", err)
+		}
+	}
+
+	return points, truncated, nil
+}
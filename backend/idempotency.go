@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+// A write sent with an Idempotency-Key is executed once: a retry with the same key
+// within IDEMPOTENCY_TTL gets the original response back instead of writing again.
+// Keys are scoped per API key, method, route and symbol, so the same key on another
+// endpoint or symbol is a different request.
+const (
+	idempotencyHeader         = "Idempotency-Key"
+	idempotencyReplayedHeader = "Idempotent-Replayed"
+	idempotencyPrefix         = "bitcoin:idempotency:"
+	defaultIdempotencyTTL     = 24 * time.Hour
+
+	// How long a claim is held while its request runs, so a crashed instance's claim
+	// doesn't block retries for the whole IDEMPOTENCY_TTL. Longer than REQUEST_TIMEOUT.
+	idempotencyPendingTTL = time.Minute
+	idempotencyPending    = "pending:"
+
+	maxIdempotencyKeyLength = 255
+)
+
+// The stored outcome of a request, replayed for retries
+type idempotencyRecord struct {
+	Fingerprint string              `json:"fingerprint"` // Of the request body, to catch a key reused for another request
+	Status      int                 `json:"status"`
+	Header      map[string][]string `json:"header"` // Headers the handler set
+	Body        []byte              `json:"body"`
+}
+
+// Replace our own claim (ARGV[1]) with the finished record (ARGV[2], kept ARGV[3] ms),
+// or drop the claim when ARGV[2] is empty. A claim that expired and was taken by
+// another request is left alone.
+var finishIdempotencyScript = redis.NewScript(`
+	if redis.call("GET", KEYS[1]) ~= ARGV[1] then
+		return 0
+	end
+	if ARGV[2] == "" then
+		return redis.call("DEL", KEYS[1])
+	end
+	redis.call("SET", KEYS[1], ARGV[2], "PX", ARGV[3])
+	return 1
+`)
+
+// Captures the response body so it can be stored once the handler is done
+type recordingWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *recordingWriter) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *recordingWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// Only final outcomes are kept: a server error or a 429 is worth retrying for real
+func storableStatus(status int) bool {
+	return status < http.StatusInternalServerError && status != http.StatusTooManyRequests
+}
+
+func (cs *CacheService) idempotencyCacheKey(c *gin.Context, key string) string {
+	scope := []string{c.Request.Method, c.FullPath(), strings.ToUpper(c.Param("symbol")), key}
+	if apiKey, ok := c.Get(apiKeyContextKey); ok {
+		scope = append(scope, strconv.FormatInt(apiKey.(*APIKey).ID, 10))
+	}
+	sum := sha256.Sum256([]byte(strings.Join(scope, "\x00")))
+	return idempotencyPrefix + hex.EncodeToString(sum[:])
+}
+
+// Honour Idempotency-Key on /api/ writes. The first request with a key claims it
+// (SET NX) and runs; a request arriving while it runs gets 409, and one arriving
+// after gets the stored response. Like API key quotas, keys aren't checked while
+// Redis is unavailable.
+func idempotencyMiddleware(cs *CacheService, ttl time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(idempotencyHeader)
+		if key == "" || !isWriteMethod(c.Request.Method) || !strings.HasPrefix(c.Request.URL.Path, "/api/") {
+			c.Next()
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			c.AbortWithStatusJSON(http.StatusBadRequest, errorJSON(CodeValidationFailed,
+				fmt.Sprintf("%s must be at most %d characters", idempotencyHeader, maxIdempotencyKeyLength)))
+			return
+		}
+		if cs.cacheDisabled() {
+			c.Next()
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, errorJSON(CodeValidationFailed, "Failed to read request body"))
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		sum := sha256.Sum256(body)
+		fingerprint := hex.EncodeToString(sum[:])
+
+		cacheKey := cs.idempotencyCacheKey(c, key)
+		nonce, err := randomHex(16)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, errorJSON(CodeInternal, "Failed to generate idempotency token"))
+			return
+		}
+		claim := idempotencyPending + nonce
+
+		// A record dropped between our SET NX and GET (its request failed) can be claimed again
+		for attempt := 0; ; attempt++ {
+			claimed, err := cs.redisClient.SetNX(cs.ctx, cacheKey, claim, idempotencyPendingTTL).Result()
+			if err != nil {
+				// Fail open, as for quotas: a Redis blip shouldn't block writes
+				slog.ErrorContext(c.Request.Context(), "claiming idempotency key failed", "error", err)
+				c.Next()
+				return
+			}
+			if claimed {
+				break
+			}
+
+			stored, err := cs.redisClient.Get(cs.ctx, cacheKey).Result()
+			if err == redis.Nil && attempt == 0 {
+				continue
+			}
+			if err != nil {
+				slog.ErrorContext(c.Request.Context(), "reading idempotency key failed", "error", err)
+				c.AbortWithStatusJSON(http.StatusInternalServerError, errorJSON(codeFor(err), "Failed to check idempotency key"))
+				return
+			}
+			replayIdempotent(c, stored, fingerprint)
+			return
+		}
+
+		presetHeaders := make(map[string]bool, len(c.Writer.Header()))
+		for name := range c.Writer.Header() {
+			presetHeaders[name] = true
+		}
+		recorder := &recordingWriter{ResponseWriter: c.Writer}
+		c.Writer = recorder
+		c.Next()
+
+		// Keep the outcome, or give the key up so a retry runs the request again
+		var data []byte
+		if status := recorder.Status(); storableStatus(status) {
+			record := idempotencyRecord{Fingerprint: fingerprint, Status: status, Header: map[string][]string{}, Body: recorder.body.Bytes()}
+			for name, values := range recorder.Header() {
+				if !presetHeaders[name] {
+					record.Header[name] = values
+				}
+			}
+			if data, err = json.Marshal(record); err != nil {
+				slog.ErrorContext(c.Request.Context(), "marshaling idempotency record failed", "error", err)
+				data = nil
+			}
+		}
+		if err := finishIdempotencyScript.Run(cs.ctx, cs.redisClient, []string{cacheKey}, claim, data, ttl.Milliseconds()).Err(); err != nil {
+			slog.ErrorContext(c.Request.Context(), "storing idempotency record failed", "error", err)
+		}
+	}
+}
+
+// Answer a request whose key is already taken: 409 while the first request runs,
+// 422 if the key was used for a different body, else the stored response
+func replayIdempotent(c *gin.Context, stored, fingerprint string) {
+	if strings.HasPrefix(stored, idempotencyPending) {
+		c.Header("Retry-After", "1")
+		c.AbortWithStatusJSON(http.StatusConflict, errorJSON(CodeConflict, "A request with this "+idempotencyHeader+" is still in progress"))
+		return
+	}
+
+	var record idempotencyRecord
+	if err := json.Unmarshal([]byte(stored), &record); err != nil {
+		slog.ErrorContext(c.Request.Context(), "unmarshaling idempotency record failed", "error", err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, errorJSON(CodeInternal, "Failed to read stored response"))
+		return
+	}
+	if record.Fingerprint != fingerprint {
+		c.AbortWithStatusJSON(http.StatusUnprocessableEntity, errorJSON(CodeIdempotencyKeyReused,
+			idempotencyHeader+" was already used for a different request"))
+		return
+	}
+
+	for name, values := range record.Header {
+		for _, value := range values {
+			c.Writer.Header().Add(name, value)
+		}
+	}
+	c.Header(idempotencyReplayedHeader, "true")
+	c.Status(record.Status)
+	c.Writer.WriteHeaderNow()
+	c.Writer.Write(record.Body)
+	c.Abort()
+}
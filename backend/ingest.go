@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	ingestRetryMinBackoff = 500 * time.Millisecond
+	ingestRetryMaxBackoff = 30 * time.Second
+	ingestCommitTimeout   = 5 * time.Second
+)
+
+// A price update as published by the feed
+type ingestMessage struct {
+	Symbol string           `json:"symbol"`
+	Price  *decimal.Decimal `json:"price"`
+	Supply *float64         `json:"supply"`
+}
+
+func parseIngestMessage(value []byte) (*ingestMessage, error) {
+	var m ingestMessage
+	if err := json.Unmarshal(value, &m); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+	if strings.TrimSpace(m.Symbol) == "" || m.Price == nil {
+		return nil, errors.New("symbol and price are required")
+	}
+	symbol, err := normalizeSymbol(m.Symbol)
+	if err != nil {
+		return nil, err
+	}
+	m.Symbol = symbol
+	if m.Supply != nil && *m.Supply < 0 {
+		return nil, errors.New("supply must be non-negative")
+	}
+	return &m, nil
+}
+
+// Consume price updates from Kafka and apply them through SetBitcoin until ctx is
+// cancelled. Offsets are committed only once a message is persisted (or skipped as
+// malformed), so a crash replays uncommitted messages rather than losing them.
+// deadLetter may be nil, in which case malformed messages are only logged.
+func (cs *CacheService) RunIngestConsumer(ctx context.Context, reader *kafka.Reader, deadLetter *kafka.Writer) {
+	defer reader.Close()
+
+	for {
+		msg, err := reader.FetchMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				slog.InfoContext(ctx, "ingest consumer stopped")
+				return
+			}
+			slog.ErrorContext(ctx, "fetching ingest message failed", "error", err)
+			continue
+		}
+
+		update, err := parseIngestMessage(msg.Value)
+		if err != nil {
+			slog.WarnContext(ctx, "skipping malformed ingest message", "partition", msg.Partition, "offset", msg.Offset, "error", err)
+			if deadLetter != nil {
+				dead := kafka.Message{
+					Key:     msg.Key,
+					Value:   msg.Value,
+					Headers: append(msg.Headers, kafka.Header{Key: "error", Value: []byte(err.Error())}),
+				}
+				if err := deadLetter.WriteMessages(ctx, dead); err != nil {
+					// Leave the offset uncommitted so the message is retried rather than lost
+					slog.ErrorContext(ctx, "dead-lettering ingest message failed", "offset", msg.Offset, "error", err)
+					continue
+				}
+			}
+		} else if !cs.applyIngestMessage(ctx, update) {
+			return
+		}
+
+		// Not bound to ctx: a message persisted just before shutdown should still be committed
+		commitCtx, cancel := context.WithTimeout(context.Background(), ingestCommitTimeout)
+		if err := reader.CommitMessages(commitCtx, msg); err != nil {
+			slog.ErrorContext(ctx, "committing ingest offset failed", "offset", msg.Offset, "error", err)
+		}
+		cancel()
+	}
+}
+
+// Persist one update, retrying with backoff while the database is unavailable so
+// later offsets are never committed past an unpersisted message. Returns false if
+// ctx was cancelled first.
+func (cs *CacheService) applyIngestMessage(ctx context.Context, update *ingestMessage) bool {
+	backoff := ingestRetryMinBackoff
+	for {
+		_, _, err := cs.SetBitcoin(ctx, update.Symbol, *update.Price, update.Supply, 0)
+		if err == nil {
+			return true
+		}
+
+		// Not a failure: hold the update until the symbol's interval has passed
+		var throttled *ThrottledError
+		if errors.As(err, &throttled) {
+			select {
+			case <-ctx.Done():
+				return false
+			case <-time.After(throttled.RetryAfter):
+			}
+			continue
+		}
+
+		slog.ErrorContext(ctx, "applying ingest update failed, retrying", "symbol", update.Symbol, "backoff", backoff.String(), "error", err)
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, ingestRetryMaxBackoff)
+	}
+}
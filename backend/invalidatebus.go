@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Redis pub/sub channel on which every write names the symbol it changed, so each
+// replica can drop whatever it holds in process for that symbol. Redis itself is
+// shared and already up to date; this is only for state local to an instance.
+const invalidateChannel = "bitcoin:invalidate"
+
+type InvalidationMessage struct {
+	Symbol string `json:"symbol"`
+	Op     string `json:"op"`     // eventTypeUpdate or eventTypeDelete
+	Origin string `json:"origin"` // INSTANCE_ID of the writer, which skips its own messages
+}
+
+// Register fn to be called with each symbol another instance changed. An empty symbol
+// means messages may have been missed (the subscription dropped), so everything held
+// locally should be treated as stale. Must be called before RunInvalidationSubscriber.
+func (cs *CacheService) onInvalidate(fn func(symbol string)) {
+	cs.invalidationHandlers = append(cs.invalidationHandlers, fn)
+}
+
+// Best effort, like publishChange: a lost message leaves other instances' local state
+// stale until it expires, but never affects the write itself
+func (cs *CacheService) publishInvalidation(ctx context.Context, symbol, op string) {
+	if cs.cacheWritesDisabled() {
+		return
+	}
+	data, err := json.Marshal(InvalidationMessage{Symbol: symbol, Op: op, Origin: cs.instanceID})
+	if err != nil {
+		slog.ErrorContext(ctx, "marshaling invalidation failed", "symbol", symbol, "error", err)
+		return
+	}
+	if err := cs.redisClient.Publish(cs.ctx, invalidateChannel, data).Err(); err != nil {
+		slog.ErrorContext(ctx, "publishing invalidation failed", "symbol", symbol, "error", err)
+	}
+}
+
+// Apply other instances' invalidations until ctx is cancelled, resubscribing with
+// backoff when the subscription drops
+func (cs *CacheService) RunInvalidationSubscriber(ctx context.Context) {
+	backoff := cs.pubsubMinBackoff
+	for {
+		sub := cs.redisClient.Subscribe(ctx, invalidateChannel)
+		err := cs.receiveInvalidations(ctx, sub, func() { backoff = cs.pubsubMinBackoff })
+		sub.Close()
+		if ctx.Err() != nil {
+			return
+		}
+
+		cs.metrics.pubsubReconnects.Inc()
+		slog.WarnContext(ctx, "invalidation subscription dropped, resubscribing", "error", err, "backoff", backoff.String())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, cs.pubsubMaxBackoff)
+
+		// Whatever was published during the gap is lost
+		cs.invalidateLocal("")
+	}
+}
+
+func (cs *CacheService) receiveInvalidations(ctx context.Context, sub *redis.PubSub, received func()) error {
+	for {
+		msg, err := sub.ReceiveMessage(ctx)
+		if err != nil {
+			return err
+		}
+		received()
+
+		var inv InvalidationMessage
+		if err := json.Unmarshal([]byte(msg.Payload), &inv); err != nil {
+			slog.ErrorContext(ctx, "unmarshaling invalidation failed", "error", err)
+			continue
+		}
+		if inv.Origin == cs.instanceID || inv.Symbol == "" {
+			continue
+		}
+		cs.metrics.invalidationsReceived.Inc()
+		cs.invalidateLocal(inv.Symbol)
+	}
+}
+
+func (cs *CacheService) invalidateLocal(symbol string) {
+	for _, fn := range cs.invalidationHandlers {
+		fn(symbol)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestInvalidationSubscriberSurvivesDroppedSubscription(t *testing.T) {
+	cs, fr, _ := newFakeBackedCacheService(t, nil)
+	cs.pubsubMinBackoff, cs.pubsubMaxBackoff = 10*time.Millisecond, 20*time.Millisecond
+
+	var mu sync.Mutex
+	var invalidated []string
+	cs.onInvalidate(func(symbol string) {
+		mu.Lock()
+		invalidated = append(invalidated, symbol)
+		mu.Unlock()
+	})
+	seen := func(n int) func() bool {
+		return func() bool {
+			mu.Lock()
+			defer mu.Unlock()
+			return len(invalidated) >= n
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		cs.RunInvalidationSubscriber(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		// A blocked receive doesn't watch ctx; closing the connection ends it
+		cancel()
+		fr.dropSubscribers()
+		<-done
+	})
+
+	publish := func(symbol, origin string) {
+		t.Helper()
+		data, _ := json.Marshal(InvalidationMessage{Symbol: symbol, Op: eventTypeUpdate, Origin: origin})
+		if err := cs.redisClient.Publish(ctx, invalidateChannel, data).Err(); err != nil {
+			t.Fatalf("publish: %v", err)
+		}
+	}
+
+	waitFor(t, "the subscription", func() bool { return fr.subscribers(invalidateChannel) == 1 })
+	publish("ETH", cs.instanceID) // Our own write: skipped
+	publish("BTC", "other-instance")
+	waitFor(t, "the first invalidation", seen(1))
+
+	fr.dropSubscribers()
+	waitFor(t, "the resubscription", func() bool { return fr.subscribers(invalidateChannel) == 1 })
+	waitFor(t, "the gap invalidation", seen(2))
+	publish("SOL", "other-instance")
+	waitFor(t, "an invalidation after resubscribing", seen(3))
+
+	mu.Lock()
+	got := append([]string(nil), invalidated...)
+	mu.Unlock()
+	// "" after the drop: anything published while unsubscribed was missed
+	if want := []string{"BTC", "", "SOL"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("invalidated %q, want %q", got, want)
+	}
+	if n := counterValue(t, cs.metrics.pubsubReconnects); n != 1 {
+		t.Errorf("pubsub reconnects = %v, want 1", n)
+	}
+	if n := counterValue(t, cs.metrics.invalidationsReceived); n != 2 {
+		t.Errorf("invalidations received = %v, want 2", n)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// With RANKINGS_INVALIDATION_DEBOUNCE set, a burst of writes invalidates the derived
+// caches once, after the burst settles, instead of once per write. Until then readers
+// keep getting the pre-burst payloads, and rankingsPendingKey stops any rebuild that
+// runs mid-burst from being cached, so nobody is served a half-updated ranking from
+// cache. A steady stream of writes still flushes at least every
+// debounceMaxDelayFactor windows.
+const (
+	rankingsPendingKey     = "bitcoin:rankings:pending"
+	debounceMaxDelayFactor = 10
+)
+
+type invalidationDebouncer struct {
+	mu    sync.Mutex
+	timer *time.Timer
+	first time.Time // When the oldest unflushed write arrived
+}
+
+// Defer the derived-cache invalidation for a write. Per-symbol keys are dropped right
+// away; only the shared derived caches wait for the burst to end.
+func (cs *CacheService) debounceInvalidation(ctx context.Context, extraKeys ...string) {
+	maxDelay := debounceMaxDelayFactor * cs.invalidationDebounce
+
+	pipe := cs.redisClient.TxPipeline()
+	pipe.Incr(cs.ctx, dataEpochKey)
+	if len(extraKeys) > 0 {
+		pipe.Del(cs.ctx, extraKeys...)
+	}
+	pipe.Set(cs.ctx, rankingsPendingKey, 1, maxDelay+cs.invalidationDebounce)
+	if _, err := pipe.Exec(cs.ctx); err != nil {
+		// Without the marker a mid-burst rebuild could be cached; don't risk it
+		slog.ErrorContext(ctx, "deferring invalidation failed, invalidating now", "error", err)
+		cs.invalidateDerivedNow(ctx, extraKeys...)
+		return
+	}
+
+	d := &cs.debouncer
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	if d.timer == nil {
+		d.first = now
+	} else {
+		d.timer.Stop()
+	}
+	delay := cs.invalidationDebounce
+	if remaining := d.first.Add(maxDelay).Sub(now); remaining < delay {
+		delay = max(remaining, 0)
+	}
+	d.timer = time.AfterFunc(delay, cs.flushInvalidation)
+}
+
+// Run a deferred invalidation now, if one is pending. Also called on shutdown so a
+// burst's invalidation isn't lost with the timer.
+func (cs *CacheService) flushInvalidation() {
+	d := &cs.debouncer
+	d.mu.Lock()
+	if d.timer == nil {
+		d.mu.Unlock()
+		return
+	}
+	d.timer.Stop()
+	d.timer = nil
+	burst := time.Since(d.first)
+	d.mu.Unlock()
+
+	cs.invalidateDerivedNow(cs.ctx)
+	slog.InfoContext(cs.ctx, "debounced invalidation flushed", "burst_ms", latencyMs(burst))
+}
+
+// Whether a deferred invalidation is outstanding, in which case freshly built derived
+// payloads may mix pre- and post-burst data and must not be cached
+func (cs *CacheService) invalidationPending() bool {
+	if cs.invalidationDebounce <= 0 {
+		return false
+	}
+	n, err := cs.redisClient.Exists(cs.ctx, rankingsPendingKey).Result()
+	return err != nil || n > 0
+}
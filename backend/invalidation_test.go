@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestDebouncedInvalidationFlushesBurstOnce(t *testing.T) {
+	cs, fr, _ := newFakeBackedCacheService(t, nil)
+	cs.invalidationDebounce = 200 * time.Millisecond
+	fr.set(rankCacheKey, "pre-burst")
+
+	for i := 0; i < 20; i++ {
+		cs.invalidateDerived(context.Background())
+		time.Sleep(time.Millisecond)
+	}
+
+	// Mid-burst: readers keep the pre-burst payload and rebuilds aren't cached
+	if v, _ := fr.get(rankCacheKey); v != "pre-burst" {
+		t.Errorf("rankings payload = %q mid-burst, want the pre-burst payload kept", v)
+	}
+	if !cs.invalidationPending() {
+		t.Error("no invalidation pending mid-burst")
+	}
+	cs.redisClient.Del(cs.ctx, rankCacheKey)
+	cs.cacheRankings(context.Background(), cs.rankingsVersion(), []Bitcoin{{Symbol: "TORN"}})
+	if fr.exists(rankCacheKey) {
+		t.Error("a rankings rebuild was cached while the burst's invalidation was pending")
+	}
+
+	waitFor(t, "the debounced flush", func() bool { return !fr.exists(rankingsPendingKey) })
+	time.Sleep(2 * cs.invalidationDebounce)
+
+	// One flush for the whole burst: the rankings version moved exactly once
+	if v, _ := fr.get(rankVersionKey); v != "1" {
+		t.Errorf("rankings version = %q after the burst, want 1 (a single flush)", v)
+	}
+	if cs.invalidationPending() {
+		t.Error("invalidation still pending after the flush")
+	}
+
+	cs.cacheRankings(context.Background(), cs.rankingsVersion(), []Bitcoin{{Symbol: "BTC"}})
+	if !fr.exists(rankCacheKey) {
+		t.Error("rankings not cached once the burst was flushed")
+	}
+}
+
+// A SetBitcoin that lands while a rankings rebuild is reading the database bumps the
+// rankings version, so the rebuild's result (from before the write) isn't cached
+func TestRankingsRebuildDoesNotCacheOverConcurrentWrite(t *testing.T) {
+	ctx := context.Background()
+	var cs *CacheService
+	price, raced := "100", false
+	cs, fr, _ := newFakeBackedCacheService(t, func(query string, args []driver.Value) (fakeResult, error) {
+		switch {
+		case strings.Contains(query, "ON CONFLICT"):
+			price = "200"
+			return fakeResult{columns: append(bitcoinColumns, "prev"), rows: [][]driver.Value{append(bitcoinRow("BTC", "200"), "100")}}, nil
+		case strings.Contains(query, "ROW_NUMBER()"):
+			rows := [][]driver.Value{append(bitcoinRow("BTC", price), int64(1))}
+			// The write lands after this rebuild has read the old price
+			if !raced {
+				raced = true
+				if _, _, err := cs.SetBitcoin(ctx, "BTC", decimal.RequireFromString("200"), nil, 0); err != nil {
+					t.Errorf("SetBitcoin during the rebuild: %v", err)
+				}
+			}
+			return fakeResult{columns: append(bitcoinColumns, "rank"), rows: rows}, nil
+		}
+		return fakeResult{}, nil
+	})
+
+	stale, err := cs.GetBitcoinsRanked(ctx, consistencyEventual, priceRange{})
+	if err != nil || len(stale) != 1 || stale[0].Price.String() != "100" {
+		t.Fatalf("racing rebuild = %+v, %v; want BTC at the old price", stale, err)
+	}
+	if fr.exists(rankCacheKey) {
+		t.Fatal("the rebuild cached rankings read before the concurrent write")
+	}
+
+	fresh, err := cs.GetBitcoinsRanked(ctx, consistencyEventual, priceRange{})
+	if err != nil || len(fresh) != 1 || fresh[0].Price.String() != "200" {
+		t.Fatalf("next read = %+v, %v; want BTC at the new price", fresh, err)
+	}
+	cached, _ := fr.get(rankCacheKey)
+	if !strings.Contains(cached, `"price":200`) {
+		t.Errorf("cached rankings = %s, want the new price", cached)
+	}
+}
+
+// A SetBitcoin clears every derived key, shared and per-symbol, in one go
+func TestSetBitcoinClearsDerivedKeys(t *testing.T) {
+	cs, fr, _ := newFakeBackedCacheService(t, func(query string, args []driver.Value) (fakeResult, error) {
+		if strings.Contains(query, "ON CONFLICT") {
+			return fakeResult{columns: append(bitcoinColumns, "prev"), rows: [][]driver.Value{append(bitcoinRow("BTC", "200"), "100")}}, nil
+		}
+		return fakeResult{}, nil
+	})
+	ctx := context.Background()
+
+	derived := derivedKeysWith(cs.getEnrichmentCacheKey("BTC"))
+	for _, key := range derived {
+		fr.set(key, "stale")
+	}
+
+	if _, _, err := cs.SetBitcoin(ctx, "BTC", decimal.RequireFromString("200"), nil, 0); err != nil {
+		t.Fatalf("SetBitcoin: %v", err)
+	}
+	for _, key := range derived {
+		if fr.exists(key) {
+			t.Errorf("derived key %s still cached after the write", key)
+		}
+	}
+}
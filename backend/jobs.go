@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Bulk writes larger than bulkAsyncThreshold run as background jobs: the batch is
+// split into chunks of bulkChunkSize, and chunks from every running job share
+// bulkSlots, so at most BULK_CONCURRENCY chunk upserts hit the database at once.
+// Job status lives in Redis so any replica can answer GET /api/jobs/:id.
+const (
+	jobKeyPrefix = "bitcoin:jobs:"
+	jobTTL       = 24 * time.Hour
+
+	defaultBulkChunkSize      = 500
+	defaultBulkConcurrency    = 4
+	defaultBulkAsyncThreshold = 5000
+)
+
+const (
+	jobStatusRunning     = "running"
+	jobStatusDone        = "done"
+	jobStatusFailed      = "failed"      // Finished, but at least one chunk failed
+	jobStatusInterrupted = "interrupted" // Shutdown stopped it before every chunk ran
+)
+
+type BulkJob struct {
+	ID          string     `json:"id"`
+	Status      string     `json:"status"`
+	Total       int        `json:"total"`   // Distinct symbols in the batch
+	Written     int        `json:"written"` // Symbols in chunks that committed
+	Failed      int        `json:"failed"`  // Symbols in chunks that rolled back
+	Errors      []string   `json:"errors"`
+	CreatedAt   time.Time  `json:"created_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+}
+
+func (cs *CacheService) getJobCacheKey(id string) string {
+	return jobKeyPrefix + id
+}
+
+// Validate and deduplicate a bulk write, then apply it chunk by chunk in the
+// background until done or ctx is cancelled. Each chunk is all-or-nothing; a failed
+// chunk is recorded on the job and the rest carry on.
+func (cs *CacheService) StartBulkJob(ctx context.Context, items []BitcoinInput) (*BulkJob, error) {
+	if cs.readOnly {
+		return nil, ErrReadOnly
+	}
+
+	items, err := dedupeBatch(items, cs.batchDuplicates)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := randomHex(8)
+	if err != nil {
+		return nil, fmt.Errorf("generating job ID: %w", err)
+	}
+
+	job := &BulkJob{ID: id, Status: jobStatusRunning, Total: len(items), Errors: []string{}, CreatedAt: time.Now()}
+	if err := cs.saveJob(job); err != nil {
+		return nil, err
+	}
+	snapshot := *job
+
+	cs.bulkJobs.Add(1)
+	go cs.runBulkJob(ctx, job, items)
+
+	slog.InfoContext(ctx, "bulk job started", "job_id", id, "symbols", len(items), "chunk_size", cs.bulkChunkSize)
+	return &snapshot, nil
+}
+
+func (cs *CacheService) runBulkJob(ctx context.Context, job *BulkJob, items []BitcoinInput) {
+	defer cs.bulkJobs.Done()
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	for start := 0; start < len(items); start += cs.bulkChunkSize {
+		chunk := items[start:min(start+cs.bulkChunkSize, len(items))]
+
+		// Block here rather than queueing every chunk: this is the backpressure
+		acquired := false
+		select {
+		case cs.bulkSlots <- struct{}{}:
+			acquired = true
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			// With both cases ready select may have taken a slot; hand it back
+			if acquired {
+				<-cs.bulkSlots
+			}
+			break
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-cs.bulkSlots }()
+
+			_, err := cs.upsertBatch(ctx, chunk)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				slog.ErrorContext(ctx, "bulk job chunk failed", "job_id", job.ID, "chunk_size", len(chunk), "error", err)
+				job.Failed += len(chunk)
+				job.Errors = append(job.Errors, fmt.Sprintf("%s..%s: %v", chunk[0].Symbol, chunk[len(chunk)-1].Symbol, err))
+			} else {
+				job.Written += len(chunk)
+			}
+			if err := cs.saveJob(job); err != nil {
+				slog.ErrorContext(ctx, "saving bulk job progress failed", "job_id", job.ID, "error", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	completed := time.Now()
+	job.CompletedAt = &completed
+	switch {
+	case job.Written+job.Failed < job.Total:
+		job.Status = jobStatusInterrupted
+	case job.Failed > 0:
+		job.Status = jobStatusFailed
+	default:
+		job.Status = jobStatusDone
+	}
+	if err := cs.saveJob(job); err != nil {
+		slog.ErrorContext(ctx, "saving bulk job final status failed", "job_id", job.ID, "error", err)
+	}
+
+	slog.InfoContext(ctx, "bulk job finished", "job_id", job.ID, "status", job.Status, "written", job.Written, "failed", job.Failed, "total", job.Total)
+}
+
+func (cs *CacheService) saveJob(job *BulkJob) error {
+	data, err := cs.encodeCacheValue(job)
+	if err != nil {
+		return fmt.Errorf("marshaling job: %w", err)
+	}
+	if err := cs.redisClient.Set(cs.ctx, cs.getJobCacheKey(job.ID), data, jobTTL).Err(); err != nil {
+		return fmt.Errorf("saving job: %w", err)
+	}
+	return nil
+}
+
+// Look up a bulk job by ID (nil if unknown or expired)
+func (cs *CacheService) GetBulkJob(id string) (*BulkJob, error) {
+	data, err := cs.redisClient.Get(cs.ctx, cs.getJobCacheKey(id)).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cache error: %w", err)
+	}
+
+	var job BulkJob
+	if err := decodeCacheValue([]byte(data), &job); err != nil {
+		return nil, fmt.Errorf("unmarshaling job: %w", err)
+	}
+	return &job, nil
+}
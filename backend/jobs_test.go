@@ -0,0 +1,31 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+// A cancelled job stops before its next chunk. select picks at random when a slot is
+// free too, so run it repeatedly: no run may leave a slot taken.
+func TestCancelledBulkJobReleasesSlot(t *testing.T) {
+	cs, _, fdb := newFakeBackedCacheService(t, nil)
+	cs.bulkSlots = make(chan struct{}, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	for i := 0; i < 100; i++ {
+		job := &BulkJob{ID: "cancelled", Total: 1}
+		cs.bulkJobs.Add(1)
+		cs.runBulkJob(ctx, job, []BitcoinInput{{Symbol: "BTC"}})
+
+		if n := len(cs.bulkSlots); n != 0 {
+			t.Fatalf("run %d left %d bulk slot(s) taken", i, n)
+		}
+		if job.Status != jobStatusInterrupted {
+			t.Fatalf("job status = %s, want %s", job.Status, jobStatusInterrupted)
+		}
+	}
+	if n := len(fdb.queries); n != 0 {
+		t.Errorf("cancelled jobs ran %d statements", n)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru/v2"
+)
+
+const defaultL1TTL = 5 * time.Second
+
+// In-process LRU of single-symbol records, checked before Redis by GetBitcoin. Writes
+// evict locally and other instances evict on bitcoin:invalidate (see invalidatebus.go).
+// A message lost to a dropped subscription purges the whole cache, and ttl bounds how
+// long anything missed some other way can be served. A nil *l1Cache is disabled.
+type l1Cache struct {
+	entries *lru.Cache[string, l1Entry]
+	ttl     time.Duration
+
+	// Bumped by every eviction, so a read that started before a write can't put the
+	// value it read back after the write evicted it. mu makes the check and the add
+	// one step with respect to evictions.
+	mu         sync.Mutex
+	generation atomic.Uint64
+}
+
+type l1Entry struct {
+	bitcoin Bitcoin
+	expires time.Time
+}
+
+// nil (disabled) when size is 0
+func newL1Cache(size int, ttl time.Duration) (*l1Cache, error) {
+	if size <= 0 {
+		return nil, nil
+	}
+	entries, err := lru.New[string, l1Entry](size)
+	if err != nil {
+		return nil, err
+	}
+	return &l1Cache{entries: entries, ttl: ttl}, nil
+}
+
+// A copy of the cached record, so callers may modify it
+func (l *l1Cache) get(symbol string) (*Bitcoin, bool) {
+	if l == nil {
+		return nil, false
+	}
+	entry, ok := l.entries.Get(symbol)
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(entry.expires) {
+		l.entries.Remove(symbol)
+		return nil, false
+	}
+	bitcoin := entry.bitcoin
+	return &bitcoin, true
+}
+
+// Taken before reading from Redis or the DB, and passed to add with the result
+func (l *l1Cache) snapshot() uint64 {
+	if l == nil {
+		return 0
+	}
+	return l.generation.Load()
+}
+
+// Cache a record read at snapshot, unless anything was evicted since
+func (l *l1Cache) add(bitcoin *Bitcoin, snapshot uint64) {
+	if l == nil || bitcoin == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.generation.Load() != snapshot {
+		return
+	}
+	l.entries.Add(bitcoin.Symbol, l1Entry{bitcoin: *bitcoin, expires: time.Now().Add(l.ttl)})
+}
+
+// Drop symbol, or everything when symbol is empty
+func (l *l1Cache) evict(symbol string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.generation.Add(1)
+	if symbol == "" {
+		l.entries.Purge()
+		return
+	}
+	l.entries.Remove(symbol)
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	loadShedWindow       = 10 * time.Second // Only DB latencies this recent count toward p99
+	loadShedMaxSamples   = 1024
+	loadShedEvalInterval = time.Second
+	cacheOnlyKey         = "cache_only"
+)
+
+// ErrCacheOnlyMiss is returned by cache-only reads when the value isn't cached
+var ErrCacheOnlyMiss = errors.New("not cached and database reads are being shed")
+
+type latencySample struct {
+	at       time.Time
+	duration time.Duration
+}
+
+// loadShedder tracks recent DB latency and derives a shed rate from its p99: 0 at or
+// below threshold, rising linearly to maxRate once p99 reaches twice the threshold
+type loadShedder struct {
+	threshold time.Duration
+	maxRate   float64
+	metrics   *Metrics
+
+	mu      sync.Mutex
+	samples []latencySample // Ring buffer of the most recent observations
+	next    int
+	rate    float64
+}
+
+func newLoadShedder(threshold time.Duration, maxRate float64, metrics *Metrics) *loadShedder {
+	return &loadShedder{
+		threshold: threshold,
+		maxRate:   maxRate,
+		metrics:   metrics,
+		samples:   make([]latencySample, 0, loadShedMaxSamples),
+	}
+}
+
+func (s *loadShedder) observe(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sample := latencySample{at: time.Now(), duration: d}
+	if len(s.samples) < loadShedMaxSamples {
+		s.samples = append(s.samples, sample)
+		return
+	}
+	s.samples[s.next] = sample
+	s.next = (s.next + 1) % loadShedMaxSamples
+}
+
+func (s *loadShedder) currentRate() float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rate
+}
+
+// Recompute the shed rate once per interval until ctx is cancelled
+func (s *loadShedder) Run(ctx context.Context) {
+	ticker := time.NewTicker(loadShedEvalInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.evaluate()
+		}
+	}
+}
+
+func (s *loadShedder) evaluate() {
+	s.mu.Lock()
+	cutoff := time.Now().Add(-loadShedWindow)
+	var recent []time.Duration
+	for _, sample := range s.samples {
+		if sample.at.After(cutoff) {
+			recent = append(recent, sample.duration)
+		}
+	}
+
+	// No recent DB traffic means nothing to react to, so stop shedding
+	rate := 0.0
+	var p99 time.Duration
+	if len(recent) > 0 {
+		sort.Slice(recent, func(i, j int) bool { return recent[i] < recent[j] })
+		p99 = recent[(len(recent)*99)/100]
+		if p99 > s.threshold {
+			rate = min(float64(p99-s.threshold)/float64(s.threshold), 1) * s.maxRate
+		}
+	}
+
+	previous := s.rate
+	s.rate = rate
+	s.mu.Unlock()
+
+	s.metrics.loadShedRate.Set(rate)
+	if (previous == 0) != (rate == 0) {
+		slog.Info("load shedding rate changed", "rate", rate, "db_p99_ms", latencyMs(p99), "threshold_ms", latencyMs(s.threshold))
+	}
+}
+
+// Under load, reject a fraction of writes with 503 and switch the same fraction of
+// reads to cache-only, so they never queue behind a slow database. Read-safe POSTs
+// (see isReadSafe) count as reads.
+func loadShedMiddleware(s *loadShedder) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		rate := s.currentRate()
+		if rate == 0 || rand.Float64() >= rate {
+			c.Next()
+			return
+		}
+
+		if isReadSafe(c) {
+			s.metrics.loadShed.WithLabelValues("cache_only").Inc()
+			c.Set(cacheOnlyKey, true)
+			c.Next()
+			return
+		}
+		s.metrics.loadShed.WithLabelValues("reject_write").Inc()
+		c.Header("Retry-After", "1")
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, errorJSON(CodeOverloaded, "Service overloaded, retry shortly"))
+	}
+}
+
+// Whether the load shedder switched this request to cache-only reads
+func cacheOnly(c *gin.Context) bool {
+	return c.GetBool(cacheOnlyKey)
+}
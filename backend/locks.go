@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const lockPrefix = "bitcoin:lock:"
+
+// Where a lock lives: a Redis key (SET NX with a TTL) or a PostgreSQL advisory lock
+const (
+	lockKindRedis    = "redis"
+	lockKindPostgres = "postgres"
+)
+
+// A lock the service takes, as reported by GET /api/admin/locks
+type managedLock struct {
+	Name    string
+	Kind    string
+	Purpose string
+}
+
+// Every lock the service manages. acquireLock refuses Redis lock names missing from
+// here, so a new lock can't go unreported.
+var managedLocks = []managedLock{
+	{Name: recomputeRanksLock, Kind: lockKindPostgres, Purpose: "Rank recomputation (POST /api/admin/recompute-ranks)"},
+	{Name: rebuildRankingsLock, Kind: lockKindRedis, Purpose: "Rankings sorted set rebuild (POST /api/admin/rankings/rebuild)"},
+	{Name: primeLock, Kind: lockKindRedis, Purpose: "Cache priming at startup and every PRIME_INTERVAL"},
+}
+
+func lookupManagedLock(name string) (managedLock, bool) {
+	for _, l := range managedLocks {
+		if l.Name == name {
+			return l, true
+		}
+	}
+	return managedLock{}, false
+}
+
+// Only delete the lock if we still own it, so an expired-and-reacquired lock isn't released by the old holder
+var releaseLockScript = redis.NewScript(`
+	if redis.call("GET", KEYS[1]) == ARGV[1] then
+		return redis.call("DEL", KEYS[1])
+	end
+	return 0
+`)
+
+// Acquire a Redis lock (SET NX with expiry). Returns the owner token, or "" if another holder has it.
+// The token is "<instance ID>:<random hex>", so the lock value names its holder.
+func (cs *CacheService) acquireLock(name string, ttl time.Duration) (string, error) {
+	if l, ok := lookupManagedLock(name); !ok || l.Kind != lockKindRedis {
+		return "", fmt.Errorf("lock %s is not a registered Redis lock", name)
+	}
+
+	nonce, err := randomHex(16)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate lock token: %w", err)
+	}
+	token := cs.instanceID + ":" + nonce
+
+	ok, err := cs.redisClient.SetNX(cs.ctx, lockPrefix+name, token, ttl).Result()
+	if err != nil {
+		return "", fmt.Errorf("failed to acquire lock %s: %w", name, err)
+	}
+	if !ok {
+		return "", nil
+	}
+	return token, nil
+}
+
+func (cs *CacheService) releaseLock(name, token string) error {
+	return releaseLockScript.Run(cs.ctx, cs.redisClient, []string{lockPrefix + name}, token).Err()
+}
+
+// Current state of a managed lock. TTL and holder are null when the lock is free;
+// PostgreSQL advisory locks have no TTL and report the holding backend's PID instead
+// of an instance ID.
+type LockStatus struct {
+	Name      string  `json:"name"`
+	Kind      string  `json:"kind"`
+	Key       string  `json:"key"`
+	Purpose   string  `json:"purpose"`
+	Held      bool    `json:"held"`
+	TTLMs     *int64  `json:"ttl_ms"`
+	Holder    *string `json:"holder"`
+	HolderPID *int    `json:"holder_pid"`
+}
+
+func (cs *CacheService) ListLocks(ctx context.Context) ([]LockStatus, error) {
+	statuses := make([]LockStatus, 0, len(managedLocks))
+	for _, l := range managedLocks {
+		status := LockStatus{Name: l.Name, Kind: l.Kind, Purpose: l.Purpose}
+
+		var err error
+		if l.Kind == lockKindRedis {
+			status.Key = lockPrefix + l.Name
+			err = cs.redisLockStatus(&status)
+		} else {
+			status.Key = fmt.Sprintf("pg_advisory(hashtext('%s'))", l.Name)
+			err = cs.advisoryLockStatus(ctx, &status)
+		}
+		if err != nil {
+			return nil, err
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+func (cs *CacheService) redisLockStatus(status *LockStatus) error {
+	var get *redis.StringCmd
+	var pttl *redis.DurationCmd
+	_, err := cs.redisClient.Pipelined(cs.ctx, func(pipe redis.Pipeliner) error {
+		get = pipe.Get(cs.ctx, status.Key)
+		pttl = pipe.PTTL(cs.ctx, status.Key)
+		return nil
+	})
+	if err == redis.Nil {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read lock %s: %w", status.Name, err)
+	}
+
+	status.Held = true
+	// Tokens from before instance IDs were stored have no holder part
+	if i := strings.LastIndex(get.Val(), ":"); i > 0 {
+		holder := get.Val()[:i]
+		status.Holder = &holder
+	}
+	if ttl := pttl.Val(); ttl > 0 {
+		ms := ttl.Milliseconds()
+		status.TTLMs = &ms
+	}
+	return nil
+}
+
+// An advisory lock taken on a single bigint key is listed in pg_locks with the high
+// half in classid and the low half in objid
+func (cs *CacheService) advisoryLockStatus(ctx context.Context, status *LockStatus) error {
+	var pid *int
+	err := cs.db.QueryRowContext(ctx, `
+		SELECT (
+			SELECT pid FROM pg_locks
+			WHERE locktype = 'advisory' AND granted AND objsubid = 1
+			  AND ((classid::bigint << 32) | objid::bigint) = hashtext($1)::bigint
+			LIMIT 1
+		)
+	`, status.Name).Scan(&pid)
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+
+	status.Held = pid != nil
+	status.HolderPID = pid
+	return nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Logs are JSON lines on stderr via slog, filtered by LOG_LEVEL (debug, info, warn,
+// error). Every line logged with a request's context carries its request_id, so one
+// request can be followed through the handler, cache and DB layers.
+
+type requestIDContextKey struct{}
+
+func withRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDContextKey{}, id)
+}
+
+// Adds the request_id of the record's context, when it has one
+type requestIDHandler struct {
+	slog.Handler
+}
+
+func (h requestIDHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id, ok := ctx.Value(requestIDContextKey{}).(string); ok {
+		r.AddAttrs(slog.String(requestIDKey, id))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h requestIDHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return requestIDHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h requestIDHandler) WithGroup(name string) slog.Handler {
+	return requestIDHandler{h.Handler.WithGroup(name)}
+}
+
+// Install the JSON logger as slog's default. Lines still written through the log
+// package (by dependencies) become info records.
+func setupLogging(level string) error {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("invalid LOG_LEVEL %q: %w", level, err)
+	}
+	slog.SetDefault(slog.New(requestIDHandler{slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: lvl})}))
+	return nil
+}
+
+// Log the error and exit, like log.Fatalf but never filtered out by LOG_LEVEL
+func fatalf(format string, args ...any) {
+	slog.Error(fmt.Sprintf(format, args...))
+	os.Exit(1)
+}
+
+// One line per request, in place of gin's text access log. Runs after
+// requestIDMiddleware, so the line carries the request ID.
+func accessLogMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		level := slog.LevelInfo
+		if c.Writer.Status() >= 500 {
+			level = slog.LevelError
+		}
+		slog.Log(c.Request.Context(), level, "request",
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"route", c.FullPath(),
+			"status", c.Writer.Status(),
+			"latency_ms", latencyMs(time.Since(start)),
+			"client_ip", c.ClientIP(),
+		)
+	}
+}
+
+// Milliseconds with microsecond resolution, for latency_ms fields
+func latencyMs(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// Count a cache lookup and log it with structured fields. latency_ms covers the
+// cache read that began at start; attrs add context such as the symbol.
+func (cs *CacheService) logCacheLookup(ctx context.Context, operation string, hit bool, start time.Time, attrs ...any) {
+	cs.countCacheLookup(operation, hit)
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	slog.InfoContext(ctx, "cache lookup", append([]any{
+		"operation", operation,
+		"cache", result,
+		"latency_ms", latencyMs(time.Since(start)),
+	}, attrs...)...)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"testing"
+)
+
+// Capture what slog's default logger writes, as parsed JSON records
+func captureLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(slog.New(requestIDHandler{slog.NewJSONHandler(&buf, nil)}))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+	return &buf
+}
+
+// Drop log output for the rest of the test or benchmark
+func quietLogs(tb testing.TB) {
+	previous := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	tb.Cleanup(func() { slog.SetDefault(previous) })
+}
+
+func logRecords(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+	var records []map[string]any
+	dec := json.NewDecoder(buf)
+	for dec.More() {
+		var r map[string]any
+		if err := dec.Decode(&r); err != nil {
+			t.Fatalf("decoding log line: %v", err)
+		}
+		records = append(records, r)
+	}
+	return records
+}
+
+func TestServiceLogsCarryRequestID(t *testing.T) {
+	cs, fr, _ := newFakeBackedCacheService(t, func(query string, args []driver.Value) (fakeResult, error) {
+		return fakeResult{columns: bitcoinColumns}, nil
+	})
+	fr.set(cs.getBitcoinCacheKey("BTC"), "{not json")
+	logs := captureLogs(t)
+
+	ctx := withRequestID(context.Background(), "req-123")
+	if _, err := cs.GetBitcoinsBatch(ctx, []string{"BTC"}); err != nil {
+		t.Fatalf("GetBitcoinsBatch: %v", err)
+	}
+
+	var found bool
+	for _, r := range logRecords(t, logs) {
+		if r["msg"] != "unmarshaling cached bitcoin failed" {
+			continue
+		}
+		found = true
+		if r["level"] != "ERROR" || r["request_id"] != "req-123" || r["symbol"] != "BTC" || r["error"] == nil {
+			t.Errorf("record = %v, want level ERROR with request_id, symbol and error fields", r)
+		}
+	}
+	if !found {
+		t.Fatal("no record for the corrupt cache entry")
+	}
+}
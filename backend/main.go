@@ -2,449 +2,2635 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
 	"database/sql"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"math"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
-	_ "github.com/lib/pq"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/redis/go-redis/v9"
+	"github.com/segmentio/kafka-go"
+	"github.com/shopspring/decimal"
+	"golang.org/x/sync/singleflight"
 )
 
 type Bitcoin struct {
-	Symbol    string    `json:"symbol" db:"symbol"`
-	Price     int       `json:"price" db:"price"`
-	Rank      *int      `json:"rank,omitempty" db:"rank"`
-	CreatedAt time.Time `json:"created_at" db:"created_at"`
-	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+	Symbol     string           `json:"symbol" db:"symbol"`
+	Price      *decimal.Decimal `json:"price" db:"price"` // nil (null) while the symbol is listed but unpriced
+	Supply     *float64         `json:"supply,omitempty" db:"supply"`
+	Rank       *int             `json:"rank,omitempty" db:"rank"`
+	MarketCap  *float64         `json:"market_cap,omitempty"`  // Only set in market-cap rankings
+	RankChange *int             `json:"rank_change,omitempty"` // Only set with include=rank_change
+	CreatedAt  time.Time        `json:"created_at" db:"created_at"`
+	UpdatedAt  time.Time        `json:"updated_at" db:"updated_at"`
+	DeletedAt  *time.Time       `json:"deleted_at,omitempty" db:"deleted_at"` // Only set on soft-deleted records
 }
 
+// Prices are marshaled as JSON numbers with exactly the digits Postgres stores, so a
+// record reads the same from the cache as from the database
+func init() {
+	decimal.MarshalJSONWithoutQuotes = true
+}
+
+// Put a symbol in the rankings sorted set at its price, or take it out while it is
+// unpriced: rankings only ever contain priced symbols
+func setRankEntry(ctx context.Context, c redis.Cmdable, b *Bitcoin) *redis.IntCmd {
+	if b.Price == nil {
+		return c.ZRem(ctx, rankSortedSetKey, b.Symbol)
+	}
+	return c.ZAdd(ctx, rankSortedSetKey, redis.Z{Score: b.Price.InexactFloat64(), Member: b.Symbol})
+}
+
+func samePrice(a, b *decimal.Decimal) bool {
+	return (a == nil) == (b == nil) && (a == nil || a.Equal(*b))
+}
+
+func formatPrice(price *decimal.Decimal) string {
+	if price == nil {
+		return "null"
+	}
+	return price.String()
+}
+
+var ErrReadOnly = errors.New("service is in read-only mode")
+
 type CacheService struct {
-	db          *sql.DB
-	redisClient *redis.Client
-	ctx         context.Context
-	cacheTTL    time.Duration
+	db            *sql.DB
+	redisClient   *redis.Client
+	ctx           context.Context
+	cacheTTL      time.Duration
+	ttlJitter     float64 // Record TTLs vary by up to ± this fraction (0 disables)
+	rankingsTTL   time.Duration
+	negativeTTL   time.Duration
+	enrichmentTTL time.Duration
+	statsTTL      time.Duration
+	searchTTL     time.Duration
+	fieldCipher   *fieldCipher // nil when ENCRYPTION_KEY is unset
+	metrics       *Metrics
+	readOnly      bool         // Reject all database mutations (READ_ONLY=true)
+	shedder       *loadShedder // nil unless LOAD_SHED_P99_THRESHOLD is set
+
+	// Rankings are served this long past their TTL while rebuilt in the background
+	// (RANKINGS_STALE_TTL; 0 disables, see rankingsstale.go)
+	rankingsStaleTTL     time.Duration
+	rankingsRevalidating atomic.Bool
+
+	// Resubscribe backoff for change-event subscriptions
+	pubsubMinBackoff time.Duration
+	pubsubMaxBackoff time.Duration
+
+	// PRELOAD_SYMBOLS: primed first and kept warm with preloadTTL
+	preloadSymbols []string
+	preload        map[string]bool
+	preloadTTL     time.Duration
+	nullSupply     string // nullSupplyExclude or nullSupplyZero for market-cap rankings
+
+	cachePolicies cachePolicies // Per-group record TTLs set through /api/admin/cache-policy
+
+	primed        chan struct{} // Closed once startup priming has finished
+	primeMode     string        // primeModeWait or primeModePassThrough
+	primeWait     time.Duration // Longest a read waits for priming, measured from startup
+	primeDeadline time.Time
+	primeLockTTL  time.Duration // PRIME_LOCK_TTL: longest a crashed primer can hold primeLock
+	warmConns     int           // DB_MIN_IDLE_CONNS: connections opened per pool before priming
+
+	batchDuplicates string // batchDuplicatesLastWins or batchDuplicatesReject
+	rankingsSource  string // rankingsSourceRedis or rankingsSourcePostgres
+
+	// Bulk writes over bulkAsyncThreshold run as chunked background jobs (see jobs.go)
+	bulkChunkSize      int
+	bulkAsyncThreshold int
+	bulkSlots          chan struct{} // One per chunk upsert in flight across all jobs
+	bulkJobs           sync.WaitGroup
+
+	minUpdateInterval time.Duration // Per-symbol floor between SetBitcoin calls (0 disables)
+
+	// Fraction of cache hits verified against the DB's updated_at (0 disables), and how
+	// far behind the cached record may be before it counts as stale
+	freshnessSampleRate float64
+	freshnessTolerance  time.Duration
+
+	canarySampleRate float64 // Fraction of rankings reads compared against the other rankings source
+
+	loads           singleflight.Group // Concurrent cache-miss loads of the same key share one DB read
+	stampedeMaxWait time.Duration      // Longest a caller waits on a shared load (0 = no limit)
+
+	// Attempts (1 = no retries) and first backoff for transient DB errors (see dbretry.go)
+	dbRetryAttempts  int
+	dbRetryBaseDelay time.Duration
+
+	pricePrecision int // Display decimals for symbols without their own price_precision
+
+	readOrder string // readOrderCacheFirst or readOrderDBFirst for single-symbol reads
+
+	redisState atomic.Int32 // redisStateUp, or DB-only while Redis is unreachable (see degraded.go)
+
+	instanceID string // INSTANCE_ID (default: hostname), recorded in the Redis locks this instance holds
+
+	cacheCompressed   bool // Store the rankings payload gzipped (RANKINGS_CACHE_COMPRESSED)
+	compressThreshold int  // Gzip cached values longer than this many bytes; 0 = never (see cachevalue.go)
+
+	invalidationDebounce time.Duration // Quiet period before a write burst invalidates derived caches (0 = every write)
+	debouncer            invalidationDebouncer
+
+	invalidationHandlers []func(symbol string) // Called for other instances' writes (see invalidatebus.go)
+
+	lookups map[string]*lookupCounters // In-process hit/miss counts for GET /api/cache/stats
+	l1      *l1Cache                   // In-process record cache in front of Redis (nil when L1_CACHE_SIZE=0)
+
+	// Optional dual-write target during a Redis migration (nil when disabled)
+	secondary   *redis.Client
+	mirrorSlots chan struct{}
+
+	batchReadChunkSize int // Symbols per MGET and per DB query in GetBitcoinsBatch
 }
 
 const (
-	cachePrefix      = "bitcoin:"
-	rankSortedSetKey = "bitcoin:rankings:sorted" // Redis sorted set for rankings
-	defaultCacheTTL  = 1 * time.Hour
+	cachePrefix        = "bitcoin:"
+	rankCacheKey       = "bitcoin:rankings"         // Assembled rankings JSON payload
+	rankVersionKey     = "bitcoin:rankings:version" // Bumped on every rankings invalidation
+	rankSortedSetKey   = "bitcoin:rankings:sorted"  // Redis sorted set for rankings
+	defaultCacheTTL    = 1 * time.Hour
+	defaultRankingsTTL = 5 * time.Minute
+	defaultNegativeTTL = 30 * time.Second
+
+	// Spread record expiries over ±10% of their TTL, so keys written together
+	// (e.g. by priming) don't all expire at once
+	defaultTTLJitter = 0.1
+
+	// Stored under a symbol's cache key when the symbol doesn't exist in the DB
+	negativeCacheSentinel = "__missing__"
+
+	// Rankings payloads larger than this get a proportionally shorter TTL, down to minRankingsTTL
+	largeRankingsSize = 500
+	minRankingsTTL    = 30 * time.Second
+)
+
+// PostgreSQL pool defaults. Four replicas at 20 connections each stay under
+// Postgres' default max_connections of 100.
+const (
+	defaultDBMaxOpenConns    = 20
+	defaultDBMaxIdleConns    = 10
+	defaultDBConnMaxLifetime = 30 * time.Minute
 )
 
-func NewCacheService(db *sql.DB, redisClient *redis.Client) *CacheService {
+// Key kinds understood by ttlFor
+const (
+	keyKindBitcoin  = "bitcoin"
+	keyKindRankings = "rankings"
+	keyKindNegative = "negative"
+)
+
+// How long /ready fails before the server stops accepting connections on shutdown,
+// so load balancers stop routing to it first (PRE_SHUTDOWN_DELAY)
+const defaultPreShutdownDelay = 5 * time.Second
+
+func NewCacheService(db *sql.DB, redisClient *redis.Client, metrics *Metrics) *CacheService {
 	return &CacheService{
-		db:          db,
-		redisClient: redisClient,
-		ctx:         context.Background(),
-		cacheTTL:    defaultCacheTTL,
+		db:                db,
+		redisClient:       redisClient,
+		metrics:           metrics,
+		mirrorSlots:       make(chan struct{}, maxInflightMirrorWrites),
+		ctx:               context.Background(),
+		cacheTTL:          defaultCacheTTL,
+		ttlJitter:         defaultTTLJitter,
+		rankingsTTL:       defaultRankingsTTL,
+		negativeTTL:       defaultNegativeTTL,
+		enrichmentTTL:     defaultEnrichmentTTL,
+		statsTTL:          defaultStatsTTL,
+		searchTTL:         defaultSearchTTL,
+		pubsubMinBackoff:  defaultPubSubMinBackoff,
+		pubsubMaxBackoff:  defaultPubSubMaxBackoff,
+		preloadTTL:        defaultPreloadTTL,
+		pricePrecision:    defaultPricePrecision,
+		nullSupply:        nullSupplyExclude,
+		batchDuplicates:   batchDuplicatesLastWins,
+		rankingsSource:    rankingsSourceRedis,
+		bulkChunkSize:     defaultBulkChunkSize,
+		bulkSlots:         make(chan struct{}, defaultBulkConcurrency),
+		primed:            make(chan struct{}),
+		primeMode:         primeModeWait,
+		primeWait:         defaultPrimeWait,
+		primeLockTTL:      defaultPrimeLockTTL,
+		readOrder:         readOrderCacheFirst,
+		dbRetryAttempts:   defaultDBRetryAttempts,
+		dbRetryBaseDelay:  defaultDBRetryBaseDelay,
+		lookups:           newLookupCounters(),
+		compressThreshold: defaultCompressThreshold,
+
+		batchReadChunkSize: defaultBatchReadChunkSize,
 	}
 }
 
+// Case-insensitive like every symbol lookup: "btc" and "BTC" share one key
 func (cs *CacheService) getBitcoinCacheKey(symbol string) string {
-	return fmt.Sprintf("%s%s", cachePrefix, symbol)
+	return fmt.Sprintf("%s%s", cachePrefix, strings.ToUpper(symbol))
+}
+
+// A cache value that won't marshal is a bug, not a transient failure. Drop whatever
+// the key holds so reads fall through to the DB instead of serving a stale value,
+// and count it so it gets noticed. Returns false if the key couldn't be dropped.
+func (cs *CacheService) marshalFailed(ctx context.Context, key string, err error) bool {
+	slog.ErrorContext(ctx, "marshaling for the cache failed, dropping the key", "key", key, "error", err)
+	cs.metrics.cacheMarshalFailures.Inc()
+	return cs.dropCacheKey(ctx, key)
+}
+
+// A failed DEL is retried this many times in all, so a blip doesn't leave behind a
+// value the caller knows is stale
+const (
+	cacheDropAttempts   = 3
+	cacheDropRetryDelay = 20 * time.Millisecond
+)
+
+// Delete a key whose cached value is known to be stale. Returns false if it may
+// still be there, which is logged and counted in cache_stale_keys_total: the value
+// is then served until it expires or the resync after a Redis outage replaces it.
+func (cs *CacheService) dropCacheKey(ctx context.Context, key string) bool {
+	delay := cacheDropRetryDelay
+	for attempt := 1; ; attempt++ {
+		err := cs.redisClient.Del(cs.ctx, key).Err()
+		if err == nil {
+			return true
+		}
+		if attempt >= cacheDropAttempts {
+			slog.ErrorContext(ctx, "dropping key failed, a stale value may remain until it expires", "key", key, "error", err)
+			cs.metrics.staleCacheKeys.Inc()
+			return false
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// TTL policy: individual records live for the full cache TTL, "not found" markers
+// only briefly so new symbols show up quickly, and the rankings payload has its
+// own TTL that shrinks inversely with the number of items in it
+func (cs *CacheService) ttlFor(keyKind string, size int) time.Duration {
+	switch keyKind {
+	case keyKindNegative:
+		return cs.negativeTTL
+	case keyKindRankings:
+		ttl := cs.rankingsTTL
+		if size > largeRankingsSize {
+			ttl = time.Duration(float64(ttl) * largeRankingsSize / float64(size))
+			if ttl < minRankingsTTL {
+				ttl = minRankingsTTL
+			}
+		}
+		return ttl
+	default:
+		return cs.cacheTTL
+	}
 }
 
-// CACHE PRIMING: Load all data from DB into cache at startup
+// CACHE PRIMING: Load all data from DB into cache at startup. Only one instance primes
+// at a time; the others get ErrPrimeInProgress (see primeCacheLocked).
 func (cs *CacheService) PrimeCache() error {
-	log.Println("Starting cache priming...")
+	if cs.cacheDisabled() {
+		return ErrCacheDisabled
+	}
+	return cs.primeCacheLocked(cs.ctx, cs.primeLockTTL, false)
+}
+
+// Records cached per pipeline while priming, so priming N rows takes about
+// N/primePipelineSize round trips to Redis rather than N
+const primePipelineSize = 500
+
+type primedRecord struct {
+	bitcoin Bitcoin
+	data    []byte // Encoded cache value
+}
+
+// Cache a chunk of primed records and their rankings entries in one pipeline, and
+// mirror it. Commands fail independently, so a failure only skips its own record.
+// Returns how many records got both their key and their rankings entry.
+func (cs *CacheService) flushPrimed(chunk []primedRecord) int {
+	if len(chunk) == 0 {
+		return 0
+	}
+
+	write := func(ctx context.Context, pipe redis.Pipeliner) {
+		for i, r := range chunk {
+			pipe.Set(ctx, cs.getBitcoinCacheKey(r.bitcoin.Symbol), r.data, cs.bitcoinTTL(r.bitcoin.Symbol))
+			// Add to sorted set for rankings (price as score, symbol as member)
+			setRankEntry(ctx, pipe, &chunk[i].bitcoin)
+		}
+	}
+	cmds, _ := cs.redisClient.Pipelined(cs.ctx, func(pipe redis.Pipeliner) error {
+		write(cs.ctx, pipe)
+		return nil
+	})
+
+	// Two commands per record, in the order they were queued
+	primed := 0
+	for i, r := range chunk {
+		if err := cmds[2*i].Err(); err != nil {
+			slog.ErrorContext(cs.ctx, "caching bitcoin failed", "symbol", r.bitcoin.Symbol, "error", err)
+		} else if err := cmds[2*i+1].Err(); err != nil {
+			slog.ErrorContext(cs.ctx, "adding to sorted set failed", "symbol", r.bitcoin.Symbol, "error", err)
+		} else {
+			primed++
+		}
+	}
+
+	cs.mirror(write)
+	return primed
+}
+
+func (cs *CacheService) primeCache(ctx context.Context) error {
+	slog.InfoContext(ctx, "starting cache priming")
 
-	// Get all bitcoins from database (sorted by price for efficiency)
-	rows, err := cs.db.Query(`
-		SELECT symbol, price, created_at, updated_at
+	if err := cs.checkPreloadSymbols(ctx); err != nil {
+		slog.ErrorContext(ctx, "checking preload symbols failed", "error", err)
+	}
+
+	// Get all bitcoins from database, PRELOAD_SYMBOLS first so they're warm
+	// soonest, then by price
+	rows, err := cs.db.QueryContext(ctx, `
+		SELECT symbol, price, supply, created_at, updated_at
 		FROM bitcoins
-		ORDER BY price DESC
-	`)
+		WHERE deleted_at IS NULL
+		ORDER BY (symbol = ANY($1)) DESC, price DESC NULLS LAST
+	`, pq.Array(cs.preloadSymbols))
 	if err != nil {
 		return fmt.Errorf("failed to query bitcoins: %w", err)
 	}
 	defer rows.Close()
 
 	count := 0
+	chunk := make([]primedRecord, 0, primePipelineSize)
 
 	for rows.Next() {
 		var b Bitcoin
-		if err := rows.Scan(&b.Symbol, &b.Price, &b.CreatedAt, &b.UpdatedAt); err != nil {
-			log.Printf("Error scanning row: %v", err)
+		if err := rows.Scan(&b.Symbol, &b.Price, &b.Supply, &b.CreatedAt, &b.UpdatedAt); err != nil {
+			slog.ErrorContext(ctx, "scanning row failed", "error", err)
 			continue
 		}
 
 		// Cache individual bitcoin as JSON
-		data, err := json.Marshal(b)
+		data, err := cs.encodeCacheValue(b)
 		if err != nil {
-			log.Printf("Error marshaling bitcoin %s: %v", b.Symbol, err)
+			cs.marshalFailed(cs.ctx, cs.getBitcoinCacheKey(b.Symbol), err)
 			continue
 		}
 
-		err = cs.redisClient.Set(cs.ctx, cs.getBitcoinCacheKey(b.Symbol), data, cs.cacheTTL).Err()
-		if err != nil {
-			log.Printf("Error caching bitcoin %s: %v", b.Symbol, err)
-			continue
+		chunk = append(chunk, primedRecord{bitcoin: b, data: data})
+		if len(chunk) == primePipelineSize {
+			count += cs.flushPrimed(chunk)
+			// The mirror may still be reading the flushed chunk, so start a new one
+			chunk = make([]primedRecord, 0, primePipelineSize)
 		}
+	}
+	count += cs.flushPrimed(chunk)
 
-		// Add to sorted set for rankings (price as score, symbol as member)
-		err = cs.redisClient.ZAdd(cs.ctx, rankSortedSetKey, redis.Z{
-			Score:  float64(b.Price),
-			Member: b.Symbol,
-		}).Err()
-		if err != nil {
-			log.Printf("Error adding %s to sorted set: %v", b.Symbol, err)
-			continue
+	// Drop any rankings payload assembled from data we just replaced
+	cs.invalidateDerived(cs.ctx)
+	cs.mirror(func(ctx context.Context, pipe redis.Pipeliner) {
+		pipe.Del(ctx, derivedKeysWith()...)
+	})
+
+	cs.metrics.primedKeys.Set(float64(count))
+	slog.InfoContext(ctx, "cache priming completed", "bitcoins", count)
+	return nil
+}
+
+// READ-THROUGH: Get bitcoin from cache, fallback to DB if not found. ctx bounds the
+// reads; a cache fill that follows a DB read completes even if ctx is cancelled.
+func (cs *CacheService) GetBitcoin(ctx context.Context, symbol string, consistency consistencyLevel) (*Bitcoin, error) {
+	symbol, err := normalizeSymbol(symbol)
+	if err != nil {
+		return nil, err
+	}
+
+	// Strong reads skip the cache (and any priming wait) and refresh it from the DB
+	if consistency == consistencyStrong {
+		slog.InfoContext(ctx, "strong read", "symbol", symbol)
+		if cs.cacheDisabled() {
+			return cs.queryBitcoin(ctx, symbol)
+		}
+		snapshot := cs.l1.snapshot()
+		bitcoin, err := cs.loadBitcoin(ctx, symbol)
+		if err == nil {
+			cs.l1.add(bitcoin, snapshot)
 		}
+		return bitcoin, err
+	}
 
-		count++
+	if cs.cacheDisabled() {
+		return cs.queryBitcoin(ctx, symbol)
 	}
 
-	log.Printf("Cache priming completed: %d bitcoins loaded into cache and sorted set", count)
-	return nil
-}
+	if cs.readOrder == readOrderDBFirst {
+		return cs.getBitcoinDBFirst(ctx, symbol)
+	}
+
+	// Avoid a miss stampede while the cache is still being primed
+	if cs.isPriming() {
+		if cs.primeMode == primeModePassThrough {
+			return cs.queryBitcoin(ctx, symbol)
+		}
+		cs.waitForPrime(ctx)
+	}
+
+	// In-process L1 first, when enabled
+	if cs.l1 != nil {
+		if bitcoin, ok := cs.l1.get(symbol); ok {
+			cs.countCacheLookup("l1", true)
+			return bitcoin, nil
+		}
+		cs.countCacheLookup("l1", false)
+	}
+	snapshot := cs.l1.snapshot()
 
-// READ-THROUGH: Get bitcoin from cache, fallback to DB if not found
-func (cs *CacheService) GetBitcoin(symbol string) (*Bitcoin, error) {
 	cacheKey := cs.getBitcoinCacheKey(symbol)
 
 	// Try cache first
-	cached, err := cs.redisClient.Get(cs.ctx, cacheKey).Result()
+	start := time.Now()
+	cached, err := cs.redisClient.Get(ctx, cacheKey).Result()
+	if err == nil && cached == negativeCacheSentinel {
+		cs.logCacheLookup(ctx, "get", true, start, "symbol", symbol, "not_found", true)
+		return nil, nil
+	}
 	if err == nil {
-		log.Printf("Cache HIT for %s", symbol)
 		var bitcoin Bitcoin
-		if err := json.Unmarshal([]byte(cached), &bitcoin); err != nil {
-			log.Printf("Error unmarshaling cached bitcoin: %v", err)
+		if err := decodeCacheValue([]byte(cached), &bitcoin); err != nil {
+			slog.ErrorContext(ctx, "unmarshaling cached bitcoin failed", "symbol", symbol, "error", err)
 		} else {
-			return &bitcoin, nil
+			cs.logCacheLookup(ctx, "get", true, start, "symbol", symbol)
+			fresh, _, err := cs.verifyFreshness(ctx, &bitcoin)
+			if err == nil {
+				cs.l1.add(fresh, snapshot)
+			}
+			return fresh, err
 		}
 	}
 
-	log.Printf("Cache MISS for %s", symbol)
+	cs.logCacheLookup(ctx, "get", false, start, "symbol", symbol)
+	bitcoin, err := cs.loadBitcoinShared(ctx, symbol)
+	if err == nil {
+		cs.l1.add(bitcoin, snapshot)
+	}
+	return bitcoin, err
+}
 
-	// Cache miss - read from database
-	var bitcoin Bitcoin
-	err = cs.db.QueryRow(`
-		SELECT symbol, price, created_at, updated_at
-		FROM bitcoins
-		WHERE symbol = $1
-	`, symbol).Scan(&bitcoin.Symbol, &bitcoin.Price, &bitcoin.CreatedAt, &bitcoin.UpdatedAt)
+// Read a bitcoin from the database and write the result (or a not-found marker) to the cache
+func (cs *CacheService) loadBitcoin(ctx context.Context, symbol string) (*Bitcoin, error) {
+	cacheKey := cs.getBitcoinCacheKey(symbol)
 
-	if err == sql.ErrNoRows {
-		return nil, nil
-	}
+	bitcoin, err := cs.queryBitcoin(ctx, symbol)
 	if err != nil {
-		return nil, fmt.Errorf("database error: %w", err)
+		return nil, err
+	}
+
+	if bitcoin == nil {
+		// Negative cache so repeated lookups of unknown symbols don't hit the DB;
+		// SetBitcoin overwrites the same key when the symbol is created
+		err = cs.redisClient.Set(cs.ctx, cacheKey, negativeCacheSentinel, cs.ttlFor(keyKindNegative, 1)).Err()
+		if err != nil {
+			slog.ErrorContext(ctx, "caching not-found marker failed", "symbol", symbol, "error", err)
+		}
+		return nil, nil
 	}
 
 	// Write to cache for future reads
-	data, err := json.Marshal(bitcoin)
+	data, err := cs.encodeCacheValue(bitcoin)
 	if err != nil {
-		log.Printf("Error marshaling bitcoin: %v", err)
+		cs.marshalFailed(ctx, cacheKey, err)
 	} else {
-		err = cs.redisClient.Set(cs.ctx, cacheKey, data, cs.cacheTTL).Err()
+		err = cs.redisClient.Set(cs.ctx, cacheKey, data, cs.bitcoinTTL(symbol)).Err()
 		if err != nil {
-			log.Printf("Error caching bitcoin: %v", err)
+			slog.ErrorContext(ctx, "caching bitcoin failed", "symbol", symbol, "error", err)
 		}
 	}
 
-	return &bitcoin, nil
+	return bitcoin, nil
 }
 
-// WRITE-THROUGH: Write to DB and cache simultaneously
-func (cs *CacheService) SetBitcoin(symbol string, price int) (*Bitcoin, error) {
-	// Write to database first
+// Read a single bitcoin from the database, bypassing the cache (nil if not found)
+func (cs *CacheService) queryBitcoin(ctx context.Context, symbol string) (*Bitcoin, error) {
+	defer cs.observeDB("get", time.Now())
+
 	var bitcoin Bitcoin
-	err := cs.db.QueryRow(`
-		INSERT INTO bitcoins (symbol, price)
-		VALUES ($1, $2)
-		ON CONFLICT (symbol)
-		DO UPDATE SET price = $2, updated_at = CURRENT_TIMESTAMP
-		RETURNING symbol, price, created_at, updated_at
-	`, symbol, price).Scan(&bitcoin.Symbol, &bitcoin.Price, &bitcoin.CreatedAt, &bitcoin.UpdatedAt)
+	err := cs.withDBRetry(ctx, "get", func() error {
+		return cs.db.QueryRowContext(ctx, `
+			SELECT symbol, price, supply, created_at, updated_at
+			FROM bitcoins
+			WHERE symbol = $1 AND deleted_at IS NULL
+		`, symbol).Scan(&bitcoin.Symbol, &bitcoin.Price, &bitcoin.Supply, &bitcoin.CreatedAt, &bitcoin.UpdatedAt)
+	})
 
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
 	if err != nil {
 		return nil, fmt.Errorf("database error: %w", err)
 	}
 
-	// Write to cache (individual bitcoin)
-	data, err := json.Marshal(bitcoin)
+	return &bitcoin, nil
+}
+
+// WRITE-THROUGH: Write to DB and cache simultaneously. A nil supply keeps the stored value;
+// a zero ttl caches the record for the symbol's usual TTL. cacheConsistent reports
+// whether the cache was left without a stale value (see writeThroughCache).
+// Returns a *ThrottledError when the symbol was updated within MIN_UPDATE_INTERVAL.
+func (cs *CacheService) SetBitcoin(ctx context.Context, symbol string, price decimal.Decimal, supply *float64, ttl time.Duration) (bitcoin *Bitcoin, cacheConsistent bool, err error) {
+	if cs.readOnly {
+		return nil, false, ErrReadOnly
+	}
+	symbol, err = normalizeSymbol(symbol)
 	if err != nil {
-		log.Printf("Error marshaling bitcoin: %v", err)
-	} else {
-		err = cs.redisClient.Set(cs.ctx, cs.getBitcoinCacheKey(symbol), data, cs.cacheTTL).Err()
-		if err != nil {
-			log.Printf("Error caching bitcoin: %v", err)
-		}
+		return nil, false, err
+	}
+	if err := cs.claimUpdateSlot(ctx, symbol); err != nil {
+		return nil, false, err
 	}
 
-	// Update sorted set (ZADD automatically updates score if member exists)
-	err = cs.redisClient.ZAdd(cs.ctx, rankSortedSetKey, redis.Z{
-		Score:  float64(bitcoin.Price),
-		Member: bitcoin.Symbol,
-	}).Err()
+	// Write to database first, appending to price history in the same statement.
+	// All CTEs see the same snapshot, so prev still holds the price before the upsert.
+	// A cancelled ctx aborts the statement; once it has committed, the cache is
+	// updated regardless (writeThroughCache uses the service's own context).
+	// Upserting is safe to repeat after a lost connection: at worst a statement that
+	// did commit is applied twice, adding a second identical history record.
+	var written Bitcoin
+	var previousPrice *decimal.Decimal
+	start := time.Now()
+	err = cs.withDBRetry(ctx, "upsert", func() error {
+		return cs.db.QueryRowContext(ctx, `
+			WITH prev AS (
+				SELECT price FROM bitcoins WHERE symbol = $1 AND deleted_at IS NULL
+			), upserted AS (
+				INSERT INTO bitcoins (symbol, price, supply)
+				VALUES ($1, $2, $3)
+				ON CONFLICT (symbol)
+				DO UPDATE SET price = $2, supply = COALESCE($3, bitcoins.supply), updated_at = CURRENT_TIMESTAMP,
+					created_at = `+recreatedCreatedAt+`, deleted_at = NULL
+				RETURNING symbol, price, supply, created_at, updated_at
+			), history AS (
+				INSERT INTO bitcoin_price_history (symbol, price, recorded_at)
+				SELECT symbol, price, updated_at FROM upserted
+			)
+			SELECT symbol, price, supply, created_at, updated_at, (SELECT price FROM prev) FROM upserted
+		`, symbol, price, supply).Scan(&written.Symbol, &written.Price, &written.Supply, &written.CreatedAt, &written.UpdatedAt, &previousPrice)
+	})
+	cs.observeDB("upsert", start)
+
 	if err != nil {
-		log.Printf("Error updating sorted set for %s: %v", symbol, err)
+		cs.releaseUpdateSlot(ctx, symbol)
+		return nil, false, fmt.Errorf("database error: %w", err)
 	}
 
-	log.Printf("Write-through completed for %s (price: %d)", symbol, price)
-	return &bitcoin, nil
+	cacheConsistent = cs.writeThroughCache(ctx, &written, previousPrice, ttl)
+
+	slog.InfoContext(ctx, "write-through completed", "symbol", symbol, "price", price)
+	return &written, cacheConsistent, nil
 }
 
-// Get all bitcoins ranked by price using Redis sorted set
-func (cs *CacheService) GetBitcoinsRanked() ([]Bitcoin, error) {
-	// Get symbols from sorted set (highest to lowest price)
-	// ZREVRANGE returns members in descending order of score
-	symbols, err := cs.redisClient.ZRevRangeWithScores(cs.ctx, rankSortedSetKey, 0, -1).Result()
-	if err != nil {
-		log.Printf("Error getting sorted set: %v, falling back to database", err)
-		return cs.getBitcoinsRankedFromDB()
+// Cache a freshly written record (for ttl, or the symbol's usual TTL if zero), refresh
+// its rankings entry, invalidate everything derived from it and announce the change.
+// Returns false if the cache may still hold the record as it was before the write:
+// a failed cache write drops the key instead, and only if that fails too is the old
+// value left behind. A stale rankings entry counts too, until reconciliation.
+func (cs *CacheService) writeThroughCache(ctx context.Context, bitcoin *Bitcoin, previousPrice *decimal.Decimal, ttl time.Duration) bool {
+	cs.l1.evict(bitcoin.Symbol)
+
+	// The resync when Redis returns picks the write up; until then reads use the DB
+	if cs.cacheWritesDisabled() {
+		return true
 	}
 
-	if len(symbols) == 0 {
-		log.Println("Sorted set empty, falling back to database")
-		return cs.getBitcoinsRankedFromDB()
+	symbol := bitcoin.Symbol
+	if ttl <= 0 {
+		ttl = cs.bitcoinTTL(symbol)
 	}
 
-	log.Printf("Rankings served from Redis sorted set (%d bitcoins)", len(symbols))
+	// Write to cache (individual bitcoin), or failing that make the next read a clean miss
+	key := cs.getBitcoinCacheKey(symbol)
+	consistent := true
+	data, err := cs.encodeCacheValue(bitcoin)
+	if err != nil {
+		consistent = cs.marshalFailed(ctx, key, err)
+	} else if err := cs.redisClient.Set(cs.ctx, key, data, ttl).Err(); err != nil {
+		slog.ErrorContext(ctx, "caching bitcoin failed, dropping the key", "symbol", symbol, "error", err)
+		consistent = cs.dropCacheKey(ctx, key)
+	}
 
-	var bitcoins []Bitcoin
-	rank := 1
+	// Update sorted set (ZADD automatically updates score if member exists)
+	if err := setRankEntry(cs.ctx, cs.redisClient, bitcoin).Err(); err != nil {
+		slog.ErrorContext(ctx, "updating sorted set failed", "symbol", symbol, "error", err)
+		consistent = false
+	}
+
+	if cmd := cs.updateExtremes(cs.redisClient, bitcoin); cmd != nil && cmd.Err() != nil {
+		slog.ErrorContext(ctx, "updating extremes failed", "symbol", symbol, "error", cmd.Err())
+	}
 
-	for _, z := range symbols {
-		symbol := z.Member.(string)
+	// Invalidate derived caches and per-symbol derived fields
+	cs.invalidateDerived(ctx, cs.symbolDerivedKeys(symbol)...)
 
-		// Get full bitcoin details from cache
-		bitcoin, err := cs.GetBitcoin(symbol)
-		if err != nil || bitcoin == nil {
-			log.Printf("Failed to get bitcoin %s from cache: %v", symbol, err)
-			continue
+	cs.mirror(func(ctx context.Context, pipe redis.Pipeliner) {
+		if data != nil {
+			pipe.Set(ctx, cs.getBitcoinCacheKey(symbol), data, ttl)
+		} else {
+			pipe.Del(ctx, cs.getBitcoinCacheKey(symbol))
 		}
+		setRankEntry(ctx, pipe, bitcoin)
+		pipe.Del(ctx, derivedKeysWith(cs.symbolDerivedKeys(symbol)...)...)
+	})
 
-		// Create a local copy of rank to avoid pointer issues
-		rankValue := rank
-		bitcoin.Rank = &rankValue
-		bitcoins = append(bitcoins, *bitcoin)
-		rank++
+	cs.publishChange(ctx, ChangeEvent{Type: eventTypeUpdate, Symbol: symbol, Bitcoin: bitcoin, PreviousPrice: previousPrice})
+	cs.publishInvalidation(ctx, symbol, eventTypeUpdate)
+	return consistent
+}
+
+// Serve a bitcoin only if it's cached; ErrCacheOnlyMiss otherwise (nil if cached as not found)
+func (cs *CacheService) GetBitcoinCacheOnly(symbol string) (*Bitcoin, error) {
+	if cs.cacheDisabled() {
+		return nil, ErrCacheOnlyMiss
+	}
+	cached, err := cs.redisClient.Get(cs.ctx, cs.getBitcoinCacheKey(symbol)).Result()
+	if err != nil {
+		return nil, ErrCacheOnlyMiss
+	}
+	if cached == negativeCacheSentinel {
+		return nil, nil
 	}
 
-	return bitcoins, nil
+	var bitcoin Bitcoin
+	if err := decodeCacheValue([]byte(cached), &bitcoin); err != nil {
+		return nil, ErrCacheOnlyMiss
+	}
+	return &bitcoin, nil
 }
 
-// Fallback: Get rankings from database (used if Redis sorted set is empty)
-func (cs *CacheService) getBitcoinsRankedFromDB() ([]Bitcoin, error) {
-	log.Println("Fetching rankings from database...")
-
-	rows, err := cs.db.Query(`
-		SELECT
-			symbol,
-			price,
-			created_at,
-			updated_at,
-			ROW_NUMBER() OVER (ORDER BY price DESC) as rank
-		FROM bitcoins
-		ORDER BY price DESC
-	`)
+// Serve the rankings only if the payload is cached; ErrCacheOnlyMiss otherwise
+func (cs *CacheService) GetBitcoinsRankedCacheOnly() ([]Bitcoin, error) {
+	if cs.cacheDisabled() {
+		return nil, ErrCacheOnlyMiss
+	}
+	cached, err := cs.redisClient.Get(cs.ctx, rankCacheKey).Bytes()
 	if err != nil {
-		return nil, fmt.Errorf("database error: %w", err)
+		return nil, ErrCacheOnlyMiss
 	}
-	defer rows.Close()
 
-	var bitcoins []Bitcoin
-	for rows.Next() {
-		var b Bitcoin
-		if err := rows.Scan(&b.Symbol, &b.Price, &b.CreatedAt, &b.UpdatedAt, &b.Rank); err != nil {
-			return nil, fmt.Errorf("scan error: %w", err)
-		}
-		bitcoins = append(bitcoins, b)
+	bitcoins, err := decodeRankings(cached)
+	if err != nil {
+		return nil, ErrCacheOnlyMiss
 	}
-
 	return bitcoins, nil
 }
 
-// Delete bitcoin from DB and cache
-func (cs *CacheService) DeleteBitcoin(symbol string) (*Bitcoin, error) {
-	// Delete from database
-	var bitcoin Bitcoin
-	err := cs.db.QueryRow(`
-		DELETE FROM bitcoins WHERE symbol = $1
-		RETURNING symbol, price, created_at, updated_at
-	`, symbol).Scan(&bitcoin.Symbol, &bitcoin.Price, &bitcoin.CreatedAt, &bitcoin.UpdatedAt)
+// Get all bitcoins ranked by price, served from the cached rankings payload when
+// present. A bounded band keeps only the symbols priced within it, still carrying
+// their ranks in the full ranking.
+func (cs *CacheService) GetBitcoinsRanked(ctx context.Context, consistency consistencyLevel, band priceRange) ([]Bitcoin, error) {
+	if !band.bounded() {
+		return cs.getAllBitcoinsRanked(ctx, consistency)
+	}
 
-	if err == sql.ErrNoRows {
-		return nil, nil
+	// The sorted set answers a band without building the whole ranking; every
+	// other read ranks and filters in one query
+	if cs.cacheDisabled() || consistency == consistencyStrong || cs.rankingsSource != rankingsSourceRedis {
+		return cs.getBitcoinsRankedInRangeFromDB(ctx, band)
 	}
+	cs.waitForPrime(ctx)
+	bitcoins, err := cs.buildBitcoinsRankedInRange(ctx, band)
 	if err != nil {
-		return nil, fmt.Errorf("database error: %w", err)
+		slog.WarnContext(ctx, "reading price band from sorted set failed, falling back to database", "error", err)
+		return cs.getBitcoinsRankedInRangeFromDB(ctx, band)
 	}
+	return bitcoins, nil
+}
 
-	// Delete from individual cache
-	cs.redisClient.Del(cs.ctx, cs.getBitcoinCacheKey(symbol))
+func (cs *CacheService) getAllBitcoinsRanked(ctx context.Context, consistency consistencyLevel) ([]Bitcoin, error) {
+	if cs.cacheDisabled() {
+		return cs.getBitcoinsRankedFromDB(ctx)
+	}
 
-	// Remove from sorted set
-	cs.redisClient.ZRem(cs.ctx, rankSortedSetKey, symbol)
+	// Strong reads rank straight from the DB and replace the cached payload
+	if consistency == consistencyStrong {
+		slog.InfoContext(ctx, "strong read for rankings")
+		version := cs.rankingsVersion()
+		bitcoins, err := cs.getBitcoinsRankedFromDB(ctx)
+		if err != nil {
+			return nil, err
+		}
+		cs.cacheRankings(ctx, version, bitcoins)
+		return bitcoins, nil
+	}
 
-	log.Printf("Deleted %s from DB, cache, and sorted set", symbol)
-	return &bitcoin, nil
-}
+	// A rankings build during priming would read every symbol through the DB
+	cs.waitForPrime(ctx)
 
-func main() {
-	// Database connection
-	dbHost := getEnv("POSTGRES_HOST", "localhost")
-	dbPort := getEnv("POSTGRES_PORT", "5432")
-	dbUser := getEnv("POSTGRES_USER", "postgres")
-	dbPassword := getEnv("POSTGRES_PASSWORD", "postgres")
-	dbName := getEnv("POSTGRES_DB", "bitcoin_db")
+	canaryVersion, canary := cs.rankingsCanary()
 
-	connStr := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
-		dbHost, dbPort, dbUser, dbPassword, dbName)
+	start := time.Now()
+	cached, err := cs.getRankingsPayload(ctx, true)
+	if err == nil {
+		if bitcoins, err := decodeRankings(cached); err != nil {
+			slog.ErrorContext(ctx, "unmarshaling cached rankings failed", "error", err)
+		} else {
+			cs.logCacheLookup(ctx, "rankings", true, start)
+			if canary {
+				go cs.compareRankingsCanary(canaryVersion, bitcoins)
+			}
+			return bitcoins, nil
+		}
+	}
 
-	db, err := sql.Open("postgres", connStr)
+	cs.logCacheLookup(ctx, "rankings", false, start)
+
+	shared, err := cs.sharedLoad(ctx, "rankings", cs.loadRankings)
 	if err != nil {
-		log.Fatalf("Failed to connect to database: %v", err)
+		return nil, err
 	}
-	defer db.Close()
 
-	// Test database connection
-	if err := db.Ping(); err != nil {
-		log.Fatalf("Failed to ping database: %v", err)
+	// Each caller gets its own copy: some annotate the list in place (rank_change)
+	bitcoins := append([]Bitcoin(nil), shared.([]Bitcoin)...)
+	if canary {
+		go cs.compareRankingsCanary(canaryVersion, bitcoins)
 	}
-	log.Println("Connected to PostgreSQL")
+	return bitcoins, nil
+}
 
-	// Redis connection
-	redisHost := getEnv("REDIS_HOST", "localhost")
-	redisPort := getEnv("REDIS_PORT", "6379")
+// Cache the rankings payload unless the rankings version has moved past version
+// (i.e. a write landed after the caller started reading)
+func (cs *CacheService) cacheRankings(ctx context.Context, version string, bitcoins []Bitcoin) {
+	data, err := json.Marshal(bitcoins)
+	if err != nil {
+		cs.marshalFailed(ctx, rankCacheKey, err)
+		return
+	}
+	if cs.cacheCompressed || (cs.compressThreshold > 0 && len(data) > cs.compressThreshold) {
+		if data, err = gzipRankings(data, len(bitcoins)); err != nil {
+			slog.ErrorContext(ctx, "compressing rankings failed", "error", err)
+			return
+		}
+	}
 
-	redisClient := redis.NewClient(&redis.Options{
-		Addr: fmt.Sprintf("%s:%s", redisHost, redisPort),
-	})
-	defer redisClient.Close()
+	ttl := cs.ttlFor(keyKindRankings, len(bitcoins))
+	if len(bitcoins) > largeRankingsSize {
+		slog.InfoContext(ctx, "large rankings payload, caching with a shorter TTL", "bitcoins", len(bitcoins), "ttl", ttl.String())
+	}
+	// Kept past its TTL for stale-while-revalidate reads (see rankingsstale.go)
+	ttl += cs.rankingsStaleTTL
+	stored, err := setIfVersionScript.Run(cs.ctx, cs.redisClient,
+		[]string{rankVersionKey, rankCacheKey, rankingsPendingKey}, version, data, ttl.Milliseconds()).Int()
+	if err != nil {
+		slog.ErrorContext(ctx, "caching rankings failed", "error", err)
+	} else if stored == 0 {
+		slog.InfoContext(ctx, "rankings changed while rebuilding, not caching stale result")
+	}
+}
 
-	// Test Redis connection
-	ctx := context.Background()
-	if err := redisClient.Ping(ctx).Err(); err != nil {
-		log.Fatalf("Failed to connect to Redis: %v", err)
+// Compare-and-set for the rankings payload: only store it if no write has
+// invalidated rankings since the reader captured the version (missing = "0") and
+// no debounced invalidation is pending (KEYS[3])
+var setIfVersionScript = redis.NewScript(`
+	local current = redis.call("GET", KEYS[1]) or "0"
+	if current ~= ARGV[1] or redis.call("EXISTS", KEYS[3]) == 1 then
+		return 0
+	end
+	redis.call("SET", KEYS[2], ARGV[2], "PX", ARGV[3])
+	return 1
+`)
+
+func (cs *CacheService) rankingsVersion() string {
+	version, err := cs.redisClient.Get(cs.ctx, rankVersionKey).Result()
+	if err != nil {
+		return "0"
 	}
-	log.Println("Connected to Redis")
+	return version
+}
 
-	// Initialize cache service
-	cacheService := NewCacheService(db, redisClient)
+// Serve a derived ranking from a key that embeds the rankings version. A build that
+// races with a write lands under the old version, where no reader will look for it.
+func (cs *CacheService) getVersionedRankings(ctx context.Context, cacheKey, label string, build func() ([]Bitcoin, error)) ([]Bitcoin, error) {
+	start := time.Now()
+	cached, err := cs.redisClient.Get(cs.ctx, cacheKey).Result()
+	if err == nil {
+		var bitcoins []Bitcoin
+		if err := decodeCacheValue([]byte(cached), &bitcoins); err != nil {
+			slog.ErrorContext(ctx, "unmarshaling cached rankings failed", "ranking", label, "error", err)
+		} else {
+			cs.logCacheLookup(cs.ctx, "derived_rankings", true, start, "ranking", label)
+			return bitcoins, nil
+		}
+	}
 
-	// Prime the cache at startup
-	if err := cacheService.PrimeCache(); err != nil {
-		log.Printf("Warning: Cache priming failed: %v", err)
+	cs.logCacheLookup(cs.ctx, "derived_rankings", false, start, "ranking", label)
+
+	bitcoins, err := build()
+	if err != nil {
+		return nil, err
 	}
 
-	// Setup Gin router
-	router := gin.Default()
+	data, err := cs.encodeCacheValue(bitcoins)
+	if err != nil {
+		slog.ErrorContext(ctx, "marshaling rankings failed", "ranking", label, "error", err)
+	} else if cs.invalidationPending() {
+		slog.InfoContext(ctx, "invalidation pending, not caching rankings", "ranking", label)
+	} else if err := cs.redisClient.Set(cs.ctx, cacheKey, data, cs.ttlFor(keyKindRankings, len(bitcoins))).Err(); err != nil {
+		slog.ErrorContext(ctx, "caching rankings failed", "ranking", label, "error", err)
+	}
 
-	// CORS middleware
-	router.Use(cors.New(cors.Config{
-		AllowOrigins:     []string{"*"},
-		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Accept"},
-		ExposeHeaders:    []string{"Content-Length"},
-		AllowCredentials: true,
-		MaxAge:           12 * time.Hour,
-	}))
+	return bitcoins, nil
+}
 
-	// Health check
-	router.GET("/health", func(c *gin.Context) {
-		c.JSON(http.StatusOK, gin.H{"status": "healthy"})
-	})
+// Cache keys derived from the whole dataset, cleared together on every write. A new
+// aggregate endpoint registers its fixed key here; keys that embed the rankings
+// version (per-tag, market cap) are invalidated by the version bump alone.
+var derivedCacheKeys = []string{rankCacheKey, tagStatsCacheKey}
+
+// Record a DB operation's latency; use as defer cs.observeDB("get", time.Now())
+func (cs *CacheService) observeDB(operation string, start time.Time) {
+	duration := time.Since(start)
+	cs.metrics.observeDB(operation, duration)
+	if cs.shedder != nil {
+		cs.shedder.observe(duration)
+	}
+}
 
-	// Get all bitcoins ranked by price
-	router.GET("/api/bitcoins", func(c *gin.Context) {
-		bitcoins, err := cacheService.GetBitcoinsRanked()
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch bitcoins"})
-			return
+// Keys derived from a single symbol: its enrichment fields and every search prefix it matches
+func (cs *CacheService) symbolDerivedKeys(symbol string) []string {
+	return append([]string{cs.getEnrichmentCacheKey(symbol)}, cs.searchKeysFor(symbol)...)
+}
+
+// All derived keys plus any extra per-symbol keys, as a fresh slice
+func derivedKeysWith(extraKeys ...string) []string {
+	keys := make([]string, 0, len(derivedCacheKeys)+len(extraKeys))
+	keys = append(keys, derivedCacheKeys...)
+	return append(keys, extraKeys...)
+}
+
+// Invalidate derived caches after a write, right away or debounced (see invalidation.go)
+func (cs *CacheService) invalidateDerived(ctx context.Context, extraKeys ...string) {
+	if cs.invalidationDebounce > 0 {
+		cs.debounceInvalidation(ctx, extraKeys...)
+		return
+	}
+	cs.invalidateDerivedNow(ctx, extraKeys...)
+}
+
+// Drop every derived key (plus any extra per-symbol keys) and bump the rankings version
+// in one MULTI/EXEC, so readers that started rebuilding before this write won't cache
+// their stale result. The data epoch is bumped alongside.
+func (cs *CacheService) invalidateDerivedNow(ctx context.Context, extraKeys ...string) {
+	_, err := cs.redisClient.TxPipelined(cs.ctx, func(pipe redis.Pipeliner) error {
+		pipe.Incr(cs.ctx, rankVersionKey)
+		pipe.Incr(cs.ctx, dataEpochKey)
+		pipe.Del(cs.ctx, derivedKeysWith(extraKeys...)...)
+		if cs.invalidationDebounce > 0 {
+			pipe.Del(cs.ctx, rankingsPendingKey)
+		}
+		return nil
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "invalidating derived caches failed", "error", err)
+	}
+}
+
+// Assemble the ranked list using the Redis sorted set
+func (cs *CacheService) buildBitcoinsRanked(ctx context.Context) ([]Bitcoin, error) {
+	// Get symbols from sorted set (highest to lowest price)
+	// ZREVRANGE returns members in descending order of score
+	symbols, err := cs.redisClient.ZRevRangeWithScores(ctx, rankSortedSetKey, 0, -1).Result()
+	if err != nil {
+		slog.ErrorContext(ctx, "reading sorted set failed, falling back to database", "error", err)
+		return cs.getBitcoinsRankedFromDB(ctx)
+	}
+
+	// Missing (e.g. evicted or flushed): rehydrate it from the database so later
+	// builds are served from Redis again
+	if len(symbols) == 0 {
+		slog.InfoContext(ctx, "sorted set empty, rehydrating from database")
+		members, err := cs.refreshRankingsSortedSet(ctx)
+		if err != nil || members == 0 {
+			if err != nil {
+				slog.ErrorContext(ctx, "rehydrating sorted set failed", "error", err)
+			}
+			return cs.getBitcoinsRankedFromDB(ctx)
+		}
+		if symbols, err = cs.redisClient.ZRevRangeWithScores(ctx, rankSortedSetKey, 0, -1).Result(); err != nil {
+			slog.ErrorContext(ctx, "reading sorted set failed, falling back to database", "error", err)
+			return cs.getBitcoinsRankedFromDB(ctx)
+		}
+	}
+
+	slog.InfoContext(ctx, "rankings served from Redis sorted set", "bitcoins", len(symbols))
+	sortRankedMembers(symbols)
+
+	// Full details for every member in one MGET (plus one query for any misses)
+	ordered := make([]string, len(symbols))
+	for i, z := range symbols {
+		ordered[i] = z.Member.(string)
+	}
+	records, err := cs.GetBitcoinsBatch(ctx, ordered)
+	if err != nil {
+		return nil, err
+	}
+
+	// Members whose record is gone (deleted since) are skipped without leaving a gap
+	var bitcoins []Bitcoin
+	rank := 1
+
+	for _, symbol := range ordered {
+		bitcoin, ok := records[symbol]
+		if !ok {
+			slog.WarnContext(ctx, "rankings member has no record, skipping", "symbol", symbol)
+			continue
+		}
+
+		// Create a local copy of rank to avoid pointer issues
+		rankValue := rank
+		bitcoin.Rank = &rankValue
+		bitcoins = append(bitcoins, bitcoin)
+		rank++
+	}
+
+	return bitcoins, nil
+}
+
+// Fallback: Get rankings from database (used if Redis sorted set is empty)
+func (cs *CacheService) getBitcoinsRankedFromDB(ctx context.Context) ([]Bitcoin, error) {
+	return cs.getBitcoinsRankedInRangeFromDB(ctx, priceRange{})
+}
+
+// The rankings from the database, limited to band. Ranks are numbered over every
+// priced symbol before the band is applied.
+func (cs *CacheService) getBitcoinsRankedInRangeFromDB(ctx context.Context, band priceRange) ([]Bitcoin, error) {
+	slog.InfoContext(ctx, "fetching rankings from database")
+	defer cs.observeDB("rankings", time.Now())
+
+	var bitcoins []Bitcoin
+	err := cs.withDBRetry(ctx, "rankings", func() error {
+		rows, err := cs.db.QueryContext(ctx, `
+			SELECT symbol, price, supply, created_at, updated_at, rank
+			FROM (
+				SELECT
+					symbol,
+					price,
+					supply,
+					created_at,
+					updated_at,
+					ROW_NUMBER() OVER (ORDER BY price DESC, symbol ASC) as rank
+				FROM bitcoins
+				WHERE price IS NOT NULL AND deleted_at IS NULL
+			) ranked
+			WHERE ($1::numeric IS NULL OR price >= $1) AND ($2::numeric IS NULL OR price <= $2)
+			ORDER BY rank
+		`, band.min, band.max)
+		if err != nil {
+			return fmt.Errorf("database error: %w", err)
+		}
+		defer rows.Close()
+
+		bitcoins = nil
+		for rows.Next() {
+			var b Bitcoin
+			if err := rows.Scan(&b.Symbol, &b.Price, &b.Supply, &b.CreatedAt, &b.UpdatedAt, &b.Rank); err != nil {
+				return fmt.Errorf("scan error: %w", err)
+			}
+			bitcoins = append(bitcoins, b)
+		}
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("database error: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return bitcoins, nil
+}
+
+// Delete a symbol from DB and cache. Unless hard is set it is only soft-deleted (see
+// softdelete.go); a hard delete removes the row, and its price history too if
+// purgeHistory is set, in the same statement. cacheConsistent is false if the cached
+// record or rankings entry couldn't be removed.
+func (cs *CacheService) DeleteBitcoin(ctx context.Context, symbol string, hard, purgeHistory bool) (deleted *Bitcoin, cacheConsistent bool, err error) {
+	if cs.readOnly {
+		return nil, false, ErrReadOnly
+	}
+	symbol, err = normalizeSymbol(symbol)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// Delete from database; as with SetBitcoin, the cache is updated once it has committed.
+	// A hard delete also purges a symbol that was already soft-deleted.
+	var bitcoin Bitcoin
+	start := time.Now()
+	if hard {
+		err = cs.db.QueryRowContext(ctx, `
+			WITH deleted AS (
+				DELETE FROM bitcoins WHERE symbol = $1
+				RETURNING symbol, price, supply, created_at, updated_at, deleted_at
+			), purged AS (
+				DELETE FROM bitcoin_price_history
+				WHERE $2 AND symbol IN (SELECT symbol FROM deleted)
+			)
+			SELECT symbol, price, supply, created_at, updated_at, deleted_at FROM deleted
+		`, symbol, purgeHistory).Scan(&bitcoin.Symbol, &bitcoin.Price, &bitcoin.Supply, &bitcoin.CreatedAt, &bitcoin.UpdatedAt, &bitcoin.DeletedAt)
+	} else {
+		err = cs.db.QueryRowContext(ctx, `
+			UPDATE bitcoins SET deleted_at = CURRENT_TIMESTAMP
+			WHERE symbol = $1 AND deleted_at IS NULL
+			RETURNING symbol, price, supply, created_at, updated_at, deleted_at
+		`, symbol).Scan(&bitcoin.Symbol, &bitcoin.Price, &bitcoin.Supply, &bitcoin.CreatedAt, &bitcoin.UpdatedAt, &bitcoin.DeletedAt)
+	}
+	cs.observeDB("delete", start)
+
+	if err == sql.ErrNoRows {
+		return nil, true, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("database error: %w", err)
+	}
+	cs.l1.evict(symbol)
+
+	// The resync when Redis returns drops the cached record
+	if cs.cacheWritesDisabled() {
+		slog.InfoContext(ctx, "deleted from DB (cache disabled)", "symbol", symbol, "hard", hard)
+		return &bitcoin, true, nil
+	}
+
+	// Replace the cached record with a not-found marker, so reads of the deleted
+	// symbol don't fall through to the DB. If that fails, drop the record (with
+	// retries) so the deleted symbol can't stay readable.
+	cacheKey := cs.getBitcoinCacheKey(symbol)
+	cacheConsistent = true
+	if err := cs.redisClient.Set(cs.ctx, cacheKey, negativeCacheSentinel, cs.ttlFor(keyKindNegative, 1)).Err(); err != nil {
+		slog.ErrorContext(ctx, "caching not-found marker failed", "symbol", symbol, "error", err)
+		cacheConsistent = cs.dropCacheKey(ctx, cacheKey)
+	}
+
+	// Remove from sorted set
+	if err := cs.redisClient.ZRem(cs.ctx, rankSortedSetKey, symbol).Err(); err != nil {
+		slog.ErrorContext(ctx, "removing from sorted set failed", "symbol", symbol, "error", err)
+		cacheConsistent = false
+	}
+
+	// Invalidate derived caches and per-symbol derived fields
+	cs.invalidateDerived(ctx, cs.symbolDerivedKeys(symbol)...)
+
+	cs.mirror(func(ctx context.Context, pipe redis.Pipeliner) {
+		pipe.Del(ctx, derivedKeysWith(append(cs.symbolDerivedKeys(symbol), cs.getBitcoinCacheKey(symbol))...)...)
+		pipe.ZRem(ctx, rankSortedSetKey, symbol)
+	})
+
+	// Subscribers already saw a purged tombstone go
+	if !hard || bitcoin.DeletedAt == nil {
+		cs.publishChange(ctx, ChangeEvent{Type: eventTypeDelete, Symbol: bitcoin.Symbol, Bitcoin: &bitcoin, PreviousPrice: bitcoin.Price})
+	}
+	cs.publishInvalidation(ctx, bitcoin.Symbol, eventTypeDelete)
+
+	slog.InfoContext(ctx, "deleted from DB, cache and sorted set", "symbol", symbol, "hard", hard)
+	return &bitcoin, cacheConsistent, nil
+}
+
+type StaleSymbol struct {
+	Symbol    string    `json:"symbol"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Symbols whose price hasn't been updated within threshold (e.g. a stalled feed).
+// Always read from the database since this is used for alerting.
+func (cs *CacheService) GetStaleSymbols(ctx context.Context, threshold time.Duration) ([]StaleSymbol, error) {
+	rows, err := cs.db.QueryContext(ctx, `
+		SELECT symbol, updated_at
+		FROM bitcoins
+		WHERE updated_at < CURRENT_TIMESTAMP - make_interval(secs => $1) AND deleted_at IS NULL
+		ORDER BY updated_at ASC
+	`, threshold.Seconds())
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	defer rows.Close()
+
+	stale := []StaleSymbol{}
+	for rows.Next() {
+		var s StaleSymbol
+		if err := rows.Scan(&s.Symbol, &s.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("scan error: %w", err)
+		}
+		stale = append(stale, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("scan error: %w", err)
+	}
+
+	return stale, nil
+}
+
+func main() {
+	if err := setupLogging(getEnv("LOG_LEVEL", "info")); err != nil {
+		fatalf("%v", err)
+	}
+
+	// Database connection
+	dbHost := getEnv("POSTGRES_HOST", "localhost")
+	dbPort := getEnv("POSTGRES_PORT", "5432")
+	dbUser := getEnv("POSTGRES_USER", "postgres")
+	dbPassword := getEnv("POSTGRES_PASSWORD", "postgres")
+	dbName := getEnv("POSTGRES_DB", "bitcoin_db")
+
+	connStr := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
+		dbHost, dbPort, dbUser, dbPassword, dbName)
+
+	db, err := sql.Open("postgres", connStr)
+	if err != nil {
+		fatalf("Failed to connect to database: %v", err)
+	}
+	defer db.Close()
+
+	// Test database connection
+	if err := db.Ping(); err != nil {
+		fatalf("Failed to ping database: %v", err)
+	}
+	slog.Info("connected to PostgreSQL")
+
+	// Bound the pool so a burst of requests can't exhaust Postgres' max_connections,
+	// and recycle connections so failovers and load balancer changes are picked up
+	maxOpenConns := getEnvInt("DB_MAX_OPEN_CONNS", defaultDBMaxOpenConns)
+	maxIdleConns := getEnvInt("DB_MAX_IDLE_CONNS", defaultDBMaxIdleConns)
+	connMaxLifetime := getEnvDuration("DB_CONN_MAX_LIFETIME", defaultDBConnMaxLifetime)
+	warmConns := getEnvInt("DB_MIN_IDLE_CONNS", 0)
+	if maxOpenConns < 0 || maxIdleConns < 0 {
+		fatalf("DB_MAX_OPEN_CONNS and DB_MAX_IDLE_CONNS must not be negative")
+	}
+	if maxOpenConns > 0 && warmConns > maxOpenConns {
+		fatalf("DB_MIN_IDLE_CONNS (%d) must not exceed DB_MAX_OPEN_CONNS (%d)", warmConns, maxOpenConns)
+	}
+	// A warm-up larger than the idle limit would have most of its connections closed.
+	// database/sql caps idle connections at the open limit; cap here so the log is exact.
+	maxIdleConns = max(maxIdleConns, warmConns)
+	if maxOpenConns > 0 {
+		maxIdleConns = min(maxIdleConns, maxOpenConns)
+	}
+	db.SetMaxOpenConns(maxOpenConns)
+	db.SetMaxIdleConns(maxIdleConns)
+	db.SetConnMaxLifetime(connMaxLifetime)
+	slog.Info("PostgreSQL pool configured (max_open 0 = unlimited)", "max_open", maxOpenConns, "max_idle", maxIdleConns, "conn_max_lifetime", connMaxLifetime.String())
+
+	// Apply migrations and fail fast if the schema can't support our queries
+	if err := runMigrations(db); err != nil {
+		fatalf("Database schema is not usable: %v", err)
+	}
+
+	// Redis connection
+	redisHost := getEnv("REDIS_HOST", "localhost")
+	redisPort := getEnv("REDIS_PORT", "6379")
+
+	// Transient blips (e.g. a failover) are retried inside the client before an
+	// error ever reaches CacheService
+	redisMaxRetries := getEnvInt("REDIS_MAX_RETRIES", 3)
+	redisMinRetryBackoff := getEnvDuration("REDIS_MIN_RETRY_BACKOFF", 8*time.Millisecond)
+	redisMaxRetryBackoff := getEnvDuration("REDIS_MAX_RETRY_BACKOFF", 512*time.Millisecond)
+
+	redisClient := redis.NewClient(&redis.Options{
+		Addr:            fmt.Sprintf("%s:%s", redisHost, redisPort),
+		MaxRetries:      redisMaxRetries,
+		MinRetryBackoff: redisMinRetryBackoff,
+		MaxRetryBackoff: redisMaxRetryBackoff,
+	})
+	defer redisClient.Close()
+
+	// Test Redis connection. Without it the service still starts, serving from the
+	// database only until RunRedisMonitor sees Redis come back.
+	ctx := context.Background()
+	redisErr := redisClient.Ping(ctx).Err()
+	if redisErr != nil {
+		slog.Warn("connecting to Redis failed, starting in DB-only mode", "error", redisErr)
+	} else {
+		slog.Info("connected to Redis")
+	}
+	slog.Info("Redis retry policy", "max_retries", redisMaxRetries, "min_backoff", redisMinRetryBackoff.String(), "max_backoff", redisMaxRetryBackoff.String())
+
+	// Background jobs run until shutdown
+	bgCtx, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+
+	latencyBuckets := defaultLatencyBuckets
+	if spec := os.Getenv("LATENCY_BUCKETS"); spec != "" {
+		buckets, err := parseLatencyBuckets(spec)
+		if err != nil {
+			fatalf("Invalid LATENCY_BUCKETS: %v", err)
+		}
+		latencyBuckets = buckets
+	}
+	metrics := NewMetrics(prometheus.NewRegistry(), latencyBuckets)
+	redisClient.AddHook(redisLatencyHook{metrics: metrics})
+
+	// Initialize cache service
+	cacheService := NewCacheService(db, redisClient, metrics)
+	if redisErr != nil {
+		cacheService.setRedisState(redisStateDown)
+	} else {
+		cacheService.setRedisState(redisStateUp)
+	}
+	go cacheService.RunRedisMonitor(bgCtx, getEnvDuration("REDIS_HEALTH_INTERVAL", defaultRedisHealthInterval))
+	cacheService.cacheTTL = getEnvDuration("CACHE_TTL", defaultCacheTTL)
+	ttlJitter, err := strconv.ParseFloat(getEnv("CACHE_TTL_JITTER", strconv.FormatFloat(defaultTTLJitter, 'f', -1, 64)), 64)
+	if err != nil || ttlJitter < 0 || ttlJitter >= 1 {
+		fatalf("CACHE_TTL_JITTER must be in [0, 1)")
+	}
+	cacheService.ttlJitter = ttlJitter
+	cacheService.rankingsTTL = getEnvDuration("RANKINGS_CACHE_TTL", defaultRankingsTTL)
+	cacheService.rankingsStaleTTL = getEnvDuration("RANKINGS_STALE_TTL", 0)
+	cacheService.negativeTTL = getEnvDuration("NEGATIVE_CACHE_TTL", defaultNegativeTTL)
+	cacheService.enrichmentTTL = getEnvDuration("ENRICHMENT_CACHE_TTL", defaultEnrichmentTTL)
+	cacheService.statsTTL = getEnvDuration("STATS_CACHE_TTL", defaultStatsTTL)
+	cacheService.searchTTL = getEnvDuration("SEARCH_CACHE_TTL", defaultSearchTTL)
+	cacheService.pubsubMinBackoff = getEnvDuration("PUBSUB_RECONNECT_MIN_BACKOFF", defaultPubSubMinBackoff)
+	cacheService.pubsubMaxBackoff = getEnvDuration("PUBSUB_RECONNECT_MAX_BACKOFF", defaultPubSubMaxBackoff)
+	cacheService.minUpdateInterval = getEnvDuration("MIN_UPDATE_INTERVAL", 0)
+	cacheService.invalidationDebounce = getEnvDuration("RANKINGS_INVALIDATION_DEBOUNCE", 0)
+	sampleRate, err := strconv.ParseFloat(getEnv("FRESHNESS_SAMPLE_RATE", "0"), 64)
+	if err != nil || sampleRate < 0 || sampleRate > 1 {
+		fatalf("FRESHNESS_SAMPLE_RATE must be in [0, 1]")
+	}
+	cacheService.freshnessSampleRate = sampleRate
+	cacheService.freshnessTolerance = getEnvDuration("FRESHNESS_TOLERANCE", defaultFreshnessTolerance)
+	canaryRate, err := strconv.ParseFloat(getEnv("RANKINGS_CANARY_SAMPLE_RATE", "0"), 64)
+	if err != nil || canaryRate < 0 || canaryRate > 1 {
+		fatalf("RANKINGS_CANARY_SAMPLE_RATE must be in [0, 1]")
+	}
+	cacheService.canarySampleRate = canaryRate
+	cacheService.stampedeMaxWait = getEnvDuration("STAMPEDE_MAX_WAIT", 0)
+	cacheService.dbRetryAttempts = getEnvInt("DB_RETRY_ATTEMPTS", defaultDBRetryAttempts)
+	if cacheService.dbRetryAttempts < 1 {
+		fatalf("DB_RETRY_ATTEMPTS must be at least 1")
+	}
+	cacheService.dbRetryBaseDelay = getEnvDuration("DB_RETRY_BASE_DELAY", defaultDBRetryBaseDelay)
+	cacheService.pricePrecision = getEnvInt("PRICE_PRECISION", defaultPricePrecision)
+	if cacheService.pricePrecision < 0 || cacheService.pricePrecision > maxPricePrecision {
+		fatalf("PRICE_PRECISION must be between 0 and %d", maxPricePrecision)
+	}
+	cacheService.batchReadChunkSize = getEnvInt("BATCH_READ_CHUNK_SIZE", defaultBatchReadChunkSize)
+	if cacheService.batchReadChunkSize < 1 {
+		fatalf("BATCH_READ_CHUNK_SIZE must be at least 1")
+	}
+
+	cacheService.nullSupply = getEnv("MARKETCAP_NULL_SUPPLY", nullSupplyExclude)
+	if cacheService.nullSupply != nullSupplyExclude && cacheService.nullSupply != nullSupplyZero {
+		fatalf("MARKETCAP_NULL_SUPPLY must be %q or %q", nullSupplyExclude, nullSupplyZero)
+	}
+	cacheService.batchDuplicates = getEnv("BATCH_DUPLICATES", batchDuplicatesLastWins)
+	if cacheService.batchDuplicates != batchDuplicatesLastWins && cacheService.batchDuplicates != batchDuplicatesReject {
+		fatalf("BATCH_DUPLICATES must be %q or %q", batchDuplicatesLastWins, batchDuplicatesReject)
+	}
+	cacheService.rankingsSource = getEnv("RANKINGS_SOURCE", rankingsSourceRedis)
+	if cacheService.rankingsSource != rankingsSourceRedis && cacheService.rankingsSource != rankingsSourcePostgres {
+		fatalf("RANKINGS_SOURCE must be %q or %q", rankingsSourceRedis, rankingsSourcePostgres)
+	}
+	// getEnvDuration rejects 0, so the off switch is checked first
+	if getEnv("RANKINGS_RECONCILE_INTERVAL", "") != "0" {
+		go cacheService.RunRankingsReconciler(bgCtx, getEnvDuration("RANKINGS_RECONCILE_INTERVAL", 5*time.Minute))
+	}
+	slog.Info("rankings source", "source", cacheService.rankingsSource)
+
+	cacheService.bulkChunkSize = getEnvInt("BULK_CHUNK_SIZE", defaultBulkChunkSize)
+	cacheService.bulkAsyncThreshold = getEnvInt("BULK_ASYNC_THRESHOLD", defaultBulkAsyncThreshold)
+	bulkConcurrency := getEnvInt("BULK_CONCURRENCY", defaultBulkConcurrency)
+	if cacheService.bulkChunkSize < 1 || bulkConcurrency < 1 {
+		fatalf("BULK_CHUNK_SIZE and BULK_CONCURRENCY must be at least 1")
+	}
+	if cacheService.bulkAsyncThreshold < 0 {
+		fatalf("BULK_ASYNC_THRESHOLD must not be negative")
+	}
+	cacheService.bulkSlots = make(chan struct{}, bulkConcurrency)
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	cacheService.instanceID = getEnv("INSTANCE_ID", hostname)
+
+	// Read-only instances (e.g. pointed at a replica) never mutate the database
+	readOnly := getEnv("READ_ONLY", "false") == "true"
+	cacheService.readOnly = readOnly
+	if readOnly {
+		slog.Info("running in read-only mode: write endpoints are disabled")
+	}
+
+	// Keys for encrypting sensitive metadata fields at rest
+	if keySpec := os.Getenv("ENCRYPTION_KEY"); keySpec != "" {
+		fc, err := newFieldCipher(keySpec)
+		if err != nil {
+			fatalf("Invalid ENCRYPTION_KEY: %v", err)
+		}
+		cacheService.fieldCipher = fc
+		slog.Info("field encryption enabled", "active_key", fc.activeKeyID)
+	}
+
+	// Optional secondary Redis for dual-write during a cluster migration
+	if secondaryAddr := os.Getenv("SECONDARY_REDIS_ADDR"); secondaryAddr != "" {
+		secondary := redis.NewClient(&redis.Options{
+			Addr:            secondaryAddr,
+			MaxRetries:      redisMaxRetries,
+			MinRetryBackoff: redisMinRetryBackoff,
+			MaxRetryBackoff: redisMaxRetryBackoff,
+		})
+		defer secondary.Close()
+
+		if err := secondary.Ping(ctx).Err(); err != nil {
+			slog.Warn("secondary Redis unreachable, dual-writes will fail until it recovers", "addr", secondaryAddr, "error", err)
+		}
+		cacheService.secondary = secondary
+
+		compareInterval := getEnvDuration("DUAL_WRITE_COMPARE_INTERVAL", time.Minute)
+		go cacheService.RunDualWriteComparator(bgCtx, compareInterval)
+		slog.Info("dual-writing cache to secondary Redis", "addr", secondaryAddr, "compare_interval", compareInterval.String())
+	}
+
+	// Critical symbols primed first and kept warm regardless of traffic
+	if spec := os.Getenv("PRELOAD_SYMBOLS"); spec != "" {
+		cacheService.setPreloadSymbols(parsePreloadSymbols(spec))
+		cacheService.preloadTTL = getEnvDuration("PRELOAD_CACHE_TTL", defaultPreloadTTL)
+		refreshInterval := getEnvDuration("PRELOAD_REFRESH_INTERVAL", defaultPreloadRefreshInterval)
+		go cacheService.RunPreloadRefresh(bgCtx, refreshInterval)
+		slog.Info("preloading symbols", "symbols", cacheService.preloadSymbols, "ttl", cacheService.preloadTTL.String(), "refresh_interval", refreshInterval.String())
+	}
+
+	// Group TTL policies, loaded before priming so primed records get them
+	if err := cacheService.loadCachePolicies(bgCtx); err != nil {
+		slog.Warn("loading cache policies failed, using default TTLs until the next refresh", "error", err)
+	}
+	go cacheService.RunCachePolicyRefresh(bgCtx, getEnvDuration("CACHE_POLICY_REFRESH_INTERVAL", defaultCachePolicyRefreshInterval))
+
+	l1Size := getEnvInt("L1_CACHE_SIZE", 0)
+	cacheService.l1, err = newL1Cache(l1Size, getEnvDuration("L1_CACHE_TTL", defaultL1TTL))
+	if err != nil {
+		fatalf("Invalid L1_CACHE_SIZE: %v", err)
+	}
+	if cacheService.l1 != nil {
+		cacheService.onInvalidate(cacheService.l1.evict)
+		slog.Info("L1 cache enabled", "records", l1Size, "ttl", cacheService.l1.ttl.String())
+	}
+
+	// Other instances' writes, for whatever this instance holds in process
+	go cacheService.RunInvalidationSubscriber(bgCtx)
+
+	cacheService.readOrder = getEnv("READ_ORDER", readOrderCacheFirst)
+	if cacheService.readOrder != readOrderCacheFirst && cacheService.readOrder != readOrderDBFirst {
+		fatalf("READ_ORDER must be %q or %q", readOrderCacheFirst, readOrderDBFirst)
+	}
+
+	// Warm the connection pools and prime the cache in the background; /ready reports 503 until both finish
+	cacheService.primeMode = getEnv("PRIME_WAIT_MODE", primeModeWait)
+	if cacheService.primeMode != primeModeWait && cacheService.primeMode != primeModePassThrough {
+		fatalf("PRIME_WAIT_MODE must be %q or %q", primeModeWait, primeModePassThrough)
+	}
+	cacheService.primeWait = getEnvDuration("PRIME_WAIT_TIMEOUT", defaultPrimeWait)
+	cacheService.warmConns = warmConns
+	cacheService.primeLockTTL = getEnvDuration("PRIME_LOCK_TTL", defaultPrimeLockTTL)
+	cacheService.StartPriming()
+	if interval := getEnvDuration("PRIME_INTERVAL", 0); interval > 0 {
+		go cacheService.RunScheduledPriming(bgCtx, interval)
+		slog.Info("re-priming the cache periodically", "interval", interval.String())
+	}
+
+	// Daily rank snapshots feed rank_change in the rankings
+	if !readOnly {
+		go cacheService.RunRankSnapshots(bgCtx)
+	}
+
+	// Optional streaming ingest of price updates from Kafka
+	ingestDone := make(chan struct{})
+	if topic := os.Getenv("INGEST_TOPIC"); topic != "" && !readOnly {
+		brokers := strings.Split(getEnv("KAFKA_BROKERS", "localhost:9092"), ",")
+		reader := kafka.NewReader(kafka.ReaderConfig{
+			Brokers: brokers,
+			Topic:   topic,
+			GroupID: getEnv("INGEST_GROUP_ID", "bitcoin-cache-backend"),
+		})
+
+		var deadLetter *kafka.Writer
+		if dlqTopic := os.Getenv("INGEST_DEAD_LETTER_TOPIC"); dlqTopic != "" {
+			deadLetter = &kafka.Writer{Addr: kafka.TCP(brokers...), Topic: dlqTopic}
+			defer deadLetter.Close()
+		}
+
+		go func() {
+			defer close(ingestDone)
+			cacheService.RunIngestConsumer(bgCtx, reader, deadLetter)
+		}()
+		slog.Info("consuming price updates from Kafka", "topic", topic, "brokers", strings.Join(brokers, ","))
+	} else {
+		if topic != "" {
+			slog.Warn("INGEST_TOPIC ignored in read-only mode", "topic", topic)
+		}
+		close(ingestDone)
+	}
+
+	maxResponseBytes := getEnvInt("MAX_RESPONSE_BYTES", defaultMaxResponseBytes)
+	cacheService.cacheCompressed = getEnv("RANKINGS_CACHE_COMPRESSED", "false") == "true"
+	cacheService.compressThreshold = getEnvInt("CACHE_COMPRESS_THRESHOLD", defaultCompressThreshold)
+	if cacheService.compressThreshold < 0 {
+		fatalf("CACHE_COMPRESS_THRESHOLD must not be negative")
+	}
+
+	// Streaming responses, told to reconnect elsewhere and then closed on shutdown
+	streams := newStreamRegistry()
+	streamDrainGrace := getEnvDuration("STREAM_DRAIN_GRACE", defaultStreamDrainGrace)
+
+	// Set on SIGTERM; /ready fails from then on while /live stays OK
+	var shuttingDown atomic.Bool
+	preShutdownDelay := getEnvDelay("PRE_SHUTDOWN_DELAY", defaultPreShutdownDelay)
+
+	healthCheckTimeout := getEnvDuration("HEALTH_CHECK_TIMEOUT", defaultHealthCheckTimeout)
+
+	// Reject unknown JSON body fields (e.g. a typo'd "symbl") instead of silently ignoring them
+	binding.EnableDecoderDisallowUnknownFields = getEnv("STRICT_JSON", "true") == "true"
+
+	// Setup Gin router
+	router := gin.New()
+	// Match on the escaped path so an encoded slash (%2F) stays inside the :symbol
+	// segment, where symbolParam rejects it, instead of silently changing the route
+	router.UseRawPath = true
+	// Client IPs (used by the rate limiter) are only taken from X-Forwarded-For when the
+	// request comes through one of these proxies
+	if proxies := getEnv("TRUSTED_PROXIES", ""); proxies != "" {
+		if err := router.SetTrustedProxies(strings.Split(proxies, ",")); err != nil {
+			fatalf("Invalid TRUSTED_PROXIES: %v", err)
+		}
+	}
+	router.Use(requestIDMiddleware(), accessLogMiddleware(), latencyMiddleware(metrics), recoveryMiddleware(metrics))
+	if getEnv("REQUEST_TIMEOUT", "") != "0" {
+		router.Use(requestTimeoutMiddleware(getEnvDuration("REQUEST_TIMEOUT", defaultRequestTimeout), "/api/bitcoins/moves/stream", "/api/bitcoins/stream", "/api/ws"))
+	}
+
+	// CORS middleware
+	router.Use(cors.New(cors.Config{
+		AllowOrigins:     []string{"*"},
+		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization", requestIDHeader, apiKeyHeader, idempotencyHeader},
+		ExposeHeaders:    []string{"Content-Length", requestIDHeader, "X-Truncated", "X-Total-Count", "X-Returned-Count", dataEpochHeader, cacheConsistentHeader, idempotencyReplayedHeader},
+		AllowCredentials: true,
+		MaxAge:           12 * time.Hour,
+	}))
+
+	// The middleware below runs after CORS so preflight requests are still answered
+
+	// Shed load when DB latency climbs instead of letting every request time out
+	if threshold := getEnvDuration("LOAD_SHED_P99_THRESHOLD", 0); threshold > 0 {
+		maxRate, err := strconv.ParseFloat(getEnv("LOAD_SHED_MAX_RATE", "0.5"), 64)
+		if err != nil || maxRate <= 0 || maxRate > 1 {
+			fatalf("LOAD_SHED_MAX_RATE must be in (0, 1]")
+		}
+		cacheService.shedder = newLoadShedder(threshold, maxRate, metrics)
+		go cacheService.shedder.Run(bgCtx)
+		router.Use(loadShedMiddleware(cacheService.shedder))
+		slog.Info("load shedding enabled", "db_p99_threshold", threshold.String(), "max_rate", maxRate)
+	}
+
+	if readOnly {
+		router.Use(readOnlyMiddleware())
+	}
+
+	// Partner API keys: scoped to symbols, with per-key quotas
+	router.Use(apiKeyMiddleware(cacheService, getEnv("REQUIRE_API_KEY", "false") == "true"))
+	router.Use(dataEpochMiddleware(cacheService))
+
+	// Per-client rate limits, shared across replicas through Redis
+	readLimit := getEnvInt("RATE_LIMIT", 0)
+	limits := rateLimits{
+		read:   readLimit,
+		write:  getEnvInt("RATE_LIMIT_WRITE", readLimit),
+		window: getEnvDuration("RATE_WINDOW", defaultRateLimitWindow),
+		byKey:  getEnv("RATE_LIMIT_BY_KEY", "false") == "true",
+	}
+	if limits.read < 0 || limits.write < 0 {
+		fatalf("RATE_LIMIT and RATE_LIMIT_WRITE must not be negative")
+	}
+	if limits.read > 0 || limits.write > 0 {
+		router.Use(rateLimitMiddleware(cacheService, limits))
+		slog.Info("rate limiting enabled, per client (0 = unlimited)", "reads", limits.read, "writes", limits.write, "window", limits.window.String())
+	}
+
+	// Retried writes with the same Idempotency-Key get the original response
+	router.Use(idempotencyMiddleware(cacheService, getEnvDuration("IDEMPOTENCY_TTL", defaultIdempotencyTTL)))
+	adminToken := os.Getenv("ADMIN_TOKEN")
+	adminAuth := adminAuthMiddleware(adminToken)
+
+	// Unknown routes get the same JSON error shape as everything else
+	router.NoRoute(func(c *gin.Context) {
+		c.JSON(http.StatusNotFound, errorJSON(CodeNotFound, "Route not found"))
+	})
+
+	// Health check
+	router.GET("/health", func(c *gin.Context) {
+		deps := cacheService.checkDependencies(c.Request.Context(), healthCheckTimeout)
+		status, code := "healthy", http.StatusOK
+		if !deps.healthy() {
+			status, code = "degraded", http.StatusServiceUnavailable
+		}
+		c.JSON(code, gin.H{
+			"status":         status,
+			"postgres":       deps.Postgres,
+			"redis":          deps.Redis,
+			"read_only":      readOnly,
+			"cache_disabled": cacheService.cacheDisabled(),
+		})
+	})
+	// Liveness: the process is up and serving HTTP; dependencies are not checked,
+	// so an outage doesn't get every pod restarted
+	router.GET("/live", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"status": "alive"})
+	})
+
+	// Readiness: not ready until startup cache priming has finished, nor once shutdown begins
+	router.GET("/ready", func(c *gin.Context) {
+		if shuttingDown.Load() {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"status": "shutting_down", "priming": false})
+			return
+		}
+		if cacheService.isPriming() {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"status": "priming", "priming": true})
+			return
+		}
+		if !cacheService.postgresReachable(c.Request.Context(), healthCheckTimeout) {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"status": "postgres_unavailable", "priming": false})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "ready", "priming": false})
+	})
+
+	// Build/version info to confirm which image is running
+	router.GET("/version", func(c *gin.Context) {
+		c.JSON(http.StatusOK, currentBuildInfo(readOnly))
+	})
+
+	// Prometheus metrics
+	router.GET("/metrics", gin.WrapH(metrics.Handler()))
+
+	// Get all bitcoins ranked by price
+	router.GET("/api/bitcoins", func(c *gin.Context) {
+		consistency, err := parseConsistency(c.Query("consistency"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, errorJSON(CodeValidationFailed, err.Error()))
+			return
+		}
+		limit, offset, ok := parsePage(c)
+		if !ok {
+			return
+		}
+		band, ok := parsePriceRange(c)
+		if !ok {
+			return
+		}
+
+		var bitcoins []Bitcoin
+
+		rawTag, filtered := c.GetQuery("tag")
+		include := c.Query("include")
+		switch rankBy := c.DefaultQuery("rankBy", "price"); {
+		case include != "" && include != "rank_change":
+			c.JSON(http.StatusBadRequest, errorJSON(CodeValidationFailed, "include must be rank_change"))
+			return
+		case consistency == consistencyStrong && (rankBy != "price" || filtered || include != ""):
+			c.JSON(http.StatusBadRequest, errorJSON(CodeValidationFailed, "consistency=strong is only supported for the default price rankings"))
+			return
+		case band.bounded() && (rankBy != "price" || filtered || include != ""):
+			c.JSON(http.StatusBadRequest, errorJSON(CodeValidationFailed, "min_price and max_price are only supported for the default price rankings"))
+			return
+		case (rankBy == "marketcap" || filtered) && include != "":
+			c.JSON(http.StatusBadRequest, errorJSON(CodeValidationFailed, "include=rank_change cannot be combined with tag or rankBy=marketcap"))
+			return
+		case rankBy == "marketcap" && filtered:
+			c.JSON(http.StatusBadRequest, errorJSON(CodeValidationFailed, "tag filtering is only supported with rankBy=price"))
+			return
+		case rankBy == "marketcap":
+			bitcoins, err = cacheService.GetBitcoinsRankedByMarketCap(c.Request.Context())
+		case rankBy != "price":
+			c.JSON(http.StatusBadRequest, errorJSON(CodeValidationFailed, "rankBy must be price or marketcap"))
+			return
+		case include == "rank_change":
+			bitcoins, err = cacheService.GetBitcoinsRankedWithChange(c.Request.Context())
+		case filtered:
+			tag, tagErr := normalizeTag(rawTag)
+			if tagErr != nil {
+				c.JSON(http.StatusBadRequest, errorJSON(CodeValidationFailed, tagErr.Error()))
+				return
+			}
+			bitcoins, err = cacheService.GetBitcoinsRankedByTag(c.Request.Context(), tag)
+		case cacheOnly(c) && consistency == consistencyStrong:
+			// A shed request can't make the DB read a strong read promises
+			err = ErrCacheOnlyMiss
+		case cacheOnly(c):
+			c.Header("X-Cache-Only", "true")
+			if !band.bounded() {
+				if payload := cacheService.GetBitcoinsRankedPayload(c.Request.Context(), true); payload != nil && writeRankingsPayload(c, payload, maxResponseBytes, limit, offset) {
+					return
+				}
+			}
+			bitcoins, err = cacheService.GetBitcoinsRankedCacheOnly()
+			bitcoins = band.filter(bitcoins)
+		case consistency == consistencyStrong:
+			bitcoins, err = cacheService.GetBitcoinsRanked(c.Request.Context(), consistency, band)
+		default:
+			// Precompressed payload straight from the cache
+			if !band.bounded() {
+				if payload := cacheService.GetBitcoinsRankedPayload(c.Request.Context(), false); payload != nil && writeRankingsPayload(c, payload, maxResponseBytes, limit, offset) {
+					return
+				}
+			}
+			bitcoins, err = cacheService.GetBitcoinsRanked(c.Request.Context(), consistency, band)
+		}
+		if errors.Is(err, ErrCacheOnlyMiss) {
+			c.Header("Retry-After", "1")
+			c.JSON(http.StatusServiceUnavailable, errorJSON(CodeOverloaded, "Service overloaded, retry shortly"))
+			return
+		}
+		if errors.Is(err, ErrLoadTimeout) {
+			c.Header("Retry-After", "1")
+			c.JSON(http.StatusServiceUnavailable, errorJSON(CodeOverloaded, "Timed out waiting for the database, retry shortly"))
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, errorJSON(codeFor(err), "Failed to fetch bitcoins"))
+			return
+		}
+
+		bitcoins = filterForAPIKey(c, bitcoins)
+
+		// The whole ranking is cached once; pages are sliced from it
+		total := len(bitcoins)
+		c.Header("X-Total-Count", strconv.Itoa(total))
+		bitcoins = pageOf(bitcoins, limit, offset)
+
+		if limited, truncated := truncateToBytes(bitcoins, maxResponseBytes); truncated {
+			slog.WarnContext(c.Request.Context(), "rankings response truncated", "returned", len(limited), "total", len(bitcoins), "limit_bytes", maxResponseBytes)
+			setTruncationHeaders(c, total, len(limited))
+			bitcoins = limited
+		}
+		c.JSON(http.StatusOK, bitcoins)
+	})
+
+	// Stream significant price moves (Server-Sent Events)
+	router.GET("/api/bitcoins/moves/stream", func(c *gin.Context) {
+		minBps, err := strconv.ParseFloat(c.DefaultQuery("min_bps", "50"), 64)
+		if err != nil || minBps <= 0 {
+			c.JSON(http.StatusBadRequest, errorJSON(CodeValidationFailed, "min_bps must be a positive number"))
+			return
+		}
+
+		serveChangeStream(c, cacheService, streams, "Stream was interrupted; moves during the gap were not delivered", func(event ChangeEvent) {
+			// Filter server-side so clients only see moves above the threshold
+			bps, ok := event.moveBps()
+			if !ok || math.Abs(bps) < minBps || !apiKeyAllows(c, event.Symbol) {
+				return
+			}
+			c.SSEvent("move", gin.H{
+				"symbol":         event.Symbol,
+				"previous_price": *event.PreviousPrice,
+				"price":          event.Bitcoin.Price,
+				"bps":            math.Round(bps*100) / 100,
+				"updated_at":     event.Bitcoin.UpdatedAt,
+			})
+		})
+	})
+
+	// Stream every write (Server-Sent Events)
+	router.GET("/api/bitcoins/stream", func(c *gin.Context) {
+		serveChangeStream(c, cacheService, streams, "Stream was interrupted; updates during the gap were not delivered", func(event ChangeEvent) {
+			if !apiKeyAllows(c, event.Symbol) {
+				return
+			}
+			c.SSEvent(event.Type, event)
+		})
+	})
+
+	// Live top-N leaderboard over a websocket
+	wsMaxTop := getEnvInt("WS_MAX_TOP", defaultWSMaxTop)
+	router.GET("/api/ws", func(c *gin.Context) {
+		serveRankingsSocket(c, cacheService, streams, wsMaxTop)
+	})
+
+	// Symbols that haven't been updated recently
+	router.GET("/api/bitcoins/stale", func(c *gin.Context) {
+		threshold, err := time.ParseDuration(c.DefaultQuery("older_than", "1h"))
+		if err != nil || threshold <= 0 {
+			c.JSON(http.StatusBadRequest, errorJSON(CodeValidationFailed, "older_than must be a positive duration (e.g. 30m, 1h)"))
+			return
+		}
+
+		stale, err := cacheService.GetStaleSymbols(c.Request.Context(), threshold)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, errorJSON(codeFor(err), "Failed to fetch stale symbols"))
+			return
+		}
+
+		visible := stale[:0]
+		for _, s := range stale {
+			if apiKeyAllows(c, s.Symbol) {
+				visible = append(visible, s)
+			}
+		}
+		stale = visible
+
+		c.JSON(http.StatusOK, gin.H{
+			"older_than": threshold.String(),
+			"count":      len(stale),
+			"symbols":    stale,
+		})
+	})
+
+	// Symbols starting with a prefix, case-insensitive
+	router.GET("/api/bitcoins/search", func(c *gin.Context) {
+		prefix := c.Query("prefix")
+		if prefix == "" || len(prefix) > maxSearchPrefix {
+			c.JSON(http.StatusBadRequest, errorJSON(CodeValidationFailed, fmt.Sprintf("prefix must be 1-%d characters", maxSearchPrefix)))
+			return
+		}
+
+		bitcoins, err := cacheService.SearchBitcoins(c.Request.Context(), prefix)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, errorJSON(codeFor(err), "Failed to search bitcoins"))
+			return
+		}
+
+		visible := bitcoins[:0]
+		for _, b := range bitcoins {
+			if apiKeyAllows(c, b.Symbol) {
+				visible = append(visible, b)
+			}
+		}
+		c.JSON(http.StatusOK, visible)
+	})
+
+	// Resumable full export in symbol order at a fixed point in time
+	router.GET("/api/bitcoins/export", func(c *gin.Context) {
+		pageSize, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultExportPageSize)))
+		if err != nil || pageSize < 1 || pageSize > maxExportPageSize {
+			c.JSON(http.StatusBadRequest, errorJSON(CodeValidationFailed, fmt.Sprintf("limit must be between 1 and %d", maxExportPageSize)))
+			return
+		}
+
+		page, err := cacheService.ExportBitcoins(c.Request.Context(), c.Query("cursor"), pageSize)
+		if errors.Is(err, ErrInvalidCursor) {
+			c.JSON(http.StatusBadRequest, errorJSON(CodeValidationFailed, err.Error()))
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, errorJSON(codeFor(err), "Failed to export bitcoins"))
+			return
+		}
+
+		// Filter after paging so the cursor still advances past hidden symbols
+		visible := page.Items[:0]
+		for _, b := range page.Items {
+			if apiKeyAllows(c, b.Symbol) {
+				visible = append(visible, b)
+			}
+		}
+		page.Items = visible
+		c.JSON(http.StatusOK, page)
+	})
+
+	// Per-symbol routes without a symbol
+	router.GET("/api/bitcoins/", missingSymbol)
+	router.PUT("/api/bitcoins/", missingSymbol)
+	router.PATCH("/api/bitcoins/", missingSymbol)
+	router.DELETE("/api/bitcoins/", missingSymbol)
+
+	// Get single bitcoin by symbol
+	router.GET("/api/bitcoins/:symbol", func(c *gin.Context) {
+		symbol, ok := symbolParam(c)
+		if !ok {
+			return
+		}
+		consistency, err := parseConsistency(c.Query("consistency"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, errorJSON(CodeValidationFailed, err.Error()))
+			return
+		}
+		// Tombstoned records are only shown to admins
+		includeDeleted := c.Query("include_deleted") == "true"
+		if includeDeleted && !checkAdminToken(c, adminToken) {
+			return
+		}
+		if cacheService.primeMode == primeModePassThrough && cacheService.isPriming() && consistency == consistencyEventual {
+			c.Header("X-Cache-Priming", "true")
+		}
+		var bitcoin *BitcoinDetail
+		if cacheOnly(c) && consistency == consistencyStrong {
+			// A shed request can't make the DB read a strong read promises
+			err = ErrCacheOnlyMiss
+		} else if cacheOnly(c) {
+			// Enrichment needs the database, so shed reads return the bare record
+			c.Header("X-Cache-Only", "true")
+			var cached *Bitcoin
+			if cached, err = cacheService.GetBitcoinCacheOnly(symbol); cached != nil {
+				bitcoin = &BitcoinDetail{Bitcoin: *cached}
+			}
+		} else {
+			bitcoin, err = cacheService.GetBitcoinDetail(c.Request.Context(), symbol, consistency)
+		}
+		if errors.Is(err, ErrCacheOnlyMiss) {
+			c.Header("Retry-After", "1")
+			c.JSON(http.StatusServiceUnavailable, errorJSON(CodeOverloaded, "Service overloaded, retry shortly"))
+			return
+		}
+		if errors.Is(err, ErrLoadTimeout) {
+			c.Header("Retry-After", "1")
+			c.JSON(http.StatusServiceUnavailable, errorJSON(CodeOverloaded, "Timed out waiting for the database, retry shortly"))
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, errorJSON(codeFor(err), "Failed to fetch bitcoin"))
+			return
+		}
+		if bitcoin == nil && includeDeleted {
+			deleted, err := cacheService.GetDeletedBitcoin(c.Request.Context(), symbol)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, errorJSON(codeFor(err), "Failed to fetch bitcoin"))
+				return
+			}
+			if deleted != nil {
+				c.JSON(http.StatusOK, deleted)
+				return
+			}
+		}
+		if bitcoin == nil {
+			c.JSON(http.StatusNotFound, errorJSON(CodeSymbolNotFound, "Bitcoin not found"))
+			return
+		}
+		c.JSON(http.StatusOK, bitcoin)
+	})
+
+	// Get several bitcoins in one request, as a map of symbol to record
+	router.POST("/api/bitcoins/batch", func(c *gin.Context) {
+		symbols, ok := batchSymbols(c)
+		if !ok {
+			return
+		}
+		for _, symbol := range symbols {
+			if !apiKeyAllows(c, symbol) {
+				c.JSON(http.StatusForbidden, errorJSON(CodeForbidden, "API key is not allowed to access "+symbol))
+				return
+			}
+		}
+
+		getBatch := cacheService.GetBitcoinsBatch
+		if cacheOnly(c) {
+			c.Header("X-Cache-Only", "true")
+			getBatch = cacheService.GetBitcoinsBatchCacheOnly
+		}
+		bitcoins, err := getBatch(c.Request.Context(), symbols)
+		if errors.Is(err, ErrCacheOnlyMiss) {
+			c.Header("Retry-After", "1")
+			c.JSON(http.StatusServiceUnavailable, errorJSON(CodeOverloaded, "Service overloaded, retry shortly"))
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, errorJSON(codeFor(err), "Failed to fetch bitcoins"))
+			return
+		}
+		c.JSON(http.StatusOK, bitcoins)
+	})
+
+	// Create or update bitcoin
+	router.POST("/api/bitcoins", func(c *gin.Context) {
+		var req struct {
+			Symbol     string           `json:"symbol" binding:"required"`
+			Price      *decimal.Decimal `json:"price" binding:"required"`
+			Supply     *float64         `json:"supply" binding:"omitempty,gte=0"`
+			TTLSeconds int              `json:"ttl_seconds" binding:"omitempty,gt=0"` // Cache TTL for this write only
+		}
+
+		if !bindJSON(c, &req, "Symbol and price are required; supply and ttl_seconds must be positive") {
+			return
+		}
+		symbol, ok := bodySymbol(c, req.Symbol)
+		if !ok {
+			return
+		}
+
+		if !apiKeyAllows(c, symbol) {
+			c.JSON(http.StatusForbidden, errorJSON(CodeForbidden, "API key is not allowed to access "+symbol))
+			return
+		}
+
+		bitcoin, cacheConsistent, err := cacheService.SetBitcoin(c.Request.Context(), symbol, *req.Price, req.Supply, time.Duration(req.TTLSeconds)*time.Second)
+		if writeThrottled(c, err) {
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, errorJSON(codeFor(err), "Failed to create/update bitcoin"))
+			return
+		}
+		setCacheConsistent(c, cacheConsistent)
+
+		c.JSON(http.StatusCreated, bitcoin)
+	})
+
+	// Create or update many bitcoins in one statement
+	router.POST("/api/bitcoins/bulk", func(c *gin.Context) {
+		// The whole batch is validated up front and written in one statement, so an
+		// invalid entry means nothing is written
+		var items []BitcoinInput
+		if !bindJSONList(c, &items, "Body must be a list of entries with symbol and price; supply must be non-negative") {
+			return
+		}
+		for i := range items {
+			symbol, err := normalizeSymbol(items[i].Symbol)
+			if err != nil {
+				invalidEntry(c, http.StatusBadRequest, CodeValidationFailed, i, fmt.Sprintf("Invalid symbol %q: must be 1-10 letters or digits", items[i].Symbol))
+				return
+			}
+			if !apiKeyAllows(c, symbol) {
+				invalidEntry(c, http.StatusForbidden, CodeForbidden, i, "API key is not allowed to access "+symbol)
+				return
+			}
+			items[i].Symbol = symbol
+		}
+
+		// Very large batches are applied in the background, chunk by chunk
+		if len(items) > cacheService.bulkAsyncThreshold {
+			job, err := cacheService.StartBulkJob(bgCtx, items)
+			var dupErr *DuplicateSymbolsError
+			if errors.As(err, &dupErr) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Duplicate symbols in batch", "code": CodeValidationFailed, "duplicates": dupErr.Symbols})
+				return
+			}
+			if err != nil {
+				slog.ErrorContext(c.Request.Context(), "starting bulk job failed", "error", err)
+				c.JSON(http.StatusInternalServerError, errorJSON(codeFor(err), "Failed to start bulk job"))
+				return
+			}
+			c.Header("Location", "/api/jobs/"+job.ID)
+			c.JSON(http.StatusAccepted, job)
+			return
+		}
+
+		bitcoins, err := cacheService.SetBitcoinsBatch(c.Request.Context(), items)
+		var dupErr *DuplicateSymbolsError
+		if errors.As(err, &dupErr) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Duplicate symbols in batch", "code": CodeValidationFailed, "duplicates": dupErr.Symbols})
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "writing batch failed", "error", err)
+			c.JSON(http.StatusInternalServerError, errorJSON(codeFor(err), "Failed to create/update bitcoins"))
+			return
+		}
+
+		c.JSON(http.StatusCreated, bitcoins)
+	})
+
+	// Progress of a background bulk write
+	router.GET("/api/jobs/:id", func(c *gin.Context) {
+		job, err := cacheService.GetBulkJob(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, errorJSON(codeFor(err), "Failed to fetch job"))
+			return
+		}
+		if job == nil {
+			c.JSON(http.StatusNotFound, errorJSON(CodeNotFound, "Job not found"))
+			return
+		}
+		c.JSON(http.StatusOK, job)
+	})
+
+	// Update bitcoin
+	router.PUT("/api/bitcoins/:symbol", func(c *gin.Context) {
+		symbol, ok := symbolParam(c)
+		if !ok {
+			return
+		}
+		var req struct {
+			Price      *decimal.Decimal `json:"price" binding:"required"`
+			Supply     *float64         `json:"supply" binding:"omitempty,gte=0"`
+			TTLSeconds int              `json:"ttl_seconds" binding:"omitempty,gt=0"`
+		}
+
+		if !bindJSON(c, &req, "Price is required; supply and ttl_seconds must be positive") {
+			return
+		}
+
+		bitcoin, cacheConsistent, err := cacheService.SetBitcoin(c.Request.Context(), symbol, *req.Price, req.Supply, time.Duration(req.TTLSeconds)*time.Second)
+		if writeThrottled(c, err) {
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, errorJSON(codeFor(err), "Failed to update bitcoin"))
+			return
+		}
+		setCacheConsistent(c, cacheConsistent)
+
+		c.JSON(http.StatusOK, bitcoin)
+	})
+
+	// Adjust a bitcoin's price by an amount or a percentage
+	router.PATCH("/api/bitcoins/:symbol", func(c *gin.Context) {
+		symbol, ok := symbolParam(c)
+		if !ok {
+			return
+		}
+		var req struct {
+			Delta      *decimal.Decimal `json:"delta"`
+			Percent    *decimal.Decimal `json:"percent"`
+			TTLSeconds int              `json:"ttl_seconds" binding:"omitempty,gt=0"`
+		}
+
+		const usage = "Exactly one of delta or percent is required; ttl_seconds must be positive"
+		if !bindJSON(c, &req, usage) {
+			return
+		}
+		if (req.Delta == nil) == (req.Percent == nil) {
+			c.JSON(http.StatusBadRequest, errorJSON(CodeValidationFailed, usage))
+			return
+		}
+		var delta, percent decimal.Decimal
+		if req.Delta != nil {
+			delta = *req.Delta
+		} else {
+			percent = *req.Percent
+		}
+
+		bitcoin, cacheConsistent, err := cacheService.AdjustBitcoin(c.Request.Context(), symbol, delta, percent, time.Duration(req.TTLSeconds)*time.Second)
+		if writeThrottled(c, err) {
+			return
 		}
-		c.JSON(http.StatusOK, bitcoins)
+		if errors.Is(err, ErrNegativePrice) || errors.Is(err, ErrUnpriced) {
+			c.JSON(http.StatusUnprocessableEntity, errorJSON(codeFor(err), err.Error()))
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, errorJSON(codeFor(err), "Failed to adjust bitcoin"))
+			return
+		}
+		if bitcoin == nil {
+			c.JSON(http.StatusNotFound, errorJSON(CodeSymbolNotFound, "Bitcoin not found"))
+			return
+		}
+		setCacheConsistent(c, cacheConsistent)
+
+		c.JSON(http.StatusOK, bitcoin)
 	})
 
-	// Get single bitcoin by symbol
-	router.GET("/api/bitcoins/:symbol", func(c *gin.Context) {
-		symbol := c.Param("symbol")
-		bitcoin, err := cacheService.GetBitcoin(symbol)
+	// Delete bitcoin: soft-deleted unless hard=true, which needs the admin token
+	router.DELETE("/api/bitcoins/:symbol", func(c *gin.Context) {
+		symbol, ok := symbolParam(c)
+		if !ok {
+			return
+		}
+		hard := c.Query("hard") == "true"
+		purgeHistory := c.Query("purge_history") == "true"
+		if purgeHistory && !hard {
+			c.JSON(http.StatusBadRequest, errorJSON(CodeValidationFailed, "purge_history requires hard=true"))
+			return
+		}
+		if hard && !checkAdminToken(c, adminToken) {
+			return
+		}
+		bitcoin, cacheConsistent, err := cacheService.DeleteBitcoin(c.Request.Context(), symbol, hard, purgeHistory)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, errorJSON(codeFor(err), "Failed to delete bitcoin"))
+			return
+		}
+		if bitcoin == nil {
+			c.JSON(http.StatusNotFound, errorJSON(CodeSymbolNotFound, "Bitcoin not found"))
+			return
+		}
+		setCacheConsistent(c, cacheConsistent)
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Bitcoin deleted successfully",
+			"bitcoin": bitcoin,
+		})
+	})
+
+	// Undo a soft delete
+	router.POST("/api/bitcoins/:symbol/restore", func(c *gin.Context) {
+		symbol, ok := symbolParam(c)
+		if !ok {
+			return
+		}
+		bitcoin, cacheConsistent, err := cacheService.RestoreBitcoin(c.Request.Context(), symbol)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch bitcoin"})
+			c.JSON(http.StatusInternalServerError, errorJSON(codeFor(err), "Failed to restore bitcoin"))
 			return
 		}
 		if bitcoin == nil {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Bitcoin not found"})
+			c.JSON(http.StatusNotFound, errorJSON(CodeSymbolNotFound, "No deleted bitcoin with this symbol"))
 			return
 		}
+		setCacheConsistent(c, cacheConsistent)
+
 		c.JSON(http.StatusOK, bitcoin)
 	})
 
-	// Create or update bitcoin
-	router.POST("/api/bitcoins", func(c *gin.Context) {
-		var req struct {
-			Symbol string `json:"symbol" binding:"required"`
-			Price  int    `json:"price" binding:"required"`
+	// Get the price of a bitcoin at a point in time
+	router.GET("/api/bitcoins/:symbol/at", func(c *gin.Context) {
+		symbol, ok := symbolParam(c)
+		if !ok {
+			return
+		}
+		loc, err := parseTimeZone(c.Query("tz"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, errorJSON(CodeValidationFailed, err.Error()))
+			return
+		}
+		t, err := parseTimestamp(c.Query("time"), loc)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, errorJSON(CodeValidationFailed, "time must be an RFC3339 timestamp (e.g. 2024-01-01T00:00:00Z)"))
+			return
 		}
 
-		if err := c.ShouldBindJSON(&req); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Symbol and price are required"})
+		point, err := cacheService.GetPriceAt(c.Request.Context(), symbol, t)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, errorJSON(codeFor(err), "Failed to fetch price history"))
 			return
 		}
+		if point == nil {
+			c.JSON(http.StatusNotFound, errorJSON(CodeNotFound, "No price history at or before that time"))
+			return
+		}
+		point.RecordedAt = point.RecordedAt.In(loc)
+		c.JSON(http.StatusOK, point)
+	})
 
-		bitcoin, err := cacheService.SetBitcoin(req.Symbol, req.Price)
+	// Price history of a bitcoin over a time window
+	router.GET("/api/bitcoins/:symbol/history", func(c *gin.Context) {
+		symbol, ok := symbolParam(c)
+		if !ok {
+			return
+		}
+		loc, err := parseTimeZone(c.Query("tz"))
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create/update bitcoin"})
+			c.JSON(http.StatusBadRequest, errorJSON(CodeValidationFailed, err.Error()))
+			return
+		}
+		to := time.Now().UTC()
+		if raw := c.Query("to"); raw != "" {
+			if to, err = parseTimestamp(raw, loc); err != nil {
+				c.JSON(http.StatusBadRequest, errorJSON(CodeValidationFailed, "to must be an RFC3339 timestamp (e.g. 2024-01-01T00:00:00Z)"))
+				return
+			}
+		}
+		from := to.Add(-defaultHistoryWindow)
+		if raw := c.Query("from"); raw != "" {
+			if from, err = parseTimestamp(raw, loc); err != nil {
+				c.JSON(http.StatusBadRequest, errorJSON(CodeValidationFailed, "from must be an RFC3339 timestamp (e.g. 2024-01-01T00:00:00Z)"))
+				return
+			}
+		}
+		if from.After(to) {
+			c.JSON(http.StatusBadRequest, errorJSON(CodeValidationFailed, "from must not be after to"))
 			return
 		}
 
-		c.JSON(http.StatusCreated, bitcoin)
+		points, truncated, err := cacheService.GetPriceHistory(c.Request.Context(), symbol, from, to)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, errorJSON(codeFor(err), "Failed to fetch price history"))
+			return
+		}
+		for i := range points {
+			points[i].RecordedAt = points[i].RecordedAt.In(loc)
+		}
+		if truncated {
+			c.Header("X-Truncated", "true")
+		}
+		c.JSON(http.StatusOK, points)
 	})
 
-	// Update bitcoin
-	router.PUT("/api/bitcoins/:symbol", func(c *gin.Context) {
-		symbol := c.Param("symbol")
+	// Symbols whose daily returns correlate with this one's
+	router.GET("/api/bitcoins/:symbol/correlated", func(c *gin.Context) {
+		symbol, ok := symbolParam(c)
+		if !ok {
+			return
+		}
+		days, err := parseWindowDays(c.DefaultQuery("window", fmt.Sprintf("%dd", defaultCorrelationDays)))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, errorJSON(CodeValidationFailed, err.Error()))
+			return
+		}
+		limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultCorrelatedLimit)))
+		if err != nil || limit < 1 || limit > maxCorrelatedLimit {
+			c.JSON(http.StatusBadRequest, errorJSON(CodeValidationFailed, fmt.Sprintf("limit must be between 1 and %d", maxCorrelatedLimit)))
+			return
+		}
+		loc, err := parseTimeZone(c.Query("tz"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, errorJSON(CodeValidationFailed, err.Error()))
+			return
+		}
+
+		bitcoin, err := cacheService.GetBitcoin(c.Request.Context(), symbol, consistencyEventual)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, errorJSON(codeFor(err), "Failed to fetch bitcoin"))
+			return
+		}
+		if bitcoin == nil {
+			c.JSON(http.StatusNotFound, errorJSON(CodeSymbolNotFound, "Bitcoin not found"))
+			return
+		}
+
+		// Fetch the full list so a scoped API key still gets up to limit allowed symbols
+		correlated, err := cacheService.GetCorrelatedSymbols(c.Request.Context(), symbol, days, maxCorrelatedLimit, loc)
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "computing correlations failed", "symbol", symbol, "error", err)
+			c.JSON(http.StatusInternalServerError, errorJSON(codeFor(err), "Failed to compute correlations"))
+			return
+		}
+
+		visible := correlated[:0]
+		for _, other := range correlated {
+			if apiKeyAllows(c, other.Symbol) {
+				visible = append(visible, other)
+			}
+		}
+		correlated = visible[:min(limit, len(visible))]
+
+		c.JSON(http.StatusOK, gin.H{
+			"symbol":     symbol,
+			"window":     fmt.Sprintf("%dd", days),
+			"tz":         loc.String(),
+			"method":     correlationMethodPearson,
+			"correlated": correlated,
+		})
+	})
+
+	// Assign tags to a symbol
+	router.POST("/api/bitcoins/:symbol/tags", func(c *gin.Context) {
+		symbol, ok := symbolParam(c)
+		if !ok {
+			return
+		}
+
 		var req struct {
-			Price int `json:"price" binding:"required"`
+			Tags []string `json:"tags" binding:"required"`
+		}
+		if !bindJSON(c, &req, "At least one tag is required") {
+			return
+		}
+		if len(req.Tags) == 0 {
+			c.JSON(http.StatusBadRequest, errorJSON(CodeValidationFailed, "At least one tag is required"))
+			return
+		}
+
+		tags := make([]string, 0, len(req.Tags))
+		for _, raw := range req.Tags {
+			tag, err := normalizeTag(raw)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, errorJSON(CodeValidationFailed, err.Error()))
+				return
+			}
+			tags = append(tags, tag)
+		}
+
+		all, err := cacheService.AddSymbolTags(c.Request.Context(), symbol, tags)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, errorJSON(codeFor(err), "Failed to assign tags"))
+			return
+		}
+		if all == nil {
+			c.JSON(http.StatusNotFound, errorJSON(CodeSymbolNotFound, "Bitcoin not found"))
+			return
 		}
 
-		if err := c.ShouldBindJSON(&req); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Price is required"})
+		c.JSON(http.StatusOK, gin.H{"symbol": symbol, "tags": all})
+	})
+
+	// Value a portfolio of holdings at current prices
+	router.POST("/api/portfolio/value", func(c *gin.Context) {
+		var req struct {
+			Holdings []struct {
+				Symbol string  `json:"symbol" binding:"required"`
+				Amount float64 `json:"amount" binding:"gte=0"`
+			} `json:"holdings" binding:"required,dive"`
+		}
+		if !bindJSON(c, &req, "At least one holding with a symbol and non-negative amount is required") {
 			return
 		}
+		if len(req.Holdings) == 0 {
+			c.JSON(http.StatusBadRequest, errorJSON(CodeValidationFailed, "At least one holding with a symbol and non-negative amount is required"))
+			return
+		}
+
+		// Repeated symbols are summed into one holding
+		holdings := make(map[string]float64, len(req.Holdings))
+		for _, h := range req.Holdings {
+			symbol, ok := bodySymbol(c, h.Symbol)
+			if !ok {
+				return
+			}
+			if !apiKeyAllows(c, symbol) {
+				c.JSON(http.StatusForbidden, errorJSON(CodeForbidden, "API key is not allowed to access "+symbol))
+				return
+			}
+			holdings[symbol] += h.Amount
+		}
 
-		bitcoin, err := cacheService.SetBitcoin(symbol, req.Price)
+		if cacheOnly(c) {
+			c.Header("X-Cache-Only", "true")
+		}
+		valuation, err := cacheService.ValuePortfolio(c.Request.Context(), holdings, cacheOnly(c))
+		if errors.Is(err, ErrCacheOnlyMiss) {
+			c.Header("Retry-After", "1")
+			c.JSON(http.StatusServiceUnavailable, errorJSON(CodeOverloaded, "Service overloaded, retry shortly"))
+			return
+		}
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update bitcoin"})
+			c.JSON(http.StatusInternalServerError, errorJSON(codeFor(err), "Failed to value portfolio"))
 			return
 		}
 
-		c.JSON(http.StatusOK, bitcoin)
+		c.JSON(http.StatusOK, valuation)
 	})
 
-	// Delete bitcoin
-	router.DELETE("/api/bitcoins/:symbol", func(c *gin.Context) {
-		symbol := c.Param("symbol")
-		bitcoin, err := cacheService.DeleteBitcoin(symbol)
+	// Get symbol metadata
+	router.GET("/api/bitcoins/:symbol/metadata", func(c *gin.Context) {
+		symbol, ok := symbolParam(c)
+		if !ok {
+			return
+		}
+
+		metadata, err := cacheService.GetSymbolMetadata(c.Request.Context(), symbol)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete bitcoin"})
+			c.JSON(http.StatusInternalServerError, errorJSON(codeFor(err), "Failed to fetch metadata"))
 			return
 		}
-		if bitcoin == nil {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Bitcoin not found"})
+		if metadata == nil {
+			c.JSON(http.StatusNotFound, errorJSON(CodeNotFound, "Metadata not found"))
 			return
 		}
+		c.JSON(http.StatusOK, metadata)
+	})
 
-		c.JSON(http.StatusOK, gin.H{
-			"message": "Bitcoin deleted successfully",
-			"bitcoin": bitcoin,
+	// Create or replace symbol metadata
+	router.PUT("/api/bitcoins/:symbol/metadata", func(c *gin.Context) {
+		symbol, ok := symbolParam(c)
+		if !ok {
+			return
+		}
+
+		var req struct {
+			Name        string `json:"name"`
+			Description string `json:"description"`
+			Notes       string `json:"notes"`
+			// Omitted or null falls back to PRICE_PRECISION
+			PricePrecision *int `json:"price_precision" binding:"omitempty,min=0,max=18"`
+		}
+
+		if !bindJSON(c, &req, "Invalid metadata (price_precision must be 0-18)") {
+			return
+		}
+
+		metadata, err := cacheService.SetSymbolMetadata(c.Request.Context(), SymbolMetadata{
+			Symbol:         symbol,
+			Name:           req.Name,
+			Description:    req.Description,
+			Notes:          req.Notes,
+			PricePrecision: req.PricePrecision,
 		})
+		if errors.Is(err, ErrEncryptionDisabled) {
+			c.JSON(http.StatusServiceUnavailable, errorJSON(CodeEncryptionDisabled, "Notes require encryption, which is not configured"))
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, errorJSON(codeFor(err), "Failed to update metadata"))
+			return
+		}
+		if metadata == nil {
+			c.JSON(http.StatusNotFound, errorJSON(CodeSymbolNotFound, "Bitcoin not found"))
+			return
+		}
+
+		c.JSON(http.StatusOK, metadata)
+	})
+
+	// Provision a partner API key
+	router.POST("/api/admin/keys", adminAuth, func(c *gin.Context) {
+		var req struct {
+			Name           string   `json:"name" binding:"required"`
+			AllowedSymbols []string `json:"allowed_symbols"`
+			QuotaPerMinute int      `json:"quota_per_minute" binding:"required,gt=0"`
+		}
+		if !bindJSON(c, &req, "name and a positive quota_per_minute are required") {
+			return
+		}
+		if req.AllowedSymbols == nil {
+			req.AllowedSymbols = []string{}
+		}
+
+		key, plaintext, err := cacheService.CreateAPIKey(c.Request.Context(), req.Name, req.AllowedSymbols, req.QuotaPerMinute)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, errorJSON(codeFor(err), "Failed to create API key"))
+			return
+		}
+
+		// The plaintext key is only ever shown in this response
+		c.JSON(http.StatusCreated, gin.H{"key": plaintext, "api_key": key})
+	})
+
+	// Cached vs DB value for one symbol, without backfilling the cache
+	router.GET("/api/admin/debug/", adminAuth, missingSymbol)
+	router.GET("/api/admin/debug/:symbol", adminAuth, func(c *gin.Context) {
+		symbol, ok := symbolParam(c)
+		if !ok {
+			return
+		}
+		debug, err := cacheService.DebugSymbol(symbol)
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "debugging symbol failed", "symbol", symbol, "error", err)
+			c.JSON(http.StatusInternalServerError, errorJSON(codeFor(err), "Failed to read cache and database"))
+			return
+		}
+		c.JSON(http.StatusOK, debug)
+	})
+
+	// Re-apply price updates missed during an outage, skipping any older than the stored row
+	router.POST("/api/admin/replay", adminAuth, func(c *gin.Context) {
+		var req struct {
+			Updates []ReplayRecord `json:"updates" binding:"required,min=1,dive"`
+		}
+		if !bindJSON(c, &req, "updates must be a non-empty list of symbol, price and timestamp") {
+			return
+		}
+		for i := range req.Updates {
+			symbol, ok := bodySymbol(c, req.Updates[i].Symbol)
+			if !ok {
+				return
+			}
+			req.Updates[i].Symbol = symbol
+		}
+
+		result, err := cacheService.ReplayPrices(c.Request.Context(), req.Updates)
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "replaying prices failed", "error", err)
+			c.JSON(http.StatusInternalServerError, errorJSON(codeFor(err), "Failed to replay prices"))
+			return
+		}
+		c.JSON(http.StatusOK, result)
+	})
+
+	// Record TTL for a tag or a list of symbols
+	router.PUT("/api/admin/cache-policy", adminAuth, func(c *gin.Context) {
+		var req struct {
+			Name       string   `json:"name" binding:"required,max=64"`
+			Tag        string   `json:"tag"`
+			Symbols    []string `json:"symbols"`
+			TTLSeconds int      `json:"ttl_seconds" binding:"required,gt=0"`
+			Backfill   bool     `json:"backfill"`
+		}
+		if !bindJSON(c, &req, "name and a positive ttl_seconds are required") {
+			return
+		}
+		if (req.Tag == "") == (req.Symbols == nil) {
+			c.JSON(http.StatusBadRequest, errorJSON(CodeValidationFailed, "Exactly one of tag or symbols is required"))
+			return
+		}
+
+		policy := CachePolicy{Name: strings.TrimSpace(req.Name), TTLSeconds: req.TTLSeconds, Backfill: req.Backfill}
+		if req.Tag != "" {
+			tag, err := normalizeTag(req.Tag)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, errorJSON(CodeValidationFailed, err.Error()))
+				return
+			}
+			policy.Tag = &tag
+		} else {
+			if len(req.Symbols) == 0 {
+				c.JSON(http.StatusBadRequest, errorJSON(CodeValidationFailed, "symbols must not be empty"))
+				return
+			}
+			policy.Symbols = parsePreloadSymbols(strings.Join(req.Symbols, ","))
+		}
+
+		saved, backfilled, err := cacheService.PutCachePolicy(c.Request.Context(), policy)
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "saving cache policy failed", "policy", policy.Name, "error", err)
+			c.JSON(http.StatusInternalServerError, errorJSON(codeFor(err), "Failed to save cache policy"))
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"policy": saved, "backfilled": backfilled})
+	})
+
+	// Active cache policies
+	router.GET("/api/admin/cache-policy", adminAuth, func(c *gin.Context) {
+		policies, err := cacheService.ListCachePolicies(c.Request.Context())
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, errorJSON(codeFor(err), "Failed to list cache policies"))
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"policies": policies})
+	})
+
+	router.DELETE("/api/admin/cache-policy/:name", adminAuth, func(c *gin.Context) {
+		found, err := cacheService.DeleteCachePolicy(c.Request.Context(), c.Param("name"))
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "deleting cache policy failed", "policy", c.Param("name"), "error", err)
+			c.JSON(http.StatusInternalServerError, errorJSON(codeFor(err), "Failed to delete cache policy"))
+			return
+		}
+		if !found {
+			c.JSON(http.StatusNotFound, errorJSON(CodeNotFound, "Cache policy not found"))
+			return
+		}
+		c.Status(http.StatusNoContent)
+	})
+
+	// Repopulate the rankings sorted set from Postgres
+	router.POST("/api/admin/rankings/rebuild", adminAuth, func(c *gin.Context) {
+		result, err := cacheService.RebuildRankingsSortedSet(c.Request.Context())
+		if errors.Is(err, ErrRebuildInProgress) {
+			c.JSON(http.StatusConflict, errorJSON(CodeConflict, "Rankings rebuild already in progress"))
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "rebuilding rankings sorted set failed", "error", err)
+			c.JSON(http.StatusInternalServerError, errorJSON(codeFor(err), "Failed to rebuild rankings"))
+			return
+		}
+		c.JSON(http.StatusOK, result)
+	})
+
+	// Known distributed locks and who holds them
+	router.GET("/api/admin/locks", adminAuth, func(c *gin.Context) {
+		locks, err := cacheService.ListLocks(c.Request.Context())
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "reading locks failed", "error", err)
+			c.JSON(http.StatusInternalServerError, errorJSON(codeFor(err), "Failed to read locks"))
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"instance_id": cacheService.instanceID, "locks": locks})
+	})
+
+	// Recompute stored ranks for the whole table
+	router.POST("/api/admin/recompute-ranks", adminAuth, func(c *gin.Context) {
+		result, err := cacheService.RecomputeRanks(c.Request.Context())
+		if errors.Is(err, ErrRecomputeInProgress) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Rank recomputation already in progress", "code": CodeConflict, "lock_acquired": false})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, errorJSON(codeFor(err), "Failed to recompute ranks"))
+			return
+		}
+		c.JSON(http.StatusOK, result)
 	})
 
 	// Cache stats endpoint
 	router.GET("/api/cache/stats", func(c *gin.Context) {
-		info := redisClient.Info(ctx, "stats").Val()
-		c.JSON(http.StatusOK, gin.H{"info": info})
+		stats, err := cacheService.GetCacheStats(c.Request.Context())
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "reading cache stats failed", "error", err)
+			c.JSON(http.StatusInternalServerError, errorJSON(codeFor(err), "Failed to get cache stats"))
+			return
+		}
+		c.JSON(http.StatusOK, stats)
+	})
+
+	// Per-tag aggregates: count, total and average price
+	router.GET("/api/stats/by-tag", func(c *gin.Context) {
+		stats, err := cacheService.GetTagStats(c.Request.Context())
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, errorJSON(codeFor(err), "Failed to fetch tag stats"))
+			return
+		}
+		c.JSON(http.StatusOK, stats)
+	})
+
+	// Price distribution over equal-width buckets
+	router.GET("/api/stats/histogram", func(c *gin.Context) {
+		buckets := defaultHistogramBuckets
+		if raw := c.Query("buckets"); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil || n < 1 || n > maxHistogramBuckets {
+				c.JSON(http.StatusBadRequest, errorJSON(CodeValidationFailed, fmt.Sprintf("buckets must be an integer between 1 and %d", maxHistogramBuckets)))
+				return
+			}
+			buckets = n
+		}
+
+		histogram, err := cacheService.PriceHistogram(c.Request.Context(), buckets)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, errorJSON(codeFor(err), "Failed to compute price histogram"))
+			return
+		}
+		c.JSON(http.StatusOK, histogram)
 	})
 
 	// Start server
@@ -457,27 +2643,51 @@ func main() {
 	// Graceful shutdown
 	go func() {
 		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("Failed to start server: %v", err)
+			fatalf("Failed to start server: %v", err)
 		}
 	}()
 
-	log.Printf("Server running on port %s", port)
+	slog.Info("server running", "port", port, "version", version, "commit", commit, "built", buildTime)
 
 	// Wait for interrupt signal
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 
-	log.Println("Shutting down server...")
+	// Fail readiness first and keep serving while the load balancer notices, so
+	// clients don't hit a closed port mid-deploy. A second signal skips the wait.
+	shuttingDown.Store(true)
+	slog.Info("shutdown phase 1/3: readiness failing, still serving", "delay", preShutdownDelay.String())
+	select {
+	case <-time.After(preShutdownDelay):
+	case <-quit:
+		slog.Info("second signal received, skipping the pre-shutdown delay")
+	}
+
+	slog.Info("shutdown phase 2/3: closing listener and draining requests and streams")
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), streamDrainGrace+5*time.Second)
 	defer cancel()
 
-	if err := srv.Shutdown(ctx); err != nil {
-		log.Fatal("Server forced to shutdown:", err)
+	// Shutdown stops accepting connections right away but waits on open streams,
+	// so drain them alongside it
+	shutdownErr := make(chan error, 1)
+	go func() { shutdownErr <- srv.Shutdown(ctx) }()
+	streams.drain(streamDrainGrace)
+
+	if err := <-shutdownErr; err != nil {
+		fatalf("Server forced to shutdown: %v", err)
 	}
 
-	log.Println("Server exited")
+	// Let the ingest consumer finish (and commit) the message it's applying, and
+	// bulk jobs finish their in-flight chunks and record where they stopped
+	slog.Info("shutdown phase 3/3: stopping background work")
+	stopBackground()
+	<-ingestDone
+	cacheService.bulkJobs.Wait()
+	cacheService.flushInvalidation()
+
+	slog.Info("server exited")
 }
 
 func getEnv(key, defaultValue string) string {
@@ -486,3 +2696,45 @@ func getEnv(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+func randomHex(nBytes int) (string, error) {
+	buf := make([]byte, nBytes)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+func getEnvInt(key string, defaultValue int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		slog.Warn("invalid setting, using default", "key", key, "value", value, "default", defaultValue)
+		return defaultValue
+	}
+	return n
+}
+
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		slog.Warn("invalid setting, using default", "key", key, "value", value, "default", defaultValue.String())
+		return defaultValue
+	}
+	return d
+}
+
+// getEnvDuration for a delay that may be skipped: an explicit "0" means no delay
+func getEnvDelay(key string, defaultValue time.Duration) time.Duration {
+	if os.Getenv(key) == "0" {
+		return 0
+	}
+	return getEnvDuration(key, defaultValue)
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// A NaN supply has no JSON encoding, so caching this record always fails to marshal
+func unmarshalableBitcoin() *Bitcoin {
+	nan := math.NaN()
+	return &Bitcoin{Symbol: "BTC", Supply: &nan, CreatedAt: testTime, UpdatedAt: testTime}
+}
+
+func TestWriteThroughMarshalFailureDropsStaleKey(t *testing.T) {
+	cs, fr, _ := newFakeBackedCacheService(t, func(query string, args []driver.Value) (fakeResult, error) {
+		return fakeResult{}, nil
+	})
+	key := cs.getBitcoinCacheKey("BTC")
+	fr.set(key, `{"symbol":"BTC","price":1}`)
+
+	if !cs.writeThroughCache(context.Background(), unmarshalableBitcoin(), nil, 0) {
+		t.Error("writeThroughCache reported the cache inconsistent although the stale key was dropped")
+	}
+	if fr.exists(key) {
+		t.Error("stale value still cached after the marshal failure")
+	}
+	if n := counterValue(t, cs.metrics.cacheMarshalFailures); n != 1 {
+		t.Errorf("cache marshal failures = %v, want 1", n)
+	}
+}
+
+func TestWriteThroughMarshalFailureReportsUndroppedKey(t *testing.T) {
+	cs, fr, _ := newFakeBackedCacheService(t, func(query string, args []driver.Value) (fakeResult, error) {
+		return fakeResult{}, nil
+	})
+	key := cs.getBitcoinCacheKey("BTC")
+	fr.set(key, `{"symbol":"BTC","price":1}`)
+	fr.failCommands(func(args []string) error {
+		if strings.EqualFold(args[0], "DEL") && args[1] == key {
+			return errors.New("ERR injected")
+		}
+		return nil
+	})
+
+	if cs.writeThroughCache(context.Background(), unmarshalableBitcoin(), nil, 0) {
+		t.Error("writeThroughCache reported the cache consistent with the stale key left behind")
+	}
+	if n := counterValue(t, cs.metrics.staleCacheKeys); n != 1 {
+		t.Errorf("stale cache keys = %v, want 1", n)
+	}
+}
+
+// A record loaded on a miss that can't be cached is still returned, and the next
+// read misses again rather than finding anything stale
+func TestLoadBitcoinMarshalFailureStillServes(t *testing.T) {
+	cs, fr, fdb := newFakeBackedCacheService(t, func(query string, args []driver.Value) (fakeResult, error) {
+		row := bitcoinRow("BTC", "1")
+		row[2] = math.NaN()
+		return fakeResult{columns: bitcoinColumns, rows: [][]driver.Value{row}}, nil
+	})
+	close(cs.primed)
+
+	for i := 0; i < 2; i++ {
+		bitcoin, err := cs.GetBitcoin(context.Background(), "BTC", consistencyEventual)
+		if err != nil || bitcoin == nil || bitcoin.Symbol != "BTC" {
+			t.Fatalf("read %d: %v, %v", i, bitcoin, err)
+		}
+	}
+	if fr.exists(cs.getBitcoinCacheKey("BTC")) {
+		t.Error("unmarshalable record was cached")
+	}
+	if n := fdb.count("FROM bitcoins"); n != 2 {
+		t.Errorf("%d database reads, want 2", n)
+	}
+	if n := counterValue(t, cs.metrics.cacheMarshalFailures); n != 2 {
+		t.Errorf("cache marshal failures = %v, want 2", n)
+	}
+}
+
+// A symbol listed but not yet priced reads back with a null price from the database,
+// the cache, batches and search, and stays out of the rankings
+func TestUnpricedSymbolHandledEverywhere(t *testing.T) {
+	cs, fr, _ := newFakeBackedCacheService(t, func(query string, args []driver.Value) (fakeResult, error) {
+		if strings.Contains(query, "ON CONFLICT") {
+			return fakeResult{}, nil
+		}
+		return fakeResult{columns: bitcoinColumns, rows: [][]driver.Value{bitcoinRow("NEW", "")}}, nil
+	})
+	close(cs.primed)
+	ctx := context.Background()
+	fr.zadd(rankSortedSetKey, 1, "NEW") // Left over from before the price was cleared
+
+	for _, source := range []string{"database", "cache"} {
+		bitcoin, err := cs.GetBitcoin(ctx, "NEW", consistencyEventual)
+		if err != nil || bitcoin == nil || bitcoin.Price != nil {
+			t.Fatalf("GetBitcoin from the %s = %+v, %v; want NEW with no price", source, bitcoin, err)
+		}
+	}
+	data, _ := json.Marshal(Bitcoin{Symbol: "NEW"})
+	if !strings.Contains(string(data), `"price":null`) {
+		t.Errorf("unpriced record marshals as %s, want a null price", data)
+	}
+
+	cs.redisClient.Del(ctx, cs.getBitcoinCacheKey("NEW"))
+	found, err := cs.GetBitcoinsBatch(ctx, []string{"NEW"})
+	if err != nil || found["NEW"].Symbol != "NEW" || found["NEW"].Price != nil {
+		t.Errorf("GetBitcoinsBatch = %+v, %v; want NEW with no price", found, err)
+	}
+
+	results, err := cs.SearchBitcoins(context.Background(), "NE")
+	if err != nil || len(results) != 1 || results[0].Price != nil {
+		t.Errorf("SearchBitcoins = %+v, %v; want NEW with no price", results, err)
+	}
+
+	cs.writeThroughCache(context.Background(), &Bitcoin{Symbol: "NEW", CreatedAt: testTime, UpdatedAt: testTime}, nil, 0)
+	if _, ranked := fr.zscore(rankSortedSetKey, "NEW"); ranked {
+		t.Error("unpriced symbol still in the rankings sorted set")
+	}
+	if (priceRange{min: decimalPtr("0")}).contains(nil) {
+		t.Error("a price band matched the unpriced symbol")
+	}
+}
+
+// A symbol looked up before it exists is negative-cached for NEGATIVE_CACHE_TTL
+// only, and creating it replaces the marker so it's readable straight away
+func TestCreatedSymbolReadableAfterNegativeCacheHit(t *testing.T) {
+	created := false
+	cs, fr, fdb := newFakeBackedCacheService(t, func(query string, args []driver.Value) (fakeResult, error) {
+		switch {
+		case strings.Contains(query, "ON CONFLICT"):
+			created = true
+			return fakeResult{columns: append(bitcoinColumns, "prev"), rows: [][]driver.Value{append(bitcoinRow("NEW", "5"), nil)}}, nil
+		case created:
+			return fakeResult{columns: bitcoinColumns, rows: [][]driver.Value{bitcoinRow("NEW", "5")}}, nil
+		}
+		return fakeResult{columns: bitcoinColumns}, nil
+	})
+	ctx := context.Background()
+	key := cs.getBitcoinCacheKey("NEW")
+
+	for i := 0; i < 2; i++ {
+		if bitcoin, err := cs.GetBitcoin(ctx, "NEW", consistencyEventual); err != nil || bitcoin != nil {
+			t.Fatalf("GetBitcoin before creation = %+v, %v; want not found", bitcoin, err)
+		}
+	}
+	if v, _ := fr.get(key); v != negativeCacheSentinel {
+		t.Fatalf("cached value = %q, want the not-found marker", v)
+	}
+	if ttl := cs.redisClient.PTTL(ctx, key).Val(); ttl <= 0 || ttl > cs.negativeTTL {
+		t.Errorf("not-found marker TTL = %v, want at most NEGATIVE_CACHE_TTL (%v)", ttl, cs.negativeTTL)
+	}
+	reads := fdb.count("FROM bitcoins")
+
+	if _, _, err := cs.SetBitcoin(ctx, "NEW", decimal.RequireFromString("5"), nil, 0); err != nil {
+		t.Fatalf("SetBitcoin: %v", err)
+	}
+	bitcoin, err := cs.GetBitcoin(ctx, "NEW", consistencyEventual)
+	if err != nil || bitcoin == nil || bitcoin.Price.String() != "5" {
+		t.Fatalf("GetBitcoin right after creation = %+v, %v; want NEW at 5", bitcoin, err)
+	}
+	if n := fdb.count("FROM bitcoins") - reads; n != 1 {
+		t.Errorf("%d database statements after the negative-cache hit, want only the upsert", n)
+	}
+}
+
+// PRE_SHUTDOWN_DELAY=0 skips the wait instead of being rejected as invalid
+func TestGetEnvDelayAcceptsZero(t *testing.T) {
+	for _, tc := range []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", defaultPreShutdownDelay},
+		{"0", 0},
+		{"2s", 2 * time.Second},
+		{"-1s", defaultPreShutdownDelay},
+		{"soon", defaultPreShutdownDelay},
+	} {
+		t.Setenv("PRE_SHUTDOWN_DELAY", tc.value)
+		if got := getEnvDelay("PRE_SHUTDOWN_DELAY", defaultPreShutdownDelay); got != tc.want {
+			t.Errorf("PRE_SHUTDOWN_DELAY=%q gives %v, want %v", tc.value, got, tc.want)
+		}
+	}
+}
+
+// Deleting a symbol leaves the not-found marker in its place, so reads of it are
+// answered from the cache rather than the database
+func TestDeleteCachesNotFoundMarker(t *testing.T) {
+	cs, fr, fdb := newFakeBackedCacheService(t, func(query string, args []driver.Value) (fakeResult, error) {
+		if strings.Contains(query, "SET deleted_at") {
+			return fakeResult{columns: append(bitcoinColumns, "deleted_at"), rows: [][]driver.Value{append(bitcoinRow("BTC", "1"), testTime)}}, nil
+		}
+		return fakeResult{columns: bitcoinColumns}, nil
+	})
+	close(cs.primed)
+	ctx := context.Background()
+	data, _ := cs.encodeCacheValue(Bitcoin{Symbol: "BTC", CreatedAt: testTime, UpdatedAt: testTime})
+	fr.set(cs.getBitcoinCacheKey("BTC"), string(data))
+
+	if deleted, consistent, err := cs.DeleteBitcoin(ctx, "BTC", false, false); err != nil || deleted == nil || !consistent {
+		t.Fatalf("DeleteBitcoin = %v, %v, %v", deleted, consistent, err)
+	}
+	if v, _ := fr.get(cs.getBitcoinCacheKey("BTC")); v != negativeCacheSentinel {
+		t.Errorf("BTC cached as %q after the delete, want the not-found marker", v)
+	}
+
+	queries := len(fdb.queries)
+	bitcoin, err := cs.GetBitcoin(ctx, "BTC", consistencyEventual)
+	if err != nil || bitcoin != nil {
+		t.Errorf("read after the delete = %v, %v; want not found", bitcoin, err)
+	}
+	if n := len(fdb.queries) - queries; n != 0 {
+		t.Errorf("read after the delete ran %d queries, want none", n)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+const (
+	nullSupplyExclude = "exclude" // Symbols without a supply are left out of market-cap rankings
+	nullSupplyZero    = "zero"    // ...or ranked last with a market cap of 0
+)
+
+func (cs *CacheService) getMarketCapRankingsCacheKey(version string) string {
+	return fmt.Sprintf("%s:marketcap:v%s", rankCacheKey, version)
+}
+
+// Rankings ordered by price x supply, computed in SQL. Cached separately from the
+// price rankings, keyed by the rankings version so any price or supply write invalidates it.
+func (cs *CacheService) GetBitcoinsRankedByMarketCap(ctx context.Context) ([]Bitcoin, error) {
+	version := cs.rankingsVersion()
+	return cs.getVersionedRankings(ctx, cs.getMarketCapRankingsCacheKey(version), "market cap", func() ([]Bitcoin, error) {
+		return cs.getBitcoinsRankedByMarketCapFromDB(ctx)
+	})
+}
+
+func (cs *CacheService) getBitcoinsRankedByMarketCapFromDB(ctx context.Context) ([]Bitcoin, error) {
+	filter := "WHERE price IS NOT NULL AND supply IS NOT NULL AND deleted_at IS NULL"
+	if cs.nullSupply == nullSupplyZero {
+		filter = "WHERE price IS NOT NULL AND deleted_at IS NULL"
+	}
+
+	rows, err := cs.db.QueryContext(ctx, `
+		SELECT symbol, price, supply, created_at, updated_at, market_cap,
+			ROW_NUMBER() OVER (ORDER BY market_cap DESC, symbol ASC) AS rank
+		FROM (
+			SELECT symbol, price, supply, created_at, updated_at,
+				price * COALESCE(supply, 0) AS market_cap
+			FROM bitcoins
+			`+filter+`
+		) caps
+		ORDER BY rank
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	defer rows.Close()
+
+	bitcoins := []Bitcoin{}
+	for rows.Next() {
+		var b Bitcoin
+		if err := rows.Scan(&b.Symbol, &b.Price, &b.Supply, &b.CreatedAt, &b.UpdatedAt, &b.MarketCap, &b.Rank); err != nil {
+			return nil, fmt.Errorf("scan error: %w", err)
+		}
+		bitcoins = append(bitcoins, b)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("scan error: %w", err)
+	}
+
+	return bitcoins, nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	defaultPricePrecision = 2
+	maxPricePrecision     = 18
+)
+
+// Descriptive metadata for a symbol. Notes is sensitive: it is encrypted at rest
+// in Postgres and, because it would be plaintext once decrypted, metadata is never
+// written to Redis. PricePrecision alone is cached, with the enrichment fields.
+type SymbolMetadata struct {
+	Symbol         string    `json:"symbol"`
+	Name           string    `json:"name"`
+	Description    string    `json:"description"`
+	Notes          string    `json:"notes"`
+	PricePrecision *int      `json:"price_precision"` // Display decimals; nil uses PRICE_PRECISION
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+func (cs *CacheService) GetSymbolMetadata(ctx context.Context, symbol string) (*SymbolMetadata, error) {
+	var m SymbolMetadata
+	var encryptedNotes sql.NullString
+	err := cs.db.QueryRowContext(ctx, `
+		SELECT symbol, name, description, notes, price_precision, updated_at
+		FROM symbol_metadata
+		WHERE symbol = $1
+	`, symbol).Scan(&m.Symbol, &m.Name, &m.Description, &encryptedNotes, &m.PricePrecision, &m.UpdatedAt)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+
+	if encryptedNotes.Valid {
+		m.Notes, err = cs.fieldCipher.Decrypt(encryptedNotes.String, m.Symbol)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt notes for %s: %w", symbol, err)
+		}
+	}
+
+	return &m, nil
+}
+
+// Upsert metadata for an existing symbol; returns nil if the symbol doesn't exist
+func (cs *CacheService) SetSymbolMetadata(ctx context.Context, m SymbolMetadata) (*SymbolMetadata, error) {
+	if cs.readOnly {
+		return nil, ErrReadOnly
+	}
+
+	var encryptedNotes sql.NullString
+	if m.Notes != "" {
+		ciphertext, err := cs.fieldCipher.Encrypt(m.Notes, m.Symbol)
+		if err != nil {
+			return nil, err
+		}
+		encryptedNotes = sql.NullString{String: ciphertext, Valid: true}
+	}
+
+	err := cs.db.QueryRowContext(ctx, `
+		INSERT INTO symbol_metadata (symbol, name, description, notes, price_precision)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (symbol)
+		DO UPDATE SET name = $2, description = $3, notes = $4, price_precision = $5, updated_at = CURRENT_TIMESTAMP
+		RETURNING updated_at
+	`, m.Symbol, m.Name, m.Description, encryptedNotes, m.PricePrecision).Scan(&m.UpdatedAt)
+
+	// foreign_key_violation: the symbol itself doesn't exist
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23503" {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+
+	// The cached enrichment carries the precision
+	if err := cs.redisClient.Del(cs.ctx, cs.getEnrichmentCacheKey(m.Symbol)).Err(); err != nil {
+		slog.ErrorContext(ctx, "invalidating enrichment failed", "symbol", m.Symbol, "error", err)
+	}
+
+	slog.InfoContext(ctx, "metadata updated", "symbol", m.Symbol)
+	return &m, nil
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/redis/go-redis/v9"
+)
+
+// Default latency buckets in seconds, dense around our SLO thresholds
+// (5ms, 20ms, 50ms, 100ms, 500ms) rather than Prometheus' wide defaults
+var defaultLatencyBuckets = []float64{0.001, 0.005, 0.01, 0.02, 0.05, 0.1, 0.25, 0.5, 1, 2.5}
+
+// Parse LATENCY_BUCKETS ("0.005,0.02,0.05"): positive seconds in strictly ascending order
+func parseLatencyBuckets(spec string) ([]float64, error) {
+	var buckets []float64
+	for _, part := range strings.Split(spec, ",") {
+		b, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bucket %q: %w", part, err)
+		}
+		if b <= 0 {
+			return nil, fmt.Errorf("bucket %v must be positive", b)
+		}
+		if len(buckets) > 0 && b <= buckets[len(buckets)-1] {
+			return nil, fmt.Errorf("buckets must be strictly ascending (%v after %v)", b, buckets[len(buckets)-1])
+		}
+		buckets = append(buckets, b)
+	}
+	return buckets, nil
+}
+
+// Metrics holds the Prometheus collectors served on /metrics. The registry is
+// passed in rather than using the global default so tests can inspect a fresh one.
+type Metrics struct {
+	registry *prometheus.Registry
+	panics   prometheus.Counter
+
+	dualWriteCompared  prometheus.Counter
+	dualWriteDivergent prometheus.Counter
+	dualWriteErrors    *prometheus.CounterVec
+
+	httpDuration  *prometheus.HistogramVec
+	dbDuration    *prometheus.HistogramVec
+	redisDuration *prometheus.HistogramVec
+
+	loadShedRate prometheus.Gauge
+	loadShed     *prometheus.CounterVec
+
+	pubsubReconnects      prometheus.Counter
+	invalidationsReceived prometheus.Counter
+
+	rankingsDrift       prometheus.Gauge
+	rankingsStaleServed prometheus.Counter
+
+	cacheMarshalFailures prometheus.Counter
+	staleCacheKeys       prometheus.Counter
+
+	freshnessChecks *prometheus.CounterVec
+
+	rankingsCanary *prometheus.CounterVec
+
+	stampedeTimeouts prometheus.Counter
+
+	dbRetries *prometheus.CounterVec
+
+	rateLimited *prometheus.CounterVec
+
+	pipelineFailures *prometheus.CounterVec
+
+	redisUp prometheus.Gauge
+
+	cacheHits   *prometheus.CounterVec
+	cacheMisses *prometheus.CounterVec
+	primedKeys  prometheus.Gauge
+}
+
+func NewMetrics(registry *prometheus.Registry, latencyBuckets []float64) *Metrics {
+	m := &Metrics{
+		registry: registry,
+		panics: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "panics_total",
+			Help: "Panics recovered while serving HTTP requests.",
+		}),
+		dualWriteCompared: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "dual_write_compared_total",
+			Help: "Cache keys compared between the primary and secondary Redis.",
+		}),
+		dualWriteDivergent: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "dual_write_divergent_total",
+			Help: "Compared cache keys that were missing or different on the secondary Redis.",
+		}),
+		dualWriteErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "dual_write_errors_total",
+			Help: "Secondary Redis failures by stage (write, dropped, compare).",
+		}, []string{"stage"}),
+		httpDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "http_request_duration_seconds",
+			Help:    "HTTP request latency by method, route and status.",
+			Buckets: latencyBuckets,
+		}, []string{"method", "route", "status"}),
+		dbDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "db_query_duration_seconds",
+			Help:    "PostgreSQL query latency by operation.",
+			Buckets: latencyBuckets,
+		}, []string{"operation"}),
+		redisDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "redis_command_duration_seconds",
+			Help:    "Redis command latency by command (pipelines are recorded as \"pipeline\").",
+			Buckets: latencyBuckets,
+		}, []string{"command"}),
+		loadShedRate: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "load_shed_rate",
+			Help: "Fraction of requests currently being shed (0 when not overloaded).",
+		}),
+		loadShed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "load_shed_total",
+			Help: "Requests shed under load by action (cache_only, reject_write).",
+		}, []string{"action"}),
+		pubsubReconnects: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "pubsub_reconnects_total",
+			Help: "Change-event and invalidation subscriptions re-established after dropping.",
+		}),
+		invalidationsReceived: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "cache_invalidations_received_total",
+			Help: "Invalidations received from other instances on the bitcoin:invalidate channel.",
+		}),
+		rankingsDrift: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "rankings_drift_positions",
+			Help: "Rank positions that differed between the Redis sorted set and Postgres at the last reconciliation.",
+		}),
+		rankingsStaleServed: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "rankings_stale_served_total",
+			Help: "Rankings reads served a payload past its TTL (within RANKINGS_STALE_TTL) while it was rebuilt in the background.",
+		}),
+		cacheMarshalFailures: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "cache_marshal_failures_total",
+			Help: "Cache writes abandoned because the value failed to marshal (the stale key is dropped instead).",
+		}),
+		staleCacheKeys: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "cache_stale_keys_total",
+			Help: "Records left stale in Redis after a write because neither the cache write nor the fallback delete succeeded.",
+		}),
+		freshnessChecks: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "cache_freshness_checks_total",
+			Help: "Sampled cache hits checked against the database by result (fresh, stale, error); stale entries were refreshed.",
+		}, []string{"result"}),
+		rankingsCanary: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "rankings_canary_comparisons_total",
+			Help: "Sampled rankings reads compared against the other rankings source by result (match, mismatch, skipped, error).",
+		}, []string{"result"}),
+		stampedeTimeouts: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "stampede_wait_timeouts_total",
+			Help: "Callers that gave up waiting on a shared cache-miss load after STAMPEDE_MAX_WAIT.",
+		}),
+		dbRetries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "db_retries_total",
+			Help: "Database queries retried after a transient error, by operation.",
+		}, []string{"operation"}),
+		rateLimited: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "rate_limited_requests_total",
+			Help: "Requests rejected with 429 by the per-client rate limiter, by class (read, write).",
+		}, []string{"class"}),
+		pipelineFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "cache_pipeline_command_failures_total",
+			Help: "Failed commands in batch cache-write pipelines by command; their keys are evicted so reads fall through to the database.",
+		}, []string{"command"}),
+		redisUp: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "redis_up",
+			Help: "1 while Redis is reachable and the cache is in use, 0 in DB-only mode.",
+		}),
+		cacheHits: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "cache_hits_total",
+			Help: "Cache lookups answered from Redis by operation (a cached \"not found\" counts as a hit).",
+		}, []string{"operation"}),
+		cacheMisses: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "cache_misses_total",
+			Help: "Cache lookups that fell through to the database by operation.",
+		}, []string{"operation"}),
+		primedKeys: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "cache_primed_keys",
+			Help: "Records loaded into the cache by the last priming (at startup or after a Redis outage).",
+		}),
+	}
+
+	registry.MustRegister(m.panics, m.dualWriteCompared, m.dualWriteDivergent, m.dualWriteErrors,
+		m.httpDuration, m.dbDuration, m.redisDuration, m.loadShedRate, m.loadShed, m.pubsubReconnects, m.invalidationsReceived,
+		m.rankingsDrift, m.rankingsStaleServed, m.cacheMarshalFailures, m.staleCacheKeys, m.freshnessChecks, m.rankingsCanary, m.stampedeTimeouts, m.dbRetries,
+		m.rateLimited, m.pipelineFailures, m.redisUp, m.cacheHits, m.cacheMisses, m.primedKeys)
+	return m
+}
+
+func (m *Metrics) observeDB(operation string, duration time.Duration) {
+	m.dbDuration.WithLabelValues(operation).Observe(duration.Seconds())
+}
+
+func (m *Metrics) cacheLookup(operation string, hit bool) {
+	if hit {
+		m.cacheHits.WithLabelValues(operation).Inc()
+	} else {
+		m.cacheMisses.WithLabelValues(operation).Inc()
+	}
+}
+
+// Add hits and misses for a multi-key lookup in one go
+func (m *Metrics) cacheLookups(operation string, hits, misses int) {
+	m.cacheHits.WithLabelValues(operation).Add(float64(hits))
+	m.cacheMisses.WithLabelValues(operation).Add(float64(misses))
+}
+
+// redisLatencyHook times every command issued through a go-redis client
+type redisLatencyHook struct {
+	metrics *Metrics
+}
+
+func (h redisLatencyHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (h redisLatencyHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		start := time.Now()
+		err := next(ctx, cmd)
+		h.metrics.redisDuration.WithLabelValues(cmd.Name()).Observe(time.Since(start).Seconds())
+		return err
+	}
+}
+
+func (h redisLatencyHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		start := time.Now()
+		err := next(ctx, cmds)
+		h.metrics.redisDuration.WithLabelValues("pipeline").Observe(time.Since(start).Seconds())
+		return err
+	}
+}
+
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+)
+
+func TestCacheLookupCounters(t *testing.T) {
+	cs, _, _ := newFakeBackedCacheService(t, func(query string, args []driver.Value) (fakeResult, error) {
+		// Single reads pass the bare symbol, batch reads the {A,B} array
+		var rows [][]driver.Value
+		for _, symbol := range fakeArrayArg(args[0]) {
+			if symbol == "BTC" || symbol == "ETH" {
+				rows = append(rows, bitcoinRow(symbol, "100"))
+			}
+		}
+		return fakeResult{columns: bitcoinColumns, rows: rows}, nil
+	})
+	close(cs.primed)
+	ctx := context.Background()
+
+	counts := func(operation string) (hits, misses float64) {
+		return counterValue(t, cs.metrics.cacheHits.WithLabelValues(operation)),
+			counterValue(t, cs.metrics.cacheMisses.WithLabelValues(operation))
+	}
+	expect := func(step, operation string, wantHits, wantMisses float64) {
+		t.Helper()
+		if hits, misses := counts(operation); hits != wantHits || misses != wantMisses {
+			t.Errorf("%s: %s hits/misses = %v/%v, want %v/%v", step, operation, hits, misses, wantHits, wantMisses)
+		}
+	}
+
+	steps := []struct {
+		name   string
+		symbol string
+		hits   float64
+		misses float64
+	}{
+		{"cold BTC", "BTC", 0, 1},
+		{"warm BTC", "BTC", 1, 1},
+		{"unknown symbol", "ZZZ", 1, 2},
+		{"cached not-found", "ZZZ", 2, 2},
+	}
+	for _, step := range steps {
+		if _, err := cs.GetBitcoin(ctx, step.symbol, consistencyEventual); err != nil {
+			t.Fatalf("%s: GetBitcoin: %v", step.name, err)
+		}
+		expect(step.name, "get", step.hits, step.misses)
+	}
+
+	// BTC and ZZZ are cached by now; ETH is not
+	if _, err := cs.GetBitcoinsBatch(ctx, []string{"BTC", "ETH", "ZZZ"}); err != nil {
+		t.Fatalf("GetBitcoinsBatch: %v", err)
+	}
+	expect("batch", "batch", 2, 1)
+	expect("batch", "get", 2, 2)
+}
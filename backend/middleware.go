@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"runtime/debug"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	requestIDHeader = "X-Request-ID"
+	requestIDKey    = "request_id"
+)
+
+// Caller-supplied IDs end up in logs, so only accept short, plain tokens
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// Tag each request with an ID (reusing the caller's X-Request-ID when valid) and echo it back
+func requestIDMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(requestIDHeader)
+		if !validRequestID.MatchString(id) {
+			generated, err := randomHex(8)
+			if err != nil {
+				generated = "unknown"
+			}
+			id = generated
+		}
+
+		c.Set(requestIDKey, id)
+		c.Request = c.Request.WithContext(withRequestID(c.Request.Context(), id))
+		c.Header(requestIDHeader, id)
+		c.Next()
+	}
+}
+
+// Record request latency labelled by route template (e.g. /api/bitcoins/:symbol) so
+// symbols don't explode the label cardinality
+func latencyMiddleware(metrics *Metrics) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		metrics.httpDuration.WithLabelValues(c.Request.Method, route, strconv.Itoa(c.Writer.Status())).
+			Observe(time.Since(start).Seconds())
+	}
+}
+
+// Longest a request's database and Redis work may take (REQUEST_TIMEOUT); 0 disables
+const defaultRequestTimeout = 10 * time.Second
+
+// Bound each request's context so a slow database can't pin its goroutine: reads and
+// writes made with it give up at the deadline (and answer DB_UNAVAILABLE). Streaming
+// routes are long-lived by design and are skipped.
+func requestTimeoutMiddleware(timeout time.Duration, skip ...string) gin.HandlerFunc {
+	skipped := make(map[string]bool, len(skip))
+	for _, route := range skip {
+		skipped[route] = true
+	}
+	return func(c *gin.Context) {
+		if skipped[c.FullPath()] {
+			c.Next()
+			return
+		}
+		ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+		defer cancel()
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
+
+// Routes that take a POST only to carry a request body and don't change anything,
+// keyed by method and route template. They are served in read-only mode.
+var readSafeRoutes = map[string]bool{
+	http.MethodPost + " /api/bitcoins/batch":  true,
+	http.MethodPost + " /api/portfolio/value": true,
+}
+
+// Whether the matched route only reads: a safe method, or one of readSafeRoutes
+func isReadSafe(c *gin.Context) bool {
+	switch c.Request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return readSafeRoutes[c.Request.Method+" "+c.FullPath()]
+}
+
+// In read-only mode only read-safe routes get through; everything else is a 405
+func readOnlyMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !isReadSafe(c) {
+			c.Header("Allow", "GET, HEAD, OPTIONS")
+			c.AbortWithStatusJSON(http.StatusMethodNotAllowed, errorJSON(CodeReadOnly, "Service is in read-only mode"))
+			return
+		}
+		c.Next()
+	}
+}
+
+// Replaces gin's default recovery: the stack goes to the logs only, and the client
+// gets the usual error envelope plus the request ID to quote when reporting it
+func recoveryMiddleware(metrics *Metrics) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer func() {
+			rec := recover()
+			if rec == nil {
+				return
+			}
+
+			requestID := c.GetString(requestIDKey)
+			slog.ErrorContext(c.Request.Context(), "panic recovered",
+				"method", c.Request.Method,
+				"path", c.Request.URL.Path,
+				"panic", fmt.Sprint(rec),
+				"stack", string(debug.Stack()),
+			)
+			metrics.panics.Inc()
+
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+				"error":      "Internal server error",
+				"code":       CodeInternal,
+				"request_id": requestID,
+			})
+		}()
+		c.Next()
+	}
+}
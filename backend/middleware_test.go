@@ -0,0 +1,116 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// A router set up as main does with READ_ONLY=true, with each route answering 200
+func readOnlyRouter(routes ...string) *gin.Engine {
+	router := gin.New()
+	router.Use(readOnlyMiddleware())
+	for _, route := range routes {
+		method, path, _ := strings.Cut(route, " ")
+		router.Handle(method, path, func(c *gin.Context) { c.Status(http.StatusOK) })
+	}
+	return router
+}
+
+func TestReadOnlyServesReadSafeRoutes(t *testing.T) {
+	router := readOnlyRouter(
+		"GET /api/bitcoins/:symbol",
+		"POST /api/bitcoins/batch",
+		"POST /api/portfolio/value",
+		"POST /api/bitcoins",
+		"PUT /api/bitcoins/:symbol",
+		"DELETE /api/bitcoins/:symbol",
+	)
+	for _, tc := range []struct {
+		method, path string
+		want         int
+	}{
+		{http.MethodGet, "/api/bitcoins/BTC", http.StatusOK},
+		{http.MethodPost, "/api/bitcoins/batch", http.StatusOK},
+		{http.MethodPost, "/api/portfolio/value", http.StatusOK},
+		{http.MethodPost, "/api/bitcoins", http.StatusMethodNotAllowed},
+		{http.MethodPut, "/api/bitcoins/BTC", http.StatusMethodNotAllowed},
+		{http.MethodDelete, "/api/bitcoins/BTC", http.StatusMethodNotAllowed},
+	} {
+		t.Run(tc.method+" "+tc.path, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(tc.method, tc.path, strings.NewReader(`{}`)))
+			if w.Code != tc.want {
+				t.Errorf("status = %d, want %d", w.Code, tc.want)
+			}
+		})
+	}
+}
+
+// With every request shed, reads (read-safe POSTs included) go cache-only and the
+// rest are rejected with 503
+func TestLoadShedTreatsReadSafePostsAsReads(t *testing.T) {
+	shedder := newLoadShedder(time.Millisecond, 1, NewMetrics(prometheus.NewRegistry(), defaultLatencyBuckets))
+	shedder.rate = 1
+	router := gin.New()
+	router.Use(loadShedMiddleware(shedder))
+	for _, route := range []string{"GET /api/bitcoins/:symbol", "POST /api/bitcoins/batch", "POST /api/portfolio/value", "POST /api/bitcoins"} {
+		method, path, _ := strings.Cut(route, " ")
+		router.Handle(method, path, func(c *gin.Context) {
+			if cacheOnly(c) {
+				c.Header("X-Cache-Only", "true")
+			}
+			c.Status(http.StatusOK)
+		})
+	}
+
+	for _, tc := range []struct {
+		method, path string
+		want         int
+		cacheOnly    bool
+	}{
+		{http.MethodGet, "/api/bitcoins/BTC", http.StatusOK, true},
+		{http.MethodPost, "/api/bitcoins/batch", http.StatusOK, true},
+		{http.MethodPost, "/api/portfolio/value", http.StatusOK, true},
+		{http.MethodPost, "/api/bitcoins", http.StatusServiceUnavailable, false},
+	} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(tc.method, tc.path, strings.NewReader(`{}`)))
+		if w.Code != tc.want || (w.Header().Get("X-Cache-Only") == "true") != tc.cacheOnly {
+			t.Errorf("%s %s = %d (cache-only %q), want %d (cache-only %v)",
+				tc.method, tc.path, w.Code, w.Header().Get("X-Cache-Only"), tc.want, tc.cacheOnly)
+		}
+	}
+}
+
+// With writes limited to one a window, read-safe POSTs are still counted as reads
+func TestRateLimitCountsReadSafePostsAsReads(t *testing.T) {
+	cs, _, _ := newFakeBackedCacheService(t, nil)
+	router := gin.New()
+	router.Use(rateLimitMiddleware(cs, rateLimits{read: 100, write: 1, window: time.Minute}))
+	for _, route := range []string{"/api/bitcoins/batch", "/api/portfolio/value", "/api/bitcoins"} {
+		router.POST(route, func(c *gin.Context) { c.Status(http.StatusOK) })
+	}
+
+	for _, tc := range []struct {
+		path string
+		want int
+	}{
+		{"/api/bitcoins", http.StatusOK},
+		{"/api/bitcoins", http.StatusTooManyRequests},
+		{"/api/bitcoins/batch", http.StatusOK},
+		{"/api/bitcoins/batch", http.StatusOK},
+		{"/api/portfolio/value", http.StatusOK},
+	} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, tc.path, strings.NewReader(`{}`)))
+		if w.Code != tc.want {
+			t.Errorf("POST %s = %d, want %d", tc.path, w.Code, tc.want)
+		}
+	}
+}
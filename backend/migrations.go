@@ -0,0 +1,257 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+)
+
+// migration is a single idempotent schema change applied at startup
+type migration struct {
+	name string
+	sql  string
+}
+
+// Migrations run in order on every boot, so each statement must be safe to re-run
+var migrations = []migration{
+	{
+		name: "create_bitcoins_table",
+		sql: `
+			CREATE TABLE IF NOT EXISTS bitcoins (
+				symbol VARCHAR(10) PRIMARY KEY,
+				price INTEGER NOT NULL,
+				created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+			)
+		`,
+	},
+	{
+		name: "create_price_index",
+		sql:  `CREATE INDEX IF NOT EXISTS idx_bitcoin_price ON bitcoins(price DESC)`,
+	},
+	{
+		name: "add_stored_rank_column",
+		sql:  `ALTER TABLE bitcoins ADD COLUMN IF NOT EXISTS rank INTEGER`,
+	},
+	{
+		// Rank recomputation rewrites every row; don't let that masquerade as a price update
+		name: "updated_at_ignores_rank_changes",
+		sql: `
+			CREATE OR REPLACE FUNCTION update_updated_at_column()
+			RETURNS TRIGGER AS $$
+			BEGIN
+				IF (to_jsonb(NEW) - 'rank' - 'updated_at') IS DISTINCT FROM (to_jsonb(OLD) - 'rank' - 'updated_at') THEN
+					NEW.updated_at = CURRENT_TIMESTAMP;
+				END IF;
+				RETURN NEW;
+			END;
+			$$ language 'plpgsql'
+		`,
+	},
+	{
+		name: "create_price_history_table",
+		sql: `
+			CREATE TABLE IF NOT EXISTS bitcoin_price_history (
+				id BIGSERIAL PRIMARY KEY,
+				symbol VARCHAR(10) NOT NULL,
+				price INTEGER NOT NULL,
+				recorded_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+			)
+		`,
+	},
+	{
+		name: "create_price_history_index",
+		sql:  `CREATE INDEX IF NOT EXISTS idx_price_history_symbol_time ON bitcoin_price_history(symbol, recorded_at DESC)`,
+	},
+	{
+		// notes holds AES-GCM ciphertext ("<keyID>:<base64>"), never plaintext
+		name: "create_symbol_metadata_table",
+		sql: `
+			CREATE TABLE IF NOT EXISTS symbol_metadata (
+				symbol VARCHAR(10) PRIMARY KEY REFERENCES bitcoins(symbol) ON DELETE CASCADE,
+				name TEXT NOT NULL DEFAULT '',
+				description TEXT NOT NULL DEFAULT '',
+				notes TEXT,
+				updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+			)
+		`,
+	},
+	{
+		name: "create_symbol_tags_table",
+		sql: `
+			CREATE TABLE IF NOT EXISTS symbol_tags (
+				symbol VARCHAR(10) NOT NULL REFERENCES bitcoins(symbol) ON DELETE CASCADE,
+				tag VARCHAR(32) NOT NULL,
+				PRIMARY KEY (symbol, tag)
+			)
+		`,
+	},
+	{
+		name: "create_symbol_tags_tag_index",
+		sql:  `CREATE INDEX IF NOT EXISTS idx_symbol_tags_tag ON symbol_tags(tag)`,
+	},
+	{
+		// Circulating supply for market-cap ranking; NULL when unknown
+		name: "add_supply_column",
+		sql:  `ALTER TABLE bitcoins ADD COLUMN IF NOT EXISTS supply DOUBLE PRECISION`,
+	},
+	{
+		// Partner API keys; key_hash is the SHA-256 of the key, never the key itself
+		name: "create_api_keys_table",
+		sql: `
+			CREATE TABLE IF NOT EXISTS api_keys (
+				id BIGSERIAL PRIMARY KEY,
+				key_hash CHAR(64) NOT NULL UNIQUE,
+				name TEXT NOT NULL,
+				allowed_symbols TEXT[] NOT NULL DEFAULT '{}',
+				quota_per_minute INTEGER NOT NULL,
+				created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+			)
+		`,
+	},
+	{
+		name: "create_rank_snapshots_table",
+		sql: `
+			CREATE TABLE IF NOT EXISTS rank_snapshots (
+				snapshot_date DATE NOT NULL,
+				symbol VARCHAR(10) NOT NULL,
+				rank INTEGER NOT NULL,
+				PRIMARY KEY (snapshot_date, symbol)
+			)
+		`,
+	},
+	{
+		// Replays set updated_at to the replayed timestamp; only stamp the current
+		// time when the statement left updated_at alone
+		name: "updated_at_keeps_explicit_values",
+		sql: `
+			CREATE OR REPLACE FUNCTION update_updated_at_column()
+			RETURNS TRIGGER AS $$
+			BEGIN
+				IF NEW.updated_at IS NOT DISTINCT FROM OLD.updated_at
+					AND (to_jsonb(NEW) - 'rank' - 'updated_at') IS DISTINCT FROM (to_jsonb(OLD) - 'rank' - 'updated_at') THEN
+					NEW.updated_at = CURRENT_TIMESTAMP;
+				END IF;
+				RETURN NEW;
+			END;
+			$$ language 'plpgsql'
+		`,
+	},
+	{
+		// Record TTLs for a tag or an explicit symbol list (exactly one of the two)
+		name: "create_cache_policies_table",
+		sql: `
+			CREATE TABLE IF NOT EXISTS cache_policies (
+				name VARCHAR(64) PRIMARY KEY,
+				tag VARCHAR(32),
+				symbols TEXT[],
+				ttl_seconds INTEGER NOT NULL CHECK (ttl_seconds > 0),
+				backfill BOOLEAN NOT NULL DEFAULT FALSE,
+				updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				CHECK ((tag IS NULL) <> (symbols IS NULL))
+			)
+		`,
+	},
+	{
+		// Decimal places clients should display; NULL falls back to PRICE_PRECISION
+		name: "add_metadata_price_precision",
+		sql:  `ALTER TABLE symbol_metadata ADD COLUMN IF NOT EXISTS price_precision SMALLINT CHECK (price_precision BETWEEN 0 AND 18)`,
+	},
+	{
+		// Fractional prices. Only convert columns still INTEGER: altering a column's type
+		// rewrites its indexes under an exclusive lock, which shouldn't happen every boot
+		name: "numeric_prices",
+		sql: `
+			DO $$
+			BEGIN
+				IF (SELECT data_type FROM information_schema.columns
+					WHERE table_name = 'bitcoins' AND column_name = 'price') = 'integer' THEN
+					ALTER TABLE bitcoins ALTER COLUMN price TYPE NUMERIC;
+				END IF;
+				IF (SELECT data_type FROM information_schema.columns
+					WHERE table_name = 'bitcoin_price_history' AND column_name = 'price') = 'integer' THEN
+					ALTER TABLE bitcoin_price_history ALTER COLUMN price TYPE NUMERIC;
+				END IF;
+			END
+			$$
+		`,
+	},
+	{
+		// Symbols are stored in the canonical form the API normalizes to. NOT VALID so
+		// rows written before normalization don't block startup; new writes are checked.
+		name: "normalized_symbols",
+		sql: `
+			DO $$
+			BEGIN
+				IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'bitcoins_symbol_normalized') THEN
+					ALTER TABLE bitcoins ADD CONSTRAINT bitcoins_symbol_normalized
+						CHECK (symbol ~ '^[A-Z0-9]{1,10}$') NOT VALID;
+				END IF;
+			END
+			$$
+		`,
+	},
+	{
+		// Soft deletes: set instead of removing the row; NULL for live symbols
+		name: "add_deleted_at_column",
+		sql:  `ALTER TABLE bitcoins ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP`,
+	},
+	{
+		// Deleting or restoring a symbol doesn't change its price, so leaves updated_at alone
+		name: "updated_at_ignores_deleted_at",
+		sql: `
+			CREATE OR REPLACE FUNCTION update_updated_at_column()
+			RETURNS TRIGGER AS $$
+			BEGIN
+				IF NEW.updated_at IS NOT DISTINCT FROM OLD.updated_at
+					AND (to_jsonb(NEW) - 'rank' - 'updated_at' - 'deleted_at') IS DISTINCT FROM (to_jsonb(OLD) - 'rank' - 'updated_at' - 'deleted_at') THEN
+					NEW.updated_at = CURRENT_TIMESTAMP;
+				END IF;
+				RETURN NEW;
+			END;
+			$$ language 'plpgsql'
+		`,
+	},
+}
+
+// MIGRATION RUNNER: Apply schema migrations, then verify the invariants the queries rely on
+func runMigrations(db *sql.DB) error {
+	for _, m := range migrations {
+		if _, err := db.Exec(m.sql); err != nil {
+			return fmt.Errorf("migration %s failed: %w", m.name, err)
+		}
+		slog.Info("migration applied", "migration", m.name)
+	}
+
+	return verifySymbolUniqueConstraint(db)
+}
+
+// SetBitcoin upserts with ON CONFLICT (symbol), which Postgres only accepts when a
+// non-partial unique index covers exactly that column. A table bootstrapped without
+// one would otherwise fail every write at runtime with a cryptic error.
+func verifySymbolUniqueConstraint(db *sql.DB) error {
+	var exists bool
+	err := db.QueryRow(`
+		SELECT EXISTS (
+			SELECT 1
+			FROM pg_index i
+			JOIN pg_attribute a ON a.attrelid = i.indrelid AND a.attnum = i.indkey[0]
+			WHERE i.indrelid = to_regclass('bitcoins')
+			  AND i.indisunique
+			  AND i.indnatts = 1
+			  AND i.indpred IS NULL
+			  AND a.attname = 'symbol'
+		)
+	`).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("failed to inspect constraints on bitcoins: %w", err)
+	}
+
+	if !exists {
+		return fmt.Errorf("table bitcoins has no unique constraint on symbol, which ON CONFLICT (symbol) requires; " +
+			"remove duplicate symbols and run: ALTER TABLE bitcoins ADD CONSTRAINT bitcoins_symbol_key UNIQUE (symbol)")
+	}
+
+	slog.Info("schema check passed: unique constraint on bitcoins.symbol present")
+	return nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"sort"
+
+	"github.com/shopspring/decimal"
+)
+
+type HoldingValue struct {
+	Symbol string          `json:"symbol"`
+	Amount float64         `json:"amount"`
+	Price  decimal.Decimal `json:"price"`
+	Value  float64         `json:"value"`
+}
+
+type PortfolioValuation struct {
+	Holdings []HoldingValue `json:"holdings"`
+	Total    float64        `json:"total"`
+	Missing  []string       `json:"missing"` // Symbols with no price; excluded from the total
+}
+
+// Value a set of holdings (symbol -> amount) at current cached prices. With cacheOnly
+// set the prices come from Redis alone, failing with ErrCacheOnlyMiss if one isn't cached.
+func (cs *CacheService) ValuePortfolio(ctx context.Context, holdings map[string]float64, cacheOnly bool) (*PortfolioValuation, error) {
+	symbols := make([]string, 0, len(holdings))
+	for symbol := range holdings {
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(symbols)
+
+	getBatch := cs.GetBitcoinsBatch
+	if cacheOnly {
+		getBatch = cs.GetBitcoinsBatchCacheOnly
+	}
+	prices, err := getBatch(ctx, symbols)
+	if err != nil {
+		return nil, err
+	}
+
+	valuation := &PortfolioValuation{Holdings: []HoldingValue{}, Missing: []string{}}
+	for _, symbol := range symbols {
+		bitcoin, ok := prices[symbol]
+		if !ok || bitcoin.Price == nil {
+			valuation.Missing = append(valuation.Missing, symbol)
+			continue
+		}
+
+		value := holdings[symbol] * bitcoin.Price.InexactFloat64()
+		valuation.Holdings = append(valuation.Holdings, HoldingValue{
+			Symbol: symbol,
+			Amount: holdings[symbol],
+			Price:  *bitcoin.Price,
+			Value:  value,
+		})
+		valuation.Total += value
+	}
+
+	return valuation, nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	defaultPreloadTTL             = 24 * time.Hour
+	defaultPreloadRefreshInterval = 5 * time.Minute
+)
+
+// Parse PRELOAD_SYMBOLS ("BTC, ETH") into an ordered, de-duplicated list
+func parsePreloadSymbols(spec string) []string {
+	var symbols []string
+	seen := make(map[string]bool)
+	for _, part := range strings.Split(spec, ",") {
+		symbol := strings.TrimSpace(part)
+		if symbol == "" || seen[symbol] {
+			continue
+		}
+		seen[symbol] = true
+		symbols = append(symbols, symbol)
+	}
+	return symbols
+}
+
+func (cs *CacheService) setPreloadSymbols(symbols []string) {
+	cs.preloadSymbols = symbols
+	cs.preload = make(map[string]bool, len(symbols))
+	for _, symbol := range symbols {
+		cs.preload[symbol] = true
+	}
+}
+
+// Per-symbol record TTL: preloaded symbols are kept much longer, then any cache
+// policy covering the symbol applies, then the default. Jittered (CACHE_TTL_JITTER)
+// so records primed or written together expire spread out.
+func (cs *CacheService) bitcoinTTL(symbol string) time.Duration {
+	if cs.preload[symbol] {
+		return cs.jitterTTL(cs.preloadTTL)
+	}
+	if policy := cs.cachePolicies.lookup(symbol); policy != nil {
+		return cs.jitterTTL(policy.ttl())
+	}
+	return cs.jitterTTL(cs.ttlFor(keyKindBitcoin, 1))
+}
+
+// Log any preload symbols that aren't in the database; they're simply skipped
+func (cs *CacheService) checkPreloadSymbols(ctx context.Context) error {
+	if len(cs.preloadSymbols) == 0 {
+		return nil
+	}
+
+	rows, err := cs.db.QueryContext(ctx, `SELECT symbol FROM bitcoins WHERE symbol = ANY($1) AND deleted_at IS NULL`, pq.Array(cs.preloadSymbols))
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	defer rows.Close()
+
+	found := make(map[string]bool)
+	for rows.Next() {
+		var symbol string
+		if err := rows.Scan(&symbol); err != nil {
+			return fmt.Errorf("scan error: %w", err)
+		}
+		found[symbol] = true
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("scan error: %w", err)
+	}
+
+	for _, symbol := range cs.preloadSymbols {
+		if !found[symbol] {
+			slog.WarnContext(cs.ctx, "PRELOAD_SYMBOLS entry does not exist in the database", "symbol", symbol)
+		}
+	}
+	return nil
+}
+
+// Re-cache the preload symbols from the database every interval, so they stay warm
+// even if evicted, until ctx is cancelled
+func (cs *CacheService) RunPreloadRefresh(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := cs.refreshPreloadSymbols(ctx); err != nil {
+				slog.ErrorContext(ctx, "refreshing preload symbols failed", "error", err)
+			}
+		}
+	}
+}
+
+func (cs *CacheService) refreshPreloadSymbols(ctx context.Context) error {
+	rows, err := cs.db.QueryContext(ctx, `
+		SELECT symbol, price, supply, created_at, updated_at
+		FROM bitcoins
+		WHERE symbol = ANY($1) AND deleted_at IS NULL
+	`, pq.Array(cs.preloadSymbols))
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	defer rows.Close()
+
+	var bitcoins []Bitcoin
+	for rows.Next() {
+		var b Bitcoin
+		if err := rows.Scan(&b.Symbol, &b.Price, &b.Supply, &b.CreatedAt, &b.UpdatedAt); err != nil {
+			return fmt.Errorf("scan error: %w", err)
+		}
+		bitcoins = append(bitcoins, b)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("scan error: %w", err)
+	}
+
+	_, err = cs.redisClient.Pipelined(cs.ctx, func(pipe redis.Pipeliner) error {
+		for _, b := range bitcoins {
+			data, err := cs.encodeCacheValue(b)
+			if err != nil {
+				cs.marshalFailed(cs.ctx, cs.getBitcoinCacheKey(b.Symbol), err)
+				continue
+			}
+			pipe.Set(cs.ctx, cs.getBitcoinCacheKey(b.Symbol), data, cs.preloadTTL)
+		}
+		return nil
+	})
+	return err
+}
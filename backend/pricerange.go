@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"github.com/shopspring/decimal"
+)
+
+// Inclusive price bounds for the rankings (?min_price=, ?max_price=); a nil bound is
+// open. Filtering never renumbers: each symbol keeps its rank in the full ranking.
+type priceRange struct {
+	min *decimal.Decimal
+	max *decimal.Decimal
+}
+
+// Parse ?min_price= and ?max_price=, writing a 400 and returning false if either is
+// malformed or min_price exceeds max_price
+func parsePriceRange(c *gin.Context) (priceRange, bool) {
+	var band priceRange
+	for _, bound := range []struct {
+		param string
+		dst   **decimal.Decimal
+	}{{"min_price", &band.min}, {"max_price", &band.max}} {
+		raw, ok := c.GetQuery(bound.param)
+		if !ok {
+			continue
+		}
+		price, err := decimal.NewFromString(raw)
+		if err != nil || price.IsNegative() {
+			c.JSON(http.StatusBadRequest, errorJSON(CodeValidationFailed, bound.param+" must be a non-negative number"))
+			return priceRange{}, false
+		}
+		*bound.dst = &price
+	}
+	if band.min != nil && band.max != nil && band.min.GreaterThan(*band.max) {
+		c.JSON(http.StatusBadRequest, errorJSON(CodeValidationFailed, "min_price must not be greater than max_price"))
+		return priceRange{}, false
+	}
+	return band, true
+}
+
+func (r priceRange) bounded() bool {
+	return r.min != nil || r.max != nil
+}
+
+func (r priceRange) contains(price *decimal.Decimal) bool {
+	if price == nil {
+		return false
+	}
+	return (r.min == nil || !price.LessThan(*r.min)) && (r.max == nil || !price.GreaterThan(*r.max))
+}
+
+// The entries of a ranked list within the range, in order and with their ranks unchanged
+func (r priceRange) filter(bitcoins []Bitcoin) []Bitcoin {
+	if !r.bounded() {
+		return bitcoins
+	}
+	inRange := []Bitcoin{}
+	for _, b := range bitcoins {
+		if r.contains(b.Price) {
+			inRange = append(inRange, b)
+		}
+	}
+	return inRange
+}
+
+// The sorted set is missing (e.g. evicted), so it can't say what a band holds
+var errRankingsSetMissing = errors.New("rankings sorted set is missing")
+
+// The rankings within band from the sorted set, in one round trip: ZREVRANGEBYSCORE
+// for the members priced in the band and a ZCOUNT of those above it, which numbers
+// them as in the full ranking. Scores are float64 copies of the prices, so the
+// loaded records are checked against the exact bounds.
+func (cs *CacheService) buildBitcoinsRankedInRange(ctx context.Context, band priceRange) ([]Bitcoin, error) {
+	byScore := &redis.ZRangeBy{Min: "-inf", Max: "+inf"}
+	if band.min != nil {
+		byScore.Min = strconv.FormatFloat(band.min.InexactFloat64(), 'g', -1, 64)
+	}
+	if band.max != nil {
+		byScore.Max = strconv.FormatFloat(band.max.InexactFloat64(), 'g', -1, 64)
+	}
+
+	pipe := cs.redisClient.Pipeline()
+	exists := pipe.Exists(ctx, rankSortedSetKey)
+	inBand := pipe.ZRevRangeByScoreWithScores(ctx, rankSortedSetKey, byScore)
+	above := pipe.ZCount(ctx, rankSortedSetKey, "("+byScore.Max, "+inf")
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+	if exists.Val() == 0 {
+		return nil, errRankingsSetMissing
+	}
+
+	members := inBand.Val()
+	sortRankedMembers(members)
+	ordered := make([]string, len(members))
+	for i, z := range members {
+		ordered[i] = z.Member.(string)
+	}
+	records, err := cs.GetBitcoinsBatch(ctx, ordered)
+	if err != nil {
+		return nil, err
+	}
+
+	bitcoins := []Bitcoin{}
+	rank := int(above.Val()) + 1
+	for _, symbol := range ordered {
+		bitcoin, ok := records[symbol]
+		if !ok {
+			continue
+		}
+		// A member just past max by its exact price still ranks above the band
+		rankValue := rank
+		rank++
+		if !band.contains(bitcoin.Price) {
+			continue
+		}
+		bitcoin.Rank = &rankValue
+		bitcoins = append(bitcoins, bitcoin)
+	}
+	return bitcoins, nil
+}
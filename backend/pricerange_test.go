@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestGetBitcoinsRankedBandFromSortedSet(t *testing.T) {
+	cs, fr, fdb := newFakeBackedCacheService(t, func(query string, args []driver.Value) (fakeResult, error) {
+		return fakeResult{columns: bitcoinColumns}, nil
+	})
+	for symbol, price := range map[string]string{"A": "300", "B": "200", "C": "200", "D": "150.5", "E": "100"} {
+		p := decimal.RequireFromString(price)
+		data, _ := cs.encodeCacheValue(Bitcoin{Symbol: symbol, Price: &p, CreatedAt: testTime, UpdatedAt: testTime})
+		fr.set(cs.getBitcoinCacheKey(symbol), string(data))
+		fr.zadd(rankSortedSetKey, p.InexactFloat64(), symbol)
+	}
+
+	min, max := decimal.RequireFromString("150.5"), decimal.RequireFromString("250")
+	bitcoins, err := cs.GetBitcoinsRanked(context.Background(), consistencyEventual, priceRange{min: &min, max: &max})
+	if err != nil {
+		t.Fatalf("GetBitcoinsRanked: %v", err)
+	}
+
+	// Global ranks: A (300) is first, so the band starts at 2; B and C tie by symbol
+	want := []struct {
+		symbol string
+		rank   int
+	}{{"B", 2}, {"C", 3}, {"D", 4}}
+	if len(bitcoins) != len(want) {
+		t.Fatalf("got %d bitcoins, want %d: %v", len(bitcoins), len(want), bitcoins)
+	}
+	for i, w := range want {
+		if bitcoins[i].Symbol != w.symbol || bitcoins[i].Rank == nil || *bitcoins[i].Rank != w.rank {
+			t.Errorf("position %d = %s rank %v, want %s rank %d", i, bitcoins[i].Symbol, bitcoins[i].Rank, w.symbol, w.rank)
+		}
+	}
+
+	if n := fr.count("ZREVRANGEBYSCORE"); n != 1 {
+		t.Errorf("%d ZREVRANGEBYSCORE, want 1", n)
+	}
+	if n := fr.count("ZREVRANGE"); n != 0 {
+		t.Errorf("full ZREVRANGE ran %d times; the band should not build the whole ranking", n)
+	}
+	if n := len(fdb.queries); n != 0 {
+		t.Errorf("%d database queries, want none", n)
+	}
+}
+
+func TestGetBitcoinsRankedBandFallsBackWithoutSortedSet(t *testing.T) {
+	cs, _, fdb := newFakeBackedCacheService(t, func(query string, args []driver.Value) (fakeResult, error) {
+		return fakeResult{columns: append(bitcoinColumns, "rank"), rows: [][]driver.Value{
+			append(bitcoinRow("B", "200"), int64(2)),
+		}}, nil
+	})
+
+	min := decimal.RequireFromString("150")
+	bitcoins, err := cs.GetBitcoinsRanked(context.Background(), consistencyEventual, priceRange{min: &min})
+	if err != nil {
+		t.Fatalf("GetBitcoinsRanked: %v", err)
+	}
+	if len(bitcoins) != 1 || bitcoins[0].Symbol != "B" || *bitcoins[0].Rank != 2 {
+		t.Errorf("got %v, want B at rank 2 from the database", bitcoins)
+	}
+	if n := fdb.count("ROW_NUMBER()"); n != 1 {
+		t.Errorf("%d ranked queries, want 1", n)
+	}
+}
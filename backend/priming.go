@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+)
+
+const (
+	primeModeWait        = "wait"         // Block reads briefly until priming finishes
+	primeModePassThrough = "pass-through" // Serve single-symbol reads straight from the DB while priming
+	defaultPrimeWait     = 5 * time.Second
+)
+
+// Redis lock so only one replica primes at a time. It expires after PRIME_LOCK_TTL so
+// an instance that dies mid-prime can't block the others for good; set it above the
+// time a full prime takes.
+const (
+	primeLock           = "cache-prime"
+	defaultPrimeLockTTL = 5 * time.Minute
+)
+
+var ErrPrimeInProgress = errors.New("cache priming already in progress on another instance")
+
+// Prime the cache under primeLock, held for at most ttl. With keep, a successful prime
+// leaves the lock to expire, so other replicas skip priming until then; otherwise,
+// and whenever priming fails, it is released as soon as priming ends.
+func (cs *CacheService) primeCacheLocked(ctx context.Context, ttl time.Duration, keep bool) error {
+	token, err := cs.acquireLock(primeLock, ttl)
+	if err != nil {
+		return err
+	}
+	if token == "" {
+		return ErrPrimeInProgress
+	}
+
+	err = cs.primeCache(ctx)
+	if err == nil && keep {
+		return nil
+	}
+	if err := cs.releaseLock(primeLock, token); err != nil {
+		slog.ErrorContext(ctx, "releasing lock failed", "lock", primeLock, "error", err)
+	}
+	return err
+}
+
+// Warm the pools and prime the cache in the background; reads check isPriming until
+// primed is closed. A failed prime still ends the priming state so requests fall back
+// to read-through.
+func (cs *CacheService) StartPriming() {
+	cs.primeDeadline = time.Now().Add(cs.primeWait)
+
+	go func() {
+		defer close(cs.primed)
+
+		cs.warmUpPools(cs.warmConns)
+
+		start := time.Now()
+		err := cs.PrimeCache()
+		switch {
+		case errors.Is(err, ErrPrimeInProgress):
+			// Reads fall back to read-through while the other instance fills the cache
+			slog.InfoContext(cs.ctx, "another instance is priming the cache, skipping")
+		case err != nil:
+			slog.WarnContext(cs.ctx, "cache priming failed", "error", err)
+		default:
+			slog.InfoContext(cs.ctx, "cache primed", "duration_ms", latencyMs(time.Since(start)))
+		}
+	}()
+}
+
+// Prime the cache again every interval (PRIME_INTERVAL) until ctx is cancelled. A run
+// keeps primeLock for the interval, so across all replicas the cache is primed about
+// once per interval rather than once per replica.
+func (cs *CacheService) RunScheduledPriming(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if cs.cacheDisabled() {
+				continue
+			}
+			start := time.Now()
+			err := cs.primeCacheLocked(ctx, max(interval, cs.primeLockTTL), true)
+			switch {
+			case errors.Is(err, ErrPrimeInProgress):
+				slog.InfoContext(ctx, "scheduled cache priming skipped: another instance primed or is priming")
+			case err != nil:
+				slog.ErrorContext(ctx, "scheduled cache priming failed", "error", err)
+			default:
+				slog.InfoContext(ctx, "cache re-primed", "duration_ms", latencyMs(time.Since(start)))
+			}
+		}
+	}
+}
+
+func (cs *CacheService) isPriming() bool {
+	select {
+	case <-cs.primed:
+		return false
+	default:
+		return true
+	}
+}
+
+// Wait for priming to finish, but never past primeWait after startup: the deadline is
+// shared so nested reads (rankings -> GetBitcoin per symbol) can't stack their waits.
+// Returns false on timeout, in which case the caller carries on with a normal read-through.
+func (cs *CacheService) waitForPrime(ctx context.Context) bool {
+	if !cs.isPriming() {
+		return true
+	}
+
+	remaining := time.Until(cs.primeDeadline)
+	if remaining <= 0 {
+		return false
+	}
+
+	timer := time.NewTimer(remaining)
+	defer timer.Stop()
+
+	select {
+	case <-cs.primed:
+		return true
+	case <-timer.C:
+		slog.WarnContext(ctx, "timed out waiting for cache priming", "prime_wait", cs.primeWait.String())
+		return false
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// A go-redis hook counting round trips: one per command, one per pipeline
+type roundTripHook struct{ n *atomic.Int64 }
+
+func (h roundTripHook) DialHook(next redis.DialHook) redis.DialHook {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return next(ctx, network, addr)
+	}
+}
+
+func (h roundTripHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		h.n.Add(1)
+		return next(ctx, cmd)
+	}
+}
+
+func (h roundTripHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		h.n.Add(1)
+		return next(ctx, cmds)
+	}
+}
+
+// A fake database holding rows priced bitcoins
+func primingDB(rows int) fakeHandler {
+	res := fakeResult{columns: bitcoinColumns}
+	for i := 0; i < rows; i++ {
+		res.rows = append(res.rows, bitcoinRow(fmt.Sprintf("S%d", i), fmt.Sprint(i+1)))
+	}
+	return func(query string, args []driver.Value) (fakeResult, error) {
+		if strings.Contains(query, "FROM bitcoins") {
+			return res, nil
+		}
+		return fakeResult{}, nil
+	}
+}
+
+func TestPrimeCachePipelinesWrites(t *testing.T) {
+	const rows = 1234
+	cs, fr, _ := newFakeBackedCacheService(t, primingDB(rows))
+	var roundTrips atomic.Int64
+	cs.redisClient.AddHook(roundTripHook{&roundTrips})
+
+	if err := cs.PrimeCache(); err != nil {
+		t.Fatalf("PrimeCache: %v", err)
+	}
+
+	for _, symbol := range []string{"S0", "S617", fmt.Sprintf("S%d", rows-1)} {
+		if !fr.exists(cs.getBitcoinCacheKey(symbol)) {
+			t.Errorf("%s not primed", symbol)
+		}
+		if _, ok := fr.zscore(rankSortedSetKey, symbol); !ok {
+			t.Errorf("%s missing from the rankings sorted set", symbol)
+		}
+	}
+	if n := fr.count("SET") - 1; n != rows {
+		t.Errorf("%d records cached, want %d", n, rows)
+	}
+	// A pipeline per 500 rows, plus the lock, its release and the invalidation
+	if n := roundTrips.Load(); n > 10 {
+		t.Errorf("%d Redis round trips to prime %d rows, want a handful", n, rows)
+	}
+}
+
+// Priming 10k rows through pipelines against the one SET and one ZADD per row it
+// used to take. Reports Redis round trips per prime alongside the time.
+func BenchmarkPrimeCache10k(b *testing.B) {
+	const rows = 10000
+	primeEach := func(cs *CacheService) error {
+		rows, err := cs.db.Query(`SELECT symbol, price, supply, created_at, updated_at FROM bitcoins`)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var b Bitcoin
+			if err := rows.Scan(&b.Symbol, &b.Price, &b.Supply, &b.CreatedAt, &b.UpdatedAt); err != nil {
+				return err
+			}
+			data, _ := cs.encodeCacheValue(b)
+			if err := cs.redisClient.Set(cs.ctx, cs.getBitcoinCacheKey(b.Symbol), data, cs.bitcoinTTL(b.Symbol)).Err(); err != nil {
+				return err
+			}
+			if err := setRankEntry(cs.ctx, cs.redisClient, &b).Err(); err != nil {
+				return err
+			}
+		}
+		return rows.Err()
+	}
+
+	for _, variant := range []struct {
+		name  string
+		prime func(cs *CacheService) error
+	}{
+		{"pipelined", (*CacheService).PrimeCache},
+		{"per-row", primeEach},
+	} {
+		b.Run(variant.name, func(b *testing.B) {
+			quietLogs(b)
+			cs, _, _ := newFakeBackedCacheService(b, primingDB(rows))
+			var roundTrips atomic.Int64
+			cs.redisClient.AddHook(roundTripHook{&roundTrips})
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := variant.prime(cs); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(roundTrips.Load())/float64(b.N), "round-trips/op")
+		})
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// With RANKINGS_CACHE_COMPRESSED, the rankings payload is cached gzipped and served
+// as-is to clients that accept gzip, so the hot list endpoint neither re-marshals
+// nor re-compresses on a hit. Readers recognise both formats by the gzip magic
+// bytes, so the setting can be changed one instance at a time. A payload over
+// CACHE_COMPRESS_THRESHOLD is stored this way whatever the setting.
+
+// A cached, gzipped rankings payload ready to be written to the client
+type rankingsPayload struct {
+	gzipped []byte
+	etag    string // Over the uncompressed JSON, so both encodings share it
+	size    int    // Uncompressed length
+	count   int    // Entries in the list; -1 if the payload predates the count
+}
+
+func isGzipped(data []byte) bool {
+	return len(data) >= 2 && data[0] == 0x1f && data[1] == 0x8b
+}
+
+// Weak, because the gzipped and the plain response carry the same tag
+func rankingsETag(data []byte) string {
+	sum := sha256.Sum256(data)
+	return `W/"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// The ETag and the entry count travel in the gzip header (comment and name), so
+// serving the payload never needs to decompress it
+func gzipRankings(data []byte, count int) ([]byte, error) {
+	var buf bytes.Buffer
+	zw, err := gzip.NewWriterLevel(&buf, gzip.BestSpeed)
+	if err != nil {
+		return nil, err
+	}
+	zw.Comment = rankingsETag(data)
+	zw.Name = strconv.Itoa(count)
+	if _, err := zw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decode a cached rankings payload in either format
+func decodeRankings(cached []byte) ([]Bitcoin, error) {
+	var bitcoins []Bitcoin
+	if err := decodeCacheValue(cached, &bitcoins); err != nil {
+		return nil, err
+	}
+	return bitcoins, nil
+}
+
+// The cached rankings if they are stored gzipped; nil on a miss or a plain payload,
+// in which case the caller takes the regular path. Shed (cache-only) reads skip the
+// canary.
+func (cs *CacheService) GetBitcoinsRankedPayload(ctx context.Context, cacheOnly bool) *rankingsPayload {
+	if cs.cacheDisabled() {
+		return nil
+	}
+	var canaryVersion string
+	var canary bool
+	if !cacheOnly {
+		canaryVersion, canary = cs.rankingsCanary()
+	}
+
+	start := time.Now()
+	cached, err := cs.getRankingsPayload(cs.ctx, !cacheOnly)
+	if err != nil || !isGzipped(cached) || len(cached) < 18 {
+		return nil
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(cached))
+	if err != nil {
+		slog.ErrorContext(ctx, "reading cached rankings failed", "error", err)
+		return nil
+	}
+
+	count, err := strconv.Atoi(zr.Name)
+	if err != nil {
+		count = -1
+	}
+
+	cs.logCacheLookup(cs.ctx, "rankings", true, start, "gzipped", true)
+	if canary {
+		go func() {
+			bitcoins, err := decodeRankings(cached)
+			if err != nil {
+				slog.ErrorContext(ctx, "rankings canary: decoding cached rankings failed", "error", err)
+				return
+			}
+			cs.compareRankingsCanary(canaryVersion, bitcoins)
+		}()
+	}
+
+	return &rankingsPayload{
+		gzipped: cached,
+		etag:    zr.Comment,
+		// ISIZE trailer: the uncompressed length mod 2^32
+		size:  int(binary.LittleEndian.Uint32(cached[len(cached)-4:])),
+		count: count,
+	}
+}
+
+// Write the payload, gzipped if the client accepts it and decompressed on the fly
+// otherwise. Returns false, having written nothing, when the response needs the
+// regular path: a key-filtered list, one over maxBytes, or a page that isn't the
+// whole list.
+func writeRankingsPayload(c *gin.Context, p *rankingsPayload, maxBytes, limit, offset int) bool {
+	if _, restricted := c.Get(apiKeyContextKey); restricted || p.size > maxBytes {
+		return false
+	}
+	if offset > 0 || p.count < 0 || p.count > limit {
+		return false
+	}
+	c.Header("X-Total-Count", strconv.Itoa(p.count))
+
+	c.Header("Vary", "Accept-Encoding")
+	c.Header("ETag", p.etag)
+	if match := c.GetHeader("If-None-Match"); match != "" && strings.Contains(match, p.etag) {
+		c.Status(http.StatusNotModified)
+		return true
+	}
+
+	if acceptsGzip(c.GetHeader("Accept-Encoding")) {
+		c.Header("Content-Encoding", "gzip")
+		c.Data(http.StatusOK, "application/json; charset=utf-8", p.gzipped)
+		return true
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(p.gzipped))
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "decompressing cached rankings failed", "error", err)
+		return false
+	}
+	c.Header("Content-Type", "application/json; charset=utf-8")
+	c.Status(http.StatusOK)
+	if _, err := io.Copy(c.Writer, zr); err != nil {
+		slog.ErrorContext(c.Request.Context(), "streaming decompressed rankings failed", "error", err)
+	}
+	return true
+}
+
+// Whether an Accept-Encoding header allows gzip (explicitly or via "*") with a
+// non-zero quality
+func acceptsGzip(header string) bool {
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding != "gzip" && coding != "*" {
+			continue
+		}
+		q := strings.ReplaceAll(strings.TrimSpace(params), " ", "")
+		return q != "q=0" && q != "q=0.0" && q != "q=0.00" && q != "q=0.000"
+	}
+	return false
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Where GetBitcoinsRanked builds the rankings on a cache miss (RANKINGS_SOURCE).
+// Either way the result is cached as the same JSON payload and ranked by price
+// descending with ties broken by symbol ascending, matching the stored ranks.
+const (
+	rankingsSourceRedis    = "redis"    // The Redis sorted set plus cached records (faster)
+	rankingsSourcePostgres = "postgres" // A ranked query against Postgres (authoritative)
+)
+
+func (cs *CacheService) rankBitcoins(ctx context.Context) ([]Bitcoin, error) {
+	if cs.rankingsSource == rankingsSourcePostgres {
+		return cs.getBitcoinsRankedFromDB(ctx)
+	}
+	return cs.buildBitcoinsRanked(ctx)
+}
+
+// The sorted set has no entry for the symbol at its price (not yet added, or drifted)
+var errRankNotInSet = errors.New("symbol not in the rankings sorted set at its price")
+
+// A symbol's position in the rankings, worked out on each read since any write can
+// move it: 1 + the symbols priced above it + those tied with it that sort first by
+// symbol. Read from the source the rankings list is built from, so both agree;
+// strong reads use the database. nil for an unpriced symbol.
+func (cs *CacheService) rankOf(ctx context.Context, bitcoin *Bitcoin, consistency consistencyLevel) (*int, error) {
+	if bitcoin.Price == nil {
+		return nil, nil
+	}
+	if cs.rankingsSource == rankingsSourceRedis && consistency == consistencyEventual && !cs.cacheDisabled() {
+		rank, err := cs.rankFromSortedSet(ctx, bitcoin)
+		if err == nil {
+			return &rank, nil
+		}
+		slog.WarnContext(ctx, "rank from sorted set failed, falling back to database", "symbol", bitcoin.Symbol, "error", err)
+	}
+	return cs.rankFromDB(ctx, bitcoin)
+}
+
+// One round trip: the symbol's own score (to check the set agrees with the record),
+// the count strictly above it and the members tied with it
+func (cs *CacheService) rankFromSortedSet(ctx context.Context, bitcoin *Bitcoin) (int, error) {
+	price := bitcoin.Price.InexactFloat64()
+	bound := strconv.FormatFloat(price, 'g', -1, 64)
+
+	pipe := cs.redisClient.Pipeline()
+	score := pipe.ZScore(ctx, rankSortedSetKey, bitcoin.Symbol)
+	above := pipe.ZCount(ctx, rankSortedSetKey, "("+bound, "+inf")
+	ties := pipe.ZRangeByScore(ctx, rankSortedSetKey, &redis.ZRangeBy{Min: bound, Max: bound})
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return 0, err
+	}
+	if score.Err() == redis.Nil || score.Val() != price {
+		return 0, errRankNotInSet
+	}
+
+	rank := int(above.Val()) + 1
+	for _, symbol := range ties.Val() {
+		if symbol < bitcoin.Symbol {
+			rank++
+		}
+	}
+	return rank, nil
+}
+
+func (cs *CacheService) rankFromDB(ctx context.Context, bitcoin *Bitcoin) (*int, error) {
+	defer cs.observeDB("rank", time.Now())
+
+	var rank int
+	err := cs.db.QueryRowContext(ctx, `
+		SELECT COUNT(*) + 1
+		FROM bitcoins
+		WHERE (price > $1 OR (price = $1 AND symbol < $2)) AND deleted_at IS NULL
+	`, *bitcoin.Price, bitcoin.Symbol).Scan(&rank)
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	return &rank, nil
+}
+
+// ZREVRANGE breaks score ties by reverse member order; re-sort so ties go by symbol
+// ascending like the database does
+func sortRankedMembers(members []redis.Z) {
+	sort.SliceStable(members, func(i, j int) bool {
+		if members[i].Score != members[j].Score {
+			return members[i].Score > members[j].Score
+		}
+		return members[i].Member.(string) < members[j].Member.(string)
+	})
+}
+
+// Periodically compare the sorted-set ranking with the database ranking and report
+// drift, whichever source is serving
+func (cs *CacheService) RunRankingsReconciler(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			cs.reconcileRankings(ctx)
+		}
+	}
+}
+
+// Count rank positions whose symbol or price differs between the two sources. Skipped
+// when the sorted set is unavailable or empty, since there's nothing to compare.
+func (cs *CacheService) reconcileRankings(ctx context.Context) {
+	members, err := cs.redisClient.ZRevRangeWithScores(ctx, rankSortedSetKey, 0, -1).Result()
+	if err != nil {
+		slog.ErrorContext(ctx, "rankings reconciliation skipped: reading sorted set failed", "error", err)
+		return
+	}
+	if len(members) == 0 {
+		slog.InfoContext(ctx, "rankings reconciliation skipped: sorted set empty")
+		return
+	}
+	sortRankedMembers(members)
+
+	ranked, err := cs.getBitcoinsRankedFromDB(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "rankings reconciliation skipped", "error", err)
+		return
+	}
+
+	mismatched := 0
+	for i := 0; i < len(members) || i < len(ranked); i++ {
+		if i >= len(members) || i >= len(ranked) {
+			mismatched++
+			continue
+		}
+		if members[i].Member.(string) != ranked[i].Symbol || members[i].Score != ranked[i].Price.InexactFloat64() {
+			if mismatched == 0 {
+				slog.WarnContext(ctx, "rankings drift", "rank", i+1, "redis_symbol", members[i].Member, "redis_score", members[i].Score, "postgres_symbol", ranked[i].Symbol, "postgres_price", ranked[i].Price)
+			}
+			mismatched++
+		}
+	}
+
+	cs.metrics.rankingsDrift.Set(float64(mismatched))
+	slog.InfoContext(ctx, "rankings reconciliation", "redis_members", len(members), "postgres_rows", len(ranked), "positions_differ", mismatched)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// With RANKINGS_STALE_TTL set, the rankings payload is kept that much longer than its
+// TTL. A read in that window is served the stale payload straight away and starts a
+// rebuild in the background, so readers only wait on the database when the cache is
+// cold. Writes still delete the payload outright: staleness only ever covers the TTL,
+// never a change the reader could have seen.
+
+// The cached rankings payload (redis.Nil on a miss). With revalidate, a payload past
+// its TTL triggers a background rebuild.
+func (cs *CacheService) getRankingsPayload(ctx context.Context, revalidate bool) ([]byte, error) {
+	if cs.rankingsStaleTTL <= 0 {
+		return cs.redisClient.Get(ctx, rankCacheKey).Bytes()
+	}
+
+	pipe := cs.redisClient.Pipeline()
+	get := pipe.Get(ctx, rankCacheKey)
+	pttl := pipe.PTTL(ctx, rankCacheKey)
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+	cached, err := get.Bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	// The remaining TTL only drops into the stale window once the soft TTL has passed
+	if remaining := pttl.Val(); revalidate && remaining > 0 && remaining <= cs.rankingsStaleTTL {
+		cs.metrics.rankingsStaleServed.Inc()
+		cs.revalidateRankings()
+	}
+	return cached, nil
+}
+
+// Rebuild and re-cache the rankings in the background. Only one rebuild runs at a time:
+// later stale reads return without starting another, and it shares the singleflight
+// key of cache-miss loads, so a miss arriving meanwhile waits on it rather than
+// running its own.
+func (cs *CacheService) revalidateRankings() {
+	if !cs.rankingsRevalidating.CompareAndSwap(false, true) {
+		return
+	}
+	slog.InfoContext(cs.ctx, "rankings payload is stale, rebuilding in the background")
+
+	go func() {
+		defer cs.rankingsRevalidating.Store(false)
+		if _, err := cs.sharedLoad(cs.ctx, "rankings", cs.loadRankings); err != nil {
+			slog.ErrorContext(cs.ctx, "revalidating rankings failed", "error", err)
+		}
+	}()
+}
+
+// Build the rankings and cache them, as a shared load
+func (cs *CacheService) loadRankings(ctx context.Context) (interface{}, error) {
+	// Capture the version before reading so a concurrent write can't get overwritten by our result
+	version := cs.rankingsVersion()
+
+	bitcoins, err := cs.rankBitcoins(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	cs.cacheRankings(ctx, version, bitcoins)
+	return bitcoins, nil
+}
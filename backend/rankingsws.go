@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"github.com/shopspring/decimal"
+	"golang.org/x/net/websocket"
+)
+
+// Live leaderboard over a websocket (GET /api/ws). A client sends
+//
+//	{"subscribe": {"type": "rankings", "top": 20}}
+//
+// and gets the current top N, then only the positions that change as writes arrive.
+// Each connection keeps its own view; a change event triggers a sorted-set read only
+// when it can affect that view.
+const (
+	defaultWSMaxTop   = 100
+	wsWriteTimeout    = 10 * time.Second
+	wsMaxMessageBytes = 4096
+)
+
+type wsSubscribe struct {
+	Subscribe *struct {
+		Type string `json:"type"`
+		Top  int    `json:"top"`
+	} `json:"subscribe"`
+}
+
+type RankEntry struct {
+	Rank   int             `json:"rank"`
+	Symbol string          `json:"symbol"`
+	Price  decimal.Decimal `json:"price"`
+}
+
+// The top n priced symbols that allow admits, with their global rank. Reads a prefix
+// of the sorted set (completed with every member tied at the cut, so ties order by
+// symbol as everywhere else) and falls back to the database when the set is empty.
+func (cs *CacheService) topRanked(ctx context.Context, n int, allow func(string) bool) ([]RankEntry, error) {
+	var members []redis.Z
+	for start := int64(0); ; start += int64(n) {
+		page, err := cs.redisClient.ZRevRangeWithScores(ctx, rankSortedSetKey, start, start+int64(n)-1).Result()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read sorted set: %w", err)
+		}
+		members = append(members, page...)
+		if len(page) < n || countAllowed(members, allow) >= n {
+			break
+		}
+	}
+
+	if len(members) == 0 {
+		return cs.topRankedFromDB(ctx, n, allow)
+	}
+
+	cut := members[len(members)-1].Score
+	bound := fmt.Sprint(cut)
+	ties, err := cs.redisClient.ZRangeByScoreWithScores(ctx, rankSortedSetKey, &redis.ZRangeBy{Min: bound, Max: bound}).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sorted set: %w", err)
+	}
+	above := members[:0]
+	for _, z := range members {
+		if z.Score != cut {
+			above = append(above, z)
+		}
+	}
+	members = append(above, ties...)
+	sortRankedMembers(members)
+
+	entries := []RankEntry{}
+	for i, z := range members {
+		symbol := z.Member.(string)
+		if !allow(symbol) {
+			continue
+		}
+		entries = append(entries, RankEntry{Rank: i + 1, Symbol: symbol, Price: decimal.NewFromFloat(z.Score)})
+		if len(entries) == n {
+			break
+		}
+	}
+	return entries, nil
+}
+
+func (cs *CacheService) topRankedFromDB(ctx context.Context, n int, allow func(string) bool) ([]RankEntry, error) {
+	ranked, err := cs.getBitcoinsRankedFromDB(ctx)
+	if err != nil {
+		return nil, err
+	}
+	entries := []RankEntry{}
+	for _, b := range ranked {
+		if len(entries) == n {
+			break
+		}
+		if allow(b.Symbol) {
+			entries = append(entries, RankEntry{Rank: *b.Rank, Symbol: b.Symbol, Price: *b.Price})
+		}
+	}
+	return entries, nil
+}
+
+func countAllowed(members []redis.Z, allow func(string) bool) int {
+	n := 0
+	for _, z := range members {
+		if allow(z.Member.(string)) {
+			n++
+		}
+	}
+	return n
+}
+
+// Whether a change event can alter a top-n view: it touches a listed symbol, or it
+// prices a symbol at or above the last listed one (or the view isn't full yet)
+func affectsView(view []RankEntry, n int, event ChangeEvent) bool {
+	if event.Type == eventTypeGap {
+		return true
+	}
+	for _, e := range view {
+		if e.Symbol == event.Symbol {
+			return true
+		}
+	}
+	if event.Type != eventTypeUpdate || event.Bitcoin == nil || event.Bitcoin.Price == nil {
+		return false
+	}
+	return len(view) < n || event.Bitcoin.Price.GreaterThanOrEqual(view[len(view)-1].Price)
+}
+
+// Positions whose entry changed, and symbols that dropped out of the view
+func diffView(old, current []RankEntry) (changes []RankEntry, removed []string) {
+	changes, removed = []RankEntry{}, []string{}
+	for i, e := range current {
+		if i >= len(old) || old[i].Rank != e.Rank || old[i].Symbol != e.Symbol || !old[i].Price.Equal(e.Price) {
+			changes = append(changes, e)
+		}
+	}
+	kept := make(map[string]bool, len(current))
+	for _, e := range current {
+		kept[e.Symbol] = true
+	}
+	for _, e := range old {
+		if !kept[e.Symbol] {
+			removed = append(removed, e.Symbol)
+		}
+	}
+	return changes, removed
+}
+
+// Handle one websocket connection. Its lifetime is tracked by streams, so shutdown
+// tells the client to reconnect and then closes it.
+func serveRankingsSocket(c *gin.Context, cs *CacheService, streams *streamRegistry, maxTop int) {
+	allow := func(symbol string) bool { return apiKeyAllows(c, symbol) }
+
+	server := websocket.Server{Handler: func(ws *websocket.Conn) {
+		defer ws.Close()
+		ws.MaxPayloadBytes = wsMaxMessageBytes
+
+		streamCtx, draining, done := streams.open(c.Request.Context())
+		defer done()
+		ctx, cancel := context.WithCancel(streamCtx)
+		defer cancel()
+
+		// A hijacked connection's request context isn't cancelled when the client
+		// leaves; the reader notices instead
+		subscribes := make(chan int)
+		go func() {
+			defer cancel()
+			for {
+				var msg wsSubscribe
+				if err := websocket.JSON.Receive(ws, &msg); err != nil {
+					return
+				}
+				top := 0
+				if msg.Subscribe != nil && msg.Subscribe.Type == "rankings" {
+					top = msg.Subscribe.Top
+				}
+				select {
+				case subscribes <- top:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+
+		send := func(v interface{}) bool {
+			ws.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if err := websocket.JSON.Send(ws, v); err != nil {
+				slog.WarnContext(c.Request.Context(), "websocket send failed", "error", err)
+				return false
+			}
+			return true
+		}
+
+		var events <-chan ChangeEvent
+		var view []RankEntry
+		top := 0
+		for {
+			select {
+			case <-ctx.Done():
+				return
+
+			case <-draining:
+				send(gin.H{"type": "shutdown", "message": "Server is shutting down; reconnect to continue"})
+				draining = nil
+
+			case n := <-subscribes:
+				if n < 1 || n > maxTop {
+					msg := fmt.Sprintf(`send {"subscribe": {"type": "rankings", "top": N}} with N between 1 and %d`, maxTop)
+					if !send(gin.H{"type": "error", "error": msg, "code": CodeValidationFailed}) {
+						return
+					}
+					continue
+				}
+				if events == nil {
+					events = cs.SubscribeChanges(ctx)
+				}
+				entries, err := cs.topRanked(ctx, n, allow)
+				if err != nil {
+					slog.ErrorContext(c.Request.Context(), "reading top rankings failed", "top", n, "error", err)
+					send(gin.H{"type": "error", "error": "Failed to fetch rankings", "code": codeFor(err)})
+					return
+				}
+				top, view = n, entries
+				if !send(gin.H{"type": "rankings", "top": top, "items": view}) {
+					return
+				}
+
+			case event, ok := <-events:
+				if !ok {
+					return
+				}
+				if (event.Type != eventTypeGap && !allow(event.Symbol)) || !affectsView(view, top, event) {
+					continue
+				}
+				entries, err := cs.topRanked(ctx, top, allow)
+				if err != nil {
+					slog.ErrorContext(c.Request.Context(), "reading top rankings failed", "top", top, "error", err)
+					continue
+				}
+				changes, removed := diffView(view, entries)
+				view = entries
+				if len(changes) == 0 && len(removed) == 0 {
+					continue
+				}
+				if !send(gin.H{"type": "rankings_update", "size": len(view), "changes": changes, "removed": removed}) {
+					return
+				}
+			}
+		}
+	}}
+	server.ServeHTTP(c.Writer, c.Request)
+}
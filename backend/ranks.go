@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/redis/go-redis/v9"
+	"github.com/shopspring/decimal"
+)
+
+// Postgres advisory lock name (hashed to a lock key) guarding rank recomputation
+const recomputeRanksLock = "bitcoin:recompute-ranks"
+
+// Redis lock guarding POST /api/admin/rankings/rebuild, held at most this long
+const (
+	rebuildRankingsLock    = "rankings-rebuild"
+	rebuildRankingsLockTTL = 5 * time.Minute
+)
+
+// Members are written to the temporary set in pipelines of this many, and rows
+// updated this long before the snapshot are re-applied after the swap
+const (
+	sortedSetBuildChunk    = 1000
+	sortedSetCatchUpMargin = 5 * time.Second
+)
+
+var (
+	ErrRecomputeInProgress = errors.New("rank recomputation already in progress")
+	ErrRebuildInProgress   = errors.New("rankings rebuild already in progress")
+)
+
+type RecomputeResult struct {
+	RowsUpdated  int64 `json:"rows_updated"`
+	DurationMs   int64 `json:"duration_ms"`
+	LockAcquired bool  `json:"lock_acquired"`
+}
+
+// Rebuild the stored rank column for the whole table (e.g. after a bulk import),
+// then refresh the rankings sorted set so both agree
+func (cs *CacheService) RecomputeRanks(ctx context.Context) (*RecomputeResult, error) {
+	if cs.readOnly {
+		return nil, ErrReadOnly
+	}
+
+	tx, err := cs.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Transaction-scoped advisory lock: released on commit, rollback, or when a
+	// crashed instance's connection drops, so it can never go stale
+	var acquired bool
+	if err := tx.QueryRowContext(ctx, `SELECT pg_try_advisory_xact_lock(hashtext($1))`, recomputeRanksLock).Scan(&acquired); err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	if !acquired {
+		return nil, ErrRecomputeInProgress
+	}
+
+	slog.InfoContext(ctx, "recomputing stored ranks")
+	start := time.Now()
+
+	// Single statement so readers never see a half-renumbered table; rows whose
+	// rank is already correct are skipped to keep the write volume down
+	res, err := tx.ExecContext(ctx, `
+		UPDATE bitcoins b
+		SET rank = sub.rn
+		FROM (
+			SELECT symbol,
+				CASE WHEN price IS NOT NULL AND deleted_at IS NULL
+					THEN ROW_NUMBER() OVER (ORDER BY deleted_at IS NULL DESC, price DESC NULLS LAST, symbol ASC) END AS rn
+			FROM bitcoins
+		) sub
+		WHERE b.symbol = sub.symbol
+		  AND b.rank IS DISTINCT FROM sub.rn
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+
+	// Committed: refresh the sorted set even if the request goes away meanwhile
+	if _, err := cs.refreshRankingsSortedSet(cs.ctx); err != nil {
+		slog.ErrorContext(ctx, "refreshing rankings sorted set after recompute failed", "error", err)
+	}
+	cs.invalidateDerived(ctx)
+
+	duration := time.Since(start)
+	slog.InfoContext(ctx, "rank recomputation completed", "rows", rows, "duration_ms", latencyMs(duration))
+
+	return &RecomputeResult{
+		RowsUpdated:  rows,
+		DurationMs:   duration.Milliseconds(),
+		LockAcquired: true,
+	}, nil
+}
+
+type RebuildResult struct {
+	Members    int   `json:"members"`
+	DurationMs int64 `json:"duration_ms"`
+}
+
+// Recovery tool for a sorted set that drifted from Postgres: rebuild it from scratch.
+// Only one instance rebuilds at a time; reads keep using the old set until the swap.
+func (cs *CacheService) RebuildRankingsSortedSet(ctx context.Context) (*RebuildResult, error) {
+	token, err := cs.acquireLock(rebuildRankingsLock, rebuildRankingsLockTTL)
+	if err != nil {
+		return nil, err
+	}
+	if token == "" {
+		return nil, ErrRebuildInProgress
+	}
+	defer func() {
+		if err := cs.releaseLock(rebuildRankingsLock, token); err != nil {
+			slog.ErrorContext(ctx, "releasing lock failed", "lock", rebuildRankingsLock, "error", err)
+		}
+	}()
+
+	start := time.Now()
+	members, err := cs.refreshRankingsSortedSet(ctx)
+	if err != nil {
+		return nil, err
+	}
+	cs.invalidateDerived(ctx)
+
+	duration := time.Since(start)
+	slog.InfoContext(ctx, "rankings sorted set rebuilt", "members", members, "duration_ms", latencyMs(duration))
+	return &RebuildResult{Members: members, DurationMs: duration.Milliseconds()}, nil
+}
+
+// Replace the rankings sorted set with the current prices from the database. The set
+// is built under a temporary key and RENAMEd over the live one, so readers see the old
+// set until the swap and Redis never runs one huge transaction. Returns the member count.
+func (cs *CacheService) refreshRankingsSortedSet(ctx context.Context) (int, error) {
+	// Postgres' clock, for the catch-up below
+	var snapshotAt time.Time
+	if err := cs.db.QueryRowContext(ctx, `SELECT now()`).Scan(&snapshotAt); err != nil {
+		return 0, fmt.Errorf("database error: %w", err)
+	}
+
+	members, err := cs.queryRankMembers(ctx, `SELECT symbol, price FROM bitcoins WHERE price IS NOT NULL AND deleted_at IS NULL`)
+	if err != nil {
+		return 0, err
+	}
+
+	suffix, err := randomHex(8)
+	if err != nil {
+		return 0, fmt.Errorf("failed to generate temporary key: %w", err)
+	}
+	tmpKey := rankSortedSetKey + ":build:" + suffix
+
+	// The temporary set expires on its own if we die before the swap
+	_, err = cs.redisClient.Pipelined(cs.ctx, func(pipe redis.Pipeliner) error {
+		for i := 0; i < len(members); i += sortedSetBuildChunk {
+			pipe.ZAdd(cs.ctx, tmpKey, members[i:min(i+sortedSetBuildChunk, len(members))]...)
+		}
+		pipe.Expire(cs.ctx, tmpKey, rebuildRankingsLockTTL)
+		return nil
+	})
+	if err != nil {
+		cs.redisClient.Del(cs.ctx, tmpKey)
+		return 0, fmt.Errorf("failed to build sorted set: %w", err)
+	}
+
+	// RENAME carries the temporary key's TTL over, hence the PERSIST
+	_, err = cs.redisClient.TxPipelined(cs.ctx, func(pipe redis.Pipeliner) error {
+		if len(members) == 0 {
+			pipe.Del(cs.ctx, rankSortedSetKey)
+		} else {
+			pipe.Rename(cs.ctx, tmpKey, rankSortedSetKey)
+			pipe.Persist(cs.ctx, rankSortedSetKey)
+		}
+		return nil
+	})
+	if err != nil {
+		cs.redisClient.Del(cs.ctx, tmpKey)
+		return 0, fmt.Errorf("failed to swap sorted set: %w", err)
+	}
+
+	// Writes that landed in the live set while we built were just replaced by the
+	// snapshot; re-apply every row updated since it was taken. The margin covers
+	// transactions that started (and so were timestamped) before the snapshot but
+	// committed after it.
+	recent, err := cs.queryRankMembers(ctx, `SELECT symbol, price FROM bitcoins WHERE price IS NOT NULL AND deleted_at IS NULL AND updated_at >= $1`,
+		snapshotAt.Add(-sortedSetCatchUpMargin))
+	if err != nil {
+		slog.ErrorContext(ctx, "catching up rankings sorted set failed", "error", err)
+	} else if len(recent) > 0 {
+		if err := cs.redisClient.ZAdd(cs.ctx, rankSortedSetKey, recent...).Err(); err != nil {
+			slog.ErrorContext(ctx, "catching up rankings sorted set failed", "error", err)
+		}
+	}
+
+	// Likewise a symbol deleted, soft-deleted or unpriced since the snapshot is back in
+	// the set; drop every snapshot member that no longer has a live priced row
+	if err := cs.removeDeletedRankMembers(ctx, members); err != nil {
+		slog.ErrorContext(ctx, "catching up rankings sorted set failed", "error", err)
+	}
+
+	return len(members), nil
+}
+
+func (cs *CacheService) removeDeletedRankMembers(ctx context.Context, members []redis.Z) error {
+	if len(members) == 0 {
+		return nil
+	}
+	symbols := make([]string, len(members))
+	for i, m := range members {
+		symbols[i] = m.Member.(string)
+	}
+
+	rows, err := cs.db.QueryContext(ctx, `
+		SELECT s FROM unnest($1::text[]) AS s
+		WHERE NOT EXISTS (SELECT 1 FROM bitcoins b WHERE b.symbol = s AND b.price IS NOT NULL AND b.deleted_at IS NULL)
+	`, pq.Array(symbols))
+	if err != nil {
+		return fmt.Errorf("failed to query bitcoins: %w", err)
+	}
+	defer rows.Close()
+
+	var deleted []interface{}
+	for rows.Next() {
+		var symbol string
+		if err := rows.Scan(&symbol); err != nil {
+			return fmt.Errorf("scan error: %w", err)
+		}
+		deleted = append(deleted, symbol)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("scan error: %w", err)
+	}
+	if len(deleted) == 0 {
+		return nil
+	}
+	return cs.redisClient.ZRem(cs.ctx, rankSortedSetKey, deleted...).Err()
+}
+
+func (cs *CacheService) queryRankMembers(ctx context.Context, query string, args ...interface{}) ([]redis.Z, error) {
+	rows, err := cs.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query bitcoins: %w", err)
+	}
+	defer rows.Close()
+
+	var members []redis.Z
+	for rows.Next() {
+		var symbol string
+		var price decimal.Decimal
+		if err := rows.Scan(&symbol, &price); err != nil {
+			return nil, fmt.Errorf("scan error: %w", err)
+		}
+		members = append(members, redis.Z{Score: price.InexactFloat64(), Member: symbol})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("scan error: %w", err)
+	}
+	return members, nil
+}
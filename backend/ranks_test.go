@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// While one instance holds the advisory lock, a second RecomputeRanks gets false from
+// pg_try_advisory_xact_lock and gives up without touching the ranks
+func TestRecomputeRanksSkipsWhileLockHeld(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		held     bool
+		updating = make(chan struct{})
+		finish   = make(chan struct{})
+	)
+	cs, _, fdb := newFakeBackedCacheService(t, func(query string, args []driver.Value) (fakeResult, error) {
+		switch {
+		case strings.Contains(query, "pg_try_advisory_xact_lock"):
+			mu.Lock()
+			defer mu.Unlock()
+			acquired := !held
+			held = true
+			return fakeResult{columns: []string{"acquired"}, rows: [][]driver.Value{{acquired}}}, nil
+		case strings.Contains(query, "SET rank"):
+			close(updating)
+			<-finish
+			return fakeResult{affected: 3}, nil
+		}
+		return fakeResult{}, nil
+	})
+
+	type outcome struct {
+		result *RecomputeResult
+		err    error
+	}
+	first := make(chan outcome, 1)
+	go func() {
+		result, err := cs.RecomputeRanks(context.Background())
+		first <- outcome{result, err}
+	}()
+	<-updating
+
+	// The first recompute is mid-UPDATE, holding the lock
+	if result, err := cs.RecomputeRanks(context.Background()); !errors.Is(err, ErrRecomputeInProgress) {
+		t.Errorf("concurrent RecomputeRanks = %+v, %v; want ErrRecomputeInProgress", result, err)
+	}
+
+	close(finish)
+	got := <-first
+	if got.err != nil || !got.result.LockAcquired || got.result.RowsUpdated != 3 {
+		t.Fatalf("first RecomputeRanks = %+v, %v; want 3 rows with the lock acquired", got.result, got.err)
+	}
+	if n := fdb.count("SET rank"); n != 1 {
+		t.Errorf("ranks written %d times, want once", n)
+	}
+}
+
+// A symbol deleted after the snapshot was read is in the temporary set, so the RENAME
+// would bring it back; the catch-up removes it again
+func TestRebuildRankingsSortedSetDropsSymbolsDeletedDuringBuild(t *testing.T) {
+	var (
+		mu   sync.Mutex
+		live = map[string]bool{"A": true, "B": true}
+	)
+	cs, fr, _ := newFakeBackedCacheService(t, func(query string, args []driver.Value) (fakeResult, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case strings.Contains(query, "now()"):
+			return fakeResult{columns: []string{"now"}, rows: [][]driver.Value{{testTime}}}, nil
+		case strings.Contains(query, "NOT EXISTS"):
+			res := fakeResult{columns: []string{"s"}}
+			for _, symbol := range fakeArrayArg(args[0]) {
+				if !live[symbol] {
+					res.rows = append(res.rows, []driver.Value{symbol})
+				}
+			}
+			return res, nil
+		case strings.Contains(query, "updated_at >="):
+			return fakeResult{columns: []string{"symbol", "price"}}, nil
+		case strings.Contains(query, "FROM bitcoins"):
+			res := fakeResult{columns: []string{"symbol", "price"}}
+			for symbol := range live {
+				res.rows = append(res.rows, []driver.Value{symbol, int64(10)})
+			}
+			// B is deleted (and ZREMed from the live set) while the set is built
+			delete(live, "B")
+			return res, nil
+		}
+		return fakeResult{}, nil
+	})
+
+	if _, err := cs.RebuildRankingsSortedSet(context.Background()); err != nil {
+		t.Fatalf("RebuildRankingsSortedSet: %v", err)
+	}
+	if _, ok := fr.zscore(rankSortedSetKey, "A"); !ok {
+		t.Error("A missing from the rebuilt sorted set")
+	}
+	if _, ok := fr.zscore(rankSortedSetKey, "B"); ok {
+		t.Error("B, deleted during the rebuild, is back in the sorted set")
+	}
+}
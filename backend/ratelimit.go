@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	rateLimitPrefix          = "bitcoin:ratelimit:"
+	defaultRateLimitWindow   = time.Minute
+	rateLimitClassRead       = "read"
+	rateLimitClassWrite      = "write"
+	rateLimitClientIPPrefix  = "ip:"
+	rateLimitClientKeyPrefix = "key:"
+)
+
+// Per-client request limits on /api/ routes, counted in fixed windows in Redis so
+// every replica shares them (RATE_LIMIT, RATE_LIMIT_WRITE, RATE_WINDOW). Reads and
+// writes are counted separately, so writes can be held to a stricter limit without
+// eating into a client's reads; read-safe POST routes (see isReadSafe) count as reads.
+// A limit of 0 leaves that class unlimited.
+type rateLimits struct {
+	read   int
+	write  int
+	window time.Duration
+	byKey  bool // Count requests with a valid API key per key rather than per IP (RATE_LIMIT_BY_KEY)
+}
+
+func isWriteMethod(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// Count a request against client's limit for class in the current window. The INCR
+// and EXPIRE go in one MULTI, so a counter can't be left without an expiry. Returns
+// whether it's allowed and, if not, how long until the window resets.
+func (cs *CacheService) consumeRateLimit(class, client string, limit int, window time.Duration) (bool, time.Duration, error) {
+	// Fail open, as for API key quotas
+	if cs.cacheDisabled() {
+		return true, 0, nil
+	}
+
+	now := time.Now()
+	start := now.Truncate(window)
+	counterKey := fmt.Sprintf("%s%s:%s:%d", rateLimitPrefix, class, client, start.Unix())
+
+	pipe := cs.redisClient.TxPipeline()
+	incr := pipe.Incr(cs.ctx, counterKey)
+	pipe.Expire(cs.ctx, counterKey, window)
+	if _, err := pipe.Exec(cs.ctx); err != nil {
+		return false, 0, err
+	}
+
+	if incr.Val() > int64(limit) {
+		return false, start.Add(window).Sub(now), nil
+	}
+	return true, 0, nil
+}
+
+// Enforce limits on /api/ routes; health, readiness and metrics endpoints are never
+// limited. Runs after apiKeyMiddleware so that only an authenticated key, not any
+// X-API-Key value a client makes up, gets its own counter.
+func rateLimitMiddleware(cs *CacheService, limits rateLimits) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !strings.HasPrefix(c.Request.URL.Path, "/api/") {
+			c.Next()
+			return
+		}
+
+		class, limit := rateLimitClassRead, limits.read
+		if !isReadSafe(c) {
+			class, limit = rateLimitClassWrite, limits.write
+		}
+		if limit <= 0 {
+			c.Next()
+			return
+		}
+
+		client := rateLimitClientIPPrefix + c.ClientIP()
+		if key, ok := c.Get(apiKeyContextKey); ok && limits.byKey {
+			client = rateLimitClientKeyPrefix + strconv.FormatInt(key.(*APIKey).ID, 10)
+		}
+
+		// Fail open: a Redis blip shouldn't turn every request away
+		allowed, retryAfter, err := cs.consumeRateLimit(class, client, limit, limits.window)
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "tracking rate limit failed", "client", client, "error", err)
+		} else if !allowed {
+			cs.metrics.rateLimited.WithLabelValues(class).Inc()
+			c.Header("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, errorJSON(CodeRateLimited, "Rate limit exceeded"))
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+)
+
+const (
+	readOrderCacheFirst = "cache-first" // Redis, then the DB on a miss (the default)
+	readOrderDBFirst    = "db-first"    // The DB, backfilling Redis for other instances
+)
+
+// db-first read: query the DB and populate the cache in the background, so the read
+// itself never waits on Redis. The cache is only consulted when the DB read fails.
+func (cs *CacheService) getBitcoinDBFirst(ctx context.Context, symbol string) (*Bitcoin, error) {
+	bitcoin, err := cs.queryBitcoin(ctx, symbol)
+	if err != nil {
+		cached, cacheErr := cs.GetBitcoinCacheOnly(symbol)
+		if cacheErr != nil {
+			return nil, err
+		}
+		slog.WarnContext(ctx, "DB read failed, serving cache", "symbol", symbol, "error", err)
+		return cached, nil
+	}
+
+	go cs.backfillBitcoin(ctx, symbol, bitcoin)
+	return bitcoin, nil
+}
+
+// Cache a DB-first read only where nothing is cached yet (SET NX): a key that exists
+// was written by write-through or invalidated through it, and a read that raced with
+// that write mustn't overwrite it with an older value
+func (cs *CacheService) backfillBitcoin(ctx context.Context, symbol string, bitcoin *Bitcoin) {
+	cacheKey := cs.getBitcoinCacheKey(symbol)
+
+	var err error
+	if bitcoin == nil {
+		err = cs.redisClient.SetNX(cs.ctx, cacheKey, negativeCacheSentinel, cs.ttlFor(keyKindNegative, 1)).Err()
+	} else {
+		data, marshalErr := cs.encodeCacheValue(bitcoin)
+		if marshalErr != nil {
+			cs.marshalFailed(ctx, cacheKey, marshalErr)
+			return
+		}
+		err = cs.redisClient.SetNX(cs.ctx, cacheKey, data, cs.bitcoinTTL(symbol)).Err()
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "backfilling cache failed", "symbol", symbol, "error", err)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Wait for d by yielding in a loop: sleeps are rounded up to the timer granularity,
+// which can be coarser than the latencies simulated here
+func simulateLatency(d time.Duration) {
+	for start := time.Now(); time.Since(start) < d; {
+		runtime.Gosched()
+	}
+}
+
+// A go-redis hook that adds a fixed delay to every round trip, standing in for the
+// network hop to a Redis that isn't local
+type latencyHook time.Duration
+
+func (h latencyHook) DialHook(next redis.DialHook) redis.DialHook {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return next(ctx, network, addr)
+	}
+}
+
+func (h latencyHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		simulateLatency(time.Duration(h))
+		return next(ctx, cmd)
+	}
+}
+
+func (h latencyHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		simulateLatency(time.Duration(h))
+		return next(ctx, cmds)
+	}
+}
+
+// Warm single-symbol reads under each READ_ORDER, with Redis near and PostgreSQL
+// far and the other way round. The delays stand in for the network; the fakes
+// themselves answer in microseconds. Results are recorded in the README's
+// "Read order" section.
+func BenchmarkGetBitcoinReadOrder(b *testing.B) {
+	setups := []struct {
+		name      string
+		redis, db time.Duration
+	}{
+		{"local-redis-remote-db", 100 * time.Microsecond, time.Millisecond},
+		{"remote-redis-local-db", time.Millisecond, 100 * time.Microsecond},
+	}
+	for _, setup := range setups {
+		for _, order := range []string{readOrderCacheFirst, readOrderDBFirst} {
+			b.Run(setup.name+"/"+order, func(b *testing.B) {
+				quietLogs(b)
+				cs, fr, _ := newFakeBackedCacheService(b, func(query string, args []driver.Value) (fakeResult, error) {
+					simulateLatency(setup.db)
+					return fakeResult{columns: bitcoinColumns, rows: [][]driver.Value{bitcoinRow("BTC", "50000")}}, nil
+				})
+				cs.redisClient.AddHook(latencyHook(setup.redis))
+				cs.readOrder = order
+				close(cs.primed)
+				data, _ := cs.encodeCacheValue(Bitcoin{Symbol: "BTC", Price: decimalPtr("50000"), CreatedAt: testTime, UpdatedAt: testTime})
+				fr.set(cs.getBitcoinCacheKey("BTC"), string(data))
+
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					if _, err := cs.GetBitcoin(context.Background(), "BTC", consistencyEventual); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
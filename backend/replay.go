@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// A price observed by the feed at Timestamp, replayed after an outage
+type ReplayRecord struct {
+	Symbol    string           `json:"symbol" binding:"required"`
+	Price     *decimal.Decimal `json:"price" binding:"required"`
+	Timestamp time.Time        `json:"timestamp" binding:"required"`
+}
+
+type ReplayResult struct {
+	Applied int      `json:"applied"`
+	Skipped []string `json:"skipped"` // Symbol@timestamp of records older than the stored row
+}
+
+// Apply replayed prices oldest first. A record only lands if its timestamp is newer
+// than the stored updated_at, so a replay never clobbers fresher data; the replayed
+// timestamp becomes the row's updated_at and the history point's recorded_at.
+func (cs *CacheService) ReplayPrices(ctx context.Context, records []ReplayRecord) (*ReplayResult, error) {
+	if cs.readOnly {
+		return nil, ErrReadOnly
+	}
+
+	sorted := append([]ReplayRecord(nil), records...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Timestamp.Before(sorted[j].Timestamp)
+	})
+
+	result := &ReplayResult{Skipped: []string{}}
+	for _, r := range sorted {
+		bitcoin, previousPrice, err := cs.replayRecord(ctx, r)
+		if err != nil {
+			return nil, fmt.Errorf("replaying %s at %s: %w", r.Symbol, r.Timestamp.Format(time.RFC3339), err)
+		}
+		if bitcoin == nil {
+			result.Skipped = append(result.Skipped, r.Symbol+"@"+r.Timestamp.Format(time.RFC3339))
+			continue
+		}
+
+		cs.writeThroughCache(ctx, bitcoin, previousPrice, 0)
+		result.Applied++
+	}
+
+	slog.InfoContext(ctx, "replay applied", "applied", result.Applied, "records", len(records))
+	return result, nil
+}
+
+// Returns a nil record when the stored row is at least as new as r
+func (cs *CacheService) replayRecord(ctx context.Context, r ReplayRecord) (*Bitcoin, *decimal.Decimal, error) {
+	defer cs.observeDB("replay", time.Now())
+
+	// Columns are TIMESTAMP without zone, written by a server running in UTC
+	ts := r.Timestamp.UTC()
+
+	var bitcoin Bitcoin
+	var previousPrice *decimal.Decimal
+	err := cs.db.QueryRowContext(ctx, `
+		WITH prev AS (
+			SELECT price FROM bitcoins WHERE symbol = $1 AND deleted_at IS NULL
+		), upserted AS (
+			INSERT INTO bitcoins (symbol, price, created_at, updated_at)
+			VALUES ($1, $2, $3, $3)
+			ON CONFLICT (symbol)
+			DO UPDATE SET price = EXCLUDED.price, updated_at = EXCLUDED.updated_at
+			WHERE EXCLUDED.updated_at > bitcoins.updated_at AND bitcoins.deleted_at IS NULL
+			RETURNING symbol, price, supply, created_at, updated_at
+		), history AS (
+			INSERT INTO bitcoin_price_history (symbol, price, recorded_at)
+			SELECT symbol, price, updated_at FROM upserted
+		)
+		SELECT symbol, price, supply, created_at, updated_at, (SELECT price FROM prev) FROM upserted
+	`, r.Symbol, *r.Price, ts).Scan(&bitcoin.Symbol, &bitcoin.Price, &bitcoin.Supply, &bitcoin.CreatedAt, &bitcoin.UpdatedAt, &previousPrice)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("database error: %w", err)
+	}
+
+	return &bitcoin, previousPrice, nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+const defaultMaxResponseBytes = 1 << 20 // 1 MiB
+
+// Page size of GET /api/bitcoins when ?limit= is omitted, and the largest allowed
+const (
+	defaultRankingsPageLimit = 100
+	maxRankingsPageLimit     = 1000
+)
+
+// Parse ?limit= and ?offset= for a ranked list, writing a 400 and returning false if
+// either is malformed or out of range
+func parsePage(c *gin.Context) (limit, offset int, ok bool) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultRankingsPageLimit)))
+	if err != nil || limit < 1 || limit > maxRankingsPageLimit {
+		c.JSON(http.StatusBadRequest, errorJSON(CodeValidationFailed, fmt.Sprintf("limit must be between 1 and %d", maxRankingsPageLimit)))
+		return 0, 0, false
+	}
+	offset, err = strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, errorJSON(CodeValidationFailed, "offset must be a non-negative integer"))
+		return 0, 0, false
+	}
+	return limit, offset, true
+}
+
+// The page of a ranked list starting at offset; empty past the end. Items keep their
+// global rank.
+func pageOf(bitcoins []Bitcoin, limit, offset int) []Bitcoin {
+	if offset >= len(bitcoins) {
+		return []Bitcoin{}
+	}
+	return bitcoins[offset:min(offset+limit, len(bitcoins))]
+}
+
+// Trim a ranked list so its JSON encoding stays within maxBytes. Items are kept
+// in rank order, so a truncated response is always a complete prefix of the list.
+func truncateToBytes(bitcoins []Bitcoin, maxBytes int) ([]Bitcoin, bool) {
+	size := 2 // "[]"
+	for i, b := range bitcoins {
+		data, err := json.Marshal(b)
+		if err != nil {
+			return bitcoins[:i], true
+		}
+		size += len(data)
+		if i > 0 {
+			size++ // ","
+		}
+		if size > maxBytes {
+			return bitcoins[:i], true
+		}
+	}
+	return bitcoins, false
+}
+
+// The list endpoint returns a bare JSON array, so truncation is signalled in headers
+func setTruncationHeaders(c *gin.Context, total, returned int) {
+	c.Header("X-Truncated", "true")
+	c.Header("X-Total-Count", strconv.Itoa(total))
+	c.Header("X-Returned-Count", strconv.Itoa(returned))
+}
+
+// Set on every successful single-symbol write. "false" means the write is committed but
+// Redis may still hold the previous value until it expires (see writeThroughCache).
+const cacheConsistentHeader = "X-Cache-Consistent"
+
+func setCacheConsistent(c *gin.Context, consistent bool) {
+	c.Header(cacheConsistentHeader, strconv.FormatBool(consistent))
+}
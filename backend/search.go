@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+const (
+	defaultSearchTTL = 1 * time.Minute
+	maxSearchResults = 50
+	maxSearchPrefix  = 10 // Symbols are VARCHAR(10)
+)
+
+// Search results are cached per uppercased prefix
+func (cs *CacheService) getSearchCacheKey(prefix string) string {
+	return fmt.Sprintf("%ssearch:%s", cachePrefix, prefix)
+}
+
+// The search keys a write to symbol can affect: one per prefix of it (B, BT, BTC for
+// BTC). Computed directly, so invalidation never has to scan for matching keys.
+func (cs *CacheService) searchKeysFor(symbol string) []string {
+	upper := strings.ToUpper(symbol)
+	keys := make([]string, 0, len(upper))
+	for i := range upper {
+		if i > 0 {
+			keys = append(keys, cs.getSearchCacheKey(upper[:i]))
+		}
+	}
+	return append(keys, cs.getSearchCacheKey(upper))
+}
+
+// Symbols starting with prefix (case-insensitive), in symbol order, at most maxSearchResults
+func (cs *CacheService) SearchBitcoins(ctx context.Context, prefix string) ([]Bitcoin, error) {
+	prefix = strings.ToUpper(prefix)
+	cacheKey := cs.getSearchCacheKey(prefix)
+
+	start := time.Now()
+	cached, err := cs.redisClient.Get(cs.ctx, cacheKey).Result()
+	if err == nil {
+		var bitcoins []Bitcoin
+		if err := decodeCacheValue([]byte(cached), &bitcoins); err != nil {
+			slog.ErrorContext(ctx, "unmarshaling cached search results failed", "prefix", prefix, "error", err)
+		} else {
+			cs.logCacheLookup(cs.ctx, "search", true, start, "prefix", prefix)
+			return bitcoins, nil
+		}
+	}
+
+	cs.logCacheLookup(cs.ctx, "search", false, start, "prefix", prefix)
+
+	bitcoins, err := cs.searchBitcoinsFromDB(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := cs.encodeCacheValue(bitcoins)
+	if err != nil {
+		slog.ErrorContext(ctx, "marshaling search results failed", "prefix", prefix, "error", err)
+	} else if err := cs.redisClient.Set(cs.ctx, cacheKey, data, cs.searchTTL).Err(); err != nil {
+		slog.ErrorContext(ctx, "caching search results failed", "prefix", prefix, "error", err)
+	}
+
+	return bitcoins, nil
+}
+
+func (cs *CacheService) searchBitcoinsFromDB(ctx context.Context, prefix string) ([]Bitcoin, error) {
+	defer cs.observeDB("search", time.Now())
+
+	// Escape LIKE wildcards so "_" and "%" in a prefix match literally
+	pattern := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(prefix) + "%"
+
+	rows, err := cs.db.QueryContext(ctx, `
+		SELECT symbol, price, supply, created_at, updated_at
+		FROM bitcoins
+		WHERE upper(symbol) LIKE $1 AND deleted_at IS NULL
+		ORDER BY symbol
+		LIMIT $2
+	`, pattern, maxSearchResults)
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	defer rows.Close()
+
+	bitcoins := []Bitcoin{}
+	for rows.Next() {
+		var b Bitcoin
+		if err := rows.Scan(&b.Symbol, &b.Price, &b.Supply, &b.CreatedAt, &b.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("scan error: %w", err)
+		}
+		bitcoins = append(bitcoins, b)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("scan error: %w", err)
+	}
+
+	return bitcoins, nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestSearchKeysFor(t *testing.T) {
+	cs := newTestCacheService(t)
+	want := []string{cs.getSearchCacheKey("B"), cs.getSearchCacheKey("BT"), cs.getSearchCacheKey("BTC")}
+	if got := cs.searchKeysFor("btc"); !reflect.DeepEqual(got, want) {
+		t.Errorf("searchKeysFor(btc) = %v, want %v", got, want)
+	}
+}
+
+// Creating BTC clears the cached searches for B, BT and BTC and leaves the others alone
+func TestCreatingSymbolClearsPrefixSearches(t *testing.T) {
+	var created bool
+	cs, fr, _ := newFakeBackedCacheService(t, func(query string, args []driver.Value) (fakeResult, error) {
+		switch {
+		case strings.Contains(query, "ON CONFLICT (symbol)"):
+			created = true
+			return fakeResult{columns: append(bitcoinColumns, "previous_price"), rows: [][]driver.Value{append(bitcoinRow("BTC", "1"), nil)}}, nil
+		case strings.Contains(query, "LIKE"):
+			res := fakeResult{columns: bitcoinColumns}
+			if created && strings.HasPrefix("BTC", strings.TrimSuffix(args[0].(string), "%")) {
+				res.rows = append(res.rows, bitcoinRow("BTC", "1"))
+			}
+			return res, nil
+		}
+		return fakeResult{}, nil
+	})
+
+	for _, prefix := range []string{"b", "BT", "btc", "E"} {
+		if results, err := cs.SearchBitcoins(context.Background(), prefix); err != nil || len(results) != 0 {
+			t.Fatalf("search %q before the create: %v, %v", prefix, results, err)
+		}
+	}
+	for _, prefix := range []string{"B", "BT", "BTC", "E"} {
+		if !fr.exists(cs.getSearchCacheKey(prefix)) {
+			t.Fatalf("search for %s not cached", prefix)
+		}
+	}
+
+	if _, _, err := cs.SetBitcoin(context.Background(), "BTC", decimal.RequireFromString("1"), nil, 0); err != nil {
+		t.Fatalf("SetBitcoin: %v", err)
+	}
+
+	for _, prefix := range []string{"B", "BT", "BTC"} {
+		if fr.exists(cs.getSearchCacheKey(prefix)) {
+			t.Errorf("cached search for %s survived the create", prefix)
+		}
+	}
+	if !fr.exists(cs.getSearchCacheKey("E")) {
+		t.Error("cached search for the unrelated prefix E was cleared")
+	}
+
+	results, err := cs.SearchBitcoins(context.Background(), "bt")
+	if err != nil || len(results) != 1 || results[0].Symbol != "BTC" {
+		t.Errorf("search for bt after the create = %v, %v; want BTC", results, err)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+const rankSnapshotCheckInterval = 1 * time.Hour
+
+func (cs *CacheService) getRankChangeCacheKey(version string) string {
+	return fmt.Sprintf("%s:rank_change:v%s", rankCacheKey, version)
+}
+
+// Record today's ranks unless another instance already has. Safe to run from every
+// replica: the (snapshot_date, symbol) key makes repeats no-ops.
+func (cs *CacheService) SnapshotRanks(ctx context.Context) error {
+	if cs.readOnly {
+		return ErrReadOnly
+	}
+
+	res, err := cs.db.ExecContext(ctx, `
+		INSERT INTO rank_snapshots (snapshot_date, symbol, rank)
+		SELECT CURRENT_DATE, symbol, ROW_NUMBER() OVER (ORDER BY price DESC, symbol ASC)
+		FROM bitcoins
+		WHERE price IS NOT NULL AND deleted_at IS NULL
+		ON CONFLICT (snapshot_date, symbol) DO NOTHING
+	`)
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	if rows > 0 {
+		slog.InfoContext(cs.ctx, "rank snapshot taken", "symbols", rows)
+		// Today's snapshot doesn't change rank_change (it compares against earlier
+		// days), but tomorrow it will, so drop any cached variant now
+		cs.invalidateDerived(cs.ctx)
+	}
+	return nil
+}
+
+// Take the daily snapshot at startup and then hourly (so a new day is picked up
+// within the hour) until ctx is cancelled
+func (cs *CacheService) RunRankSnapshots(ctx context.Context) {
+	ticker := time.NewTicker(rankSnapshotCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := cs.SnapshotRanks(ctx); err != nil {
+			slog.ErrorContext(ctx, "taking rank snapshot failed", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Rankings with rank_change against the most recent snapshot before today:
+// positive means the symbol moved up. Symbols absent from that snapshot (newly
+// listed) have no rank_change.
+func (cs *CacheService) GetBitcoinsRankedWithChange(ctx context.Context) ([]Bitcoin, error) {
+	version := cs.rankingsVersion()
+	return cs.getVersionedRankings(ctx, cs.getRankChangeCacheKey(version), "rank change", func() ([]Bitcoin, error) {
+		bitcoins, err := cs.GetBitcoinsRanked(ctx, consistencyEventual, priceRange{})
+		if err != nil {
+			return nil, err
+		}
+
+		previous, err := cs.previousSnapshotRanks(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		for i := range bitcoins {
+			prior, ok := previous[bitcoins[i].Symbol]
+			if !ok || bitcoins[i].Rank == nil {
+				continue
+			}
+			change := prior - *bitcoins[i].Rank
+			bitcoins[i].RankChange = &change
+		}
+		return bitcoins, nil
+	})
+}
+
+func (cs *CacheService) previousSnapshotRanks(ctx context.Context) (map[string]int, error) {
+	rows, err := cs.db.QueryContext(ctx, `
+		SELECT symbol, rank
+		FROM rank_snapshots
+		WHERE snapshot_date = (
+			SELECT MAX(snapshot_date) FROM rank_snapshots WHERE snapshot_date < CURRENT_DATE
+		)
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	defer rows.Close()
+
+	ranks := make(map[string]int)
+	for rows.Next() {
+		var symbol string
+		var rank int
+		if err := rows.Scan(&symbol, &rank); err != nil {
+			return nil, fmt.Errorf("scan error: %w", err)
+		}
+		ranks[symbol] = rank
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("scan error: %w", err)
+	}
+
+	return ranks, nil
+}
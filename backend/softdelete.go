@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// DELETE only tombstones a symbol: deleted_at is set and every read, ranking and
+// aggregate skips the row, but it can be brought back with RestoreBitcoin. Writing a
+// price for a tombstoned symbol recreates it, with a fresh created_at. DELETE with
+// hard=true removes the row for good, for purges that must not leave data behind.
+
+// created_at for an upsert: kept for a live row, reset when the write recreates a
+// tombstoned one. Shared by the upserts in SetBitcoin and SetBitcoinsBatch.
+const recreatedCreatedAt = `CASE WHEN bitcoins.deleted_at IS NULL THEN bitcoins.created_at ELSE CURRENT_TIMESTAMP END`
+
+// Read a soft-deleted bitcoin from the database (nil if the symbol isn't tombstoned)
+func (cs *CacheService) GetDeletedBitcoin(ctx context.Context, symbol string) (*Bitcoin, error) {
+	symbol, err := normalizeSymbol(symbol)
+	if err != nil {
+		return nil, err
+	}
+	defer cs.observeDB("get_deleted", time.Now())
+
+	var bitcoin Bitcoin
+	err = cs.db.QueryRowContext(ctx, `
+		SELECT symbol, price, supply, created_at, updated_at, deleted_at
+		FROM bitcoins
+		WHERE symbol = $1 AND deleted_at IS NOT NULL
+	`, symbol).Scan(&bitcoin.Symbol, &bitcoin.Price, &bitcoin.Supply, &bitcoin.CreatedAt, &bitcoin.UpdatedAt, &bitcoin.DeletedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	return &bitcoin, nil
+}
+
+// Undo a soft delete and cache the record again, as a write would. Returns nil if the
+// symbol isn't tombstoned; cacheConsistent is as for SetBitcoin.
+func (cs *CacheService) RestoreBitcoin(ctx context.Context, symbol string) (restored *Bitcoin, cacheConsistent bool, err error) {
+	if cs.readOnly {
+		return nil, false, ErrReadOnly
+	}
+	symbol, err = normalizeSymbol(symbol)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var bitcoin Bitcoin
+	start := time.Now()
+	err = cs.db.QueryRowContext(ctx, `
+		UPDATE bitcoins SET deleted_at = NULL
+		WHERE symbol = $1 AND deleted_at IS NOT NULL
+		RETURNING symbol, price, supply, created_at, updated_at
+	`, symbol).Scan(&bitcoin.Symbol, &bitcoin.Price, &bitcoin.Supply, &bitcoin.CreatedAt, &bitcoin.UpdatedAt)
+	cs.observeDB("restore", start)
+
+	if err == sql.ErrNoRows {
+		return nil, true, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("database error: %w", err)
+	}
+
+	// Overwrites the not-found marker a read may have cached while it was deleted
+	cacheConsistent = cs.writeThroughCache(ctx, &bitcoin, nil, 0)
+
+	slog.InfoContext(ctx, "restored", "symbol", symbol)
+	return &bitcoin, cacheConsistent, nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+)
+
+// ErrLoadTimeout is returned to callers that gave up waiting on a shared cache-miss load
+var ErrLoadTimeout = errors.New("timed out waiting for the database")
+
+// Run load once per key ("bitcoin:<SYMBOL>" for a record, "rankings" for the ranked
+// list) at a time: concurrent misses for the same key share a single
+// DB read and its result (or error). With STAMPEDE_MAX_WAIT set, every caller stops
+// waiting after that long and gets ErrLoadTimeout; the load itself carries on and
+// still fills the cache for later requests. A caller whose ctx ends stops waiting
+// with ctx's error. The load gets a context detached from the caller's cancellation,
+// since other callers share its result.
+func (cs *CacheService) sharedLoad(ctx context.Context, key string, load func(context.Context) (interface{}, error)) (interface{}, error) {
+	loadCtx := context.WithoutCancel(ctx)
+	results := cs.loads.DoChan(key, func() (interface{}, error) {
+		return load(loadCtx)
+	})
+
+	var timeout <-chan time.Time
+	if cs.stampedeMaxWait > 0 {
+		timer := time.NewTimer(cs.stampedeMaxWait)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case res := <-results:
+		return res.Val, res.Err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-timeout:
+		slog.WarnContext(ctx, "gave up waiting for shared load", "key", key, "max_wait", cs.stampedeMaxWait.String())
+		cs.metrics.stampedeTimeouts.Inc()
+		return nil, ErrLoadTimeout
+	}
+}
+
+func (cs *CacheService) loadBitcoinShared(ctx context.Context, symbol string) (*Bitcoin, error) {
+	v, err := cs.sharedLoad(ctx, "bitcoin:"+symbol, func(ctx context.Context) (interface{}, error) {
+		return cs.loadBitcoin(ctx, symbol)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*Bitcoin), nil
+}
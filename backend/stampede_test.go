@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const stampedeCallers = 100
+
+// A fake DB whose bitcoin reads block until release is closed, then answer with row
+// (or err); reads counts how many reached the database
+func blockingBitcoinDB(release <-chan struct{}, reads *int32, row []driver.Value, err error) fakeHandler {
+	return func(query string, args []driver.Value) (fakeResult, error) {
+		atomic.AddInt32(reads, 1)
+		<-release
+		if err != nil {
+			return fakeResult{}, err
+		}
+		return fakeResult{columns: bitcoinColumns, rows: [][]driver.Value{row}}, nil
+	}
+}
+
+// Start stampedeCallers concurrent GetBitcoin calls for a cold BTC and let the load
+// finish once they have all missed the cache
+func stampedeColdKey(t *testing.T, cs *CacheService, fr *fakeRedis, release chan struct{}) ([]*Bitcoin, []error) {
+	t.Helper()
+	close(cs.primed)
+
+	bitcoins := make([]*Bitcoin, stampedeCallers)
+	errs := make([]error, stampedeCallers)
+	var wg sync.WaitGroup
+	for i := 0; i < stampedeCallers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			bitcoins[i], errs[i] = cs.GetBitcoin(context.Background(), "BTC", consistencyEventual)
+		}(i)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for fr.count("GET") < stampedeCallers {
+		if time.Now().After(deadline) {
+			t.Fatalf("only %d of %d callers reached the cache", fr.count("GET"), stampedeCallers)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	// Every caller has missed; give the last ones time to join the shared load
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	return bitcoins, errs
+}
+
+func TestColdKeyStampedeQueriesOnce(t *testing.T) {
+	release := make(chan struct{})
+	var reads int32
+	cs, fr, _ := newFakeBackedCacheService(t, blockingBitcoinDB(release, &reads, bitcoinRow("BTC", "50000"), nil))
+
+	bitcoins, errs := stampedeColdKey(t, cs, fr, release)
+
+	if n := atomic.LoadInt32(&reads); n != 1 {
+		t.Errorf("database queried %d times for %d concurrent misses, want 1", n, stampedeCallers)
+	}
+	for i := range bitcoins {
+		if errs[i] != nil {
+			t.Errorf("caller %d: %v", i, errs[i])
+			continue
+		}
+		if bitcoins[i] == nil || bitcoins[i].Symbol != "BTC" {
+			t.Errorf("caller %d got %v, want BTC", i, bitcoins[i])
+		}
+	}
+	if !fr.exists(cs.getBitcoinCacheKey("BTC")) {
+		t.Error("shared load did not fill the cache")
+	}
+}
+
+func TestColdKeyStampedeSharesError(t *testing.T) {
+	release := make(chan struct{})
+	var reads int32
+	dbErr := errors.New("relation \"bitcoins\" does not exist")
+	cs, fr, _ := newFakeBackedCacheService(t, blockingBitcoinDB(release, &reads, nil, dbErr))
+
+	_, errs := stampedeColdKey(t, cs, fr, release)
+
+	if n := atomic.LoadInt32(&reads); n != 1 {
+		t.Errorf("database queried %d times for %d concurrent misses, want 1", n, stampedeCallers)
+	}
+	for i, err := range errs {
+		if !errors.Is(err, dbErr) {
+			t.Errorf("caller %d got %v, want the shared load's error", i, err)
+		}
+	}
+}
+
+// With STAMPEDE_MAX_WAIT set, callers behind a slow load give up with ErrLoadTimeout,
+// while the load itself finishes and fills the cache for the next request
+func TestStampedeMaxWaitGivesUpOnSlowDB(t *testing.T) {
+	release := make(chan struct{})
+	var reads int32
+	cs, fr, _ := newFakeBackedCacheService(t, blockingBitcoinDB(release, &reads, bitcoinRow("BTC", "50000"), nil))
+	cs.stampedeMaxWait = 20 * time.Millisecond
+	close(cs.primed)
+
+	var wg sync.WaitGroup
+	errs := make([]error, 10)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = cs.GetBitcoin(context.Background(), "BTC", consistencyEventual)
+		}(i)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if !errors.Is(err, ErrLoadTimeout) {
+			t.Errorf("caller %d got %v, want ErrLoadTimeout", i, err)
+		}
+	}
+	if code := codeFor(ErrLoadTimeout); code != CodeOverloaded {
+		t.Errorf("codeFor(ErrLoadTimeout) = %s, want %s", code, CodeOverloaded)
+	}
+	if n := counterValue(t, cs.metrics.stampedeTimeouts); n != float64(len(errs)) {
+		t.Errorf("stampede timeouts = %v, want %d", n, len(errs))
+	}
+
+	close(release)
+	waitFor(t, "the abandoned load to fill the cache", func() bool { return fr.exists(cs.getBitcoinCacheKey("BTC")) })
+	bitcoin, err := cs.GetBitcoin(context.Background(), "BTC", consistencyEventual)
+	if err != nil || bitcoin == nil || bitcoin.Symbol != "BTC" {
+		t.Errorf("read after the load finished = %v, %v; want BTC from the cache", bitcoin, err)
+	}
+	if n := atomic.LoadInt32(&reads); n != 1 {
+		t.Errorf("database queried %d times, want 1", n)
+	}
+}
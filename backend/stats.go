@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+const (
+	tagStatsCacheKey = "bitcoin:stats:by-tag"
+	defaultStatsTTL  = 30 * time.Second // Shared by every /api/stats aggregate
+	untaggedBucket   = "untagged"       // Symbols with no tags are aggregated under this name
+)
+
+// Aggregate over the symbols carrying one tag
+type TagStats struct {
+	Count        int             `json:"count"`
+	TotalPrice   decimal.Decimal `json:"total_price"`
+	AveragePrice float64         `json:"average_price"`
+}
+
+// Per-tag aggregates keyed by tag. A symbol with several tags counts toward each of them.
+func (cs *CacheService) GetTagStats(ctx context.Context) (map[string]TagStats, error) {
+	start := time.Now()
+	cached, err := cs.redisClient.Get(cs.ctx, tagStatsCacheKey).Result()
+	if err == nil {
+		var stats map[string]TagStats
+		if err := decodeCacheValue([]byte(cached), &stats); err != nil {
+			slog.ErrorContext(ctx, "unmarshaling cached tag stats failed", "error", err)
+		} else {
+			cs.logCacheLookup(cs.ctx, "stats", true, start, "stat", "by_tag")
+			return stats, nil
+		}
+	}
+
+	cs.logCacheLookup(cs.ctx, "stats", false, start, "stat", "by_tag")
+
+	// Same compare-and-set as the rankings payload, so a write landing mid-query isn't masked
+	version := cs.rankingsVersion()
+
+	stats, err := cs.getTagStatsFromDB(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := cs.encodeCacheValue(stats)
+	if err != nil {
+		slog.ErrorContext(ctx, "marshaling tag stats failed", "error", err)
+		return stats, nil
+	}
+	stored, err := setIfVersionScript.Run(cs.ctx, cs.redisClient,
+		[]string{rankVersionKey, tagStatsCacheKey, rankingsPendingKey}, version, data, cs.statsTTL.Milliseconds()).Int()
+	if err != nil {
+		slog.ErrorContext(ctx, "caching tag stats failed", "error", err)
+	} else if stored == 0 {
+		slog.InfoContext(ctx, "tag stats changed while rebuilding, not caching stale result")
+	}
+
+	return stats, nil
+}
+
+func (cs *CacheService) getTagStatsFromDB(ctx context.Context) (map[string]TagStats, error) {
+	defer cs.observeDB("tag_stats", time.Now())
+
+	rows, err := cs.db.QueryContext(ctx, `
+		SELECT COALESCE(t.tag, $1), COUNT(*), SUM(b.price), AVG(b.price)::float8
+		FROM bitcoins b
+		LEFT JOIN symbol_tags t ON t.symbol = b.symbol
+		WHERE b.price IS NOT NULL AND b.deleted_at IS NULL
+		GROUP BY 1
+	`, untaggedBucket)
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	defer rows.Close()
+
+	stats := map[string]TagStats{}
+	for rows.Next() {
+		var tag string
+		var s TagStats
+		if err := rows.Scan(&tag, &s.Count, &s.TotalPrice, &s.AveragePrice); err != nil {
+			return nil, fmt.Errorf("scan error: %w", err)
+		}
+		stats[tag] = s
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("scan error: %w", err)
+	}
+
+	return stats, nil
+}
+
+const (
+	defaultHistogramBuckets = 10
+	maxHistogramBuckets     = 100
+)
+
+// Price range [Lower, Upper); the last bucket also includes Upper (the maximum price)
+type HistogramBucket struct {
+	Lower float64 `json:"lower"`
+	Upper float64 `json:"upper"`
+	Count int     `json:"count"`
+}
+
+type Histogram struct {
+	Min     *decimal.Decimal  `json:"min"` // nil when there are no prices
+	Max     *decimal.Decimal  `json:"max"`
+	Buckets []HistogramBucket `json:"buckets"`
+}
+
+// Histogram cache keys embed the rankings version, so any write makes them unreachable
+func (cs *CacheService) getHistogramCacheKey(buckets int, version string) string {
+	return fmt.Sprintf("%sstats:histogram:%d:v%s", cachePrefix, buckets, version)
+}
+
+// Price distribution over equal-width buckets spanning the min..max price. When every
+// price is the same there is a single zero-width bucket.
+func (cs *CacheService) PriceHistogram(ctx context.Context, buckets int) (*Histogram, error) {
+	cacheKey := cs.getHistogramCacheKey(buckets, cs.rankingsVersion())
+
+	start := time.Now()
+	cached, err := cs.redisClient.Get(cs.ctx, cacheKey).Result()
+	if err == nil {
+		var histogram Histogram
+		if err := decodeCacheValue([]byte(cached), &histogram); err != nil {
+			slog.ErrorContext(ctx, "unmarshaling cached histogram failed", "error", err)
+		} else {
+			cs.logCacheLookup(cs.ctx, "stats", true, start, "stat", "histogram", "buckets", buckets)
+			return &histogram, nil
+		}
+	}
+
+	cs.logCacheLookup(cs.ctx, "stats", false, start, "stat", "histogram", "buckets", buckets)
+
+	histogram, err := cs.getPriceHistogramFromDB(ctx, buckets)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := cs.encodeCacheValue(histogram)
+	if err != nil {
+		slog.ErrorContext(ctx, "marshaling histogram failed", "error", err)
+	} else if cs.invalidationPending() {
+		slog.InfoContext(ctx, "invalidation pending, not caching histogram")
+	} else if err := cs.redisClient.Set(cs.ctx, cacheKey, data, cs.statsTTL).Err(); err != nil {
+		slog.ErrorContext(ctx, "caching histogram failed", "error", err)
+	}
+
+	return histogram, nil
+}
+
+func (cs *CacheService) getPriceHistogramFromDB(ctx context.Context, buckets int) (*Histogram, error) {
+	defer cs.observeDB("histogram", time.Now())
+
+	// width_bucket puts the maximum itself in bucket n+1, so clamp it into the last
+	// bucket; it also rejects equal bounds, hence the CASE for a single distinct price
+	rows, err := cs.db.QueryContext(ctx, `
+		WITH bounds AS (
+			SELECT MIN(price) AS lo, MAX(price) AS hi FROM bitcoins WHERE deleted_at IS NULL
+		)
+		SELECT b.lo, b.hi,
+			CASE WHEN b.lo = b.hi THEN 1
+				ELSE LEAST(width_bucket(p.price::float8, b.lo::float8, b.hi::float8, $1::int), $1::int)
+			END AS bucket,
+			COUNT(*)
+		FROM bitcoins p
+		CROSS JOIN bounds b
+		WHERE p.price IS NOT NULL AND p.deleted_at IS NULL
+		GROUP BY b.lo, b.hi, bucket
+		ORDER BY bucket
+	`, buckets)
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	defer rows.Close()
+
+	histogram := &Histogram{Buckets: []HistogramBucket{}}
+	counts := make(map[int]int, buckets)
+	for rows.Next() {
+		var lo, hi decimal.Decimal
+		var bucket, count int
+		if err := rows.Scan(&lo, &hi, &bucket, &count); err != nil {
+			return nil, fmt.Errorf("scan error: %w", err)
+		}
+		histogram.Min, histogram.Max = &lo, &hi
+		counts[bucket] = count
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("scan error: %w", err)
+	}
+
+	if histogram.Min == nil {
+		return histogram, nil
+	}
+
+	lo, hi := histogram.Min.InexactFloat64(), histogram.Max.InexactFloat64()
+	if lo == hi {
+		histogram.Buckets = append(histogram.Buckets, HistogramBucket{Lower: lo, Upper: hi, Count: counts[1]})
+		return histogram, nil
+	}
+
+	width := (hi - lo) / float64(buckets)
+	for i := 1; i <= buckets; i++ {
+		histogram.Buckets = append(histogram.Buckets, HistogramBucket{
+			Lower: lo + float64(i-1)*width,
+			Upper: lo + float64(i)*width,
+			Count: counts[i],
+		})
+	}
+	histogram.Buckets[buckets-1].Upper = hi
+
+	return histogram, nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// How long streaming clients get to disconnect after being told the server is
+// shutting down, before their streams are closed (STREAM_DRAIN_GRACE)
+const defaultStreamDrainGrace = 5 * time.Second
+
+// Long-lived streaming responses (SSE), tracked so shutdown can end them instead of
+// waiting on them: http.Server.Shutdown only waits for active connections, and a
+// stream never finishes on its own.
+type streamRegistry struct {
+	active    sync.WaitGroup
+	count     atomic.Int64
+	draining  chan struct{}
+	drainOnce sync.Once
+	force     context.Context
+	forceStop context.CancelFunc
+}
+
+func newStreamRegistry() *streamRegistry {
+	force, forceStop := context.WithCancel(context.Background())
+	return &streamRegistry{draining: make(chan struct{}), force: force, forceStop: forceStop}
+}
+
+// Register a stream. The returned context ends when the client goes away or the
+// stream is force-closed; draining is closed once shutdown begins, when the handler
+// should tell its client to reconnect elsewhere. Call done when the handler returns.
+func (r *streamRegistry) open(ctx context.Context) (streamCtx context.Context, draining <-chan struct{}, done func()) {
+	r.active.Add(1)
+	r.count.Add(1)
+
+	streamCtx, cancel := context.WithCancel(ctx)
+	stop := context.AfterFunc(r.force, cancel)
+
+	return streamCtx, r.draining, func() {
+		stop()
+		cancel()
+		r.count.Add(-1)
+		r.active.Done()
+	}
+}
+
+// Signal every stream to ask its client to reconnect, wait up to grace for them to
+// disconnect, then close whatever is left
+func (r *streamRegistry) drain(grace time.Duration) {
+	r.drainOnce.Do(func() { close(r.draining) })
+
+	n := r.count.Load()
+	if n == 0 {
+		return
+	}
+	slog.Info("draining streams", "streams", n, "grace", grace.String())
+
+	finished := make(chan struct{})
+	go func() {
+		r.active.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+		slog.Info("all streams disconnected")
+	case <-time.After(grace):
+		slog.Warn("force-closing streams still open after the grace period", "streams", r.count.Load(), "grace", grace.String())
+		r.forceStop()
+	}
+}
+
+// Serve change events as SSE until the client goes away or the stream is closed.
+// After a dropped subscription a gap event carrying gapMessage is sent; every other
+// event goes to send, which writes it with c.SSEvent or skips it.
+func serveChangeStream(c *gin.Context, cs *CacheService, streams *streamRegistry, gapMessage string, send func(ChangeEvent)) {
+	ctx, draining, done := streams.open(c.Request.Context())
+	defer done()
+	events := cs.SubscribeChanges(ctx)
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-draining:
+			// Sent once; events keep flowing until the client leaves or the grace period ends
+			c.SSEvent("shutdown", gin.H{"message": "Server is shutting down; reconnect to continue"})
+			draining = nil
+			return true
+		case event, ok := <-events:
+			if !ok {
+				return false
+			}
+			if event.Type == eventTypeGap {
+				c.SSEvent("gap", gin.H{"message": gapMessage})
+				return true
+			}
+			send(event)
+			return true
+		}
+	})
+}
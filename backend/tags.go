@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+var validTag = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,31}$`)
+
+// Lowercase and validate a tag name (e.g. "DeFi" -> "defi")
+func normalizeTag(tag string) (string, error) {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if !validTag.MatchString(tag) {
+		return "", fmt.Errorf("invalid tag %q: use 1-32 lowercase letters, digits or dashes", tag)
+	}
+	if tag == untaggedBucket {
+		return "", fmt.Errorf("tag %q is reserved", tag)
+	}
+	return tag, nil
+}
+
+// Per-tag rankings are keyed by the rankings version, so every price write or tag
+// change (both bump the version) makes old entries unreachable without deleting them
+func (cs *CacheService) getTagRankingsCacheKey(tag, version string) string {
+	return fmt.Sprintf("%s:tag:%s:v%s", rankCacheKey, tag, version)
+}
+
+// Rankings filtered to one tag. Ranks are the global ranks, not renumbered within the tag.
+func (cs *CacheService) GetBitcoinsRankedByTag(ctx context.Context, tag string) ([]Bitcoin, error) {
+	version := cs.rankingsVersion()
+	return cs.getVersionedRankings(ctx, cs.getTagRankingsCacheKey(tag, version), "tag "+tag, func() ([]Bitcoin, error) {
+		return cs.getBitcoinsRankedByTagFromDB(ctx, tag)
+	})
+}
+
+func (cs *CacheService) getBitcoinsRankedByTagFromDB(ctx context.Context, tag string) ([]Bitcoin, error) {
+	rows, err := cs.db.QueryContext(ctx, `
+		SELECT r.symbol, r.price, r.supply, r.created_at, r.updated_at, r.rank
+		FROM (
+			SELECT symbol, price, supply, created_at, updated_at,
+				ROW_NUMBER() OVER (ORDER BY price DESC, symbol ASC) AS rank
+			FROM bitcoins
+			WHERE price IS NOT NULL AND deleted_at IS NULL
+		) r
+		JOIN symbol_tags t ON t.symbol = r.symbol
+		WHERE t.tag = $1
+		ORDER BY r.rank
+	`, tag)
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	defer rows.Close()
+
+	bitcoins := []Bitcoin{}
+	for rows.Next() {
+		var b Bitcoin
+		if err := rows.Scan(&b.Symbol, &b.Price, &b.Supply, &b.CreatedAt, &b.UpdatedAt, &b.Rank); err != nil {
+			return nil, fmt.Errorf("scan error: %w", err)
+		}
+		bitcoins = append(bitcoins, b)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("scan error: %w", err)
+	}
+
+	return bitcoins, nil
+}
+
+// Add tags to a symbol and return its full tag list (nil if the symbol doesn't exist)
+func (cs *CacheService) AddSymbolTags(ctx context.Context, symbol string, tags []string) ([]string, error) {
+	if cs.readOnly {
+		return nil, ErrReadOnly
+	}
+
+	_, err := cs.db.ExecContext(ctx, `
+		INSERT INTO symbol_tags (symbol, tag)
+		SELECT $1, unnest($2::text[])
+		ON CONFLICT DO NOTHING
+	`, symbol, pq.Array(tags))
+
+	// foreign_key_violation: the symbol itself doesn't exist
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23503" {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+
+	// Tag membership changed, so cached per-tag rankings are stale
+	cs.invalidateDerived(ctx)
+
+	rows, err := cs.db.QueryContext(ctx, `SELECT tag FROM symbol_tags WHERE symbol = $1 ORDER BY tag`, symbol)
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	defer rows.Close()
+
+	all := []string{}
+	for rows.Next() {
+		var tag string
+		if err := rows.Scan(&tag); err != nil {
+			return nil, fmt.Errorf("scan error: %w", err)
+		}
+		all = append(all, tag)
+	}
+
+	slog.InfoContext(ctx, "tags updated", "symbol", symbol, "tags", all)
+	return all, rows.Err()
+}
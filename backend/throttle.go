@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const throttleKeyPrefix = "bitcoin:throttle:"
+
+// Returned by SetBitcoin when the symbol was updated less than MIN_UPDATE_INTERVAL ago
+type ThrottledError struct {
+	Symbol     string
+	RetryAfter time.Duration
+}
+
+func (e *ThrottledError) Error() string {
+	return fmt.Sprintf("%s was updated too recently, retry in %s", e.Symbol, e.RetryAfter)
+}
+
+// Claim the symbol's update slot for minUpdateInterval. SET NX makes the check and
+// the claim one atomic step across replicas, and the first update always gets the
+// slot. Fails open if Redis is unavailable: throttling is protection, not correctness.
+func (cs *CacheService) claimUpdateSlot(ctx context.Context, symbol string) error {
+	if cs.minUpdateInterval <= 0 || cs.cacheWritesDisabled() {
+		return nil
+	}
+
+	key := throttleKeyPrefix + symbol
+	claimed, err := cs.redisClient.SetNX(cs.ctx, key, 1, cs.minUpdateInterval).Result()
+	if err != nil {
+		slog.ErrorContext(ctx, "checking update interval failed, allowing update", "symbol", symbol, "error", err)
+		return nil
+	}
+	if claimed {
+		return nil
+	}
+
+	retryAfter, err := cs.redisClient.PTTL(cs.ctx, key).Result()
+	if err != nil || retryAfter <= 0 {
+		retryAfter = cs.minUpdateInterval
+	}
+	return &ThrottledError{Symbol: symbol, RetryAfter: retryAfter}
+}
+
+// Give the slot back when the update it was claimed for didn't happen
+func (cs *CacheService) releaseUpdateSlot(ctx context.Context, symbol string) {
+	if cs.minUpdateInterval <= 0 || cs.cacheWritesDisabled() {
+		return
+	}
+	if err := cs.redisClient.Del(cs.ctx, throttleKeyPrefix+symbol).Err(); err != nil {
+		slog.ErrorContext(ctx, "releasing update slot failed", "symbol", symbol, "error", err)
+	}
+}
+
+// Answer a *ThrottledError with 429 and Retry-After; false (nothing written) for any other error
+func writeThrottled(c *gin.Context, err error) bool {
+	var throttled *ThrottledError
+	if !errors.As(err, &throttled) {
+		return false
+	}
+	c.Header("Retry-After", strconv.Itoa(int(throttled.RetryAfter.Seconds())+1))
+	c.JSON(http.StatusTooManyRequests, gin.H{
+		"error":          throttled.Error(),
+		"code":           CodeRateLimited,
+		"retry_after_ms": throttled.RetryAfter.Milliseconds(),
+	})
+	return true
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopspring/decimal"
+)
+
+// A fake single-symbol upsert that fails while failing is set
+func upsertOneDB(failing *bool) fakeHandler {
+	return func(query string, args []driver.Value) (fakeResult, error) {
+		if *failing {
+			return fakeResult{}, errors.New("deadlock detected")
+		}
+		if len(args) == 0 {
+			return fakeResult{}, nil
+		}
+		symbol, _ := args[0].(string)
+		row := append(bitcoinRow(symbol, "1"), nil)
+		return fakeResult{columns: append(bitcoinColumns, "previous_price"), rows: [][]driver.Value{row}}, nil
+	}
+}
+
+func TestSetBitcoinThrottlesRapidUpdates(t *testing.T) {
+	var failing bool
+	cs, _, fdb := newFakeBackedCacheService(t, upsertOneDB(&failing))
+	cs.minUpdateInterval = time.Minute
+
+	// The first update is never throttled; every other one within the interval is
+	if _, _, err := cs.SetBitcoin(context.Background(), "BTC", decimal.RequireFromString("1"), nil, 0); err != nil {
+		t.Fatalf("first update: %v", err)
+	}
+	var throttled *ThrottledError
+	for i := 0; i < 20; i++ {
+		_, _, err := cs.SetBitcoin(context.Background(), "BTC", decimal.RequireFromString("1"), nil, 0)
+		if !errors.As(err, &throttled) {
+			t.Fatalf("update %d: err = %v, want *ThrottledError", i+2, err)
+		}
+	}
+	if throttled.Symbol != "BTC" || throttled.RetryAfter <= 0 || throttled.RetryAfter > time.Minute {
+		t.Errorf("throttled = %+v, want BTC with a retry within a minute", throttled)
+	}
+	if n := fdb.count("ON CONFLICT (symbol)"); n != 1 {
+		t.Errorf("%d upserts, want 1", n)
+	}
+
+	// Other symbols have their own slot
+	if _, _, err := cs.SetBitcoin(context.Background(), "ETH", decimal.RequireFromString("1"), nil, 0); err != nil {
+		t.Errorf("update of another symbol: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	if !writeThrottled(c, throttled) {
+		t.Fatal("writeThrottled did not answer a *ThrottledError")
+	}
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("status = %d, want 429", w.Code)
+	}
+	if got := w.Header().Get("Retry-After"); got != "60" {
+		t.Errorf("Retry-After = %q, want 60", got)
+	}
+	if code := decodeError(t, w)["code"]; code != string(CodeRateLimited) {
+		t.Errorf("code = %v, want %s", code, CodeRateLimited)
+	}
+}
+
+// An update that fails in the database gives its slot back, so the retry isn't throttled
+func TestSetBitcoinFailureReleasesSlot(t *testing.T) {
+	failing := true
+	cs, fr, _ := newFakeBackedCacheService(t, upsertOneDB(&failing))
+	cs.minUpdateInterval = time.Minute
+	cs.dbRetryAttempts = 1
+
+	if _, _, err := cs.SetBitcoin(context.Background(), "BTC", decimal.RequireFromString("1"), nil, 0); err == nil {
+		t.Fatal("update succeeded against a failing database")
+	}
+	if fr.exists(throttleKeyPrefix + "BTC") {
+		t.Error("failed update kept its slot")
+	}
+
+	failing = false
+	if _, _, err := cs.SetBitcoin(context.Background(), "BTC", decimal.RequireFromString("1"), nil, 0); err != nil {
+		t.Errorf("retry after a failed update: %v", err)
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	// The runtime image has no zoneinfo; embed it so ?tz= works everywhere
+	_ "time/tzdata"
+)
+
+// Resolve a ?tz= IANA zone name such as "America/New_York" (UTC when empty).
+// Postgres uses the same names, so a valid zone here is valid for AT TIME ZONE too.
+func parseTimeZone(name string) (*time.Location, error) {
+	if name == "" {
+		return time.UTC, nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil || name == "Local" {
+		return nil, fmt.Errorf("unknown time zone %q: use an IANA name such as America/New_York", name)
+	}
+	return loc, nil
+}
+
+// Parse an RFC3339 timestamp. One without an offset (2024-01-01T09:30:00) is read as
+// wall-clock time in loc. Returned in UTC, which is how timestamps are stored.
+func parseTimestamp(raw string, loc *time.Location) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		t, err = time.ParseInLocation("2006-01-02T15:04:05", raw, loc)
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid timestamp %q", raw)
+	}
+	return t.UTC(), nil
+}
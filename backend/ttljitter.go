@@ -0,0 +1,15 @@
+package main
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Randomize ttl by up to ± cs.ttlJitter of its length; ttl itself when jitter is 0
+func (cs *CacheService) jitterTTL(ttl time.Duration) time.Duration {
+	window := int64(float64(ttl) * cs.ttlJitter)
+	if window <= 0 {
+		return ttl
+	}
+	return ttl - time.Duration(window) + time.Duration(rand.Int63n(2*window+1))
+}
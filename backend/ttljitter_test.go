@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJitterTTL(t *testing.T) {
+	cs := newTestCacheService(t)
+	ttl := time.Hour
+
+	cs.ttlJitter = 0
+	for i := 0; i < 100; i++ {
+		if got := cs.jitterTTL(ttl); got != ttl {
+			t.Fatalf("jitterTTL with no jitter = %s, want exactly %s", got, ttl)
+		}
+	}
+
+	cs.ttlJitter = 0.1
+	seen := map[time.Duration]bool{}
+	for i := 0; i < 1000; i++ {
+		got := cs.jitterTTL(ttl)
+		if got < 54*time.Minute || got > 66*time.Minute {
+			t.Fatalf("jitterTTL = %s, want within ±10%% of %s", got, ttl)
+		}
+		seen[got] = true
+	}
+	if len(seen) < 2 {
+		t.Error("jitterTTL returned the same TTL every time")
+	}
+}
+
+// Keys written by priming, a read-through backfill and a write all get the same
+// jittered TTL: exactly cacheTTL with no jitter, and spread within the window with it
+func TestRecordTTLJitterAcrossWritePaths(t *testing.T) {
+	for _, jitter := range []float64{0, 0.1} {
+		t.Run(fmt.Sprint(jitter), func(t *testing.T) {
+			cs, _, _ := newFakeBackedCacheService(t, func(query string, args []driver.Value) (fakeResult, error) {
+				switch {
+				case strings.Contains(query, "ORDER BY"):
+					res := fakeResult{columns: bitcoinColumns}
+					for i := 0; i < 50; i++ {
+						res.rows = append(res.rows, bitcoinRow(fmt.Sprintf("P%d", i), "1"))
+					}
+					return res, nil
+				case strings.Contains(query, "ON CONFLICT"):
+					return fakeResult{columns: append(bitcoinColumns, "previous_price"), rows: [][]driver.Value{append(bitcoinRow("WRITTEN", "1"), nil)}}, nil
+				case strings.Contains(query, "symbol = $1"):
+					return fakeResult{columns: bitcoinColumns, rows: [][]driver.Value{bitcoinRow("COLD", "1")}}, nil
+				}
+				return fakeResult{}, nil
+			})
+			cs.cacheTTL, cs.ttlJitter = time.Hour, jitter
+			close(cs.primed)
+			ctx := context.Background()
+
+			if err := cs.PrimeCache(); err != nil {
+				t.Fatalf("PrimeCache: %v", err)
+			}
+			if _, err := cs.GetBitcoin(ctx, "COLD", consistencyEventual); err != nil {
+				t.Fatalf("GetBitcoin: %v", err)
+			}
+			if _, _, err := cs.SetBitcoin(ctx, "WRITTEN", *decimalPtr("1"), nil, 0); err != nil {
+				t.Fatalf("SetBitcoin: %v", err)
+			}
+
+			symbols := []string{"COLD", "WRITTEN"}
+			for i := 0; i < 50; i++ {
+				symbols = append(symbols, fmt.Sprintf("P%d", i))
+			}
+			window := time.Duration(float64(cs.cacheTTL) * jitter)
+			distinct := map[time.Duration]bool{}
+			for _, symbol := range symbols {
+				ttl, err := cs.redisClient.PTTL(ctx, cs.getBitcoinCacheKey(symbol)).Result()
+				if err != nil {
+					t.Fatalf("PTTL %s: %v", symbol, err)
+				}
+				// The clock runs between the write and the PTTL, so allow a second of slack below
+				if ttl > cs.cacheTTL+window || ttl < cs.cacheTTL-window-time.Second {
+					t.Errorf("%s TTL %s, want %s ± %s", symbol, ttl, cs.cacheTTL, window)
+				}
+				distinct[ttl.Truncate(time.Second)] = true
+			}
+			if jitter > 0 && len(distinct) < 2 {
+				t.Error("every key got the same TTL despite jitter")
+			}
+		})
+	}
+}
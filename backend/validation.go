@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+// Canonical symbols are 1-10 uppercase letters or digits, matching the database's
+// bitcoins_symbol_normalized constraint. "btc" and "BTC" are the same record and cache
+// key, and nothing path-like ("..", "/") can reach a Redis key.
+var validSymbol = regexp.MustCompile(`^[A-Z0-9]{1,10}$`)
+
+var ErrInvalidSymbol = errors.New("symbol must be 1-10 letters or digits")
+
+// Trim and uppercase a symbol; ErrInvalidSymbol unless the result is canonical
+func normalizeSymbol(raw string) (string, error) {
+	symbol := strings.ToUpper(strings.TrimSpace(raw))
+	if !validSymbol.MatchString(symbol) {
+		return "", ErrInvalidSymbol
+	}
+	return symbol, nil
+}
+
+// Read the :symbol path param (already URL-unescaped by gin) in canonical form.
+// Writes a 400 and returns false when invalid.
+func symbolParam(c *gin.Context) (string, bool) {
+	if strings.TrimSpace(c.Param("symbol")) == "" {
+		c.JSON(http.StatusBadRequest, errorJSON(CodeValidationFailed, "Symbol is required"))
+		return "", false
+	}
+	return bodySymbol(c, c.Param("symbol"))
+}
+
+// A symbol from a request body or query in canonical form. Writes a 400 and returns
+// false when invalid.
+func bodySymbol(c *gin.Context, raw string) (string, bool) {
+	symbol, err := normalizeSymbol(raw)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, errorJSON(CodeValidationFailed, fmt.Sprintf("Invalid symbol %q: must be 1-10 letters or digits", raw)))
+		return "", false
+	}
+	return symbol, true
+}
+
+// Per-symbol routes hit with no symbol at all (e.g. GET /api/bitcoins/)
+func missingSymbol(c *gin.Context) {
+	c.JSON(http.StatusBadRequest, errorJSON(CodeValidationFailed, "Symbol is required"))
+}
+
+// Bind a JSON body, writing a 400 and returning false on failure. With strict
+// decoding on (binding.EnableDecoderDisallowUnknownFields, set from STRICT_JSON)
+// an unknown field such as a typo'd "symbl" is named in the error; any other
+// failure gets the handler's own message.
+func bindJSON(c *gin.Context, obj interface{}, message string) bool {
+	err := c.ShouldBindJSON(obj)
+	if err == nil {
+		return true
+	}
+	bindFailed(c, err, message)
+	return false
+}
+
+func bindFailed(c *gin.Context, err error, message string) {
+	if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+		c.JSON(http.StatusBadRequest, errorJSON(CodeValidationFailed, fmt.Sprintf("Unknown field %s", field)))
+		return
+	}
+	c.JSON(http.StatusBadRequest, errorJSON(CodeValidationFailed, message))
+}
+
+// bindJSON for a JSON array of entries. When an entry fails validation the 400 names
+// the first failing one by its index (see invalidEntry), so a client can fix a large
+// batch without bisecting it. An empty list (or null) is rejected too, rather than
+// answered with an empty success.
+func bindJSONList[T any](c *gin.Context, items *[]T, message string) bool {
+	err := c.ShouldBindJSON(items)
+	var invalid binding.SliceValidationError
+	if errors.As(err, &invalid) {
+		// gin drops the indexes of the failing entries, so find the first one again
+		for i := range *items {
+			if binding.Validator.ValidateStruct(&(*items)[i]) != nil {
+				invalidEntry(c, http.StatusBadRequest, CodeValidationFailed, i, message)
+				return false
+			}
+		}
+	}
+	if err == nil && len(*items) == 0 {
+		c.JSON(http.StatusBadRequest, errorJSON(CodeValidationFailed, "At least one entry is required"))
+		return false
+	}
+	if err == nil {
+		return true
+	}
+	bindFailed(c, err, message)
+	return false
+}
+
+// Reject a batch because of the entry at index
+func invalidEntry(c *gin.Context, status int, code ErrorCode, index int, message string) {
+	c.JSON(status, gin.H{"error": message, "code": code, "index": index})
+}
+
+// Read the symbols of a POST /api/bitcoins/batch body in canonical form: at least
+// one and at most maxBatchGetSymbols distinct symbols, none empty. Repeats are
+// dropped, keeping the first. Writes a 400 and returns false when invalid.
+func batchSymbols(c *gin.Context) ([]string, bool) {
+	var req struct {
+		Symbols []string `json:"symbols" binding:"required,min=1"`
+	}
+	msg := fmt.Sprintf("At least one and at most %d non-empty symbols are required", maxBatchGetSymbols)
+	if !bindJSON(c, &req, msg) {
+		return nil, false
+	}
+
+	symbols := make([]string, 0, len(req.Symbols))
+	seen := make(map[string]bool, len(req.Symbols))
+	for _, raw := range req.Symbols {
+		if strings.TrimSpace(raw) == "" {
+			c.JSON(http.StatusBadRequest, errorJSON(CodeValidationFailed, msg))
+			return nil, false
+		}
+		symbol, ok := bodySymbol(c, raw)
+		if !ok {
+			return nil, false
+		}
+		if !seen[symbol] {
+			seen[symbol] = true
+			symbols = append(symbols, symbol)
+		}
+	}
+	if len(symbols) > maxBatchGetSymbols {
+		c.JSON(http.StatusBadRequest, errorJSON(CodeValidationFailed, msg))
+		return nil, false
+	}
+	return symbols, true
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+// Run handler against a POST with body, returning the recorded response
+func serveJSON(t *testing.T, body string, handler gin.HandlerFunc) *httptest.ResponseRecorder {
+	t.Helper()
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	c.Request.Header.Set("Content-Type", "application/json")
+	handler(c)
+	return w
+}
+
+func decodeError(t *testing.T, w *httptest.ResponseRecorder) map[string]any {
+	t.Helper()
+	var body map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("response is not JSON: %q", w.Body.String())
+	}
+	return body
+}
+
+func TestBindJSONListRejectsEmptyAndNull(t *testing.T) {
+	for _, body := range []string{`[]`, `null`, ` null `} {
+		t.Run(body, func(t *testing.T) {
+			bound := true
+			w := serveJSON(t, body, func(c *gin.Context) {
+				var items []BitcoinInput
+				bound = bindJSONList(c, &items, "bad list")
+			})
+			if bound {
+				t.Fatal("empty list was accepted")
+			}
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("status = %d, want 400", w.Code)
+			}
+			resp := decodeError(t, w)
+			if resp["code"] != string(CodeValidationFailed) {
+				t.Errorf("code = %v, want %s", resp["code"], CodeValidationFailed)
+			}
+			if resp["error"] != "At least one entry is required" {
+				t.Errorf("error = %v", resp["error"])
+			}
+		})
+	}
+}
+
+func TestBindJSONListAcceptsEntries(t *testing.T) {
+	var items []BitcoinInput
+	w := serveJSON(t, `[{"symbol":"BTC","price":1}]`, func(c *gin.Context) {
+		if !bindJSONList(c, &items, "bad list") {
+			t.Error("valid list was rejected")
+		}
+	})
+	if w.Body.Len() != 0 {
+		t.Errorf("wrote a response for a valid list: %s", w.Body.String())
+	}
+	if len(items) != 1 || items[0].Symbol != "BTC" {
+		t.Errorf("items = %+v", items)
+	}
+}
+
+func TestBindJSONListNamesInvalidEntry(t *testing.T) {
+	w := serveJSON(t, `[{"symbol":"BTC","price":1},{"symbol":"ETH"}]`, func(c *gin.Context) {
+		var items []BitcoinInput
+		bindJSONList(c, &items, "bad list")
+	})
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want 400", w.Code)
+	}
+	if index := decodeError(t, w)["index"]; index != float64(1) {
+		t.Errorf("index = %v, want 1", index)
+	}
+}
+
+// Turn STRICT_JSON decoding on or off for one test
+func setStrictJSON(t *testing.T, strict bool) {
+	t.Helper()
+	previous := binding.EnableDecoderDisallowUnknownFields
+	binding.EnableDecoderDisallowUnknownFields = strict
+	t.Cleanup(func() { binding.EnableDecoderDisallowUnknownFields = previous })
+}
+
+func TestStrictJSONNamesUnknownField(t *testing.T) {
+	setStrictJSON(t, true)
+	cases := []struct {
+		name string
+		body string
+		list bool
+		want string
+	}{
+		{"typo", `{"symbl":"BTC","price":1}`, false, `Unknown field "symbl"`},
+		{"extra", `{"symbol":"BTC","price":1,"volume":5}`, false, `Unknown field "volume"`},
+		{"extra in list", `[{"symbol":"BTC","price":1},{"symbol":"ETH","price":2,"note":"x"}]`, true, `Unknown field "note"`},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := serveJSON(t, tc.body, func(c *gin.Context) {
+				if tc.list {
+					var items []BitcoinInput
+					bindJSONList(c, &items, "bad list")
+				} else {
+					var item BitcoinInput
+					bindJSON(c, &item, "bad body")
+				}
+			})
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("status = %d, want 400", w.Code)
+			}
+			resp := decodeError(t, w)
+			if resp["error"] != tc.want {
+				t.Errorf("error = %v, want %s", resp["error"], tc.want)
+			}
+			if resp["code"] != string(CodeValidationFailed) {
+				t.Errorf("code = %v, want %s", resp["code"], CodeValidationFailed)
+			}
+		})
+	}
+}
+
+func TestLenientJSONIgnoresUnknownField(t *testing.T) {
+	setStrictJSON(t, false)
+
+	var item BitcoinInput
+	w := serveJSON(t, `{"symbol":"BTC","price":1,"volume":5}`, func(c *gin.Context) {
+		if !bindJSON(c, &item, "bad body") {
+			t.Error("extra field was rejected with STRICT_JSON off")
+		}
+	})
+	if w.Body.Len() != 0 || item.Symbol != "BTC" {
+		t.Errorf("item = %+v, response %s", item, w.Body.String())
+	}
+
+	// A typo'd required field then just reads as missing
+	w = serveJSON(t, `{"symbl":"BTC","price":1}`, func(c *gin.Context) {
+		var item BitcoinInput
+		bindJSON(c, &item, "bad body")
+	})
+	if resp := decodeError(t, w); w.Code != http.StatusBadRequest || resp["error"] != "bad body" {
+		t.Errorf("status %d, error %v; want 400 with the handler's message", w.Code, resp["error"])
+	}
+}
+
+// Every per-symbol route in main, each answered as the real handlers start: with
+// symbolParam. The router matches on the raw path, as main's does.
+func perSymbolRouter() (*gin.Engine, []string) {
+	routes := []string{
+		"GET /api/bitcoins/:symbol",
+		"PUT /api/bitcoins/:symbol",
+		"DELETE /api/bitcoins/:symbol",
+		"GET /api/bitcoins/:symbol/at",
+		"GET /api/bitcoins/:symbol/metadata",
+		"PUT /api/bitcoins/:symbol/metadata",
+		"GET /api/admin/debug/:symbol",
+	}
+	router := gin.New()
+	router.UseRawPath = true
+	for _, method := range []string{http.MethodGet, http.MethodPut, http.MethodDelete} {
+		router.Handle(method, "/api/bitcoins/", missingSymbol)
+	}
+	router.GET("/api/admin/debug/", missingSymbol)
+	for _, route := range routes {
+		method, path, _ := strings.Cut(route, " ")
+		router.Handle(method, path, func(c *gin.Context) {
+			if _, ok := symbolParam(c); ok {
+				c.Status(http.StatusOK)
+			}
+		})
+	}
+	return router, routes
+}
+
+func TestPerSymbolRoutesRejectBadSymbols(t *testing.T) {
+	router, routes := perSymbolRouter()
+	for _, route := range routes {
+		method, path, _ := strings.Cut(route, " ")
+		for _, tc := range []struct {
+			name, symbol, want string
+		}{
+			{"empty", "", "Symbol is required"},
+			{"encoded slash", "%2F", `Invalid symbol "/": must be 1-10 letters or digits`},
+			{"whitespace", "%20%20", "Symbol is required"},
+		} {
+			target := strings.Replace(path, ":symbol", tc.symbol, 1)
+			t.Run(route+"/"+tc.name, func(t *testing.T) {
+				w := httptest.NewRecorder()
+				router.ServeHTTP(w, httptest.NewRequest(method, target, nil))
+				if w.Code != http.StatusBadRequest {
+					t.Fatalf("%s %s = %d, want 400", method, target, w.Code)
+				}
+				if resp := decodeError(t, w); resp["error"] != tc.want {
+					t.Errorf("%s %s = %v, want %q", method, target, resp, tc.want)
+				}
+			})
+		}
+
+		t.Run(route+"/valid", func(t *testing.T) {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(method, strings.Replace(path, ":symbol", "BTC", 1), nil))
+			if w.Code != http.StatusOK {
+				t.Errorf("status = %d for a valid symbol, want 200", w.Code)
+			}
+		})
+	}
+}
+
+// An empty, null or missing symbols list is a 400, never an empty success
+func TestBatchSymbolsRequiresAtLeastOne(t *testing.T) {
+	tooMany := make([]string, maxBatchGetSymbols+1)
+	for i := range tooMany {
+		tooMany[i] = fmt.Sprintf("%q", fmt.Sprintf("S%d", i))
+	}
+	for _, tc := range []struct {
+		name, body string
+	}{
+		{"empty", `{"symbols":[]}`},
+		{"null", `{"symbols":null}`},
+		{"missing", `{}`},
+		{"blank symbol", `{"symbols":["BTC"," "]}`},
+		{"too many", `{"symbols":[` + strings.Join(tooMany, ",") + `]}`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			w := serveJSON(t, tc.body, func(c *gin.Context) {
+				if symbols, ok := batchSymbols(c); ok {
+					t.Errorf("batchSymbols accepted %v", symbols)
+				}
+			})
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("status = %d, want 400", w.Code)
+			}
+			if resp := decodeError(t, w); !strings.HasPrefix(resp["error"].(string), "At least one") {
+				t.Errorf("error = %v, want the at-least-one message", resp["error"])
+			}
+		})
+	}
+
+	serveJSON(t, `{"symbols":["BTC"," eth","btc"]}`, func(c *gin.Context) {
+		symbols, ok := batchSymbols(c)
+		if !ok || !reflect.DeepEqual(symbols, []string{"BTC", "ETH"}) {
+			t.Errorf("batchSymbols = %v, %v; want [BTC ETH]", symbols, ok)
+		}
+	})
+}
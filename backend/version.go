@@ -0,0 +1,34 @@
+package main
+
+import "time"
+
+// Set at build time, e.g.:
+//
+//	go build -ldflags "-X main.version=v1.2.3 -X main.commit=$(git rev-parse --short HEAD) -X main.buildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+var (
+	version   = "dev"
+	commit    = "unknown"
+	buildTime = "unknown"
+)
+
+var startTime = time.Now()
+
+type buildInfo struct {
+	Version       string `json:"version"`
+	Commit        string `json:"commit"`
+	BuildTime     string `json:"build_time"`
+	StartedAt     string `json:"started_at"`
+	UptimeSeconds int64  `json:"uptime_seconds"`
+	ReadOnly      bool   `json:"read_only"`
+}
+
+func currentBuildInfo(readOnly bool) buildInfo {
+	return buildInfo{
+		Version:       version,
+		Commit:        commit,
+		BuildTime:     buildTime,
+		StartedAt:     startTime.UTC().Format(time.RFC3339),
+		UptimeSeconds: int64(time.Since(startTime).Seconds()),
+		ReadOnly:      readOnly,
+	}
+}
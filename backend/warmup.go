@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Upper bound on the whole warm-up; a slow backend shouldn't hold /ready back forever
+const warmUpTimeout = 10 * time.Second
+
+// Open n DB and n Redis connections up front so the first requests don't pay for the
+// handshakes. Every connection is held until all are open (otherwise the pools would
+// hand the same one back each time) and then released to the idle pool.
+// Best effort: failures are logged and the pools stay lazy for the rest.
+func (cs *CacheService) warmUpPools(n int) {
+	if n <= 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(cs.ctx, warmUpTimeout)
+	defer cancel()
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var dbConns []*sql.Conn
+	var redisConns []*redis.Conn
+
+	for i := 0; i < n; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			conn, err := cs.db.Conn(ctx)
+			if err == nil {
+				if _, err = conn.ExecContext(ctx, "SELECT 1"); err != nil {
+					conn.Close()
+				}
+			}
+			if err != nil {
+				slog.WarnContext(cs.ctx, "DB pool warm-up failed", "error", err)
+				return
+			}
+			mu.Lock()
+			dbConns = append(dbConns, conn)
+			mu.Unlock()
+		}()
+		go func() {
+			defer wg.Done()
+			conn := cs.redisClient.Conn()
+			if err := conn.Ping(ctx).Err(); err != nil {
+				conn.Close()
+				slog.WarnContext(cs.ctx, "Redis pool warm-up failed", "error", err)
+				return
+			}
+			mu.Lock()
+			redisConns = append(redisConns, conn)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	for _, conn := range dbConns {
+		conn.Close()
+	}
+	for _, conn := range redisConns {
+		conn.Close()
+	}
+
+	slog.InfoContext(cs.ctx, "connection pools warmed", "duration_ms", latencyMs(time.Since(start)), "postgres", len(dbConns), "redis", len(redisConns), "wanted", n)
+}
@@ -51,7 +51,7 @@ function App() {
 
       await axios.post(`${API_URL}/api/bitcoins`, {
         symbol: symbol.toUpperCase(),
-        price: parseInt(price)
+        price: parseFloat(price)
       });
 
       const action = isEditing ? 'updated' : 'added';
@@ -135,7 +135,8 @@ function App() {
             />
             <input
               type="number"
-              placeholder="Price (integer)"
+              step="any"
+              placeholder="Price"
               value={price}
               onChange={(e) => setPrice(e.target.value)}
             />
@@ -1,9 +1,10 @@
 -- Create the bitcoins table
 CREATE TABLE IF NOT EXISTS bitcoins (
     symbol VARCHAR(10) PRIMARY KEY,
-    price INTEGER NOT NULL,
+    price NUMERIC NOT NULL,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT bitcoins_symbol_normalized CHECK (symbol ~ '^[A-Z0-9]{1,10}$')
 );
 
 -- Create an index on price for faster rank queries
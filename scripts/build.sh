@@ -10,7 +10,11 @@ echo "=== Building Docker Images ==="
 
 # Build backend
 echo "Building backend image..."
-docker build -t ${BACKEND_IMAGE} ./backend
+docker build -t ${BACKEND_IMAGE} \
+  --build-arg VERSION="$(git describe --tags --always 2>/dev/null || echo dev)" \
+  --build-arg COMMIT="$(git rev-parse --short HEAD 2>/dev/null || echo unknown)" \
+  --build-arg BUILD_TIME="$(date -u +%Y-%m-%dT%H:%M:%SZ)" \
+  ./backend
 
 # Build frontend
 echo "Building frontend image..."